@@ -0,0 +1,1414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/thomas-sievering/gchatctl/gchat"
+	"github.com/thomas-sievering/gchatctl/internal/fakechat"
+)
+
+// newFakeEnv points gchatctl at a fresh fake Chat API with an isolated config
+// dir and a valid saved token.
+func newFakeEnv(t *testing.T) *fakechat.Server {
+	t.Helper()
+	srv := fakechat.New()
+	t.Cleanup(srv.Close)
+
+	t.Setenv("GCHATCTL_CONFIG_DIR", t.TempDir())
+	t.Setenv("GCHATCTL_API_BASE_URL", srv.APIBaseURL())
+	t.Setenv("GCHATCTL_AUTH_URL", srv.AuthURL())
+	t.Setenv("GCHATCTL_TOKEN_URL", srv.TokenURL())
+	t.Setenv("GCHATCTL_DEVICE_URL", srv.DeviceURL())
+	t.Setenv("GCHATCTL_JSON_PRETTY", "")
+	t.Setenv("GCHATCTL_JSON_ENVELOPE", "")
+
+	if err := saveConfig(AppConfig{OAuthClient: OAuthClient{ClientID: "test-client"}, Scopes: defaultChatScopes}); err != nil {
+		t.Fatalf("saveConfig: %v", err)
+	}
+	st := StoredToken{
+		Token: oauth2.Token{
+			AccessToken:  fakechat.DefaultAccessToken,
+			RefreshToken: fakechat.DefaultRefreshToken,
+			TokenType:    "Bearer",
+			Expiry:       time.Now().Add(time.Hour),
+		},
+		Scopes:  defaultChatScopes,
+		Mode:    "device",
+		SavedAt: time.Now().UTC(),
+	}
+	if err := saveToken(st); err != nil {
+		t.Fatalf("saveToken: %v", err)
+	}
+	return srv
+}
+
+// captureStdout runs fn and returns everything it printed to stdout.
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	runErr := fn()
+	os.Stdout = orig
+	_ = w.Close()
+	return <-done, runErr
+}
+
+func runJSON(t *testing.T, out any, fn func() error) {
+	t.Helper()
+	stdout, err := captureStdout(t, fn)
+	if err != nil {
+		t.Fatalf("command failed: %v (stdout: %s)", err, stdout)
+	}
+	if err := json.Unmarshal([]byte(stdout), out); err != nil {
+		t.Fatalf("decode JSON output %q: %v", stdout, err)
+	}
+}
+
+func seedDM(srv *fakechat.Server, space, me string, peer fakechat.User) {
+	srv.AddSpace(fakechat.Space{Name: space, SpaceType: "DIRECT_MESSAGE"},
+		fakechat.User{Name: me, Type: "HUMAN"},
+		peer,
+	)
+}
+
+func TestChatInboxEndToEnd(t *testing.T) {
+	srv := newFakeEnv(t)
+	me := "users/me-1"
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	srv.SetCurrentUser(me)
+	seedDM(srv, "spaces/DM1", me, bob)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", DisplayName: "Room", SpaceType: "SPACE"}, fakechat.User{Name: me, Type: "HUMAN"}, bob)
+
+	now := time.Now().UTC()
+	srv.AddMessage("spaces/DM1", fakechat.Message{Text: "fresh", Sender: fakechat.User{Name: bob.Name}, CreateTime: now.Add(-2 * time.Minute)})
+	srv.AddMessage("spaces/DM1", fakechat.Message{Text: "stale", Sender: fakechat.User{Name: bob.Name}, CreateTime: now.Add(-3 * time.Hour)})
+	srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "mine", Sender: fakechat.User{Name: me}, CreateTime: now.Add(-time.Minute)})
+	srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "room hello", Sender: fakechat.User{Name: bob.Name}, CreateTime: now.Add(-time.Minute)})
+
+	var out struct {
+		Count    int             `json:"count"`
+		Messages []PolledMessage `json:"messages"`
+	}
+	runJSON(t, &out, func() error { return runChat(context.Background(), []string{"inbox", "--since", "1h", "--json"}) })
+
+	if out.Count != 2 {
+		t.Fatalf("expected 2 incoming messages, got %d: %+v", out.Count, out.Messages)
+	}
+	for _, m := range out.Messages {
+		if m.Sender != "Bob" {
+			t.Fatalf("expected sender enriched from memberships, got %q", m.Sender)
+		}
+		if m.Text == "mine" || m.Text == "stale" {
+			t.Fatalf("unexpected message in inbox: %+v", m)
+		}
+	}
+}
+
+func TestSettingsDefaultFlagsWithPrecedence(t *testing.T) {
+	srv := newFakeEnv(t)
+	me := "users/me-1"
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	srv.SetCurrentUser(me)
+	seedDM(srv, "spaces/DM1", me, bob)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", DisplayName: "Room", SpaceType: "SPACE"}, fakechat.User{Name: me, Type: "HUMAN"}, bob)
+	now := time.Now().UTC()
+	srv.AddMessage("spaces/DM1", fakechat.Message{Text: "dm hello", Sender: fakechat.User{Name: bob.Name}, CreateTime: now.Add(-30 * time.Minute)})
+	srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "room hello", Sender: fakechat.User{Name: bob.Name}, CreateTime: now.Add(-30 * time.Minute)})
+
+	for _, kv := range [][]string{{"since", "1h"}, {"chat.incoming.exclude-spaces", "ROOM"}, {"format", "json"}} {
+		if _, err := captureStdout(t, func() error { return run(context.Background(), []string{"config", "set", kv[0], kv[1]}) }); err != nil {
+			t.Fatalf("config set %v: %v", kv, err)
+		}
+	}
+	if _, err := captureStdout(t, func() error { return run(context.Background(), []string{"config", "set", "chat.inbox.bogus", "1"}) }); exitCode(err) != 2 {
+		t.Fatalf("unknown key should be a usage error, got %v", err)
+	}
+	if _, err := captureStdout(t, func() error { return run(context.Background(), []string{"config", "set", "since", "soon"}) }); exitCode(err) != 2 {
+		t.Fatalf("bad duration should be a usage error, got %v", err)
+	}
+
+	inbox := func(args ...string) []string {
+		t.Helper()
+		var out struct {
+			Messages []PolledMessage `json:"messages"`
+		}
+		// No --json: the format setting asks for JSON.
+		runJSON(t, &out, func() error { return runChat(context.Background(), append([]string{"inbox"}, args...)) })
+		texts := make([]string, 0, len(out.Messages))
+		for _, m := range out.Messages {
+			texts = append(texts, m.Text)
+		}
+		sort.Strings(texts)
+		return texts
+	}
+	if got := inbox(); !reflect.DeepEqual(got, []string{"dm hello"}) {
+		t.Fatalf("config defaults: got %v", got)
+	}
+	t.Setenv("GCHATCTL_EXCLUDE_SPACES", "spaces/DM1")
+	if got := inbox(); !reflect.DeepEqual(got, []string{"room hello"}) {
+		t.Fatalf("env over config: got %v", got)
+	}
+	if got := inbox("--exclude-spaces", "", "--since", "10m"); len(got) != 0 {
+		t.Fatalf("flags over env and config: got %v", got)
+	}
+	if got := inbox("--exclude-spaces="); !reflect.DeepEqual(got, []string{"dm hello", "room hello"}) {
+		t.Fatalf("empty flag should clear the exclusions: got %v", got)
+	}
+
+	var view SettingView
+	runJSON(t, &view, func() error { return run(context.Background(), []string{"config", "get", "chat.inbox.exclude-spaces"}) })
+	if view.Value != "spaces/DM1" || view.Source != "env" || view.Env != "GCHATCTL_EXCLUDE_SPACES" {
+		t.Fatalf("config get: %+v", view)
+	}
+	var removed struct {
+		Removed bool `json:"removed"`
+	}
+	runJSON(t, &removed, func() error { return run(context.Background(), []string{"config", "unset", "format"}) })
+	if !removed.Removed {
+		t.Fatal("format was not removed")
+	}
+	if out, _ := captureStdout(t, func() error { return run(context.Background(), []string{"config", "get", "format"}) }); out != "text\n" {
+		t.Fatalf("format after unset: %q", out)
+	}
+}
+
+func TestChatPollPaginatesAndOrders(t *testing.T) {
+	srv := newFakeEnv(t)
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", SpaceType: "SPACE"}, bob)
+	start := time.Now().UTC().Add(-30 * time.Minute)
+	for i := 0; i < 130; i++ {
+		srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "msg", Sender: fakechat.User{Name: bob.Name}, CreateTime: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	var out struct {
+		Count    int             `json:"count"`
+		Messages []PolledMessage `json:"messages"`
+	}
+	runJSON(t, &out, func() error {
+		return runChat(context.Background(), []string{"poll", "--space", "spaces/ROOM", "--since", "1h", "--limit", "130", "--json"})
+	})
+	if out.Count != 130 {
+		t.Fatalf("expected all 130 messages across pages, got %d", out.Count)
+	}
+	if got := srv.CountRequests(http.MethodGet, "/v1/spaces/ROOM/messages"); got != 2 {
+		t.Fatalf("expected 2 message pages, got %d", got)
+	}
+	first, _ := gchat.ParseTime(out.Messages[0].CreateTime)
+	last, _ := gchat.ParseTime(out.Messages[len(out.Messages)-1].CreateTime)
+	if !first.Before(last) {
+		t.Fatalf("poll output should be oldest first: %s .. %s", first, last)
+	}
+}
+
+func TestChatRecentResolvesName(t *testing.T) {
+	srv := newFakeEnv(t)
+	me := "users/me-1"
+	simon := fakechat.User{Name: "users/simon-1", DisplayName: "Simon Example", Type: "HUMAN"}
+	seedDM(srv, "spaces/DMS", me, simon)
+	seedDM(srv, "spaces/DMA", me, fakechat.User{Name: "users/alice-1", DisplayName: "Alice", Type: "HUMAN"})
+	srv.AddMessage("spaces/DMS", fakechat.Message{Text: "from simon", Sender: fakechat.User{Name: simon.Name}})
+	srv.AddMessage("spaces/DMS", fakechat.Message{Text: "from me", Sender: fakechat.User{Name: me}})
+
+	var out struct {
+		Target   string              `json:"target"`
+		Space    string              `json:"space"`
+		Count    int                 `json:"count"`
+		Messages []gchat.ChatMessage `json:"messages"`
+	}
+	runJSON(t, &out, func() error { return runChat(context.Background(), []string{"recent", "--name", "simon", "--json"}) })
+	if out.Target != simon.Name || out.Space != "spaces/DMS" {
+		t.Fatalf("resolved %s in %s, want %s in spaces/DMS", out.Target, out.Space, simon.Name)
+	}
+	if out.Count != 1 || out.Messages[0].Text != "from simon" {
+		t.Fatalf("unexpected recent messages: %+v", out.Messages)
+	}
+}
+
+func TestChatSpacesUnread(t *testing.T) {
+	srv := newFakeEnv(t)
+	now := time.Now().UTC()
+	srv.AddSpace(fakechat.Space{Name: "spaces/READ", DisplayName: "Read", SpaceType: "SPACE"})
+	srv.AddSpace(fakechat.Space{Name: "spaces/UNREAD", DisplayName: "Unread", SpaceType: "SPACE"})
+	srv.AddMessage("spaces/READ", fakechat.Message{Text: "old", CreateTime: now.Add(-time.Hour)})
+	srv.AddMessage("spaces/UNREAD", fakechat.Message{Text: "new", CreateTime: now.Add(-time.Minute)})
+	srv.SetReadState("spaces/READ", now.Add(-30*time.Minute))
+	srv.SetReadState("spaces/UNREAD", now.Add(-30*time.Minute))
+
+	var out struct {
+		Count  int               `json:"count"`
+		Spaces []UnreadSpaceView `json:"spaces"`
+	}
+	runJSON(t, &out, func() error { return runChat(context.Background(), []string{"spaces", "unread", "--json"}) })
+	if out.Count != 1 || out.Spaces[0].Space != "spaces/UNREAD" {
+		t.Fatalf("unexpected unread spaces: %+v", out.Spaces)
+	}
+}
+
+func TestSpacesUnreadReportsFailedSpaces(t *testing.T) {
+	srv := newFakeEnv(t)
+	now := time.Now().UTC()
+	for _, name := range []string{"spaces/A", "spaces/B"} {
+		srv.AddSpace(fakechat.Space{Name: name, SpaceType: "SPACE"})
+		srv.AddMessage(name, fakechat.Message{Text: "new", CreateTime: now.Add(-time.Minute)})
+		srv.SetReadState(name, now.Add(-time.Hour))
+	}
+	srv.AddFault(fakechat.Fault{Path: "/v1/users/me/spaces/B/spaceReadState", Status: http.StatusForbidden, GoogleStatus: "PERMISSION_DENIED", Message: "denied"})
+
+	var out struct {
+		Count    int  `json:"count"`
+		Complete bool `json:"complete"`
+		Errors   []struct {
+			Space     string `json:"space"`
+			Operation string `json:"operation"`
+			Code      string `json:"code"`
+		} `json:"errors"`
+	}
+	runJSON(t, &out, func() error { return runChat(context.Background(), []string{"spaces", "unread", "--json"}) })
+	if out.Count != 1 || out.Complete || len(out.Errors) != 1 {
+		t.Fatalf("expected one unread space and one failure: %+v", out)
+	}
+	if e := out.Errors[0]; e.Space != "spaces/B" || e.Operation != gchat.EndpointGetSpaceReadState || e.Code != "PERMISSION_DENIED" {
+		t.Fatalf("unexpected failure entry: %+v", e)
+	}
+
+	_, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"spaces", "unread", "--strict", "--json"})
+	})
+	if err == nil || exitCode(err) != 4 {
+		t.Fatalf("--strict should fail with the failure's exit code, got %v", err)
+	}
+	stdout, _ := captureStdout(t, func() error { return printJSONError(err) })
+	if !strings.Contains(stdout, `"errors":[{"space":"spaces/B"`) {
+		t.Fatalf("strict JSON error should list failed spaces: %s", stdout)
+	}
+}
+
+func TestThreadRepliesAndListing(t *testing.T) {
+	srv := newFakeEnv(t)
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", SpaceType: "SPACE"}, bob)
+	start := time.Now().UTC().Add(-time.Hour)
+	question := srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "question?", Sender: bob, CreateTime: start})
+	srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "unrelated", Sender: bob, CreateTime: start.Add(time.Minute)})
+
+	type sendOut struct {
+		Message gchat.ChatMessage `json:"message"`
+	}
+	var reply sendOut
+	runJSON(t, &reply, func() error {
+		return runChat(context.Background(), []string{"send", "--reply-to", question.Name, "--text", "answer", "--json"})
+	})
+	if reply.Message.ThreadName() != question.Thread || !reply.Message.ThreadReply {
+		t.Fatalf("reply not threaded under %s: %+v", question.Thread, reply.Message)
+	}
+	var keyed [2]sendOut
+	for i := range keyed {
+		runJSON(t, &keyed[i], func() error {
+			return runChat(context.Background(), []string{"send", "--space", "ROOM", "--thread-key", "deploy-42", "--text", "step", "--json"})
+		})
+	}
+	if keyed[0].Message.ThreadName() == "" || keyed[0].Message.ThreadName() != keyed[1].Message.ThreadName() || !keyed[1].Message.ThreadReply {
+		t.Fatalf("thread key should open one thread: %+v / %+v", keyed[0].Message, keyed[1].Message)
+	}
+	if _, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"send", "--thread", "spaces/ROOM/threads/missing", "--text", "x"})
+	}); gchat.ErrorCodeOf(err) != gchat.CodeNotFound {
+		t.Fatalf("reply to a missing thread should fail with NOT_FOUND, got %v", err)
+	}
+	if _, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"send", "--thread", question.Thread, "--space", "ROOM", "--text", "x"})
+	}); exitCode(err) != 2 {
+		t.Fatalf("--thread with --space should be a usage error, got %v", err)
+	}
+
+	var thread struct {
+		Thread   string              `json:"thread"`
+		Count    int                 `json:"count"`
+		Messages []gchat.ChatMessage `json:"messages"`
+	}
+	runJSON(t, &thread, func() error {
+		return runChat(context.Background(), []string{"thread", "--message", reply.Message.Name, "--json"})
+	})
+	if thread.Thread != question.Thread || thread.Count != 2 || thread.Messages[0].Text != "question?" || thread.Messages[1].Text != "answer" {
+		t.Fatalf("unexpected thread listing: %+v", thread)
+	}
+
+	var grouped struct {
+		Count   int          `json:"count"`
+		Threads []ThreadView `json:"threads"`
+	}
+	runJSON(t, &grouped, func() error {
+		return runChat(context.Background(), []string{"list", "--space", "ROOM", "--group-threads", "--json"})
+	})
+	if grouped.Count != 5 || len(grouped.Threads) != 3 {
+		t.Fatalf("expected 5 messages in 3 threads: %+v", grouped)
+	}
+	if first := grouped.Threads[0]; first.Thread != keyed[0].Message.ThreadName() || first.Count != 2 {
+		t.Fatalf("newest thread should come first: %+v", first)
+	}
+	if q := grouped.Threads[1]; q.Thread != question.Thread || q.Messages[0].Text != "question?" {
+		t.Fatalf("thread messages should run oldest first: %+v", q)
+	}
+}
+
+func TestChatGetEditDelete(t *testing.T) {
+	srv := newFakeEnv(t)
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", SpaceType: "SPACE"}, bob)
+	msg := srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "deploy at 5pn", Sender: bob})
+
+	type messageOut struct {
+		Space   string            `json:"space"`
+		Message gchat.ChatMessage `json:"message"`
+	}
+	var got messageOut
+	runJSON(t, &got, func() error {
+		return runChat(context.Background(), []string{"get", "--message", msg.Name, "--json"})
+	})
+	if got.Space != "spaces/ROOM" || got.Message.Text != "deploy at 5pn" || got.Message.Sender.DisplayName != "Bob" {
+		t.Fatalf("unexpected get output: %+v", got)
+	}
+
+	var edited messageOut
+	runJSON(t, &edited, func() error {
+		return runChat(context.Background(), []string{"edit", "--message", msg.Name, "--text", "deploy at 5pm", "--json"})
+	})
+	if edited.Message.Name != msg.Name || edited.Message.Text != "deploy at 5pm" || edited.Message.LastUpdateTime == "" {
+		t.Fatalf("edit should return the updated message: %+v", edited.Message)
+	}
+	patches := 0
+	for _, r := range srv.Requests() {
+		if r.Method == http.MethodPatch {
+			patches++
+			if r.Query.Get("updateMask") != "text" {
+				t.Fatalf("edit should send updateMask=text, got %v", r.Query)
+			}
+		}
+	}
+	if patches != 1 {
+		t.Fatalf("expected one PATCH, got %d", patches)
+	}
+
+	if _, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"get", "--message", "ROOM"})
+	}); exitCode(err) != 2 {
+		t.Fatalf("a bare space should be a usage error, got %v", err)
+	}
+	// Without a terminal to ask on, --yes is required.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	w.Close()
+	defer r.Close()
+	origStdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() { os.Stdin = origStdin })
+	if _, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"delete", "--message", msg.Name})
+	}); exitCode(err) != 2 {
+		t.Fatalf("delete without --yes should be a usage error, got %v", err)
+	}
+	if n := srv.CountRequests(http.MethodDelete, "/v1/"+msg.Name); n != 0 {
+		t.Fatalf("unconfirmed delete reached the API %d times", n)
+	}
+	var deleted struct {
+		Message string `json:"message"`
+		Deleted bool   `json:"deleted"`
+	}
+	runJSON(t, &deleted, func() error {
+		return runChat(context.Background(), []string{"delete", "--message", msg.Name, "--yes", "--json"})
+	})
+	if !deleted.Deleted || deleted.Message != msg.Name || len(srv.Messages("spaces/ROOM")) != 0 {
+		t.Fatalf("message not deleted: %+v", deleted)
+	}
+	if _, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"get", "--message", msg.Name})
+	}); gchat.ErrorCodeOf(err) != gchat.CodeNotFound {
+		t.Fatalf("deleted message should be NOT_FOUND, got %v", err)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	srv := newFakeEnv(t)
+	me := fakechat.User{Name: "users/me-1", DisplayName: "Me", Type: "HUMAN"}
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	srv.SetCurrentUser(me.Name)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", SpaceType: "SPACE"}, me, bob)
+	msg := srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "ship it?", Sender: bob})
+	srv.AddReaction(msg.Name, bob, "👀")
+
+	var reacted struct {
+		Reaction gchat.Reaction `json:"reaction"`
+	}
+	runJSON(t, &reacted, func() error {
+		return runChat(context.Background(), []string{"react", "--message", msg.Name, "--emoji", ":eyes:", "--json"})
+	})
+	if reacted.Reaction.Emoji.Unicode != "👀" || reacted.Reaction.User.Name != me.Name {
+		t.Fatalf("unexpected reaction: %+v", reacted.Reaction)
+	}
+	runJSON(t, &reacted, func() error {
+		return runChat(context.Background(), []string{"react", "--message", msg.Name, "--emoji", "✅", "--json"})
+	})
+	if _, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"react", "--message", msg.Name, "--emoji", "eyes please"})
+	}); exitCode(err) != 2 {
+		t.Fatalf("unknown shortcode should be a usage error, got %v", err)
+	}
+
+	var list struct {
+		Messages []gchat.ChatMessage `json:"messages"`
+	}
+	runJSON(t, &list, func() error {
+		return runChat(context.Background(), []string{"list", "--space", "ROOM", "--json"})
+	})
+	want := []gchat.EmojiReactionSummary{{Emoji: gchat.Emoji{Unicode: "👀"}, ReactionCount: 2}, {Emoji: gchat.Emoji{Unicode: "✅"}, ReactionCount: 1}}
+	if len(list.Messages) != 1 || !reflect.DeepEqual(list.Messages[0].EmojiReactionSummaries, want) {
+		t.Fatalf("unexpected reaction summaries: %+v", list.Messages)
+	}
+	out, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"list", "--space", "ROOM"})
+	})
+	if err != nil || !strings.Contains(out, "ship it? [👀 2, ✅ 1]") {
+		t.Fatalf("text output should show reactions, got %q (%v)", out, err)
+	}
+
+	var reactions struct {
+		Count     int              `json:"count"`
+		Reactions []gchat.Reaction `json:"reactions"`
+	}
+	runJSON(t, &reactions, func() error {
+		return runChat(context.Background(), []string{"reactions", "--message", msg.Name, "--json"})
+	})
+	if reactions.Count != 3 || reactions.Reactions[0].User.DisplayName != "Bob" {
+		t.Fatalf("unexpected reactions listing: %+v", reactions)
+	}
+
+	var removed struct {
+		Removed int `json:"removed"`
+	}
+	runJSON(t, &removed, func() error {
+		return runChat(context.Background(), []string{"unreact", "--message", msg.Name, "--emoji", "👀", "--json"})
+	})
+	if removed.Removed != 1 || !reflect.DeepEqual(srv.Reactions(msg.Name), []string{"👀", "✅"}) {
+		t.Fatalf("unreact should remove only the caller's reaction: %+v, left %v", removed, srv.Reactions(msg.Name))
+	}
+	runJSON(t, &removed, func() error {
+		return runChat(context.Background(), []string{"unreact", "--message", msg.Name, "--emoji", "👀", "--json"})
+	})
+	if removed.Removed != 0 {
+		t.Fatalf("second unreact should be a no-op, removed %d", removed.Removed)
+	}
+}
+
+func TestAttachmentsSendAndDownload(t *testing.T) {
+	srv := newFakeEnv(t)
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", SpaceType: "SPACE"}, bob)
+	shot := srv.AddMessage("spaces/ROOM", fakechat.Message{Sender: bob, Attachments: []fakechat.Attachment{
+		{ContentName: "screenshot.png", ContentType: "image/png", Data: []byte("png-bytes")},
+		{ContentName: "design doc", ContentType: "application/vnd.google-apps.document", DriveFileID: "drive-1"},
+	}})
+
+	out, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"list", "--space", "ROOM"})
+	})
+	if err != nil || !strings.Contains(out, "Bob: (attachment: screenshot.png, design doc)") {
+		t.Fatalf("file-only message should name its attachments, got %q (%v)", out, err)
+	}
+
+	dir := t.TempDir()
+	var dl struct {
+		Count   int                 `json:"count"`
+		Files   []DownloadedFile    `json:"files"`
+		Skipped []SkippedAttachment `json:"skipped"`
+	}
+	runJSON(t, &dl, func() error {
+		return runChat(context.Background(), []string{"attachments", "download", "--message", shot.Name, "--dir", dir, "--json"})
+	})
+	if dl.Count != 1 || len(dl.Skipped) != 1 || dl.Files[0].Path != filepath.Join(dir, "screenshot.png") {
+		t.Fatalf("unexpected download result: %+v", dl)
+	}
+	if b, _ := os.ReadFile(dl.Files[0].Path); string(b) != "png-bytes" {
+		t.Fatalf("downloaded content = %q", b)
+	}
+	if _, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"attachments", "download", "--message", shot.Name, "--dir", dir})
+	}); exitCode(err) != 2 {
+		t.Fatalf("existing file without --force should be a usage error, got %v", err)
+	}
+
+	logFile := filepath.Join(t.TempDir(), "deploy.log")
+	if err := os.WriteFile(logFile, []byte("all green\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var sent struct {
+		Message gchat.ChatMessage `json:"message"`
+	}
+	runJSON(t, &sent, func() error {
+		return runChat(context.Background(), []string{"send", "--space", "ROOM", "--text", "log attached", "--file", logFile, "--json"})
+	})
+	if len(sent.Message.Attachment) != 1 || sent.Message.Attachment[0].ContentName != "deploy.log" || sent.Message.Attachment[0].Source != gchat.AttachmentUploaded {
+		t.Fatalf("sent message should carry the upload: %+v", sent.Message)
+	}
+	got := srv.Messages("spaces/ROOM")
+	if a := got[len(got)-1].Attachments; len(a) != 1 || string(a[0].Data) != "all green\n" || a[0].ContentType == "" {
+		t.Fatalf("uploaded content not stored: %+v", a)
+	}
+
+	runJSON(t, &sent, func() error {
+		return runChat(context.Background(), []string{"send", "--space", "ROOM", "--file", logFile, "--json"})
+	})
+	if sent.Message.Text != "" || len(sent.Message.Attachment) != 1 || sent.Message.Attachment[0].ContentName != "deploy.log" {
+		t.Fatalf("file-only send should carry just the upload: %+v", sent.Message)
+	}
+	if _, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"send", "--space", "ROOM"})
+	}); exitCode(err) != 2 {
+		t.Fatalf("send without text or file should be a usage error, got %v", err)
+	}
+
+	blocked := filepath.Join(t.TempDir(), "setup.exe")
+	if err := os.WriteFile(blocked, []byte("MZ"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	before := len(srv.Requests())
+	if _, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"send", "--space", "ROOM", "--text", "x", "--file", blocked})
+	}); exitCode(err) != 2 || len(srv.Requests()) != before {
+		t.Fatalf("blocked file type should fail before any request, got %v", err)
+	}
+}
+
+func TestChatSendCards(t *testing.T) {
+	srv := newFakeEnv(t)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", SpaceType: "SPACE"})
+
+	var preview struct {
+		DryRun      bool                     `json:"dry_run"`
+		Destination string                   `json:"destination"`
+		Request     gchat.SendMessageRequest `json:"request"`
+	}
+	runJSON(t, &preview, func() error {
+		return runChat(context.Background(), []string{"send", "--space", "ROOM", "--text", "Deploy finished",
+			"--card", "--title", "Deploy #42", "--subtitle", "api", "--kv", "env=prod", "--kv", "version=1.4.2",
+			"--button", "Logs=https://ci.example.com/run/42?tab=logs", "--dry-run", "--json"})
+	})
+	if !preview.DryRun || preview.Destination != "spaces/ROOM" || len(preview.Request.CardsV2) != 1 {
+		t.Fatalf("unexpected dry run: %+v", preview)
+	}
+	card := string(preview.Request.CardsV2[0].Card)
+	for _, want := range []string{`"title":"Deploy #42"`, `"topLabel":"version"`, `"url":"https://ci.example.com/run/42?tab=logs"`} {
+		if !strings.Contains(card, want) {
+			t.Fatalf("built card missing %s: %s", want, card)
+		}
+	}
+	preview.Request = gchat.SendMessageRequest{}
+	runJSON(t, &preview, func() error {
+		return runChat(context.Background(), []string{"send", "--space", "ROOM", "--card", "--title", "Card only", "--dry-run", "--json"})
+	})
+	if !preview.DryRun || preview.Request.Text != "" || len(preview.Request.CardsV2) != 1 {
+		t.Fatalf("dry run without --text should preview just the card: %+v", preview)
+	}
+	if n := len(srv.Requests()); n != 0 {
+		t.Fatalf("dry run made %d requests", n)
+	}
+
+	cardFile := filepath.Join(t.TempDir(), "card.json")
+	if err := os.WriteFile(cardFile, []byte(`{"cardsV2":[{"cardId":"status","card":{"header":{"title":"Status"},"sections":[{"widgets":[{"textParagraph":{"text":"<b>green</b>"}}]}]}}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var sent struct {
+		Message gchat.ChatMessage `json:"message"`
+	}
+	runJSON(t, &sent, func() error {
+		return runChat(context.Background(), []string{"send", "--space", "ROOM", "--text", "status", "--card-file", cardFile, "--json"})
+	})
+	if len(sent.Message.CardsV2) != 1 || sent.Message.CardsV2[0].CardID != "status" {
+		t.Fatalf("card not sent: %+v", sent.Message)
+	}
+	var status struct {
+		Sections []struct {
+			Widgets []struct {
+				TextParagraph struct {
+					Text string `json:"text"`
+				} `json:"textParagraph"`
+			} `json:"widgets"`
+		} `json:"sections"`
+	}
+	if err := json.Unmarshal(sent.Message.CardsV2[0].Card, &status); err != nil || status.Sections[0].Widgets[0].TextParagraph.Text != "<b>green</b>" {
+		t.Fatalf("card content changed in transit: %s (%v)", sent.Message.CardsV2[0].Card, err)
+	}
+
+	sent.Message = gchat.ChatMessage{}
+	runJSON(t, &sent, func() error {
+		return runChat(context.Background(), []string{"send", "--space", "ROOM", "--card-file", cardFile, "--json"})
+	})
+	if sent.Message.Text != "" || len(sent.Message.CardsV2) != 1 || sent.Message.CardsV2[0].CardID != "status" {
+		t.Fatalf("card-only send should carry just the card: %+v", sent.Message)
+	}
+
+	if err := os.WriteFile(cardFile, []byte(`{"header":{"title":"x"},"sections":[{"widgets":[{"decoratdText":{"text":"x"}}]}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	before := len(srv.Requests())
+	for _, args := range [][]string{
+		{"send", "--space", "ROOM", "--text", "x", "--card-file", cardFile},
+		{"send", "--space", "ROOM", "--text", "x", "--title", "no --card"},
+		{"send", "--space", "ROOM", "--text", "x", "--card", "--title", "t", "--button", "Logs=ftp://x"},
+		{"send", "--space", "ROOM", "--text", "x", "--card", "--title", "t", "--card-file", cardFile},
+	} {
+		if _, err := captureStdout(t, func() error { return runChat(context.Background(), args) }); exitCode(err) != 2 {
+			t.Fatalf("%v should be a usage error, got %v", args, err)
+		}
+	}
+	if len(srv.Requests()) != before {
+		t.Fatal("invalid cards should be rejected before any request")
+	}
+}
+
+func TestChatSendRefreshesToken(t *testing.T) {
+	srv := newFakeEnv(t)
+	peer := fakechat.User{Name: "users/peer@example.com", Type: "HUMAN"}
+	seedDM(srv, "spaces/DMP", "users/me-1", peer)
+	srv.ExpireTokens()
+	st, _ := loadToken()
+	st.Token.Expiry = time.Now().Add(-time.Minute)
+	if err := saveToken(st); err != nil {
+		t.Fatal(err)
+	}
+
+	var out struct {
+		Space   string            `json:"space"`
+		Message gchat.ChatMessage `json:"message"`
+	}
+	runJSON(t, &out, func() error {
+		return runChat(context.Background(), []string{"send", "--email", "peer@example.com", "--text", "hello", "--json"})
+	})
+	if out.Space != "spaces/DMP" || out.Message.Text != "hello" {
+		t.Fatalf("unexpected send output: %+v", out)
+	}
+	if msgs := srv.Messages("spaces/DMP"); len(msgs) != 1 || msgs[0].Text != "hello" {
+		t.Fatalf("message not stored by fake server: %+v", msgs)
+	}
+	saved, err := loadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Token.AccessToken == fakechat.DefaultAccessToken {
+		t.Fatalf("expected refreshed access token to be persisted")
+	}
+}
+
+func TestAPIErrorEnvelopeDecoding(t *testing.T) {
+	srv := newFakeEnv(t)
+	srv.AddFault(fakechat.Fault{
+		Method:       http.MethodGet,
+		Path:         "/v1/spaces",
+		Status:       http.StatusForbidden,
+		GoogleStatus: "PERMISSION_DENIED",
+		Message:      "Request had insufficient authentication scopes.",
+	})
+	_, err := captureStdout(t, func() error { return runChat(context.Background(), []string{"spaces", "list", "--json"}) })
+	if err == nil || !strings.Contains(err.Error(), "insufficient auth scopes") {
+		t.Fatalf("expected insufficient scopes error, got %v", err)
+	}
+	if code := errorCode(err); code != gchat.CodeInsufficientScopes || exitCode(err) != 4 {
+		t.Fatalf("got code %s exit %d, want INSUFFICIENT_SCOPES/4", code, exitCode(err))
+	}
+}
+
+func TestAmbiguousNameJSONError(t *testing.T) {
+	srv := newFakeEnv(t)
+	me := "users/me-1"
+	srv.SetCurrentUser(me)
+	seedDM(srv, "spaces/DM1", me, fakechat.User{Name: "users/simon-a", DisplayName: "Simon A", Type: "HUMAN"})
+	seedDM(srv, "spaces/DM2", me, fakechat.User{Name: "users/simon-b", DisplayName: "Simon B", Type: "HUMAN"})
+
+	_, err := captureStdout(t, func() error { return runChat(context.Background(), []string{"recent", "--name", "simon", "--json"}) })
+	if err == nil {
+		t.Fatal("expected ambiguity error")
+	}
+	stdout, _ := captureStdout(t, func() error { return printJSONError(err) })
+	var out struct {
+		OK    bool `json:"ok"`
+		Error struct {
+			Code       string               `json:"code"`
+			ExitCode   int                  `json:"exit_code"`
+			Candidates []gchat.DMResolution `json:"candidates"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("decode %q: %v", stdout, err)
+	}
+	if out.OK || out.Error.Code != "AMBIGUOUS_NAME" || out.Error.ExitCode != 6 || len(out.Error.Candidates) != 2 {
+		t.Fatalf("unexpected JSON error: %s", stdout)
+	}
+}
+
+func TestChatListReplaysCassette(t *testing.T) {
+	t.Setenv("GCHATCTL_CONFIG_DIR", t.TempDir())
+	t.Setenv("GCHATCTL_JSON_ENVELOPE", "")
+
+	var out struct {
+		Count    int                 `json:"count"`
+		Messages []gchat.ChatMessage `json:"messages"`
+	}
+	runJSON(t, &out, func() error {
+		return runChat(context.Background(), []string{"list", "--space", "ROOM", "--limit", "2", "--replay", "testdata/cassettes/chat-list", "--json"})
+	})
+	if out.Count != 2 {
+		t.Fatalf("expected 2 replayed messages, got %d", out.Count)
+	}
+	if out.Messages[0].Sender.DisplayName != "Simon" || out.Messages[1].Sender.DisplayName != "Ada" {
+		t.Fatalf("sender enrichment from replayed memberships failed: %+v", out.Messages)
+	}
+}
+
+func TestRecordWritesScrubbedCassette(t *testing.T) {
+	srv := newFakeEnv(t)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", DisplayName: "Room", SpaceType: "SPACE"})
+	dir := t.TempDir()
+
+	if _, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"spaces", "list", "--record", dir, "--json"})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	var out struct {
+		Count int `json:"count"`
+	}
+	runJSON(t, &out, func() error {
+		return runChat(context.Background(), []string{"spaces", "list", "--replay", dir, "--json"})
+	})
+	if out.Count != 1 {
+		t.Fatalf("expected replayed space, got %d", out.Count)
+	}
+}
+
+func TestInboxReplaysItsRecording(t *testing.T) {
+	srv := newFakeEnv(t)
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", SpaceType: "SPACE"}, bob)
+	srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "ping", Sender: fakechat.User{Name: bob.Name}})
+	dir := t.TempDir()
+
+	type result struct {
+		Count    int             `json:"count"`
+		Complete bool            `json:"complete"`
+		Messages []PolledMessage `json:"messages"`
+	}
+	var live result
+	runJSON(t, &live, func() error {
+		return runChat(context.Background(), []string{"inbox", "--since", "1h", "--record", dir, "--json"})
+	})
+	srv.Close()
+
+	// The createTime filter is derived from the clock, so the replayed run
+	// asks for a different cutoff than the recorded one.
+	time.Sleep(10 * time.Millisecond)
+	var replayed result
+	runJSON(t, &replayed, func() error {
+		return runChat(context.Background(), []string{"inbox", "--since", "1h", "--replay", dir, "--json"})
+	})
+	if live.Count != 1 || replayed.Count != 1 || !replayed.Complete || replayed.Messages[0].Text != "ping" {
+		t.Fatalf("replay should match the recording: live %+v, replayed %+v", live, replayed)
+	}
+}
+
+func TestInboxReportsRetries(t *testing.T) {
+	srv := newFakeEnv(t)
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", SpaceType: "SPACE"}, bob)
+	srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "hello", Sender: fakechat.User{Name: bob.Name}})
+	srv.AddFault(fakechat.Fault{
+		Path:         "/v1/spaces/ROOM/messages",
+		Status:       http.StatusTooManyRequests,
+		GoogleStatus: "RESOURCE_EXHAUSTED",
+		Message:      "Quota exceeded",
+		Header:       http.Header{"Retry-After": {"0"}},
+		Times:        1,
+	})
+
+	var out struct {
+		Count   int `json:"count"`
+		Retries int `json:"retries"`
+	}
+	runJSON(t, &out, func() error { return runChat(context.Background(), []string{"inbox", "--since", "1h", "--json"}) })
+	if out.Count != 1 {
+		t.Fatalf("rate-limited space should be retried, not dropped; got %d messages", out.Count)
+	}
+	if out.Retries != 1 {
+		t.Fatalf("expected retries=1 in JSON output, got %d", out.Retries)
+	}
+}
+
+func TestInboxFanOutKeepsOtherSpaces(t *testing.T) {
+	srv := newFakeEnv(t)
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	for _, name := range []string{"spaces/A", "spaces/B", "spaces/C", "spaces/D"} {
+		srv.AddSpace(fakechat.Space{Name: name, SpaceType: "SPACE"}, bob)
+		srv.AddMessage(name, fakechat.Message{Text: "hi from " + name, Sender: fakechat.User{Name: bob.Name}})
+	}
+	srv.AddFault(fakechat.Fault{Path: "/v1/spaces/B/messages", Status: http.StatusForbidden, GoogleStatus: "PERMISSION_DENIED", Message: "denied"})
+
+	var out struct {
+		Count        int  `json:"count"`
+		FailedSpaces int  `json:"failed_spaces"`
+		Spaces       int  `json:"spaces"`
+		Complete     bool `json:"complete"`
+	}
+	runJSON(t, &out, func() error {
+		return runChat(context.Background(), []string{"inbox", "--since", "1h", "--concurrency", "3", "--json"})
+	})
+	if out.Spaces != 4 || out.Count != 3 || out.FailedSpaces != 1 || out.Complete {
+		t.Fatalf("got spaces=%d count=%d failed_spaces=%d complete=%v, want 4/3/1/false", out.Spaces, out.Count, out.FailedSpaces, out.Complete)
+	}
+}
+
+func TestInboxStopsAtRequestBudget(t *testing.T) {
+	srv := newFakeEnv(t)
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	for _, name := range []string{"spaces/A", "spaces/B", "spaces/C", "spaces/D"} {
+		srv.AddSpace(fakechat.Space{Name: name, SpaceType: "SPACE"}, bob)
+		srv.AddMessage(name, fakechat.Message{Text: "hi from " + name, Sender: fakechat.User{Name: bob.Name}})
+	}
+
+	var out struct {
+		Count           int    `json:"count"`
+		FailedSpaces    int    `json:"failed_spaces"`
+		BudgetExhausted bool   `json:"budget_exhausted"`
+		Warning         string `json:"warning"`
+	}
+	// spaces.list + users/me + (messages + members) for two spaces.
+	runJSON(t, &out, func() error {
+		return runChat(context.Background(), []string{"inbox", "--since", "1h", "--concurrency", "1", "--max-requests", "6", "--json"})
+	})
+	if out.Count != 2 || out.FailedSpaces != 2 || !out.BudgetExhausted {
+		t.Fatalf("got count=%d failed_spaces=%d budget_exhausted=%v, want 2/2/true", out.Count, out.FailedSpaces, out.BudgetExhausted)
+	}
+	if !strings.Contains(out.Warning, "partial") {
+		t.Fatalf("expected partial-results warning, got %q", out.Warning)
+	}
+	if got := len(srv.Requests()); got != 6 {
+		t.Fatalf("expected 6 API requests, got %d", got)
+	}
+}
+
+func TestRecentByNameOutOfBudgetIsNotNotFound(t *testing.T) {
+	srv := newFakeEnv(t)
+	me := "users/me-1"
+	srv.SetCurrentUser(me)
+	seedDM(srv, "spaces/DMA", me, fakechat.User{Name: "users/alice-1", DisplayName: "Alice", Type: "HUMAN"})
+	seedDM(srv, "spaces/DMS", me, fakechat.User{Name: "users/simon-1", DisplayName: "Simon", Type: "HUMAN"})
+
+	// spaces.list + users/me + members of one DM.
+	_, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"recent", "--name", "simon", "--concurrency", "1", "--max-requests", "3"})
+	})
+	if errorCode(err) != gchat.CodeBudgetExhausted || exitCode(err) == 5 {
+		t.Fatalf("a scan cut short by the budget should not look like an unknown name, got %v (%s)", err, errorCode(err))
+	}
+}
+
+func TestInboxTimeoutPrintsPartialResults(t *testing.T) {
+	srv := newFakeEnv(t)
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	for _, name := range []string{"spaces/FAST", "spaces/SLOW"} {
+		srv.AddSpace(fakechat.Space{Name: name, SpaceType: "SPACE"}, bob)
+		srv.AddMessage(name, fakechat.Message{Text: "hi from " + name, Sender: fakechat.User{Name: bob.Name}})
+	}
+	srv.SetDelay("/v1/spaces/SLOW/messages", 10*time.Second)
+
+	var out struct {
+		Count       int    `json:"count"`
+		Interrupted bool   `json:"interrupted"`
+		Warning     string `json:"warning"`
+	}
+	runJSON(t, &out, func() error {
+		return runChat(context.Background(), []string{"inbox", "--since", "1h", "--timeout", "500ms", "--json"})
+	})
+	if out.Count != 1 || !out.Interrupted || !strings.Contains(out.Warning, "--timeout") {
+		t.Fatalf("got count=%d interrupted=%v warning=%q, want 1/true/--timeout warning", out.Count, out.Interrupted, out.Warning)
+	}
+}
+
+func TestPollStopsOnCancelAndSavesToken(t *testing.T) {
+	srv := newFakeEnv(t)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", SpaceType: "SPACE"})
+	srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "hello"})
+	srv.ExpireTokens()
+	st, _ := loadToken()
+	st.Token.Expiry = time.Now().Add(-time.Minute)
+	if err := saveToken(st); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+	start := time.Now()
+	stdout, err := captureStdout(t, func() error {
+		return runChat(ctx, []string{"poll", "--space", "spaces/ROOM", "--iterations", "5", "--interval", "1h", "--json"})
+	})
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("poll sleep did not abort on cancellation")
+	}
+	if lines := strings.Count(strings.TrimSpace(stdout), "\n") + 1; lines != 1 {
+		t.Fatalf("expected one iteration of output, got %d: %s", lines, stdout)
+	}
+	saved, _ := loadToken()
+	if saved.Token.AccessToken == fakechat.DefaultAccessToken {
+		t.Fatal("refreshed token was not persisted after cancellation")
+	}
+}
+
+func TestDebugFileTracesRequestsWithoutCredentials(t *testing.T) {
+	srv := newFakeEnv(t)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", DisplayName: "Room", SpaceType: "SPACE"})
+	srv.ExpireTokens()
+	st, _ := loadToken()
+	st.Token.Expiry = time.Now().Add(-time.Minute)
+	if err := saveToken(st); err != nil {
+		t.Fatal(err)
+	}
+	logPath := t.TempDir() + "/debug.log"
+
+	if _, err := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"spaces", "list", "--debug-bodies", "--debug-file", logPath, "--json"})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(b)
+	for _, want := range []string{"POST " + srv.TokenURL(), "GET " + srv.APIBaseURL() + "/spaces", "endpoint=spaces.list", "status=200", `"displayName":"Room"`} {
+		if !strings.Contains(log, want) {
+			t.Fatalf("debug log missing %q:\n%s", want, log)
+		}
+	}
+	if strings.Contains(log, fakechat.DefaultRefreshToken) || strings.Contains(log, "test-client") {
+		t.Fatalf("debug log leaked credentials:\n%s", log)
+	}
+}
+
+func TestRecentStatsReportsNameResolutionCost(t *testing.T) {
+	srv := newFakeEnv(t)
+	me := "users/me-1"
+	simon := fakechat.User{Name: "users/simon-1", DisplayName: "Simon Example", Type: "HUMAN"}
+	seedDM(srv, "spaces/DMS", me, simon)
+	seedDM(srv, "spaces/DMA", me, fakechat.User{Name: "users/alice-1", DisplayName: "Alice", Type: "HUMAN"})
+	t.Setenv("GCHATCTL_JSON_ENVELOPE", "1")
+
+	var out struct {
+		OK   bool `json:"ok"`
+		Meta struct {
+			Calls     int64            `json:"calls"`
+			Endpoints map[string]int64 `json:"endpoints"`
+			Pages     int64            `json:"pages"`
+			Bytes     int64            `json:"bytes"`
+			Retries   *int64           `json:"retries"`
+			WallMS    *int64           `json:"wall_ms"`
+		} `json:"meta"`
+	}
+	runJSON(t, &out, func() error { return runChat(context.Background(), []string{"recent", "--name", "simon", "--json"}) })
+	if !out.OK || out.Meta.Retries == nil || out.Meta.WallMS == nil {
+		t.Fatalf("envelope should carry meta: %+v", out)
+	}
+	var total int64
+	for _, n := range out.Meta.Endpoints {
+		total += n
+	}
+	if out.Meta.Calls == 0 || total != out.Meta.Calls || out.Meta.Bytes == 0 {
+		t.Fatalf("inconsistent usage: %+v", out.Meta)
+	}
+	if out.Meta.Endpoints[gchat.EndpointSpacesList] == 0 || out.Meta.Endpoints[gchat.EndpointMessagesList] != 1 {
+		t.Fatalf("expected the space scan and one message listing: %v", out.Meta.Endpoints)
+	}
+	if got := int64(srv.CountRequests(http.MethodGet, "/v1/spaces")); out.Meta.Endpoints[gchat.EndpointSpacesList] != got {
+		t.Fatalf("spaces.list calls = %d, server saw %d", out.Meta.Endpoints[gchat.EndpointSpacesList], got)
+	}
+}
+
+func TestInboxScansMostActiveSpacesFirst(t *testing.T) {
+	srv := newFakeEnv(t)
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	now := time.Now().UTC()
+	srv.AddSpace(fakechat.Space{Name: "spaces/QUIET", SpaceType: "SPACE", LastActiveTime: now.Add(-48 * time.Hour)}, bob)
+	srv.AddSpace(fakechat.Space{Name: "spaces/SLOW", SpaceType: "SPACE"}, bob)
+	srv.AddSpace(fakechat.Space{Name: "spaces/BUSY", SpaceType: "SPACE"}, bob)
+	srv.AddMessage("spaces/SLOW", fakechat.Message{Text: "earlier", Sender: fakechat.User{Name: bob.Name}, CreateTime: now.Add(-30 * time.Minute)})
+	srv.AddMessage("spaces/BUSY", fakechat.Message{Text: "old", Sender: fakechat.User{Name: bob.Name}, CreateTime: now.Add(-3 * time.Hour)})
+	srv.AddMessage("spaces/BUSY", fakechat.Message{Text: "latest", Sender: fakechat.User{Name: bob.Name}, CreateTime: now.Add(-time.Minute)})
+
+	var out struct {
+		Count    int             `json:"count"`
+		Spaces   int             `json:"spaces"`
+		Messages []PolledMessage `json:"messages"`
+	}
+	runJSON(t, &out, func() error {
+		return runChat(context.Background(), []string{"inbox", "--since", "1h", "--space-limit", "1", "--json"})
+	})
+	if out.Spaces != 1 || out.Count != 1 || out.Messages[0].Space != "spaces/BUSY" || out.Messages[0].Text != "latest" {
+		t.Fatalf("expected only the most active space to be scanned: %+v", out)
+	}
+	if srv.CountRequests(http.MethodGet, "/v1/spaces/QUIET/messages") != 0 || srv.CountRequests(http.MethodGet, "/v1/spaces/SLOW/messages") != 0 {
+		t.Fatal("less active spaces should not be scanned")
+	}
+	for _, r := range srv.Requests() {
+		if r.Path == "/v1/spaces/BUSY/messages" && !strings.HasPrefix(r.Query.Get("filter"), "createTime > ") {
+			t.Fatalf("cutoff not pushed down: %v", r.Query)
+		}
+	}
+}
+
+func TestInboxSeesActivityNewerThanTheCachedListing(t *testing.T) {
+	srv := newFakeEnv(t)
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	now := time.Now().UTC()
+	srv.AddSpace(fakechat.Space{Name: "spaces/QUIET", SpaceType: "SPACE"}, bob)
+	srv.AddMessage("spaces/QUIET", fakechat.Message{Text: "long ago", Sender: fakechat.User{Name: bob.Name}, CreateTime: now.Add(-48 * time.Hour)})
+
+	type result struct {
+		Count    int             `json:"count"`
+		Messages []PolledMessage `json:"messages"`
+	}
+	inbox := func() result {
+		t.Helper()
+		var out result
+		runJSON(t, &out, func() error { return runChat(context.Background(), []string{"inbox", "--since", "10m", "--json"}) })
+		return out
+	}
+	// The first run caches a listing in which QUIET is inactive.
+	if out := inbox(); out.Count != 0 {
+		t.Fatalf("nothing is new yet: %+v", out)
+	}
+
+	srv.AddMessage("spaces/QUIET", fakechat.Message{Text: "just now", Sender: fakechat.User{Name: bob.Name}, CreateTime: now.Add(-time.Minute)})
+	srv.AddSpace(fakechat.Space{Name: "spaces/NEW", SpaceType: "SPACE"}, bob)
+	srv.AddMessage("spaces/NEW", fakechat.Message{Text: "hello", Sender: fakechat.User{Name: bob.Name}, CreateTime: now.Add(-time.Minute)})
+	out := inbox()
+	got := map[string]bool{}
+	for _, m := range out.Messages {
+		got[m.Space] = true
+	}
+	if out.Count != 2 || !got["spaces/QUIET"] || !got["spaces/NEW"] {
+		t.Fatalf("inbox should rank spaces by their current activity: %+v", out)
+	}
+}
+
+func TestInboxProgressJSON(t *testing.T) {
+	srv := newFakeEnv(t)
+	bob := fakechat.User{Name: "users/bob-1", DisplayName: "Bob", Type: "HUMAN"}
+	for _, name := range []string{"spaces/A", "spaces/B", "spaces/C"} {
+		srv.AddSpace(fakechat.Space{Name: name, SpaceType: "SPACE"}, bob)
+		srv.AddMessage(name, fakechat.Message{Text: "hi", Sender: fakechat.User{Name: bob.Name}})
+	}
+	srv.SetCurrentUser("users/me-1")
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stderr
+	os.Stderr = w
+	_, runErr := captureStdout(t, func() error {
+		return runChat(context.Background(), []string{"inbox", "--since", "1h", "--progress=json", "--json"})
+	})
+	os.Stderr = orig
+	_ = w.Close()
+	stderr, _ := io.ReadAll(r)
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+
+	var events []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(string(stderr)), "\n") {
+		var ev map[string]any
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("progress line is not JSON: %q", line)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected one event per space, got %d: %s", len(events), stderr)
+	}
+	last := events[2]
+	if last["type"] != "progress" || last["command"] != "chat inbox" || last["stage"] != "read messages" || last["done"] != 3.0 || last["total"] != 3.0 || last["found"] != 3.0 {
+		t.Fatalf("unexpected final event: %v", last)
+	}
+}
+
+func TestAPIPaginatesAndMergesPages(t *testing.T) {
+	srv := newFakeEnv(t)
+	for _, name := range []string{"spaces/A", "spaces/B", "spaces/C"} {
+		srv.AddSpace(fakechat.Space{Name: name, SpaceType: "SPACE"})
+	}
+
+	var out struct {
+		Spaces        []gchat.ChatSpace `json:"spaces"`
+		NextPageToken *string           `json:"nextPageToken"`
+	}
+	runJSON(t, &out, func() error {
+		return runAPI(context.Background(), []string{"spaces", "--field", "pageSize=1", "--paginate"})
+	})
+	if len(out.Spaces) != 3 || out.NextPageToken != nil {
+		t.Fatalf("expected 3 merged spaces and no page token: %+v", out)
+	}
+	if got := srv.CountRequests(http.MethodGet, "/v1/spaces"); got != 3 {
+		t.Fatalf("expected 3 page requests, got %d", got)
+	}
+}
+
+func TestAPIPostsFieldsAsJSONBody(t *testing.T) {
+	srv := newFakeEnv(t)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", SpaceType: "SPACE"})
+
+	var out gchat.ChatMessage
+	runJSON(t, &out, func() error {
+		return runAPI(context.Background(), []string{"POST", "/v1/spaces/ROOM/messages", "--field", "text=hello"})
+	})
+	if out.Text != "hello" {
+		t.Fatalf("unexpected response: %+v", out)
+	}
+	if msgs := srv.Messages("spaces/ROOM"); len(msgs) != 1 || msgs[0].Text != "hello" {
+		t.Fatalf("message not created: %+v", msgs)
+	}
+
+	_, err := captureStdout(t, func() error {
+		return runAPI(context.Background(), []string{"GET", "spaces/MISSING/messages"})
+	})
+	if gchat.ErrorCodeOf(err) != gchat.CodeNotFound {
+		t.Fatalf("expected NOT_FOUND API error, got %v", err)
+	}
+	_, err = captureStdout(t, func() error {
+		return runAPI(context.Background(), []string{"https://example.com/v1/spaces"})
+	})
+	if gchat.ErrorCodeOf(err) != gchat.CodeValidation {
+		t.Fatalf("URLs outside the API root must be refused, got %v", err)
+	}
+}
+
+func TestAuthTokenRefreshesAndPersists(t *testing.T) {
+	srv := newFakeEnv(t)
+	srv.ExpireTokens()
+	st, _ := loadToken()
+	st.Token.Expiry = time.Now().Add(time.Minute)
+	if err := saveToken(st); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, err := captureStdout(t, func() error { return runAuth(context.Background(), []string{"token"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := strings.TrimSpace(stdout)
+	if tok == "" || tok == fakechat.DefaultAccessToken {
+		t.Fatalf("expected a refreshed token, got %q", tok)
+	}
+	saved, err := loadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Token.AccessToken != tok {
+		t.Fatalf("refreshed token not persisted: %q", saved.Token.AccessToken)
+	}
+}
+
+func TestSpacesAndMembersServedFromCache(t *testing.T) {
+	srv := newFakeEnv(t)
+	me := "users/me-1"
+	srv.SetCurrentUser(me)
+	seedDM(srv, "spaces/DMS", me, fakechat.User{Name: "users/simon-1", DisplayName: "Simon Example", Type: "HUMAN"})
+	t.Setenv("GCHATCTL_JSON_ENVELOPE", "1")
+
+	type result struct {
+		Meta struct {
+			Endpoints map[string]int64 `json:"endpoints"`
+			CacheHits int64            `json:"cache_hits"`
+		} `json:"meta"`
+	}
+	run := func(extra ...string) result {
+		t.Helper()
+		var out result
+		args := append([]string{"spaces", "dm", "--json"}, extra...)
+		runJSON(t, &out, func() error { return runChat(context.Background(), args) })
+		return out
+	}
+
+	first := run()
+	if first.Meta.Endpoints[gchat.EndpointSpacesList] == 0 || first.Meta.CacheHits != 0 {
+		t.Fatalf("first run should hit the API: %+v", first.Meta)
+	}
+	before := srv.CountRequests(http.MethodGet, "/v1/spaces")
+	second := run()
+	if second.Meta.CacheHits == 0 || second.Meta.Endpoints[gchat.EndpointSpacesList] != 0 || second.Meta.Endpoints[gchat.EndpointMembersList] != 0 {
+		t.Fatalf("second run should be served from cache: %+v", second.Meta)
+	}
+	if got := srv.CountRequests(http.MethodGet, "/v1/spaces"); got != before {
+		t.Fatalf("server saw %d new spaces.list calls", got-before)
+	}
+
+	if out := run("--refresh-cache"); out.Meta.Endpoints[gchat.EndpointSpacesList] == 0 {
+		t.Fatalf("--refresh-cache should refetch: %+v", out.Meta)
+	}
+	if out := run("--no-cache"); out.Meta.Endpoints[gchat.EndpointSpacesList] == 0 || out.Meta.CacheHits != 0 {
+		t.Fatalf("--no-cache should bypass the cache: %+v", out.Meta)
+	}
+
+	var cleared struct {
+		Data struct {
+			Cleared int `json:"cleared"`
+		} `json:"data"`
+	}
+	runJSON(t, &cleared, func() error { return runCache(context.Background(), []string{"clear", "--json"}) })
+	if cleared.Data.Cleared != 1 {
+		t.Fatalf("expected one cache file removed, got %d", cleared.Data.Cleared)
+	}
+	if out := run(); out.Meta.CacheHits != 0 {
+		t.Fatalf("cleared cache should miss: %+v", out.Meta)
+	}
+}
+
+func TestRecentByNameUsesDMIndex(t *testing.T) {
+	srv := newFakeEnv(t)
+	me := "users/me-1"
+	srv.SetCurrentUser(me)
+	seedDM(srv, "spaces/DMS", me, fakechat.User{Name: "users/simon-1", DisplayName: "Simon Example", Type: "HUMAN"})
+	seedDM(srv, "spaces/DMA", me, fakechat.User{Name: "users/alice-1", DisplayName: "Alice", Type: "HUMAN"})
+	t.Setenv("GCHATCTL_JSON_ENVELOPE", "1")
+
+	var reindexed struct {
+		Data struct {
+			Indexed int `json:"indexed"`
+		} `json:"data"`
+	}
+	runJSON(t, &reindexed, func() error { return runChat(context.Background(), []string{"spaces", "dm", "--reindex", "--json"}) })
+	if reindexed.Data.Indexed != 2 {
+		t.Fatalf("expected both DMs indexed, got %d", reindexed.Data.Indexed)
+	}
+
+	// --refresh-cache rules out the cache: only the index can skip reading
+	// the other DM.
+	var out struct {
+		Data struct {
+			Space string `json:"space"`
+		} `json:"data"`
+	}
+	reads := srv.CountRequests(http.MethodGet, "/v1/spaces/DMA/members")
+	runJSON(t, &out, func() error {
+		return runChat(context.Background(), []string{"recent", "--name", "simon example", "--refresh-cache", "--json"})
+	})
+	if out.Data.Space != "spaces/DMS" {
+		t.Fatalf("resolved to %q", out.Data.Space)
+	}
+	if got := srv.CountRequests(http.MethodGet, "/v1/spaces/DMA/members"); got != reads {
+		t.Fatalf("indexed name lookup should not read other DMs, saw %d member listings", got-reads)
+	}
+}
+
+func TestCompletionSuggestsCachedSpacesNamesAndUsers(t *testing.T) {
+	srv := newFakeEnv(t)
+	me := "users/me-1"
+	srv.SetCurrentUser(me)
+	seedDM(srv, "spaces/DMS", me, fakechat.User{Name: "users/simon-1", DisplayName: "Simon Example", Type: "HUMAN"})
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", DisplayName: "Team Room", SpaceType: "SPACE"},
+		fakechat.User{Name: me, Type: "HUMAN"},
+		fakechat.User{Name: "users/carol-1", DisplayName: "Carol", Type: "HUMAN"},
+	)
+	for _, args := range [][]string{{"spaces", "dm", "--json"}, {"spaces", "members", "--space", "spaces/ROOM", "--json"}} {
+		if _, err := captureStdout(t, func() error { return runChat(context.Background(), args) }); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+
+	complete := func(words ...string) string {
+		t.Helper()
+		out, err := captureStdout(t, func() error { return run(context.Background(), append([]string{"__complete", "--"}, words...)) })
+		if err != nil {
+			t.Fatalf("__complete %v: %v", words, err)
+		}
+		return out
+	}
+	before := srv.CountRequests(http.MethodGet, "/v1/spaces")
+
+	if got := complete("chat", "list", "--space", ""); !strings.Contains(got, "spaces/ROOM\tTeam Room\n") || !strings.Contains(got, "spaces/DMS\tDM with Simon Example\n") {
+		t.Fatalf("--space completions:\n%s", got)
+	}
+	if got := complete("chat", "recent", "--name=si"); got != "--name=Simon Example\tusers/simon-1\n" {
+		t.Fatalf("--name completions:\n%s", got)
+	}
+	if got := complete("chat", "send", "--user", "users/c"); got != "users/carol-1\tCarol\n" {
+		t.Fatalf("--user completions:\n%s", got)
+	}
+	if got := complete("chat", "sp"); !strings.HasPrefix(got, "spaces\t") {
+		t.Fatalf("subcommand completions:\n%s", got)
+	}
+	if got := complete("--json", "chat", "send", "--te"); got != "--text\tmessage text to send\n" {
+		t.Fatalf("flag completions:\n%s", got)
+	}
+	if got := complete(""); strings.Contains(got, "__complete") {
+		t.Fatalf("hidden command offered:\n%s", got)
+	}
+	if after := srv.CountRequests(http.MethodGet, "/v1/spaces"); after != before {
+		t.Fatalf("completion called the API (%d -> %d list calls)", before, after)
+	}
+}
+
+func TestGlobalFlagsBeforeSubcommand(t *testing.T) {
+	srv := newFakeEnv(t)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", DisplayName: "Room", SpaceType: "SPACE"})
+
+	var out struct {
+		Count int `json:"count"`
+	}
+	runJSON(t, &out, func() error {
+		return run(context.Background(), []string{"--json", "--no-cache", "chat", "spaces", "list"})
+	})
+	if out.Count != 1 {
+		t.Fatalf("expected one space, got %d", out.Count)
+	}
+
+	t.Cleanup(func() { activeProfile = "" })
+	err := run(context.Background(), []string{"chat", "spaces", "list", "--profile", "other"})
+	if errorCode(err) != "AUTH_REQUIRED" {
+		t.Fatalf("a fresh profile should have no token, got %v", err)
+	}
+}
+
+func TestTokenRefreshClientHasTimeout(t *testing.T) {
+	t.Parallel()
+	var debug *debugLog
+	hc, ok := debug.withHTTPClient(context.Background()).Value(oauth2.HTTPClient).(*http.Client)
+	if !ok || hc.Timeout != defaultHTTPTimeout {
+		t.Fatalf("token refreshes without --debug should use a client with a timeout, got %#v", hc)
+	}
+}
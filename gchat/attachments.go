@@ -0,0 +1,132 @@
+package gchat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// MaxAttachmentSize is the largest file the Chat API accepts as an
+// attachment.
+const MaxAttachmentSize = 200 << 20
+
+// blockedAttachmentTypes are the file extensions Chat refuses to attach.
+var blockedAttachmentTypes = map[string]bool{
+	".ade": true, ".adp": true, ".apk": true, ".appx": true, ".appxbundle": true,
+	".bat": true, ".cab": true, ".chm": true, ".cmd": true, ".com": true,
+	".cpl": true, ".diagcab": true, ".diagcfg": true, ".diagpack": true, ".dll": true,
+	".dmg": true, ".ex": true, ".ex_": true, ".exe": true, ".hta": true,
+	".img": true, ".ins": true, ".iso": true, ".isp": true, ".jar": true,
+	".jnlp": true, ".js": true, ".jse": true, ".lib": true, ".lnk": true,
+	".mde": true, ".msc": true, ".msi": true, ".msix": true, ".msixbundle": true,
+	".msp": true, ".mst": true, ".nsh": true, ".pif": true, ".ps1": true,
+	".scr": true, ".sct": true, ".shb": true, ".sys": true, ".vb": true,
+	".vbe": true, ".vbs": true, ".vhd": true, ".vxd": true, ".wsc": true,
+	".wsf": true, ".wsh": true, ".xll": true,
+}
+
+// CheckAttachment reports why a file named filename of size bytes cannot be
+// attached, or nil if it can.
+func CheckAttachment(filename string, size int64) error {
+	switch {
+	case size <= 0:
+		return fmt.Errorf("%s is empty", filename)
+	case size > MaxAttachmentSize:
+		return fmt.Errorf("%s is %d bytes; attachments are limited to %d MB", filename, size, MaxAttachmentSize>>20)
+	}
+	if ext := strings.ToLower(filepath.Ext(filename)); blockedAttachmentTypes[ext] {
+		return fmt.Errorf("%s: Chat does not accept %s attachments", filename, ext)
+	}
+	return nil
+}
+
+// AttachmentContentType guesses the MIME type of a file from its name,
+// then from its first bytes.
+func AttachmentContentType(filename string, data []byte) string {
+	if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))); t != "" {
+		return t
+	}
+	return http.DetectContentType(data)
+}
+
+// UploadAttachment uploads the size bytes of r as filename to space and
+// returns the reference to pass in SendMessageRequest.Attachment. The
+// content is streamed from r, and read again if the request is retried. An
+// empty contentType is guessed with AttachmentContentType.
+func (c *Client) UploadAttachment(ctx context.Context, space, filename, contentType string, r io.ReaderAt, size int64) (AttachmentDataRef, error) {
+	if contentType == "" {
+		sniff := make([]byte, min(size, 512))
+		n, err := r.ReadAt(sniff, 0)
+		if err != nil && err != io.EOF {
+			return AttachmentDataRef{}, err
+		}
+		contentType = AttachmentContentType(filename, sniff[:n])
+	}
+	// The multipart writer only frames the content: head is everything
+	// before it and tail the closing boundary, so the file itself never
+	// has to be buffered.
+	var frame bytes.Buffer
+	mw := multipart.NewWriter(&frame)
+	metadata, err := json.Marshal(struct {
+		Filename string `json:"filename"`
+	}{filename})
+	if err != nil {
+		return AttachmentDataRef{}, err
+	}
+	meta, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	if err != nil {
+		return AttachmentDataRef{}, err
+	}
+	if _, err := meta.Write(metadata); err != nil {
+		return AttachmentDataRef{}, err
+	}
+	if _, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}}); err != nil {
+		return AttachmentDataRef{}, err
+	}
+	head := bytes.Clone(frame.Bytes())
+	if err := mw.Close(); err != nil {
+		return AttachmentDataRef{}, err
+	}
+	tail := frame.Bytes()[len(head):]
+	open := func() io.Reader {
+		return io.MultiReader(bytes.NewReader(head), io.NewSectionReader(r, 0, size), bytes.NewReader(tail))
+	}
+
+	u := c.uploadEndpoint(NormalizeSpaceName(space) + "/attachments:upload")
+	q := url.Values{"uploadType": {"multipart"}}
+	length := int64(len(head)) + size + int64(len(tail))
+	resp, err := c.sendBody(ctx, EndpointAttachmentsUpload, http.MethodPost, u, q, "multipart/related; boundary="+mw.Boundary(), length, open)
+	if err != nil {
+		return AttachmentDataRef{}, err
+	}
+	var out struct {
+		AttachmentDataRef AttachmentDataRef `json:"attachmentDataRef"`
+	}
+	err = decodeAPIResponse(resp, &out)
+	return out.AttachmentDataRef, err
+}
+
+// DownloadAttachment copies the content of an uploaded attachment, named by
+// its AttachmentDataRef.ResourceName, to w and returns the bytes written.
+func (c *Client) DownloadAttachment(ctx context.Context, resourceName string, w io.Writer) (int64, error) {
+	u := c.endpoint("media/" + strings.TrimSpace(resourceName))
+	resp, err := c.sendBytes(ctx, EndpointMediaDownload, http.MethodGet, u, url.Values{"alt": {"media"}}, "", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, newAPIError(resp.StatusCode, body)
+	}
+	return io.Copy(w, resp.Body)
+}
@@ -0,0 +1,32 @@
+package gchat
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/thomas-sievering/gchatctl/internal/fakechat"
+)
+
+func TestUploadAttachmentKeepsUnusualFilenames(t *testing.T) {
+	t.Parallel()
+	client, srv := newFakeClient(t)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM"})
+
+	name := "week\x01 \"report\" 😀.txt"
+	content := "all green\n"
+	ref, err := client.UploadAttachment(context.Background(), "spaces/ROOM", name, "", strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("UploadAttachment: %v", err)
+	}
+	if _, err := client.SendMessage(context.Background(), "spaces/ROOM", SendMessageRequest{Attachment: []Attachment{{AttachmentDataRef: &ref}}}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	msgs := srv.Messages("spaces/ROOM")
+	if len(msgs) != 1 || len(msgs[0].Attachments) != 1 {
+		t.Fatalf("expected one message with one attachment, got %+v", msgs)
+	}
+	if a := msgs[0].Attachments[0]; a.ContentName != name || string(a.Data) != content || !strings.HasPrefix(a.ContentType, "text/plain") {
+		t.Fatalf("attachment = %q %q %q", a.ContentName, a.ContentType, a.Data)
+	}
+}
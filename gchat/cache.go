@@ -0,0 +1,79 @@
+package gchat
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Cache keeps space lists and memberships between calls, typically on disk
+// between runs. Implementations decide how long entries stay fresh and must
+// be safe for concurrent use.
+type Cache interface {
+	// Get returns the JSON stored under key if it is still fresh.
+	Get(key string) ([]byte, bool)
+	// Set stores JSON under key.
+	Set(key string, value []byte)
+}
+
+type cacheRefreshKey struct{}
+
+// WithCacheRefresh makes calls under ctx skip cached results and store
+// fresh ones, for callers that need current data such as lastActiveTime.
+func WithCacheRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheRefreshKey{}, true)
+}
+
+// Cache keys used by Client.
+const (
+	cacheKeySpaces  = "spaces"
+	cacheKeyMembers = "members/"
+)
+
+// cachedSpaces is the cache entry for ListSpaces. Complete is set when the
+// listing ran to the last page; entries without it are ignored.
+type cachedSpaces struct {
+	Spaces   []ChatSpace `json:"spaces"`
+	Complete bool        `json:"complete"`
+}
+
+func (c *Client) cacheGet(ctx context.Context, key string, out any) bool {
+	if c.cache == nil {
+		return false
+	}
+	if refresh, _ := ctx.Value(cacheRefreshKey{}).(bool); refresh {
+		return false
+	}
+	b, ok := c.cache.Get(key)
+	return ok && json.Unmarshal(b, out) == nil
+}
+
+func (c *Client) cacheSet(key string, v any) {
+	if c.cache == nil {
+		return
+	}
+	if b, err := json.Marshal(v); err == nil {
+		c.cache.Set(key, b)
+	}
+}
+
+// CachedSpaces returns the space listing stored in cache, without any API
+// call. It is for callers such as shell completion that must stay offline.
+func CachedSpaces(cache Cache) ([]ChatSpace, bool) {
+	var cached cachedSpaces
+	b, ok := cache.Get(cacheKeySpaces)
+	if !ok || json.Unmarshal(b, &cached) != nil {
+		return nil, false
+	}
+	return cached.Spaces, true
+}
+
+// CachedMembers returns the memberships of spaceName stored in cache,
+// without any API call.
+func CachedMembers(cache Cache, spaceName string) ([]ChatMembership, bool) {
+	var members []ChatMembership
+	b, ok := cache.Get(cacheKeyMembers + NormalizeSpaceName(spaceName))
+	if !ok || json.Unmarshal(b, &members) != nil {
+		return nil, false
+	}
+	return members, true
+}
@@ -0,0 +1,327 @@
+package gchat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CardWithID is one entry of a message's cardsV2. Card is kept as raw JSON
+// so cards can use any of the API's widgets; ParseCardsV2 checks its shape.
+type CardWithID struct {
+	CardID string          `json:"cardId"`
+	Card   json.RawMessage `json:"card"`
+}
+
+// Known keys of the cardsV2 objects ParseCardsV2 checks. Anything else is
+// most likely a typo that the API would reject or silently drop.
+var (
+	cardKeys        = keySet("header", "sections", "sectionDividerStyle", "cardActions", "name", "fixedFooter", "displayStyle", "peekCardHeader")
+	cardHeaderKeys  = keySet("title", "subtitle", "imageType", "imageUrl", "imageAltText")
+	cardSectionKeys = keySet("header", "widgets", "collapsible", "uncollapsibleWidgetsCount", "collapseControl")
+	cardWidgetKinds = keySet("textParagraph", "image", "decoratedText", "buttonList", "textInput", "selectionInput", "dateTimePicker", "divider", "grid", "columns", "chipList", "carousel")
+	cardWidgetExtra = keySet("horizontalAlignment")
+)
+
+func keySet(keys ...string) map[string]bool {
+	out := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		out[k] = true
+	}
+	return out
+}
+
+// ParseCardsV2 reads cards in any of the shapes people keep them in: a
+// message body with "cardsV2", an array of {cardId, card}, a single
+// {cardId, card}, or a bare card. It checks each card against the cardsV2
+// shape and fills in missing card IDs as card-1, card-2, ...
+func ParseCardsV2(b []byte) ([]CardWithID, error) {
+	var root any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&root); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	path := "cardsV2"
+	var entries []any
+	switch v := root.(type) {
+	case []any:
+		entries = v
+	case map[string]any:
+		switch {
+		case v["cardsV2"] != nil:
+			list, ok := v["cardsV2"].([]any)
+			if !ok {
+				return nil, fmt.Errorf("cardsV2: must be an array")
+			}
+			entries = list
+		case v["card"] != nil:
+			entries = []any{v}
+		default:
+			entries = []any{map[string]any{"card": v}}
+		}
+	default:
+		return nil, fmt.Errorf("expected a card, an array of cards or an object with cardsV2")
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s: no cards", path)
+	}
+
+	out := make([]CardWithID, 0, len(entries))
+	seen := map[string]bool{}
+	for i, e := range entries {
+		at := fmt.Sprintf("%s[%d]", path, i)
+		entry, ok := e.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: must be an object", at)
+		}
+		for k := range entry {
+			if k != "cardId" && k != "card" {
+				return nil, fmt.Errorf("%s: unknown field %q", at, k)
+			}
+		}
+		id, _ := entry["cardId"].(string)
+		if id == "" {
+			id = fmt.Sprintf("card-%d", i+1)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("%s.cardId: duplicate %q", at, id)
+		}
+		seen[id] = true
+		card, ok := entry["card"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s.card: must be an object", at)
+		}
+		if err := checkCard(at+".card", card); err != nil {
+			return nil, err
+		}
+		raw, err := marshalCard(card)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, CardWithID{CardID: id, Card: raw})
+	}
+	return out, nil
+}
+
+// marshalCard encodes a card without escaping HTML, which card text
+// commonly uses for formatting.
+func marshalCard(card map[string]any) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(card); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+func checkCard(at string, card map[string]any) error {
+	if err := checkKeys(at, card, cardKeys); err != nil {
+		return err
+	}
+	if card["header"] == nil && card["sections"] == nil {
+		return fmt.Errorf("%s: needs a header or sections", at)
+	}
+	if h, ok := card["header"]; ok {
+		header, ok := h.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s.header: must be an object", at)
+		}
+		if err := checkKeys(at+".header", header, cardHeaderKeys); err != nil {
+			return err
+		}
+		if title, _ := header["title"].(string); strings.TrimSpace(title) == "" {
+			return fmt.Errorf("%s.header.title: required", at)
+		}
+	}
+	if s, ok := card["sections"]; ok {
+		sections, ok := s.([]any)
+		if !ok {
+			return fmt.Errorf("%s.sections: must be an array", at)
+		}
+		for i, s := range sections {
+			if err := checkSection(fmt.Sprintf("%s.sections[%d]", at, i), s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkSection(at string, s any) error {
+	section, ok := s.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: must be an object", at)
+	}
+	if err := checkKeys(at, section, cardSectionKeys); err != nil {
+		return err
+	}
+	widgets, ok := section["widgets"].([]any)
+	if !ok || len(widgets) == 0 {
+		return fmt.Errorf("%s.widgets: must be a non-empty array", at)
+	}
+	for i, w := range widgets {
+		if err := checkWidget(fmt.Sprintf("%s.widgets[%d]", at, i), w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkWidget(at string, w any) error {
+	widget, ok := w.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: must be an object", at)
+	}
+	kind := ""
+	for k := range widget {
+		switch {
+		case cardWidgetExtra[k]:
+		case !cardWidgetKinds[k]:
+			return fmt.Errorf("%s: unknown widget %q (want one of %s)", at, k, strings.Join(sortedKeys(cardWidgetKinds), ", "))
+		case kind != "":
+			return fmt.Errorf("%s: holds both %s and %s; use one widget per entry", at, kind, k)
+		default:
+			kind = k
+		}
+	}
+	if kind == "" {
+		return fmt.Errorf("%s: no widget", at)
+	}
+	body, ok := widget[kind].(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s.%s: must be an object", at, kind)
+	}
+	switch kind {
+	case "decoratedText":
+		if text, _ := body["text"].(string); text == "" {
+			return fmt.Errorf("%s.decoratedText.text: required", at)
+		}
+	case "textParagraph":
+		if text, _ := body["text"].(string); text == "" {
+			return fmt.Errorf("%s.textParagraph.text: required", at)
+		}
+	case "buttonList":
+		buttons, ok := body["buttons"].([]any)
+		if !ok || len(buttons) == 0 {
+			return fmt.Errorf("%s.buttonList.buttons: must be a non-empty array", at)
+		}
+		for i, b := range buttons {
+			if err := checkButton(fmt.Sprintf("%s.buttonList.buttons[%d]", at, i), b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkButton(at string, b any) error {
+	button, ok := b.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: must be an object", at)
+	}
+	if text, _ := button["text"].(string); text == "" && button["icon"] == nil {
+		return fmt.Errorf("%s: needs text or an icon", at)
+	}
+	onClick, ok := button["onClick"].(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s.onClick: required", at)
+	}
+	if link, ok := onClick["openLink"].(map[string]any); ok {
+		raw, _ := link["url"].(string)
+		if err := checkLinkURL(raw); err != nil {
+			return fmt.Errorf("%s.onClick.openLink.url: %v", at, err)
+		}
+	}
+	return nil
+}
+
+// checkLinkURL accepts absolute http and https URLs.
+func checkLinkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an http(s) URL, got %q", raw)
+	}
+	return nil
+}
+
+func checkKeys(at string, obj map[string]any, known map[string]bool) error {
+	for k := range obj {
+		if !known[k] {
+			return fmt.Errorf("%s: unknown field %q", at, k)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// SimpleCard is a card with a header, key/value rows and link buttons,
+// which covers most notification messages.
+type SimpleCard struct {
+	Title    string
+	Subtitle string
+	Fields   []CardField
+	Buttons  []CardButton
+}
+
+// CardField is a labelled value row of a SimpleCard.
+type CardField struct {
+	Key   string
+	Value string
+}
+
+// CardButton is a SimpleCard button that opens URL.
+type CardButton struct {
+	Label string
+	URL   string
+}
+
+// CardsV2 renders the card as cardsV2 with the given card ID.
+func (c SimpleCard) CardsV2(id string) ([]CardWithID, error) {
+	if strings.TrimSpace(c.Title) == "" {
+		return nil, fmt.Errorf("card title is required")
+	}
+	header := map[string]any{"title": c.Title}
+	if c.Subtitle != "" {
+		header["subtitle"] = c.Subtitle
+	}
+	card := map[string]any{"header": header}
+	var sections []any
+	if len(c.Fields) > 0 {
+		widgets := make([]any, 0, len(c.Fields))
+		for _, f := range c.Fields {
+			widgets = append(widgets, map[string]any{"decoratedText": map[string]any{"topLabel": f.Key, "text": f.Value}})
+		}
+		sections = append(sections, map[string]any{"widgets": widgets})
+	}
+	if len(c.Buttons) > 0 {
+		buttons := make([]any, 0, len(c.Buttons))
+		for _, b := range c.Buttons {
+			if err := checkLinkURL(b.URL); err != nil {
+				return nil, fmt.Errorf("button %q: %v", b.Label, err)
+			}
+			buttons = append(buttons, map[string]any{"text": b.Label, "onClick": map[string]any{"openLink": map[string]any{"url": b.URL}}})
+		}
+		sections = append(sections, map[string]any{"widgets": []any{map[string]any{"buttonList": map[string]any{"buttons": buttons}}}})
+	}
+	if len(sections) > 0 {
+		card["sections"] = sections
+	}
+	raw, err := marshalCard(card)
+	if err != nil {
+		return nil, err
+	}
+	return []CardWithID{{CardID: firstNonEmpty(id, "card-1"), Card: raw}}, nil
+}
@@ -0,0 +1,89 @@
+package gchat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCardsV2Shapes(t *testing.T) {
+	t.Parallel()
+
+	card := `{"header":{"title":"Deploy"},"sections":[{"widgets":[{"decoratedText":{"topLabel":"env","text":"prod"}}]}]}`
+	cases := []struct {
+		name  string
+		input string
+		ids   []string
+	}{
+		{name: "bare card", input: card, ids: []string{"card-1"}},
+		{name: "single entry", input: `{"cardId":"deploy","card":` + card + `}`, ids: []string{"deploy"}},
+		{name: "array", input: `[{"card":` + card + `},{"cardId":"b","card":` + card + `}]`, ids: []string{"card-1", "b"}},
+		{name: "message body", input: `{"cardsV2":[{"cardId":"a","card":` + card + `}]}`, ids: []string{"a"}},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cards, err := ParseCardsV2([]byte(tc.input))
+			if err != nil {
+				t.Fatalf("ParseCardsV2: %v", err)
+			}
+			if len(cards) != len(tc.ids) {
+				t.Fatalf("got %d cards, want %d", len(cards), len(tc.ids))
+			}
+			for i, c := range cards {
+				if c.CardID != tc.ids[i] || !strings.Contains(string(c.Card), `"Deploy"`) {
+					t.Fatalf("card %d = %s %s", i, c.CardID, c.Card)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCardsV2Rejects(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "not JSON", input: `{`, want: "invalid JSON"},
+		{name: "empty card", input: `{}`, want: "needs a header or sections"},
+		{name: "missing title", input: `{"header":{"subtitle":"x"}}`, want: "header.title: required"},
+		{name: "typo in card", input: `{"header":{"title":"x"},"sectons":[]}`, want: `unknown field "sectons"`},
+		{name: "unknown widget", input: `{"sections":[{"widgets":[{"decoratdText":{"text":"x"}}]}]}`, want: `unknown widget "decoratdText"`},
+		{name: "two widgets", input: `{"sections":[{"widgets":[{"divider":{},"textParagraph":{"text":"x"}}]}]}`, want: "use one widget per entry"},
+		{name: "empty widgets", input: `{"sections":[{"widgets":[]}]}`, want: "widgets: must be a non-empty array"},
+		{name: "bad link", input: `{"sections":[{"widgets":[{"buttonList":{"buttons":[{"text":"Open","onClick":{"openLink":{"url":"javascript:alert(1)"}}}]}}]}]}`, want: "must be an http(s) URL"},
+		{name: "duplicate ids", input: `[{"cardId":"a","card":{"header":{"title":"x"}}},{"cardId":"a","card":{"header":{"title":"y"}}}]`, want: `duplicate "a"`},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := ParseCardsV2([]byte(tc.input))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("ParseCardsV2(%s) error = %v, want %q", tc.input, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSimpleCardRoundTrips(t *testing.T) {
+	t.Parallel()
+
+	cards, err := SimpleCard{
+		Title:   "Deploy finished",
+		Fields:  []CardField{{Key: "env", Value: "prod"}},
+		Buttons: []CardButton{{Label: "Logs", URL: "https://ci.example.com/run/1"}},
+	}.CardsV2("")
+	if err != nil {
+		t.Fatalf("CardsV2: %v", err)
+	}
+	if _, err := ParseCardsV2([]byte(`{"cardId":"card-1","card":` + string(cards[0].Card) + `}`)); err != nil {
+		t.Fatalf("built card does not validate: %v\n%s", err, cards[0].Card)
+	}
+	if _, err := (SimpleCard{Title: "x", Buttons: []CardButton{{Label: "a", URL: "ftp://x"}}}).CardsV2(""); err == nil {
+		t.Fatal("non-http button URL should be rejected")
+	}
+}
@@ -0,0 +1,277 @@
+// Package gchat is a small Google Chat REST API client used by the gchatctl
+// CLI. It can be imported by other Go programs that need the same behavior
+// (pagination, DM lookup, sender enrichment) without shelling out.
+package gchat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultBaseURL is the production Chat API root.
+const DefaultBaseURL = "https://chat.googleapis.com/v1"
+
+// DefaultTimeout bounds a single HTTP request when Config.Timeout is unset.
+const DefaultTimeout = 30 * time.Second
+
+// Config describes how a Client reaches the Chat API.
+type Config struct {
+	// TokenSource authorizes requests. Ignored when HTTPClient is set.
+	TokenSource oauth2.TokenSource
+	// HTTPClient is used as-is when set and must already authorize requests.
+	HTTPClient *http.Client
+	// Transport is the base transport wrapped by the OAuth2 transport when
+	// TokenSource is used. Defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+	// BaseURL overrides DefaultBaseURL, e.g. for proxies or fakes.
+	BaseURL string
+	// Timeout bounds each request made by a client built from TokenSource.
+	Timeout time.Duration
+	// Concurrency caps parallel per-space requests in multi-space scans;
+	// defaults to DefaultConcurrency.
+	Concurrency int
+	// Cache, when set, serves full space listings and memberships.
+	Cache Cache
+	// DMIndex, when set, is kept up to date with the DM peers the client
+	// sees and consulted by ResolveDMByName before scanning.
+	DMIndex DMIndex
+}
+
+// Client calls the Google Chat REST API.
+type Client struct {
+	http        *http.Client
+	baseURL     string
+	concurrency int
+	cache       Cache
+	index       DMIndex
+
+	// mu guards what the client has learned for indexing DMs: the caller's
+	// name and which spaces are direct messages.
+	mu       sync.Mutex
+	me       string
+	dmSpaces map[string]bool
+}
+
+// NewClient builds a Client from cfg.
+func NewClient(cfg Config) *Client {
+	hc := cfg.HTTPClient
+	if hc == nil {
+		base := cfg.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		var rt http.RoundTripper = base
+		if cfg.TokenSource != nil {
+			rt = &oauth2.Transport{Source: cfg.TokenSource, Base: base}
+		}
+		hc = &http.Client{Timeout: timeout, Transport: rt}
+	}
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	return &Client{
+		http:        hc,
+		baseURL:     baseURL,
+		concurrency: concurrency,
+		cache:       cfg.Cache,
+		index:       cfg.DMIndex,
+		dmSpaces:    map[string]bool{},
+	}
+}
+
+// HTTPClient returns the underlying authorized HTTP client.
+func (c *Client) HTTPClient() *http.Client { return c.http }
+
+// BaseURL returns the API root requests are sent to.
+func (c *Client) BaseURL() string { return c.baseURL }
+
+// Concurrency returns the worker count used for multi-space scans.
+func (c *Client) Concurrency() int { return c.concurrency }
+
+// API method names attached to each request made by Client; see EndpointName.
+const (
+	EndpointSpacesList        = "spaces.list"
+	EndpointFindDirectMessage = "spaces.findDirectMessage"
+	EndpointMessagesList      = "spaces.messages.list"
+	EndpointMessagesCreate    = "spaces.messages.create"
+	EndpointMessagesGet       = "spaces.messages.get"
+	EndpointMessagesPatch     = "spaces.messages.patch"
+	EndpointMessagesDelete    = "spaces.messages.delete"
+	EndpointReactionsCreate   = "spaces.messages.reactions.create"
+	EndpointReactionsList     = "spaces.messages.reactions.list"
+	EndpointReactionsDelete   = "spaces.messages.reactions.delete"
+	EndpointMembersList       = "spaces.members.list"
+	EndpointAttachmentsUpload = "media.upload"
+	EndpointMediaDownload     = "media.download"
+	EndpointGetSpaceReadState = "users.spaces.getSpaceReadState"
+	EndpointCurrentUser       = "users.me"
+	// EndpointRaw marks requests sent with Raw.
+	EndpointRaw = "raw"
+)
+
+type endpointKey struct{}
+
+// EndpointName reports the API method (one of the Endpoint constants) of a
+// request made by Client, or "" for requests from elsewhere.
+func EndpointName(ctx context.Context) string {
+	name, _ := ctx.Value(endpointKey{}).(string)
+	return name
+}
+
+// endpoint returns the absolute URL for a resource path such as "spaces".
+func (c *Client) endpoint(path string) string {
+	return c.baseURL + "/" + strings.TrimPrefix(path, "/")
+}
+
+// uploadEndpoint returns the absolute media upload URL for path. Uploads go
+// to /upload/v1 next to the API root's /v1.
+func (c *Client) uploadEndpoint(path string) string {
+	root, version := c.baseURL, "v1"
+	if i := strings.LastIndex(root, "/"); i >= 0 {
+		root, version = root[:i], root[i+1:]
+	}
+	return root + "/upload/" + version + "/" + strings.TrimPrefix(path, "/")
+}
+
+// get issues a GET for path with query and decodes the JSON response into out.
+func (c *Client) get(ctx context.Context, endpoint, path string, query url.Values, out any) error {
+	return c.do(ctx, endpoint, http.MethodGet, path, query, nil, out)
+}
+
+// do sends a request with an optional JSON body and decodes the response.
+// endpoint names the API method (see EndpointName) for transports that
+// treat methods differently.
+func (c *Client) do(ctx context.Context, endpoint, method, path string, query url.Values, body any, out any) error {
+	resp, err := c.send(ctx, endpoint, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	return decodeAPIResponse(resp, out)
+}
+
+// Raw sends an authorized request to any API path, for methods Client does
+// not wrap, and returns the JSON response body. body, if non-nil, is
+// marshaled as JSON; a json.RawMessage is sent as is. Non-2xx responses
+// return an *APIError like every other call.
+func (c *Client) Raw(ctx context.Context, method, path string, query url.Values, body any) (json.RawMessage, error) {
+	resp, err := c.send(ctx, EndpointRaw, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, newAPIError(resp.StatusCode, b)
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return json.RawMessage("{}"), nil
+	}
+	return json.RawMessage(b), nil
+}
+
+// send builds and sends a request; the caller closes the response body.
+func (c *Client) send(ctx context.Context, endpoint, method, path string, query url.Values, body any) (*http.Response, error) {
+	var b []byte
+	contentType := ""
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+		contentType = "application/json"
+	}
+	return c.sendBytes(ctx, endpoint, method, c.endpoint(path), query, contentType, b)
+}
+
+// sendBytes sends body, if any, as contentType to the absolute URL u; the
+// caller closes the response body.
+func (c *Client) sendBytes(ctx context.Context, endpoint, method, u string, query url.Values, contentType string, body []byte) (*http.Response, error) {
+	if body == nil {
+		return c.sendBody(ctx, endpoint, method, u, query, contentType, 0, nil)
+	}
+	return c.sendBody(ctx, endpoint, method, u, query, contentType, int64(len(body)), func() io.Reader { return bytes.NewReader(body) })
+}
+
+// sendBody is sendBytes for bodies too large to hold in memory: open
+// returns a fresh reader over the size bytes of the body, and is called
+// again for each retry. A nil open sends no body.
+func (c *Client) sendBody(ctx context.Context, endpoint, method, u string, query url.Values, contentType string, size int64, open func() io.Reader) (*http.Response, error) {
+	ctx = context.WithValue(ctx, endpointKey{}, endpoint)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var rdr io.Reader
+	if open != nil {
+		rdr = open()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rdr)
+	if err != nil {
+		return nil, err
+	}
+	if open != nil {
+		req.ContentLength = size
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(open()), nil }
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return c.http.Do(req)
+}
+
+// GoogleAPIErrorEnvelope is the error body returned by Google APIs.
+type GoogleAPIErrorEnvelope struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			Reason string `json:"reason"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// decodeAPIResponse decodes a 2xx body into out and turns anything else into
+// an *APIError.
+func decodeAPIResponse(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return newAPIError(resp.StatusCode, body)
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return err
+	}
+	return nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
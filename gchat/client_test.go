@@ -0,0 +1,359 @@
+package gchat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/thomas-sievering/gchatctl/internal/fakechat"
+)
+
+func newFakeClient(t *testing.T) (*Client, *fakechat.Server) {
+	t.Helper()
+	srv := fakechat.New()
+	t.Cleanup(srv.Close)
+	client := NewClient(Config{
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: fakechat.DefaultAccessToken}),
+		BaseURL:     srv.APIBaseURL(),
+	})
+	return client, srv
+}
+
+func TestListMessagesPaginationAndOrder(t *testing.T) {
+	t.Parallel()
+	client, srv := newFakeClient(t)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", SpaceType: "SPACE"})
+	start := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i < 7; i++ {
+		srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "m", CreateTime: start.Add(time.Duration(i) * time.Minute)})
+	}
+
+	msgs, err := client.ListMessages(context.Background(), "ROOM", ListMessagesOptions{Limit: 5, PageSize: 2, OrderBy: OrderCreateTimeDesc})
+	if err != nil {
+		t.Fatalf("ListMessages: %v", err)
+	}
+	if len(msgs) != 5 {
+		t.Fatalf("expected 5 messages, got %d", len(msgs))
+	}
+	if got := srv.CountRequests(http.MethodGet, "/v1/spaces/ROOM/messages"); got != 3 {
+		t.Fatalf("expected 3 page requests, got %d", got)
+	}
+	newest, _ := ParseTime(msgs[0].CreateTime)
+	oldest, _ := ParseTime(msgs[4].CreateTime)
+	if !newest.After(oldest) {
+		t.Fatalf("expected newest first, got %s then %s", newest, oldest)
+	}
+}
+
+func TestListMessagesFilterAndFieldsArePushedDown(t *testing.T) {
+	t.Parallel()
+	client, srv := newFakeClient(t)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", SpaceType: "SPACE"})
+	cutoff := time.Now().UTC().Add(-time.Hour)
+	srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "old", CreateTime: cutoff.Add(-time.Minute)})
+	srv.AddMessage("spaces/ROOM", fakechat.Message{Text: "new", CreateTime: cutoff.Add(time.Minute)})
+
+	msgs, err := client.ListMessages(context.Background(), "ROOM", ListMessagesOptions{Filter: CreatedAfter(cutoff), Fields: MessageListFields})
+	if err != nil {
+		t.Fatalf("ListMessages: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Text != "new" {
+		t.Fatalf("expected only the message after the cutoff, got %+v", msgs)
+	}
+	reqs := srv.Requests()
+	q := reqs[len(reqs)-1].Query
+	if q.Get("filter") != CreatedAfter(cutoff) || q.Get("fields") != MessageListFields {
+		t.Fatalf("filter/fields not sent: %v", q)
+	}
+}
+
+func TestSortSpacesByActivity(t *testing.T) {
+	t.Parallel()
+	now := time.Now().UTC()
+	spaces := []ChatSpace{
+		{Name: "spaces/UNKNOWN"},
+		{Name: "spaces/OLD", LastActiveTime: now.Add(-time.Hour).Format(time.RFC3339)},
+		{Name: "spaces/NEW", LastActiveTime: now.Format(time.RFC3339)},
+	}
+	SortSpacesByActivity(spaces)
+	if spaces[0].Name != "spaces/NEW" || spaces[1].Name != "spaces/OLD" || spaces[2].Name != "spaces/UNKNOWN" {
+		t.Fatalf("unexpected order: %+v", spaces)
+	}
+	if !InactiveSince(spaces[1], now.Add(-time.Minute)) || InactiveSince(spaces[2], now) {
+		t.Fatal("InactiveSince should only report spaces with a known, older lastActiveTime")
+	}
+}
+
+func TestResolveDMByNamePrefersAliases(t *testing.T) {
+	t.Parallel()
+	client, srv := newFakeClient(t)
+	srv.AddSpace(fakechat.Space{Name: "spaces/DM1", SpaceType: "DIRECT_MESSAGE"},
+		fakechat.User{Name: "users/me", Type: "HUMAN"},
+		fakechat.User{Name: "users/42", Type: "HUMAN"},
+	)
+
+	res, err := client.ResolveDMByName(context.Background(), "simon", ResolveOptions{Aliases: map[string]string{"users/42": "Simon"}})
+	if err != nil {
+		t.Fatalf("ResolveDMByName: %v", err)
+	}
+	if res.User != "users/42" || res.Space != "spaces/DM1" || res.Display != "Simon" {
+		t.Fatalf("unexpected resolution: %+v", res)
+	}
+	if got := srv.CountRequests(http.MethodGet, "/v1/spaces"); got != 0 {
+		t.Fatalf("alias match should not scan spaces, saw %d list calls", got)
+	}
+}
+
+func TestResolveDMByNameReportsUnreadSpaces(t *testing.T) {
+	t.Parallel()
+	client, srv := newFakeClient(t)
+	srv.SetCurrentUser("users/me")
+	srv.AddSpace(fakechat.Space{Name: "spaces/DM1", SpaceType: "DIRECT_MESSAGE"},
+		fakechat.User{Name: "users/me", Type: "HUMAN"},
+		fakechat.User{Name: "users/41", DisplayName: "Alice", Type: "HUMAN"},
+	)
+	srv.AddSpace(fakechat.Space{Name: "spaces/DM2", SpaceType: "DIRECT_MESSAGE"},
+		fakechat.User{Name: "users/me", Type: "HUMAN"},
+		fakechat.User{Name: "users/42", DisplayName: "Simon", Type: "HUMAN"},
+	)
+	srv.AddFault(fakechat.Fault{Path: "/v1/spaces/DM2/members", Status: http.StatusForbidden, GoogleStatus: "PERMISSION_DENIED", Message: "no"})
+
+	_, err := client.ResolveDMByName(context.Background(), "simon", ResolveOptions{})
+	var scanErr *ScanError
+	if !errors.As(err, &scanErr) || len(scanErr.Skipped) != 1 || ErrorCodeOf(err) != CodePermissionDenied {
+		t.Fatalf("a name missed because a DM could not be read should not be NOT_FOUND, got %v (%s)", err, ErrorCodeOf(err))
+	}
+
+	res, err := client.ResolveDMByName(context.Background(), "alice", ResolveOptions{})
+	if err != nil || res.Space != "spaces/DM1" || len(res.Skipped) != 1 || res.Skipped[0].Space != "spaces/DM2" {
+		t.Fatalf("match should carry the skipped DM: %+v, %v", res, err)
+	}
+}
+
+func TestInferCurrentUser(t *testing.T) {
+	t.Parallel()
+	client, srv := newFakeClient(t)
+	me := fakechat.User{Name: "users/me", Type: "HUMAN"}
+	srv.AddSpace(fakechat.Space{Name: "spaces/DM1", SpaceType: "DIRECT_MESSAGE"}, fakechat.User{Name: "users/zed", Type: "HUMAN"}, me)
+	srv.AddSpace(fakechat.Space{Name: "spaces/DM2", SpaceType: "DIRECT_MESSAGE"}, me, fakechat.User{Name: "users/ann", Type: "HUMAN"})
+	spaces := []ChatSpace{{Name: "spaces/DM1", SpaceType: "DIRECT_MESSAGE"}, {Name: "spaces/DM2", SpaceType: "DIRECT_MESSAGE"}}
+
+	if got := client.InferCurrentUser(context.Background(), spaces); got != "users/me" {
+		t.Fatalf("expected the member of every DM, got %q", got)
+	}
+	for i := 0; i < 20; i++ {
+		if got := client.InferCurrentUser(context.Background(), spaces[:1]); got != "users/me" {
+			t.Fatalf("a tie should go to the lowest user name, got %q", got)
+		}
+	}
+}
+
+// memIndex is an in-memory DMIndex.
+type memIndex struct {
+	mu      sync.Mutex
+	entries map[string]DMEntry
+}
+
+func (m *memIndex) Entries() []DMEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]DMEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		out = append(out, e)
+	}
+	return out
+}
+
+func (m *memIndex) Put(e DMEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[e.Space] = e
+}
+
+func (m *memIndex) Remove(space string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, space)
+}
+
+func TestResolveDMByNameUsesIndexAndDropsStaleEntries(t *testing.T) {
+	t.Parallel()
+	srv := fakechat.New()
+	t.Cleanup(srv.Close)
+	index := &memIndex{entries: map[string]DMEntry{
+		"spaces/GONE": {Space: "spaces/GONE", User: "users/42", Display: "Simon"},
+	}}
+	client := NewClient(Config{
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: fakechat.DefaultAccessToken}),
+		BaseURL:     srv.APIBaseURL(),
+		DMIndex:     index,
+	})
+	srv.SetCurrentUser("users/me")
+	srv.AddSpace(fakechat.Space{Name: "spaces/DM1", SpaceType: "DIRECT_MESSAGE"},
+		fakechat.User{Name: "users/me", Type: "HUMAN"},
+		fakechat.User{Name: "users/42", DisplayName: "Simon", Type: "HUMAN"},
+	)
+
+	res, err := client.ResolveDMByName(context.Background(), "simon", ResolveOptions{})
+	if err != nil {
+		t.Fatalf("ResolveDMByName: %v", err)
+	}
+	if res.Space != "spaces/DM1" {
+		t.Fatalf("stale index entry should fall back to a scan: %+v", res)
+	}
+	entries := index.Entries()
+	if len(entries) != 1 || entries[0].Space != "spaces/DM1" || entries[0].User != "users/42" {
+		t.Fatalf("index should hold the scanned DM only: %+v", entries)
+	}
+
+	reads := srv.CountRequests(http.MethodGet, "/v1/spaces/DM1/members")
+	res, err = client.ResolveDMByName(context.Background(), "simon", ResolveOptions{})
+	if err != nil || res.Space != "spaces/DM1" {
+		t.Fatalf("second lookup: %+v, %v", res, err)
+	}
+	if got := srv.CountRequests(http.MethodGet, "/v1/spaces/DM1/members"); got != reads+1 {
+		t.Fatalf("indexed lookup should only check the match live, saw %d member listings", got-reads)
+	}
+}
+
+func TestResolveDMByNameWeighsIndexAgainstLiveDMs(t *testing.T) {
+	t.Parallel()
+	srv := fakechat.New()
+	t.Cleanup(srv.Close)
+	index := &memIndex{entries: map[string]DMEntry{
+		"spaces/DM1": {Space: "spaces/DM1", User: "users/41", Display: "Simon Example"},
+	}}
+	client := NewClient(Config{
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: fakechat.DefaultAccessToken}),
+		BaseURL:     srv.APIBaseURL(),
+		DMIndex:     index,
+	})
+	srv.SetCurrentUser("users/me")
+	srv.AddSpace(fakechat.Space{Name: "spaces/DM1", SpaceType: "DIRECT_MESSAGE"},
+		fakechat.User{Name: "users/me", Type: "HUMAN"},
+		fakechat.User{Name: "users/41", DisplayName: "Simon Example", Type: "HUMAN"},
+	)
+	srv.AddSpace(fakechat.Space{Name: "spaces/DM2", SpaceType: "DIRECT_MESSAGE"},
+		fakechat.User{Name: "users/me", Type: "HUMAN"},
+		fakechat.User{Name: "users/42", DisplayName: "Simon Other", Type: "HUMAN"},
+	)
+
+	_, err := client.ResolveDMByName(context.Background(), "simon", ResolveOptions{})
+	var ambiguous *AmbiguousNameError
+	if !errors.As(err, &ambiguous) || len(ambiguous.Matches) != 2 {
+		t.Fatalf("prefix match in the index should be weighed against the scan, got %v", err)
+	}
+
+	reads := srv.CountRequests(http.MethodGet, "/v1/spaces/DM2/members")
+	res, err := client.ResolveDMByName(context.Background(), "Simon Example", ResolveOptions{})
+	if err != nil || res.Space != "spaces/DM1" {
+		t.Fatalf("exact lookup: %+v, %v", res, err)
+	}
+	if got := srv.CountRequests(http.MethodGet, "/v1/spaces/DM2/members"); got != reads {
+		t.Fatalf("once every DM is indexed, other DMs should not be read, saw %d member listings", got-reads)
+	}
+}
+
+func TestResolveDMByNameReadsUnindexedDMsBeforeSettling(t *testing.T) {
+	t.Parallel()
+	srv := fakechat.New()
+	t.Cleanup(srv.Close)
+	index := &memIndex{entries: map[string]DMEntry{
+		"spaces/DM1": {Space: "spaces/DM1", User: "users/41", Display: "Simon"},
+	}}
+	client := NewClient(Config{
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: fakechat.DefaultAccessToken}),
+		BaseURL:     srv.APIBaseURL(),
+		DMIndex:     index,
+	})
+	srv.SetCurrentUser("users/me")
+	srv.AddSpace(fakechat.Space{Name: "spaces/DM1", SpaceType: "DIRECT_MESSAGE"},
+		fakechat.User{Name: "users/me", Type: "HUMAN"},
+		fakechat.User{Name: "users/41", DisplayName: "Simon", Type: "HUMAN"},
+	)
+	srv.AddSpace(fakechat.Space{Name: "spaces/DM2", SpaceType: "DIRECT_MESSAGE"},
+		fakechat.User{Name: "users/me", Type: "HUMAN"},
+		fakechat.User{Name: "users/42", DisplayName: "Simon", Type: "HUMAN"},
+	)
+
+	_, err := client.ResolveDMByName(context.Background(), "Simon", ResolveOptions{})
+	var ambiguous *AmbiguousNameError
+	if !errors.As(err, &ambiguous) || len(ambiguous.Matches) != 2 {
+		t.Fatalf("an unindexed DM with the same name should make the index hit ambiguous, got %v", err)
+	}
+}
+
+// memCache is an in-memory Cache.
+type memCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+func (m *memCache) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.entries[key]
+	return b, ok
+}
+
+func (m *memCache) Set(key string, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = value
+}
+
+func TestListSpacesCachesOnlyCompleteListings(t *testing.T) {
+	t.Parallel()
+	srv := fakechat.New()
+	t.Cleanup(srv.Close)
+	for _, name := range []string{"spaces/A", "spaces/B", "spaces/C"} {
+		srv.AddSpace(fakechat.Space{Name: name, SpaceType: "SPACE"})
+	}
+	cache := &memCache{entries: map[string][]byte{}}
+	newClient := func(maxRequests int64) *Client {
+		hc := &http.Client{Transport: &oauth2.Transport{
+			Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: fakechat.DefaultAccessToken}),
+			Base:   &RateLimitTransport{MaxRequests: maxRequests},
+		}}
+		return NewClient(Config{HTTPClient: hc, BaseURL: srv.APIBaseURL(), Cache: cache})
+	}
+
+	if _, err := newClient(0).ListSpaces(context.Background(), ListSpacesOptions{Limit: 2, PageSize: 1}); err != nil {
+		t.Fatalf("ListSpaces: %v", err)
+	}
+	if _, ok := cache.Get(cacheKeySpaces); ok {
+		t.Fatal("a listing cut short by its limit should not be cached")
+	}
+	if _, err := newClient(2).ListSpaces(context.Background(), ListSpacesOptions{PageSize: 1}); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("expected ErrBudgetExhausted, got %v", err)
+	}
+	if _, ok := cache.Get(cacheKeySpaces); ok {
+		t.Fatal("a listing that failed on a later page should not be cached")
+	}
+
+	if _, err := newClient(0).ListSpaces(context.Background(), ListSpacesOptions{PageSize: 2}); err != nil {
+		t.Fatalf("ListSpaces: %v", err)
+	}
+	if cached, ok := CachedSpaces(cache); !ok || len(cached) != 3 {
+		t.Fatalf("a listing that reached the last page should be cached, got %d spaces", len(cached))
+	}
+	before := srv.CountRequests(http.MethodGet, "/v1/spaces")
+	spaces, err := newClient(0).ListSpaces(context.Background(), ListSpacesOptions{Limit: 2})
+	if err != nil || len(spaces) != 2 || srv.CountRequests(http.MethodGet, "/v1/spaces") != before {
+		t.Fatalf("limited listing should be served from the cache: %d spaces, %v", len(spaces), err)
+	}
+}
+
+func TestAPIErrorIsReturned(t *testing.T) {
+	t.Parallel()
+	client, srv := newFakeClient(t)
+	srv.AddFault(fakechat.Fault{Path: "/v1/spaces", Status: http.StatusServiceUnavailable, GoogleStatus: "UNAVAILABLE", Message: "try later"})
+	if _, err := client.ListSpaces(context.Background(), ListSpacesOptions{Limit: 10}); err == nil {
+		t.Fatal("expected error from failing endpoint")
+	}
+}
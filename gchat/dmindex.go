@@ -0,0 +1,134 @@
+package gchat
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// DMIndex remembers the peer of each direct-message space so
+// ResolveDMByName can match names without scanning every DM. The client
+// adds entries whenever it sees the memberships of a known DM.
+// Implementations must be safe for concurrent use.
+type DMIndex interface {
+	// Entries returns every indexed DM.
+	Entries() []DMEntry
+	// Put records or replaces the entry for e.Space.
+	Put(e DMEntry)
+	// Remove forgets space, e.g. after it no longer checks out.
+	Remove(space string)
+}
+
+// DMEntry is one indexed direct-message space.
+type DMEntry struct {
+	Space   string    `json:"space"`
+	User    string    `json:"user"`
+	Display string    `json:"display,omitempty"`
+	SeenAt  time.Time `json:"seen_at"`
+}
+
+// noteDMSpaces marks the DIRECT_MESSAGE spaces among spaces, so their
+// memberships can be indexed when they are listed.
+func (c *Client) noteDMSpaces(spaces []ChatSpace) {
+	for _, s := range spaces {
+		if s.SpaceType == "DIRECT_MESSAGE" {
+			c.noteDM(s.Name)
+		}
+	}
+}
+
+// noteDM marks space as a direct message.
+func (c *Client) noteDM(space string) {
+	if c.index == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dmSpaces[NormalizeSpaceName(space)] = true
+}
+
+// noteMe records the caller's users/... name once it is known.
+func (c *Client) noteMe(me string) {
+	me = strings.TrimSpace(me)
+	if c.index == nil || me == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.me == "" {
+		c.me = NormalizeUserRef(me)
+	}
+}
+
+// indexMembers adds the peer in members to the index when space is a known
+// DM and the caller is known.
+func (c *Client) indexMembers(space string, members []ChatMembership) {
+	if c.index == nil {
+		return
+	}
+	space = NormalizeSpaceName(space)
+	c.mu.Lock()
+	me, isDM := c.me, c.dmSpaces[space]
+	c.mu.Unlock()
+	if !isDM || me == "" {
+		return
+	}
+	for _, m := range members {
+		if strings.ToUpper(strings.TrimSpace(m.Member.Type)) != "HUMAN" {
+			continue
+		}
+		id := strings.TrimSpace(m.Member.Name)
+		if id == "" || NormalizeUserRef(id) == me {
+			continue
+		}
+		c.index.Put(DMEntry{
+			Space:   space,
+			User:    NormalizeUserRef(id),
+			Display: strings.TrimSpace(m.Member.DisplayName),
+			SeenAt:  time.Now().UTC(),
+		})
+		return
+	}
+}
+
+// indexMatches matches query against the index. It returns the matching
+// entries and the set of indexed spaces: the index only knows the DMs seen
+// so far, so the caller still reads the DMs missing from it before settling
+// on a match.
+func (c *Client) indexMatches(query string, aliases map[string]string) (matches []DMResolution, indexed map[string]bool) {
+	if c.index == nil {
+		return nil, nil
+	}
+	indexed = map[string]bool{}
+	for _, e := range c.index.Entries() {
+		indexed[e.Space] = true
+		display := firstNonEmpty(e.Display, aliases[e.User])
+		score := PersonMatchScore(query, display, e.User)
+		if score <= 0 {
+			continue
+		}
+		matches = append(matches, DMResolution{Space: e.Space, User: e.User, Display: display, Score: score})
+	}
+	return matches, indexed
+}
+
+// checkIndexed checks an index match against live memberships: the DM may
+// be gone or the entry may predate a change. A stale entry is removed.
+func (c *Client) checkIndexed(ctx context.Context, m DMResolution) (bool, error) {
+	members, err := c.ListMembers(WithCacheRefresh(ctx), m.Space, ListMembersOptions{})
+	if err != nil {
+		switch ErrorCodeOf(err) {
+		case CodeNotFound, CodePermissionDenied:
+			c.index.Remove(m.Space)
+			return false, nil
+		}
+		return false, err
+	}
+	for _, member := range members {
+		if NormalizeUserRef(member.Member.Name) == m.User {
+			return true, nil
+		}
+	}
+	c.index.Remove(m.Space)
+	return false, nil
+}
@@ -0,0 +1,192 @@
+package gchat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrorCode is a stable, machine-readable error category.
+type ErrorCode string
+
+// Error codes reported by ErrorCodeOf.
+const (
+	CodeAuthRequired       ErrorCode = "AUTH_REQUIRED"
+	CodeInsufficientScopes ErrorCode = "INSUFFICIENT_SCOPES"
+	CodeChatAppNotFound    ErrorCode = "CHAT_APP_NOT_FOUND"
+	CodePermissionDenied   ErrorCode = "PERMISSION_DENIED"
+	CodeNotFound           ErrorCode = "NOT_FOUND"
+	CodeAmbiguousName      ErrorCode = "AMBIGUOUS_NAME"
+	CodeInvalidArgument    ErrorCode = "INVALID_ARGUMENT"
+	CodeConflict           ErrorCode = "CONFLICT"
+	CodeRateLimited        ErrorCode = "RATE_LIMITED"
+	CodeBudgetExhausted    ErrorCode = "BUDGET_EXHAUSTED"
+	CodeUnavailable        ErrorCode = "UNAVAILABLE"
+	CodeNetwork            ErrorCode = "NETWORK"
+	CodeTimeout            ErrorCode = "TIMEOUT"
+	CodeCanceled           ErrorCode = "CANCELED"
+	CodeValidation         ErrorCode = "VALIDATION"
+	CodeAPI                ErrorCode = "API_ERROR"
+)
+
+// APIError is a non-2xx response from the Chat API.
+type APIError struct {
+	// HTTPStatus is the response status code, e.g. 403.
+	HTTPStatus int
+	// Status is the Google canonical status from the error envelope, e.g.
+	// "PERMISSION_DENIED". Empty when the body was not an envelope.
+	Status string
+	// Message is the server's error message, or the raw body.
+	Message string
+	// Code is the category derived from the status and message.
+	Code ErrorCode
+}
+
+func (e *APIError) Error() string {
+	switch e.Code {
+	case CodeChatAppNotFound:
+		return "google chat app not found in this project; enable Chat API and configure a Chat app in Google Cloud Console (gchatctl auth setup shows links)"
+	case CodeInsufficientScopes:
+		return "insufficient auth scopes; run `gchatctl auth login --all-scopes`"
+	}
+	return fmt.Sprintf("google chat api request failed (%d %s): %s", e.HTTPStatus, http.StatusText(e.HTTPStatus), e.Message)
+}
+
+// ErrorCode implements the interface ErrorCodeOf looks for.
+func (e *APIError) ErrorCode() ErrorCode { return e.Code }
+
+// AmbiguousNameError is returned by ResolveDMByName when several people
+// match a name equally well.
+type AmbiguousNameError struct {
+	Name    string
+	Matches []DMResolution
+	// InAliases is set when the tie was among saved aliases.
+	InAliases bool
+}
+
+func (e *AmbiguousNameError) Error() string {
+	where := ""
+	if e.InAliases {
+		where = " in aliases"
+	}
+	return fmt.Sprintf("name %q is ambiguous%s; matches: %s; use --email or --user", e.Name, where, resolutionChoices(e.Matches))
+}
+
+// ErrorCode implements the interface ErrorCodeOf looks for.
+func (e *AmbiguousNameError) ErrorCode() ErrorCode { return CodeAmbiguousName }
+
+// ScanError is returned by ResolveDMByName when no peer matched but some
+// DM spaces could not be read, so the name may be in one of them. Its code
+// is that of the first failure, e.g. BUDGET_EXHAUSTED or TIMEOUT.
+type ScanError struct {
+	Name    string
+	Skipped []SkippedSpace
+}
+
+func (e *ScanError) Error() string {
+	first := e.Skipped[0]
+	return fmt.Sprintf("no match for name %q among the DM spaces read, but %d could not be read; %s: %v", e.Name, len(e.Skipped), first.Space, first.Err)
+}
+
+func (e *ScanError) Unwrap() error { return e.Skipped[0].Err }
+
+// codedError is a plain message with a fixed code.
+type codedError struct {
+	code ErrorCode
+	msg  string
+}
+
+func (e *codedError) Error() string        { return e.msg }
+func (e *codedError) ErrorCode() ErrorCode { return e.code }
+
+func notFoundf(format string, args ...any) error {
+	return &codedError{code: CodeNotFound, msg: fmt.Sprintf(format, args...)}
+}
+
+// ErrorCodeOf categorizes err. Errors (or wrapped errors) that have an
+// ErrorCode() method report that code; transport, OAuth and context errors
+// are recognized; anything else yields "".
+func ErrorCodeOf(err error) ErrorCode {
+	if err == nil {
+		return ""
+	}
+	var coded interface{ ErrorCode() ErrorCode }
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+	var retrieve *oauth2.RetrieveError
+	var netErr net.Error
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, ErrBudgetExhausted):
+		return CodeBudgetExhausted
+	case errors.As(err, &retrieve):
+		return CodeAuthRequired
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return CodeTimeout
+	case errors.As(err, &netErr), errors.As(err, &urlErr):
+		return CodeNetwork
+	}
+	return ""
+}
+
+// newAPIError builds an APIError from a failed response body.
+func newAPIError(status int, body []byte) *APIError {
+	e := &APIError{HTTPStatus: status, Message: strings.TrimSpace(string(body))}
+	if e.Message == "" {
+		e.Message = http.StatusText(status)
+	}
+	var reasons []string
+	var env GoogleAPIErrorEnvelope
+	if err := json.Unmarshal(body, &env); err == nil && env.Error.Message != "" {
+		e.Message = strings.TrimSpace(env.Error.Message)
+		e.Status = env.Error.Status
+		for _, d := range env.Error.Details {
+			reasons = append(reasons, d.Reason)
+		}
+	}
+	e.Code = classifyAPIError(status, e.Message, reasons)
+	return e
+}
+
+func classifyAPIError(status int, msg string, reasons []string) ErrorCode {
+	lower := strings.ToLower(msg)
+	if strings.Contains(lower, "google chat app not found") {
+		return CodeChatAppNotFound
+	}
+	for _, r := range reasons {
+		if r == "ACCESS_TOKEN_SCOPE_INSUFFICIENT" {
+			return CodeInsufficientScopes
+		}
+	}
+	switch {
+	case status == http.StatusUnauthorized:
+		return CodeAuthRequired
+	case status == http.StatusForbidden && strings.Contains(lower, "insufficient authentication scopes"):
+		return CodeInsufficientScopes
+	case status == http.StatusForbidden:
+		return CodePermissionDenied
+	case status == http.StatusNotFound:
+		return CodeNotFound
+	case status == http.StatusBadRequest:
+		return CodeInvalidArgument
+	case status == http.StatusConflict:
+		return CodeConflict
+	case status == http.StatusTooManyRequests:
+		return CodeRateLimited
+	case status >= 500:
+		return CodeUnavailable
+	}
+	return CodeAPI
+}
@@ -0,0 +1,58 @@
+package gchat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/thomas-sievering/gchatctl/internal/fakechat"
+)
+
+func TestAPIErrorClassification(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		status int
+		body   string
+		want   ErrorCode
+	}{
+		{401, `{"error":{"code":401,"message":"Request had invalid authentication credentials.","status":"UNAUTHENTICATED"}}`, CodeAuthRequired},
+		{403, `{"error":{"code":403,"message":"Request had insufficient authentication scopes.","status":"PERMISSION_DENIED"}}`, CodeInsufficientScopes},
+		{403, `{"error":{"code":403,"message":"nope","status":"PERMISSION_DENIED","details":[{"reason":"ACCESS_TOKEN_SCOPE_INSUFFICIENT"}]}}`, CodeInsufficientScopes},
+		{403, `{"error":{"code":403,"message":"The caller does not have permission","status":"PERMISSION_DENIED"}}`, CodePermissionDenied},
+		{404, `{"error":{"code":404,"message":"Google Chat app not found.","status":"NOT_FOUND"}}`, CodeChatAppNotFound},
+		{404, `{"error":{"code":404,"message":"Space not found.","status":"NOT_FOUND"}}`, CodeNotFound},
+		{429, `{"error":{"code":429,"message":"Quota exceeded","status":"RESOURCE_EXHAUSTED"}}`, CodeRateLimited},
+		{502, `<html>bad gateway</html>`, CodeUnavailable},
+		{418, ``, CodeAPI},
+	}
+	for _, tc := range cases {
+		e := newAPIError(tc.status, []byte(tc.body))
+		if e.Code != tc.want {
+			t.Errorf("%d %s: got %s, want %s", tc.status, tc.body, e.Code, tc.want)
+		}
+		if ErrorCodeOf(fmt.Errorf("wrapped: %w", e)) != tc.want {
+			t.Errorf("%d: code lost through wrapping", tc.status)
+		}
+	}
+}
+
+func TestErrorCodeOfTransportErrors(t *testing.T) {
+	t.Parallel()
+	client, srv := newFakeClient(t)
+	srv.AddFault(fakechat.Fault{Path: "/v1/spaces", Status: http.StatusForbidden, GoogleStatus: "PERMISSION_DENIED", Message: "denied"})
+	_, err := client.ListSpaces(context.Background(), ListSpacesOptions{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatus != 403 || apiErr.Status != "PERMISSION_DENIED" {
+		t.Fatalf("expected *APIError with status details, got %#v", err)
+	}
+
+	srv.Close()
+	if _, err := client.ListSpaces(context.Background(), ListSpacesOptions{}); ErrorCodeOf(err) != CodeNetwork {
+		t.Fatalf("closed server should be NETWORK, got %s (%v)", ErrorCodeOf(err), err)
+	}
+	if ErrorCodeOf(fmt.Errorf("x: %w", ErrBudgetExhausted)) != CodeBudgetExhausted {
+		t.Fatal("budget errors should be BUDGET_EXHAUSTED")
+	}
+}
@@ -0,0 +1,54 @@
+package gchat
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultConcurrency is the worker count for multi-space scans when
+// Config.Concurrency is unset.
+const DefaultConcurrency = 8
+
+// FanOut calls fn for each item on at most concurrency goroutines. Results
+// and errors are returned in input order, so output does not depend on
+// scheduling. Once ctx is done, items not yet started are skipped and their
+// error is ctx.Err(). Progress is reported after each item when ctx carries
+// a WithProgress callback.
+func FanOut[T, R any](ctx context.Context, concurrency int, items []T, fn func(context.Context, T) (R, error)) ([]R, []error) {
+	results := make([]R, len(items))
+	errs := make([]error, len(items))
+	if len(items) == 0 {
+		return results, errs
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	if concurrency > len(items) {
+		concurrency = len(items)
+	}
+
+	progress := newFanOutProgress(ctx, len(items))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					progress.finish(err)
+					continue
+				}
+				results[i], errs[i] = fn(ctx, items[i])
+				progress.finish(errs[i])
+			}
+		}()
+	}
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results, errs
+}
@@ -0,0 +1,90 @@
+package gchat
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFanOutOrderAndBound(t *testing.T) {
+	items := make([]int, 40)
+	for i := range items {
+		items[i] = i
+	}
+	var running, peak atomic.Int32
+	boom := errors.New("boom")
+	got, errs := FanOut(context.Background(), 4, items, func(ctx context.Context, n int) (int, error) {
+		cur := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if cur <= p || peak.CompareAndSwap(p, cur) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * time.Duration(40-n) / 10)
+		if n%10 == 3 {
+			return 0, boom
+		}
+		return n * n, nil
+	})
+	if p := peak.Load(); p > 4 {
+		t.Fatalf("peak concurrency %d exceeds 4", p)
+	}
+	for i := range items {
+		if i%10 == 3 {
+			if !errors.Is(errs[i], boom) {
+				t.Fatalf("item %d: expected boom, got %v", i, errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil || got[i] != i*i {
+			t.Fatalf("item %d: got (%d, %v), want %d", i, got[i], errs[i], i*i)
+		}
+	}
+}
+
+func TestFanOutStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int32
+	_, errs := FanOut(ctx, 1, make([]struct{}, 10), func(ctx context.Context, _ struct{}) (int, error) {
+		if calls.Add(1) == 2 {
+			cancel()
+		}
+		return 0, nil
+	})
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected 2 calls before cancellation took effect, got %d", n)
+	}
+	if !errors.Is(errs[9], context.Canceled) {
+		t.Fatalf("skipped items should report context.Canceled, got %v", errs[9])
+	}
+}
+
+func TestFanOutReportsProgress(t *testing.T) {
+	var events []Progress
+	ctx := WithProgress(context.Background(), func(p Progress) { events = append(events, p) })
+	ctx = WithStage(ctx, "scan")
+	boom := errors.New("boom")
+	_, _ = FanOut(ctx, 3, []int{1, 2, 3, 4, 5}, func(ctx context.Context, n int) (int, error) {
+		CountFound(ctx, n)
+		if n == 2 {
+			return 0, boom
+		}
+		return n, nil
+	})
+	if len(events) != 5 {
+		t.Fatalf("expected one event per item, got %d", len(events))
+	}
+	last := events[len(events)-1]
+	if last.Stage != "scan" || last.Done != 5 || last.Total != 5 || last.Failed != 1 || last.Found != 15 {
+		t.Fatalf("unexpected final progress: %+v", last)
+	}
+	for i, e := range events {
+		if e.Done != i+1 {
+			t.Fatalf("event %d reports done=%d", i, e.Done)
+		}
+	}
+}
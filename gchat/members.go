@@ -0,0 +1,145 @@
+package gchat
+
+import (
+	"context"
+	"strings"
+)
+
+// ListMembersPage fetches a single page of memberships in spaceName.
+func (c *Client) ListMembersPage(ctx context.Context, spaceName string, opts ListMembersOptions) (ListMembershipsResponse, error) {
+	var out ListMembershipsResponse
+	q := listQuery(opts.PageSize, opts.PageToken, "", opts.Filter, opts.Fields)
+	err := c.get(ctx, EndpointMembersList, NormalizeSpaceName(spaceName)+"/members", q, &out)
+	return out, err
+}
+
+// ListMembers collects memberships in spaceName across pages. Full
+// unfiltered listings are served from and stored in the client's Cache.
+func (c *Client) ListMembers(ctx context.Context, spaceName string, opts ListMembersOptions) ([]ChatMembership, error) {
+	cacheable := opts.Limit <= 0 && opts.Filter == "" && opts.PageToken == "" && opts.Fields == ""
+	key := cacheKeyMembers + NormalizeSpaceName(spaceName)
+	var cached []ChatMembership
+	if cacheable && c.cacheGet(ctx, key, &cached) {
+		c.indexMembers(spaceName, cached)
+		return cached, nil
+	}
+	out := make([]ChatMembership, 0, 16)
+	page := opts
+	for opts.Limit <= 0 || len(out) < opts.Limit {
+		page.PageSize = pageSize(opts.Limit, len(out), opts.PageSize, 200)
+		parsed, err := c.ListMembersPage(ctx, spaceName, page)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, parsed.Memberships...)
+		if parsed.NextPageToken == "" {
+			break
+		}
+		page.PageToken = parsed.NextPageToken
+	}
+	if opts.Limit > 0 && len(out) > opts.Limit {
+		out = out[:opts.Limit]
+	}
+	if cacheable {
+		c.cacheSet(key, out)
+	}
+	if opts.Limit <= 0 && opts.Filter == "" && opts.PageToken == "" {
+		c.indexMembers(spaceName, out)
+	}
+	return out, nil
+}
+
+// MemberDisplayNames maps users/... names to display names for members of
+// spaceName. Members without a display name are omitted.
+func (c *Client) MemberDisplayNames(ctx context.Context, spaceName string) (map[string]string, error) {
+	out := map[string]string{}
+	members, err := c.ListMembers(ctx, spaceName, ListMembersOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		id := strings.TrimSpace(m.Member.Name)
+		name := strings.TrimSpace(m.Member.DisplayName)
+		if id == "" || name == "" {
+			continue
+		}
+		out[id] = name
+	}
+	return out, nil
+}
+
+// DMPeer returns the other human in a direct-message space. When currentUser
+// is unknown the first human member is returned.
+func (c *Client) DMPeer(ctx context.Context, spaceName, currentUser string) (string, string, error) {
+	c.noteDM(spaceName)
+	c.noteMe(currentUser)
+	members, err := c.ListMembers(ctx, spaceName, ListMembersOptions{})
+	if err != nil {
+		return "", "", err
+	}
+	cur := strings.TrimSpace(currentUser)
+	var fallbackUser string
+	var fallbackName string
+	for _, m := range members {
+		if strings.ToUpper(strings.TrimSpace(m.Member.Type)) != "HUMAN" {
+			continue
+		}
+		id := strings.TrimSpace(m.Member.Name)
+		if id == "" {
+			continue
+		}
+		name := strings.TrimSpace(m.Member.DisplayName)
+		if fallbackUser == "" {
+			fallbackUser = id
+			fallbackName = name
+		}
+		if cur != "" && id == cur {
+			continue
+		}
+		return id, name, nil
+	}
+	return fallbackUser, fallbackName, nil
+}
+
+// InferCurrentUser guesses the caller as the human present in the most DM
+// spaces, taking the lowest user name on a tie. It is the fallback when
+// users/me is unavailable.
+func (c *Client) InferCurrentUser(ctx context.Context, spaces []ChatSpace) string {
+	dmSpaces := make([]string, 0, len(spaces))
+	for _, s := range spaces {
+		if s.SpaceType == "DIRECT_MESSAGE" {
+			dmSpaces = append(dmSpaces, s.Name)
+		}
+	}
+	memberLists, _ := FanOut(WithStage(ctx, "identify current user"), c.concurrency, dmSpaces, func(ctx context.Context, space string) ([]ChatMembership, error) {
+		return c.ListMembers(ctx, space, ListMembersOptions{})
+	})
+	counts := map[string]int{}
+	for _, members := range memberLists {
+		for _, m := range members {
+			if strings.ToUpper(strings.TrimSpace(m.Member.Type)) != "HUMAN" {
+				continue
+			}
+			id := NormalizeUserRef(m.Member.Name)
+			if id == "" || id == "users/" {
+				continue
+			}
+			counts[id]++
+		}
+	}
+	bestID := ""
+	bestCount := 0
+	for id, n := range counts {
+		if n > bestCount || (n == bestCount && id < bestID) {
+			bestID = id
+			bestCount = n
+		}
+	}
+	// The memberships were listed before the caller was known; index them
+	// now that it is.
+	c.noteMe(bestID)
+	for i, members := range memberLists {
+		c.indexMembers(dmSpaces[i], members)
+	}
+	return bestID
+}
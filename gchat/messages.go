@@ -0,0 +1,76 @@
+package gchat
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ListMessagesPage fetches a single page of messages in spaceName.
+func (c *Client) ListMessagesPage(ctx context.Context, spaceName string, opts ListMessagesOptions) (ListMessagesResponse, error) {
+	var out ListMessagesResponse
+	q := listQuery(opts.PageSize, opts.PageToken, opts.OrderBy, opts.Filter, opts.Fields)
+	err := c.get(ctx, EndpointMessagesList, NormalizeSpaceName(spaceName)+"/messages", q, &out)
+	return out, err
+}
+
+// ListMessages collects messages in spaceName across pages until opts.Limit
+// is reached or the API runs out of pages.
+func (c *Client) ListMessages(ctx context.Context, spaceName string, opts ListMessagesOptions) ([]ChatMessage, error) {
+	items := make([]ChatMessage, 0, initialCap(opts.Limit, 100))
+	page := opts
+	for opts.Limit <= 0 || len(items) < opts.Limit {
+		page.PageSize = pageSize(opts.Limit, len(items), opts.PageSize, 100)
+		parsed, err := c.ListMessagesPage(ctx, spaceName, page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, parsed.Messages...)
+		if parsed.NextPageToken == "" || len(parsed.Messages) == 0 {
+			break
+		}
+		page.PageToken = parsed.NextPageToken
+	}
+	if opts.Limit > 0 && len(items) > opts.Limit {
+		items = items[:opts.Limit]
+	}
+	return items, nil
+}
+
+// GetMessage fetches a message by name (spaces/.../messages/...).
+func (c *Client) GetMessage(ctx context.Context, name string) (ChatMessage, error) {
+	var out ChatMessage
+	err := c.get(ctx, EndpointMessagesGet, strings.TrimSpace(name), nil, &out)
+	return out, err
+}
+
+// SendMessage posts a message to spaceName, as a reply when req.Thread is
+// set.
+func (c *Client) SendMessage(ctx context.Context, spaceName string, req SendMessageRequest) (ChatMessage, error) {
+	var out ChatMessage
+	q := url.Values{}
+	if req.MessageID != "" {
+		q.Set("messageId", req.MessageID)
+	}
+	if req.Thread != nil {
+		q.Set("messageReplyOption", firstNonEmpty(req.ReplyOption, ReplyOrFail))
+	}
+	err := c.do(ctx, EndpointMessagesCreate, http.MethodPost, NormalizeSpaceName(spaceName)+"/messages", q, req, &out)
+	return out, err
+}
+
+// UpdateMessage replaces the text of the message name and returns the
+// updated message.
+func (c *Client) UpdateMessage(ctx context.Context, name, text string) (ChatMessage, error) {
+	var out ChatMessage
+	q := url.Values{"updateMask": {"text"}}
+	body := map[string]string{"text": text}
+	err := c.do(ctx, EndpointMessagesPatch, http.MethodPatch, strings.TrimSpace(name), q, body, &out)
+	return out, err
+}
+
+// DeleteMessage deletes the message name.
+func (c *Client) DeleteMessage(ctx context.Context, name string) error {
+	return c.do(ctx, EndpointMessagesDelete, http.MethodDelete, strings.TrimSpace(name), nil, nil, nil)
+}
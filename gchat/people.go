@@ -0,0 +1,298 @@
+package gchat
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ResolveOptions controls ResolveDMByName.
+type ResolveOptions struct {
+	// ScanLimit caps how many DM spaces are inspected when aliases do not match.
+	ScanLimit int
+	// Aliases maps users/... names to local display names and is consulted first.
+	Aliases map[string]string
+}
+
+// DMResolution is the DM peer chosen by ResolveDMByName.
+type DMResolution struct {
+	Space   string `json:"space,omitempty"`
+	User    string `json:"user"`
+	Display string `json:"display,omitempty"`
+	Score   int    `json:"score"`
+	// Skipped lists the DM spaces the scan could not read; an equally good
+	// match may be among them.
+	Skipped []SkippedSpace `json:"-"`
+}
+
+// SkippedSpace is a space a scan could not read.
+type SkippedSpace struct {
+	Space string
+	Err   error
+}
+
+// ResolveDMByName finds the direct-message peer whose display name or user
+// ID best matches rawName, consulting aliases first. An exact display-name
+// or alias match in the client's DMIndex skips the scan of DM spaces;
+// weaker index matches are merged with the scan before ambiguity is judged.
+func (c *Client) ResolveDMByName(ctx context.Context, rawName string, opts ResolveOptions) (DMResolution, error) {
+	query := NormalizeLookup(rawName)
+	if query == "" {
+		return DMResolution{}, &codedError{code: CodeValidation, msg: "--name cannot be empty"}
+	}
+	scanLimit := opts.ScanLimit
+	if scanLimit <= 0 {
+		scanLimit = 200
+	}
+	aliases := opts.Aliases
+
+	aliasMatches := make([]DMResolution, 0, 4)
+	for userRef, display := range aliases {
+		user := NormalizeUserRef(userRef)
+		score := PersonMatchScore(query, display, user)
+		if score <= 0 {
+			continue
+		}
+		aliasMatches = append(aliasMatches, DMResolution{
+			User:    user,
+			Display: strings.TrimSpace(display),
+			Score:   score,
+		})
+	}
+	if len(aliasMatches) > 0 {
+		sortResolutions(aliasMatches)
+		if len(aliasMatches) > 1 && aliasMatches[0].Score == aliasMatches[1].Score {
+			return DMResolution{}, &AmbiguousNameError{Name: strings.TrimSpace(rawName), Matches: aliasMatches, InAliases: true}
+		}
+		space, err := c.FindDirectMessage(ctx, aliasMatches[0].User)
+		if err == nil && strings.TrimSpace(space.Name) != "" {
+			best := aliasMatches[0]
+			best.Space = space.Name
+			return best, nil
+		}
+	}
+
+	indexedMatches, indexed := c.indexMatches(query, aliases)
+
+	spaceFetchLimit := scanLimit * 3
+	if spaceFetchLimit < 100 {
+		spaceFetchLimit = 100
+	}
+	spaces, err := c.ListSpaces(ctx, ListSpacesOptions{Limit: spaceFetchLimit})
+	if err != nil {
+		return DMResolution{}, err
+	}
+
+	// Only the DMs missing from the index are read; an indexed match is
+	// settled once no unindexed DM could hold a peer with the same name.
+	dmSpaces := make([]string, 0, scanLimit)
+	for _, s := range spaces {
+		if s.SpaceType != "DIRECT_MESSAGE" {
+			continue
+		}
+		if len(dmSpaces) >= scanLimit {
+			break
+		}
+		if !indexed[s.Name] {
+			dmSpaces = append(dmSpaces, s.Name)
+		}
+	}
+
+	var me string
+	if len(dmSpaces) > 0 {
+		me, _ = c.CurrentUser(ctx)
+		if strings.TrimSpace(me) == "" {
+			me = c.InferCurrentUser(ctx, spaces)
+		}
+	}
+	candidates, errs := FanOut(WithStage(ctx, "resolve name"), c.concurrency, dmSpaces, func(ctx context.Context, space string) (DMResolution, error) {
+		peerUser, peerName, err := c.DMPeer(ctx, space, me)
+		if err != nil || strings.TrimSpace(peerUser) == "" {
+			return DMResolution{}, err
+		}
+		peerUser = NormalizeUserRef(peerUser)
+		display := strings.TrimSpace(peerName)
+		if display == "" {
+			display = strings.TrimSpace(aliases[peerUser])
+		}
+		return DMResolution{
+			Space:   space,
+			User:    peerUser,
+			Display: display,
+			Score:   PersonMatchScore(query, display, peerUser),
+		}, nil
+	})
+	matches := make([]DMResolution, 0, 8)
+	var skipped []SkippedSpace
+	for i, m := range candidates {
+		if errs[i] != nil {
+			skipped = append(skipped, SkippedSpace{Space: dmSpaces[i], Err: errs[i]})
+			continue
+		}
+		if m.Score > 0 {
+			matches = append(matches, m)
+		}
+	}
+	scanned := make(map[string]bool, len(dmSpaces))
+	for _, space := range dmSpaces {
+		scanned[space] = true
+	}
+	matches = append(matches, indexedMatches...)
+
+	verified := map[string]bool{}
+	for {
+		if len(matches) == 0 && len(skipped) > 0 {
+			return DMResolution{}, &ScanError{Name: strings.TrimSpace(rawName), Skipped: skipped}
+		}
+		if len(matches) == 0 {
+			return DMResolution{}, notFoundf("no direct-message peer matched name %q in the last %d DM spaces; use --email or --user", strings.TrimSpace(rawName), scanLimit)
+		}
+		sortResolutions(matches)
+		// Index entries among the best matches are checked live before they
+		// count; a stale one is dropped and the rest weighed again.
+		stale := -1
+		for i := 0; i < len(matches) && matches[i].Score == matches[0].Score; i++ {
+			m := matches[i]
+			if scanned[m.Space] || verified[m.Space] {
+				continue
+			}
+			live, err := c.checkIndexed(ctx, m)
+			if err != nil {
+				return DMResolution{}, err
+			}
+			if !live {
+				stale = i
+				break
+			}
+			verified[m.Space] = true
+		}
+		if stale >= 0 {
+			matches = append(matches[:stale], matches[stale+1:]...)
+			continue
+		}
+		if len(matches) > 1 && matches[0].Score == matches[1].Score {
+			return DMResolution{}, &AmbiguousNameError{Name: strings.TrimSpace(rawName), Matches: matches}
+		}
+		best := matches[0]
+		best.Skipped = skipped
+		return best, nil
+	}
+}
+
+func sortResolutions(matches []DMResolution) {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		li := strings.ToLower(firstNonEmpty(matches[i].Display, matches[i].User))
+		lj := strings.ToLower(firstNonEmpty(matches[j].Display, matches[j].User))
+		return li < lj
+	})
+}
+
+func resolutionChoices(matches []DMResolution) string {
+	choices := make([]string, 0, minInt(3, len(matches)))
+	for i := 0; i < len(matches) && i < 3; i++ {
+		label := firstNonEmpty(matches[i].Display, matches[i].User)
+		choices = append(choices, fmt.Sprintf("%s (%s)", label, matches[i].User))
+	}
+	return strings.Join(choices, ", ")
+}
+
+// NormalizeLookup folds a name query for matching.
+func NormalizeLookup(raw string) string {
+	return strings.ToLower(strings.TrimSpace(raw))
+}
+
+// PersonMatchScore ranks how well query matches a person. Display-name
+// matches outrank user-ID matches; exact outranks prefix outranks substring.
+// Zero means no match.
+func PersonMatchScore(query, displayName, userRef string) int {
+	q := NormalizeLookup(query)
+	if q == "" {
+		return 0
+	}
+	display := NormalizeLookup(displayName)
+	user := NormalizeLookup(strings.TrimPrefix(NormalizeUserRef(userRef), "users/"))
+
+	if display != "" {
+		if display == q {
+			return 300
+		}
+		if strings.HasPrefix(display, q) {
+			return 250
+		}
+		if strings.Contains(display, q) {
+			return 200
+		}
+	}
+	if user != "" {
+		if user == q {
+			return 150
+		}
+		if strings.HasPrefix(user, q) {
+			return 120
+		}
+		if strings.Contains(user, q) {
+			return 100
+		}
+	}
+	return 0
+}
+
+// NormalizeSpaceName accepts a bare space ID or spaces/... name.
+func NormalizeSpaceName(raw string) string {
+	s := strings.TrimSpace(raw)
+	if strings.HasPrefix(s, "spaces/") {
+		return s
+	}
+	return "spaces/" + s
+}
+
+// SpaceOf returns the space of a resource named under it, such as a
+// message or thread: spaces/AAA/messages/BBB gives spaces/AAA. It returns
+// "" for names that are not under a space.
+func SpaceOf(name string) string {
+	parts := strings.Split(strings.TrimSpace(name), "/")
+	if len(parts) < 4 || parts[0] != "spaces" || parts[1] == "" {
+		return ""
+	}
+	return parts[0] + "/" + parts[1]
+}
+
+// NormalizeUserRef accepts an email, bare user ID or users/... name.
+func NormalizeUserRef(raw string) string {
+	s := strings.TrimSpace(raw)
+	if strings.HasPrefix(s, "users/") {
+		return s
+	}
+	return "users/" + s
+}
+
+// ParseTime parses an API timestamp, reporting false when raw is empty or invalid.
+func ParseTime(raw string) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err == nil {
+		return t.UTC(), true
+	}
+	t, err = time.Parse(time.RFC3339, raw)
+	if err == nil {
+		return t.UTC(), true
+	}
+	return time.Time{}, false
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package gchat
+
+import "testing"
+
+func TestNormalizeRefs(t *testing.T) {
+	t.Parallel()
+
+	if got := NormalizeSpaceName("AAA123"); got != "spaces/AAA123" {
+		t.Fatalf("NormalizeSpaceName added prefix incorrectly: got %q", got)
+	}
+	if got := NormalizeSpaceName("spaces/AAA123"); got != "spaces/AAA123" {
+		t.Fatalf("NormalizeSpaceName changed existing prefix: got %q", got)
+	}
+	if got := NormalizeUserRef("alice@example.com"); got != "users/alice@example.com" {
+		t.Fatalf("NormalizeUserRef added prefix incorrectly: got %q", got)
+	}
+	if got := NormalizeUserRef("users/123"); got != "users/123" {
+		t.Fatalf("NormalizeUserRef changed existing prefix: got %q", got)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  string
+		expect bool
+	}{
+		{name: "RFC3339Nano", input: "2026-02-17T12:34:56.123456Z", expect: true},
+		{name: "RFC3339", input: "2026-02-17T12:34:56Z", expect: true},
+		{name: "empty", input: "", expect: false},
+		{name: "invalid", input: "not-a-time", expect: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, ok := ParseTime(tc.input)
+			if ok != tc.expect {
+				t.Fatalf("ParseTime(%q) ok=%v, expected %v", tc.input, ok, tc.expect)
+			}
+		})
+	}
+}
+
+func TestPersonMatchScoreOrdering(t *testing.T) {
+	t.Parallel()
+
+	exactDisplay := PersonMatchScore("simon", "Simon", "users/simon@example.com")
+	prefixDisplay := PersonMatchScore("sim", "Simon", "users/simon@example.com")
+	containsDisplay := PersonMatchScore("imo", "Simon", "users/simon@example.com")
+	userOnly := PersonMatchScore("simon@example", "", "users/simon@example.com")
+	none := PersonMatchScore("zzz", "Simon", "users/simon@example.com")
+
+	if !(exactDisplay > prefixDisplay && prefixDisplay > containsDisplay) {
+		t.Fatalf("expected display score order exact > prefix > contains, got %d, %d, %d", exactDisplay, prefixDisplay, containsDisplay)
+	}
+	if userOnly <= 0 {
+		t.Fatalf("expected positive score for user-only match, got %d", userOnly)
+	}
+	if none != 0 {
+		t.Fatalf("expected zero score for no match, got %d", none)
+	}
+}
@@ -0,0 +1,80 @@
+package gchat
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Progress describes how far a FanOut scan has got.
+type Progress struct {
+	// Stage names the scan, as set with WithStage.
+	Stage string
+	// Done counts finished items, failures included.
+	Done   int
+	Total  int
+	Failed int
+	// Found is the running total passed to CountFound under the same
+	// WithProgress context.
+	Found int64
+}
+
+type progressKey struct{}
+
+type stageKey struct{}
+
+type progressTracker struct {
+	fn    func(Progress)
+	mu    sync.Mutex
+	found atomic.Int64
+}
+
+// WithProgress makes FanOut calls under ctx report to fn after every item.
+// Calls to fn are serialized.
+func WithProgress(ctx context.Context, fn func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, &progressTracker{fn: fn})
+}
+
+// WithStage labels the progress reported by FanOut calls under ctx.
+func WithStage(ctx context.Context, stage string) context.Context {
+	return context.WithValue(ctx, stageKey{}, stage)
+}
+
+// CountFound adds n to the Found count reported to the WithProgress callback
+// of ctx, if any.
+func CountFound(ctx context.Context, n int) {
+	if t, _ := ctx.Value(progressKey{}).(*progressTracker); t != nil {
+		t.found.Add(int64(n))
+	}
+}
+
+// fanOutProgress counts finished items of one FanOut call.
+type fanOutProgress struct {
+	tracker *progressTracker
+	stage   string
+	total   int
+	done    int
+	failed  int
+}
+
+func newFanOutProgress(ctx context.Context, total int) *fanOutProgress {
+	t, _ := ctx.Value(progressKey{}).(*progressTracker)
+	if t == nil {
+		return nil
+	}
+	stage, _ := ctx.Value(stageKey{}).(string)
+	return &fanOutProgress{tracker: t, stage: stage, total: total}
+}
+
+func (p *fanOutProgress) finish(err error) {
+	if p == nil {
+		return
+	}
+	p.tracker.mu.Lock()
+	defer p.tracker.mu.Unlock()
+	p.done++
+	if err != nil {
+		p.failed++
+	}
+	p.tracker.fn(Progress{Stage: p.stage, Done: p.done, Total: p.total, Failed: p.failed, Found: p.tracker.found.Load()})
+}
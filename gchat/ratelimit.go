@@ -0,0 +1,156 @@
+package gchat
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit is a token bucket: Rate requests per second on average, with
+// bursts of up to Burst requests. A zero Rate means unlimited.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// DefaultRateLimit is a conservative per-user limit that keeps agent loops
+// well under the Chat API read quotas.
+var DefaultRateLimit = RateLimit{Rate: 20, Burst: 40}
+
+// DefaultEndpointRateLimits are extra per-method limits for write calls,
+// which the Chat API meters far more tightly than reads.
+func DefaultEndpointRateLimits() map[string]RateLimit {
+	return map[string]RateLimit{
+		EndpointMessagesCreate:    {Rate: 1, Burst: 5},
+		EndpointMessagesPatch:     {Rate: 1, Burst: 5},
+		EndpointMessagesDelete:    {Rate: 1, Burst: 5},
+		EndpointReactionsCreate:   {Rate: 1, Burst: 5},
+		EndpointReactionsDelete:   {Rate: 1, Burst: 5},
+		EndpointAttachmentsUpload: {Rate: 1, Burst: 5},
+	}
+}
+
+// ErrBudgetExhausted is returned for requests made after a
+// RateLimitTransport's MaxRequests budget has been spent.
+var ErrBudgetExhausted = errors.New("request budget exhausted")
+
+// RateLimitTransport delays requests so they stay under a client-side rate
+// and optionally refuses them once a fixed request budget is used up. Every
+// request draws from the Limit bucket; requests whose EndpointName has an
+// entry in Endpoints also draw from that endpoint's own bucket.
+type RateLimitTransport struct {
+	Base http.RoundTripper
+	// Limit applies to all requests.
+	Limit RateLimit
+	// Endpoints adds per-method limits keyed by EndpointName.
+	Endpoints map[string]RateLimit
+	// MaxRequests caps the number of requests sent; zero means no cap.
+	MaxRequests int64
+
+	once      sync.Once
+	global    *bucket
+	endpoints map[string]*bucket
+	sent      atomic.Int64
+	refused   atomic.Bool
+}
+
+// Sent reports how many requests have been passed to Base.
+func (t *RateLimitTransport) Sent() int64 { return t.sent.Load() }
+
+// Exhausted reports whether any request was refused for lack of budget.
+func (t *RateLimitTransport) Exhausted() bool { return t.refused.Load() }
+
+// RoundTrip implements http.RoundTripper.
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.once.Do(t.init)
+	if n := t.sent.Add(1); t.MaxRequests > 0 && n > t.MaxRequests {
+		t.sent.Add(-1)
+		t.refused.Store(true)
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, ErrBudgetExhausted
+	}
+
+	ctx := req.Context()
+	wait := t.global.reserve(time.Now())
+	if b := t.endpoints[EndpointName(ctx)]; b != nil {
+		if d := b.reserve(time.Now()); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if req.Body != nil {
+				_ = req.Body.Close()
+			}
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+func (t *RateLimitTransport) init() {
+	t.global = newBucket(t.Limit)
+	t.endpoints = make(map[string]*bucket, len(t.Endpoints))
+	for name, l := range t.Endpoints {
+		if b := newBucket(l); b != nil {
+			t.endpoints[name] = b
+		}
+	}
+}
+
+// bucket is a token bucket that hands out reservations: a request always
+// takes a token, possibly driving the balance negative, and waits until the
+// balance would have refilled.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(l RateLimit) *bucket {
+	if l.Rate <= 0 {
+		return nil
+	}
+	burst := float64(l.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{rate: l.Rate, burst: burst, tokens: burst}
+}
+
+// reserve takes one token and returns how long the caller must wait before
+// using it. A nil bucket never waits.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
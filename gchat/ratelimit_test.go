@@ -0,0 +1,94 @@
+package gchat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/thomas-sievering/gchatctl/internal/fakechat"
+)
+
+func newLimitedClient(t *testing.T, srv *fakechat.Server, limiter *RateLimitTransport) *Client {
+	t.Helper()
+	hc := &http.Client{Transport: &oauth2.Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: fakechat.DefaultAccessToken}),
+		Base:   limiter,
+	}}
+	return NewClient(Config{HTTPClient: hc, BaseURL: srv.APIBaseURL()})
+}
+
+func TestBucketBurstThenPaces(t *testing.T) {
+	t.Parallel()
+	b := newBucket(RateLimit{Rate: 10, Burst: 2})
+	now := time.Now()
+	if d := b.reserve(now); d != 0 {
+		t.Fatalf("first request should not wait, got %s", d)
+	}
+	if d := b.reserve(now); d != 0 {
+		t.Fatalf("second request is within burst, got %s", d)
+	}
+	if d := b.reserve(now); d != 100*time.Millisecond {
+		t.Fatalf("third request should wait one token (100ms), got %s", d)
+	}
+	if d := b.reserve(now.Add(time.Second)); d != 0 {
+		t.Fatalf("bucket should refill after a second, got %s", d)
+	}
+	if newBucket(RateLimit{}) != nil {
+		t.Fatal("zero rate should disable the bucket")
+	}
+}
+
+func TestRateLimitTransportPacesSends(t *testing.T) {
+	t.Parallel()
+	srv := fakechat.New()
+	t.Cleanup(srv.Close)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM"})
+	limiter := &RateLimitTransport{Endpoints: map[string]RateLimit{EndpointMessagesCreate: {Rate: 20, Burst: 1}}}
+	client := newLimitedClient(t, srv, limiter)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := client.SendMessage(context.Background(), "spaces/ROOM", SendMessageRequest{Text: "hi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Fatalf("3 sends at 20/s with burst 1 should take >= 100ms, took %s", d)
+	}
+}
+
+func TestRateLimitTransportBudget(t *testing.T) {
+	t.Parallel()
+	srv := fakechat.New()
+	t.Cleanup(srv.Close)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM"})
+	limiter := &RateLimitTransport{MaxRequests: 2}
+	retry := &RetryTransport{Base: limiter}
+	hc := &http.Client{Transport: &oauth2.Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: fakechat.DefaultAccessToken}),
+		Base:   retry,
+	}}
+	client := NewClient(Config{HTTPClient: hc, BaseURL: srv.APIBaseURL()})
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.ListSpaces(context.Background(), ListSpacesOptions{Limit: 1}); err != nil {
+			t.Fatalf("request %d within budget failed: %v", i+1, err)
+		}
+	}
+	if _, err := client.ListSpaces(context.Background(), ListSpacesOptions{Limit: 1}); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("expected ErrBudgetExhausted, got %v", err)
+	}
+	if !limiter.Exhausted() || limiter.Sent() != 2 {
+		t.Fatalf("exhausted=%v sent=%d, want true/2", limiter.Exhausted(), limiter.Sent())
+	}
+	if retry.Retries() != 0 {
+		t.Fatalf("budget errors must not be retried, saw %d retries", retry.Retries())
+	}
+	if got := srv.CountRequests(http.MethodGet, "/v1/spaces"); got != 2 {
+		t.Fatalf("expected 2 requests to reach the server, got %d", got)
+	}
+}
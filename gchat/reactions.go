@@ -0,0 +1,54 @@
+package gchat
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// CreateReaction adds the caller's emoji reaction to message
+// (spaces/.../messages/...).
+func (c *Client) CreateReaction(ctx context.Context, message string, emoji Emoji) (Reaction, error) {
+	var out Reaction
+	body := map[string]Emoji{"emoji": emoji}
+	err := c.do(ctx, EndpointReactionsCreate, http.MethodPost, strings.TrimSpace(message)+"/reactions", nil, body, &out)
+	return out, err
+}
+
+// ListReactionsPage fetches a single page of the reactions to message.
+func (c *Client) ListReactionsPage(ctx context.Context, message string, opts ListReactionsOptions) (ListReactionsResponse, error) {
+	var out ListReactionsResponse
+	q := listQuery(opts.PageSize, opts.PageToken, "", opts.Filter, "")
+	err := c.get(ctx, EndpointReactionsList, strings.TrimSpace(message)+"/reactions", q, &out)
+	return out, err
+}
+
+// ListReactions collects the reactions to message across pages until
+// opts.Limit is reached or the API runs out of pages.
+func (c *Client) ListReactions(ctx context.Context, message string, opts ListReactionsOptions) ([]Reaction, error) {
+	items := make([]Reaction, 0, initialCap(opts.Limit, 200))
+	page := opts
+	for opts.Limit <= 0 || len(items) < opts.Limit {
+		page.PageSize = pageSize(opts.Limit, len(items), opts.PageSize, 200)
+		parsed, err := c.ListReactionsPage(ctx, message, page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, parsed.Reactions...)
+		if parsed.NextPageToken == "" || len(parsed.Reactions) == 0 {
+			break
+		}
+		page.PageToken = parsed.NextPageToken
+	}
+	if opts.Limit > 0 && len(items) > opts.Limit {
+		items = items[:opts.Limit]
+	}
+	return items, nil
+}
+
+// DeleteReaction removes the reaction name
+// (spaces/.../messages/.../reactions/...). Only the caller's own reactions
+// can be deleted.
+func (c *Client) DeleteReaction(ctx context.Context, name string) error {
+	return c.do(ctx, EndpointReactionsDelete, http.MethodDelete, strings.TrimSpace(name), nil, nil, nil)
+}
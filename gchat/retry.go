@@ -0,0 +1,212 @@
+package gchat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Retry defaults used when the corresponding RetryTransport field is zero.
+const (
+	DefaultMaxAttempts    = 4
+	DefaultRetryDeadline  = 2 * time.Minute
+	DefaultRetryBaseDelay = 500 * time.Millisecond
+	DefaultRetryMaxDelay  = 30 * time.Second
+)
+
+type retryAttemptKey struct{}
+
+// RetryAttempt reports which attempt (1-based) a request made through
+// RetryTransport is. It returns 0 for requests that did not pass through one.
+func RetryAttempt(ctx context.Context) int {
+	n, _ := ctx.Value(retryAttemptKey{}).(int)
+	return n
+}
+
+// RetryTransport retries 429 and 5xx responses (and transport errors) with
+// jittered exponential backoff, honoring Retry-After. Only idempotent
+// requests are retried, plus message creates that carry a client-assigned
+// messageId, which the API deduplicates.
+type RetryTransport struct {
+	Base http.RoundTripper
+	// MaxAttempts is the total number of tries including the first.
+	MaxAttempts int
+	// Deadline bounds the time spent retrying a single request.
+	Deadline time.Duration
+	// BaseDelay and MaxDelay shape the exponential backoff.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// AttemptTimeout bounds each individual attempt; zero means no limit.
+	AttemptTimeout time.Duration
+
+	retries atomic.Int64
+}
+
+// Retries reports how many retries have been issued through t.
+func (t *RetryTransport) Retries() int64 { return t.retries.Load() }
+
+// RoundTrip implements http.RoundTripper.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	maxAttempts := t.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	if !retryable(req) {
+		maxAttempts = 1
+	}
+	deadline := t.Deadline
+	if deadline <= 0 {
+		deadline = DefaultRetryDeadline
+	}
+	stopAt := time.Now().Add(deadline)
+	ctx := req.Context()
+
+	for attempt := 1; ; attempt++ {
+		actx := context.WithValue(ctx, retryAttemptKey{}, attempt)
+		cancel := context.CancelFunc(func() {})
+		if t.AttemptTimeout > 0 {
+			actx, cancel = context.WithTimeout(actx, t.AttemptTimeout)
+		}
+		attemptReq := req.WithContext(actx)
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+		resp, err := base.RoundTrip(attemptReq)
+		if err != nil {
+			cancel()
+		} else {
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+		}
+		if attempt >= maxAttempts || !shouldRetry(ctx, resp, err) {
+			return resp, err
+		}
+		wait := t.backoff(attempt, resp)
+		if time.Now().Add(wait).After(stopAt) {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		t.retries.Add(1)
+	}
+}
+
+func (t *RetryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return d
+		}
+	}
+	baseDelay := t.BaseDelay
+	if baseDelay <= 0 {
+		baseDelay = DefaultRetryBaseDelay
+	}
+	maxDelay := t.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultRetryMaxDelay
+	}
+	ceiling := baseDelay << (attempt - 1)
+	if ceiling <= 0 || ceiling > maxDelay {
+		ceiling = maxDelay
+	}
+	// Equal jitter: at least half the ceiling so retries never stampede.
+	half := ceiling / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return req.Body == nil || req.GetBody != nil
+	case http.MethodPost:
+		return req.URL.Query().Get("messageId") != "" && (req.Body == nil || req.GetBody != nil)
+	default:
+		return false
+	}
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return transientError(err)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// transientError reports whether a transport error may go away on its own:
+// timeouts, refused or dropped connections and temporary DNS failures.
+// Anything else, such as a bad URL, a certificate error or a request a
+// cassette has no recording for, fails the same way on every attempt.
+func transientError(err error) bool {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, ErrBudgetExhausted):
+		return false
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE):
+		return true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	}
+	return false
+}
+
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		d := time.Until(at)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// cancelOnClose releases an attempt's context once its body is consumed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,147 @@
+package gchat
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/thomas-sievering/gchatctl/internal/fakechat"
+)
+
+func newRetryClient(t *testing.T, srv *fakechat.Server) (*Client, *RetryTransport) {
+	t.Helper()
+	retry := &RetryTransport{BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	hc := &http.Client{Transport: &oauth2.Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: fakechat.DefaultAccessToken}),
+		Base:   retry,
+	}}
+	return NewClient(Config{HTTPClient: hc, BaseURL: srv.APIBaseURL()}), retry
+}
+
+func TestRetryTransportRetriesTransientErrors(t *testing.T) {
+	t.Parallel()
+	srv := fakechat.New()
+	t.Cleanup(srv.Close)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM"})
+	srv.AddFault(fakechat.Fault{Path: "/v1/spaces", Status: http.StatusServiceUnavailable, GoogleStatus: "UNAVAILABLE", Message: "busy", Times: 1})
+	srv.AddFault(fakechat.Fault{Path: "/v1/spaces", Status: http.StatusTooManyRequests, GoogleStatus: "RESOURCE_EXHAUSTED", Message: "slow down", Header: http.Header{"Retry-After": {"0"}}, Times: 1})
+	client, retry := newRetryClient(t, srv)
+
+	spaces, err := client.ListSpaces(context.Background(), ListSpacesOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListSpaces: %v", err)
+	}
+	if len(spaces) != 1 {
+		t.Fatalf("expected 1 space, got %d", len(spaces))
+	}
+	if got := retry.Retries(); got != 2 {
+		t.Fatalf("expected 2 retries, got %d", got)
+	}
+}
+
+func TestRetryTransportGivesUpAfterMaxAttempts(t *testing.T) {
+	t.Parallel()
+	srv := fakechat.New()
+	t.Cleanup(srv.Close)
+	srv.AddFault(fakechat.Fault{Path: "/v1/spaces", Status: http.StatusInternalServerError, GoogleStatus: "INTERNAL", Message: "boom"})
+	client, retry := newRetryClient(t, srv)
+	retry.MaxAttempts = 3
+
+	if _, err := client.ListSpaces(context.Background(), ListSpacesOptions{Limit: 1}); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected final error to surface, got %v", err)
+	}
+	if got := srv.CountRequests(http.MethodGet, "/v1/spaces"); got != 3 {
+		t.Fatalf("expected 3 attempts, got %d", got)
+	}
+}
+
+func TestRetryTransportOnlyRetriesIdempotentSends(t *testing.T) {
+	t.Parallel()
+	srv := fakechat.New()
+	t.Cleanup(srv.Close)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM"})
+	client, _ := newRetryClient(t, srv)
+	path := "/v1/spaces/ROOM/messages"
+
+	srv.AddFault(fakechat.Fault{Method: http.MethodPost, Path: path, Status: http.StatusServiceUnavailable, GoogleStatus: "UNAVAILABLE", Message: "busy", Times: 1})
+	if _, err := client.SendMessage(context.Background(), "spaces/ROOM", SendMessageRequest{Text: "hi"}); err == nil {
+		t.Fatal("send without messageId must not be retried")
+	}
+
+	srv.AddFault(fakechat.Fault{Method: http.MethodPost, Path: path, Status: http.StatusServiceUnavailable, GoogleStatus: "UNAVAILABLE", Message: "busy", Times: 1})
+	msg, err := client.SendMessage(context.Background(), "spaces/ROOM", SendMessageRequest{Text: "hi", MessageID: "client-abc"})
+	if err != nil {
+		t.Fatalf("send with messageId should be retried: %v", err)
+	}
+	if msg.Name != "spaces/ROOM/messages/client-abc" || msg.Text != "hi" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+	if got := srv.CountRequests(http.MethodPost, path); got != 3 {
+		t.Fatalf("expected 3 POSTs (1 + 1 retried), got %d", got)
+	}
+}
+
+// failingTransport fails every request with err and counts the attempts.
+type failingTransport struct {
+	err      error
+	attempts atomic.Int32
+}
+
+func (f *failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	f.attempts.Add(1)
+	return nil, f.err
+}
+
+func TestRetryTransportOnlyRetriesTransientTransportErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name     string
+		err      error
+		attempts int32
+	}{
+		{name: "permanent", err: errors.New("cassette: no recorded interaction for GET /v1/spaces"), attempts: 1},
+		{name: "certificate", err: &tls.CertificateVerificationError{Err: errors.New("x509: unknown authority")}, attempts: 1},
+		{name: "refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, attempts: 3},
+		{name: "dropped", err: io.ErrUnexpectedEOF, attempts: 3},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			base := &failingTransport{err: tc.err}
+			retry := &RetryTransport{Base: base, MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+			req, _ := http.NewRequest(http.MethodGet, "https://chat.googleapis.com/v1/spaces", nil)
+			if _, err := retry.RoundTrip(req); !errors.Is(err, tc.err) {
+				t.Fatalf("RoundTrip error = %v, want %v", err, tc.err)
+			}
+			if got := base.attempts.Load(); got != tc.attempts {
+				t.Fatalf("%d attempts, want %d", got, tc.attempts)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+	if d, ok := parseRetryAfter("3"); !ok || d != 3*time.Second {
+		t.Fatalf("seconds form: %v %v", d, ok)
+	}
+	if _, ok := parseRetryAfter("soon"); ok {
+		t.Fatal("invalid value should not parse")
+	}
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if d, ok := parseRetryAfter(future); !ok || d <= 0 || d > time.Minute {
+		t.Fatalf("date form: %v %v", d, ok)
+	}
+}
@@ -0,0 +1,151 @@
+package gchat
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ListSpacesPage fetches a single page of spaces the caller is a member of.
+func (c *Client) ListSpacesPage(ctx context.Context, opts ListSpacesOptions) (ListSpacesResponse, error) {
+	var out ListSpacesResponse
+	err := c.get(ctx, EndpointSpacesList, "spaces", listQuery(opts.PageSize, opts.PageToken, "", opts.Filter, opts.Fields), &out)
+	return out, err
+}
+
+// ListSpaces collects spaces across pages until opts.Limit is reached or the
+// API runs out of pages. Unfiltered listings are served from the client's
+// Cache, which only ever holds listings that ran to the last page.
+func (c *Client) ListSpaces(ctx context.Context, opts ListSpacesOptions) ([]ChatSpace, error) {
+	cacheable := opts.Filter == "" && opts.PageToken == ""
+	var cached cachedSpaces
+	if cacheable && c.cacheGet(ctx, cacheKeySpaces, &cached) && cached.Complete {
+		items := cached.Spaces
+		if opts.Limit > 0 && len(items) > opts.Limit {
+			items = items[:opts.Limit]
+		}
+		c.noteDMSpaces(items)
+		return items, nil
+	}
+
+	items := make([]ChatSpace, 0, initialCap(opts.Limit, 100))
+	page := opts
+	complete := false
+	for opts.Limit <= 0 || len(items) < opts.Limit {
+		page.PageSize = pageSize(opts.Limit, len(items), opts.PageSize, 100)
+		parsed, err := c.ListSpacesPage(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, parsed.Spaces...)
+		c.noteDMSpaces(parsed.Spaces)
+		if parsed.NextPageToken == "" {
+			complete = true
+			break
+		}
+		if len(parsed.Spaces) == 0 {
+			break
+		}
+		page.PageToken = parsed.NextPageToken
+	}
+	if cacheable && complete {
+		c.cacheSet(cacheKeySpaces, cachedSpaces{Spaces: items, Complete: true})
+	}
+	if opts.Limit > 0 && len(items) > opts.Limit {
+		items = items[:opts.Limit]
+	}
+	return items, nil
+}
+
+// FindDirectMessage returns the DM space between the caller and userName.
+func (c *Client) FindDirectMessage(ctx context.Context, userName string) (ChatSpace, error) {
+	var out ChatSpace
+	if err := c.get(ctx, EndpointFindDirectMessage, "spaces:findDirectMessage", url.Values{"name": {userName}}, &out); err != nil {
+		return out, err
+	}
+	if strings.TrimSpace(out.Name) == "" {
+		return out, notFoundf("no direct message found for %s", userName)
+	}
+	return out, nil
+}
+
+// GetSpaceReadState returns the caller's read state for spaceName.
+func (c *Client) GetSpaceReadState(ctx context.Context, spaceName string) (SpaceReadState, error) {
+	var out SpaceReadState
+	spaceID := strings.TrimPrefix(NormalizeSpaceName(spaceName), "spaces/")
+	err := c.get(ctx, EndpointGetSpaceReadState, "users/me/spaces/"+url.PathEscape(spaceID)+"/spaceReadState", nil, &out)
+	return out, err
+}
+
+// CurrentUser returns the caller's users/... resource name.
+func (c *Client) CurrentUser(ctx context.Context) (string, error) {
+	var u ChatUserResource
+	if err := c.get(ctx, EndpointCurrentUser, "users/me", nil, &u); err != nil {
+		return "", err
+	}
+	c.noteMe(u.Name)
+	return strings.TrimSpace(u.Name), nil
+}
+
+// SortSpacesByActivity orders spaces most recently active first. Spaces
+// without a lastActiveTime keep their relative order after the others.
+func SortSpacesByActivity(spaces []ChatSpace) {
+	sort.SliceStable(spaces, func(i, j int) bool {
+		ti, oki := ParseTime(spaces[i].LastActiveTime)
+		tj, okj := ParseTime(spaces[j].LastActiveTime)
+		if oki != okj {
+			return oki
+		}
+		return oki && ti.After(tj)
+	})
+}
+
+// InactiveSince reports whether space is known to have had no activity
+// after t, so it cannot hold messages created after t.
+func InactiveSince(space ChatSpace, t time.Time) bool {
+	last, ok := ParseTime(space.LastActiveTime)
+	return ok && last.Before(t)
+}
+
+func listQuery(size int, token, orderBy, filter, fields string) url.Values {
+	q := url.Values{}
+	if size > 0 {
+		q.Set("pageSize", strconv.Itoa(size))
+	}
+	if orderBy != "" {
+		q.Set("orderBy", orderBy)
+	}
+	if filter != "" {
+		q.Set("filter", filter)
+	}
+	if fields != "" {
+		q.Set("fields", fields)
+	}
+	if token != "" {
+		q.Set("pageToken", token)
+	}
+	return q
+}
+
+// pageSize picks the per-request page size: the requested size or max,
+// shrunk so the last page does not overshoot limit.
+func pageSize(limit, have, requested, max int) int {
+	size := max
+	if requested > 0 {
+		size = requested
+	}
+	if limit > 0 {
+		size = minInt(size, limit-have)
+	}
+	return size
+}
+
+func initialCap(limit, max int) int {
+	if limit <= 0 {
+		return max
+	}
+	return minInt(limit, max)
+}
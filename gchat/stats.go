@@ -0,0 +1,104 @@
+package gchat
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Usage summarizes the API traffic seen by a StatsTransport.
+type Usage struct {
+	// Calls counts API calls, not attempts: a retried call counts once.
+	Calls int64 `json:"calls"`
+	// Endpoints breaks Calls down by EndpointName.
+	Endpoints map[string]int64 `json:"endpoints"`
+	// Pages counts calls to list methods, one per page fetched.
+	Pages int64 `json:"pages"`
+	// Bytes is the total size of the response bodies read.
+	Bytes int64 `json:"bytes"`
+	// CacheHits counts lookups answered without an API call.
+	CacheHits int64 `json:"cache_hits"`
+}
+
+// StatsTransport counts the API calls passed to Base. Place it above
+// RetryTransport so each call is counted once however often it is retried.
+type StatsTransport struct {
+	Base http.RoundTripper
+
+	mu        sync.Mutex
+	endpoints map[string]int64
+	calls     atomic.Int64
+	pages     atomic.Int64
+	bytes     atomic.Int64
+	cacheHits atomic.Int64
+}
+
+// Usage returns a snapshot of the counters.
+func (t *StatsTransport) Usage() Usage {
+	t.mu.Lock()
+	endpoints := make(map[string]int64, len(t.endpoints))
+	for name, n := range t.endpoints {
+		endpoints[name] = n
+	}
+	t.mu.Unlock()
+	return Usage{
+		Calls:     t.calls.Load(),
+		Endpoints: endpoints,
+		Pages:     t.pages.Load(),
+		Bytes:     t.bytes.Load(),
+		CacheHits: t.cacheHits.Load(),
+	}
+}
+
+// CountCacheHit records a lookup that was answered from a cache.
+func (t *StatsTransport) CountCacheHit() { t.cacheHits.Add(1) }
+
+// RoundTrip implements http.RoundTripper.
+func (t *StatsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := EndpointName(req.Context())
+	if name == "" {
+		name = "other"
+	}
+	t.calls.Add(1)
+	if isListEndpoint(name) {
+		t.pages.Add(1)
+	}
+	t.mu.Lock()
+	if t.endpoints == nil {
+		t.endpoints = make(map[string]int64)
+	}
+	t.endpoints[name]++
+	t.mu.Unlock()
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &byteCounter{ReadCloser: resp.Body, n: &t.bytes}
+	return resp, nil
+}
+
+func isListEndpoint(name string) bool {
+	switch name {
+	case EndpointSpacesList, EndpointMessagesList, EndpointMembersList, EndpointReactionsList:
+		return true
+	}
+	return false
+}
+
+// byteCounter adds the bytes read from a response body to n.
+type byteCounter struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b *byteCounter) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,49 @@
+package gchat
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/thomas-sievering/gchatctl/internal/fakechat"
+)
+
+func TestStatsTransportCountsCallsOncePerRetry(t *testing.T) {
+	t.Parallel()
+	srv := fakechat.New()
+	t.Cleanup(srv.Close)
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM"})
+	srv.AddFault(fakechat.Fault{Path: "/v1/spaces", Status: http.StatusServiceUnavailable, GoogleStatus: "UNAVAILABLE", Message: "busy", Times: 1})
+	retry := &RetryTransport{BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	stats := &StatsTransport{Base: retry}
+	hc := &http.Client{Transport: &oauth2.Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: fakechat.DefaultAccessToken}),
+		Base:   stats,
+	}}
+	client := NewClient(Config{HTTPClient: hc, BaseURL: srv.APIBaseURL()})
+
+	if _, err := client.ListSpaces(context.Background(), ListSpacesOptions{Limit: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.SendMessage(context.Background(), "spaces/ROOM", SendMessageRequest{Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	stats.CountCacheHit()
+
+	u := stats.Usage()
+	if u.Calls != 2 || u.Pages != 1 || u.CacheHits != 1 {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+	if u.Endpoints[EndpointSpacesList] != 1 || u.Endpoints[EndpointMessagesCreate] != 1 {
+		t.Fatalf("unexpected per-endpoint counts: %v", u.Endpoints)
+	}
+	if u.Bytes == 0 {
+		t.Fatal("expected response bytes to be counted")
+	}
+	if retry.Retries() != 1 {
+		t.Fatalf("expected the retry to happen below the stats transport, got %d", retry.Retries())
+	}
+}
@@ -0,0 +1,297 @@
+package gchat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ChatSpace is a Chat space (room, group chat or direct message).
+type ChatSpace struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	SpaceType   string `json:"spaceType"`
+	// LastActiveTime is when the last message was posted, RFC 3339.
+	LastActiveTime string `json:"lastActiveTime,omitempty"`
+}
+
+// ListSpacesResponse is one page of spaces.list.
+type ListSpacesResponse struct {
+	Spaces        []ChatSpace `json:"spaces"`
+	NextPageToken string      `json:"nextPageToken"`
+}
+
+// SpaceReadState is the calling user's read position in a space.
+type SpaceReadState struct {
+	Name         string `json:"name"`
+	LastReadTime string `json:"lastReadTime"`
+}
+
+// ChatSender identifies the author of a message.
+type ChatSender struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// ChatThread is the thread of a message. Every message belongs to one; a
+// top-level message starts its own.
+type ChatThread struct {
+	Name string `json:"name"`
+	// ThreadKey is the key the thread was created with, if any.
+	ThreadKey string `json:"threadKey,omitempty"`
+}
+
+// MessageSpace is the space a message was posted in.
+type MessageSpace struct {
+	Name string `json:"name"`
+}
+
+// ChatMessage is a Chat message.
+type ChatMessage struct {
+	Name       string `json:"name"`
+	CreateTime string `json:"createTime"`
+	// LastUpdateTime is set once the message has been edited.
+	LastUpdateTime string      `json:"lastUpdateTime,omitempty"`
+	Text           string      `json:"text"`
+	Sender         ChatSender  `json:"sender"`
+	Thread         *ChatThread `json:"thread,omitempty"`
+	// ThreadReply is set on replies, as opposed to the message that
+	// started the thread.
+	ThreadReply bool          `json:"threadReply,omitempty"`
+	Space       *MessageSpace `json:"space,omitempty"`
+	// EmojiReactionSummaries counts the reactions on the message by emoji.
+	EmojiReactionSummaries []EmojiReactionSummary `json:"emojiReactionSummaries,omitempty"`
+	Attachment             []Attachment           `json:"attachment,omitempty"`
+	// CardsV2 is returned by GetMessage and SendMessage; list calls leave it
+	// out of MessageListFields to keep pages small.
+	CardsV2 []CardWithID `json:"cardsV2,omitempty"`
+}
+
+// Attachment sources.
+const (
+	AttachmentUploaded = "UPLOADED_CONTENT"
+	AttachmentDrive    = "DRIVE_FILE"
+)
+
+// Attachment is a file attached to a message: uploaded content, which can
+// be downloaded through the media endpoint, or a Google Drive file.
+type Attachment struct {
+	Name        string `json:"name,omitempty"`
+	ContentName string `json:"contentName,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	// Source is AttachmentUploaded or AttachmentDrive.
+	Source            string             `json:"source,omitempty"`
+	AttachmentDataRef *AttachmentDataRef `json:"attachmentDataRef,omitempty"`
+	DriveDataRef      *DriveDataRef      `json:"driveDataRef,omitempty"`
+	ThumbnailURI      string             `json:"thumbnailUri,omitempty"`
+	DownloadURI       string             `json:"downloadUri,omitempty"`
+}
+
+// AttachmentDataRef refers to uploaded content: by ResourceName for
+// downloads, or by the AttachmentUploadToken returned by UploadAttachment
+// when sending it.
+type AttachmentDataRef struct {
+	ResourceName          string `json:"resourceName,omitempty"`
+	AttachmentUploadToken string `json:"attachmentUploadToken,omitempty"`
+}
+
+// DriveDataRef refers to a Google Drive file.
+type DriveDataRef struct {
+	DriveFileID string `json:"driveFileId"`
+}
+
+// ThreadName returns the name of the message's thread, or "".
+func (m ChatMessage) ThreadName() string {
+	if m.Thread == nil {
+		return ""
+	}
+	return m.Thread.Name
+}
+
+// Emoji is a Unicode emoji or a custom emoji of the workspace.
+type Emoji struct {
+	Unicode     string       `json:"unicode,omitempty"`
+	CustomEmoji *CustomEmoji `json:"customEmoji,omitempty"`
+}
+
+// CustomEmoji is a workspace emoji, known by uid.
+type CustomEmoji struct {
+	UID       string `json:"uid,omitempty"`
+	EmojiName string `json:"emojiName,omitempty"`
+}
+
+// String returns the Unicode emoji, or the :name: of a custom one.
+func (e Emoji) String() string {
+	if e.Unicode != "" {
+		return e.Unicode
+	}
+	if e.CustomEmoji != nil {
+		return firstNonEmpty(e.CustomEmoji.EmojiName, ":"+e.CustomEmoji.UID+":")
+	}
+	return ""
+}
+
+// EmojiReactionSummary is the number of reactions with one emoji.
+type EmojiReactionSummary struct {
+	Emoji         Emoji `json:"emoji"`
+	ReactionCount int   `json:"reactionCount"`
+}
+
+// Reaction is one user's emoji reaction to a message.
+type Reaction struct {
+	Name  string   `json:"name"`
+	User  ChatUser `json:"user"`
+	Emoji Emoji    `json:"emoji"`
+}
+
+// ListReactionsResponse is one page of spaces.messages.reactions.list.
+type ListReactionsResponse struct {
+	Reactions     []Reaction `json:"reactions"`
+	NextPageToken string     `json:"nextPageToken"`
+}
+
+// ListMessagesResponse is one page of spaces.messages.list.
+type ListMessagesResponse struct {
+	Messages      []ChatMessage `json:"messages"`
+	NextPageToken string        `json:"nextPageToken"`
+}
+
+// ChatUser is a member of a space.
+type ChatUser struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Type        string `json:"type"`
+}
+
+// ChatMembership links a user to a space.
+type ChatMembership struct {
+	Name   string   `json:"name"`
+	Member ChatUser `json:"member"`
+}
+
+// ListMembershipsResponse is one page of spaces.members.list.
+type ListMembershipsResponse struct {
+	Memberships   []ChatMembership `json:"memberships"`
+	NextPageToken string           `json:"nextPageToken"`
+}
+
+// ChatUserResource is the response of users/me.
+type ChatUserResource struct {
+	Name string `json:"name"`
+}
+
+// Message orderings accepted by ListMessagesOptions.OrderBy.
+const (
+	OrderCreateTimeAsc  = "createTime asc"
+	OrderCreateTimeDesc = "createTime desc"
+)
+
+// Partial-response masks for the fields gchatctl reads, for use as the
+// Fields option of the list calls.
+const (
+	SpaceListFields   = "nextPageToken,spaces(name,displayName,spaceType,lastActiveTime)"
+	MessageListFields = "nextPageToken,messages(name,createTime,lastUpdateTime,text,sender,thread,threadReply,space,emojiReactionSummaries,attachment)"
+)
+
+// CreatedAfter returns a ListMessagesOptions.Filter matching messages
+// created after t.
+func CreatedAfter(t time.Time) string {
+	return fmt.Sprintf("createTime > %q", t.UTC().Format(time.RFC3339Nano))
+}
+
+// InThread returns a ListMessagesOptions.Filter matching the messages of
+// thread (spaces/.../threads/...). Combine filters with " AND ".
+func InThread(thread string) string {
+	return "thread.name = " + strings.TrimSpace(thread)
+}
+
+// ReactionsWithEmoji returns a ListReactionsOptions.Filter matching
+// reactions with the Unicode emoji e.
+func ReactionsWithEmoji(e string) string {
+	return fmt.Sprintf("emoji.unicode = %q", e)
+}
+
+// ReactionsByUser returns a ListReactionsOptions.Filter matching the
+// reactions of user (users/...). Combine filters with " AND ".
+func ReactionsByUser(user string) string {
+	return fmt.Sprintf("user.name = %q", NormalizeUserRef(user))
+}
+
+// ListSpacesOptions controls ListSpaces and ListSpacesPage.
+type ListSpacesOptions struct {
+	// Limit caps the number of spaces collected across pages; <= 0 means all.
+	Limit int
+	// PageSize is the per-request page size; defaults to min(Limit, 100).
+	PageSize  int
+	PageToken string
+	Filter    string
+	// Fields is a partial-response mask, e.g. SpaceListFields.
+	Fields string
+}
+
+// ListMessagesOptions controls ListMessages and ListMessagesPage.
+type ListMessagesOptions struct {
+	// Limit caps the number of messages collected across pages; <= 0 means all.
+	Limit int
+	// PageSize is the per-request page size; defaults to min(Limit, 100).
+	PageSize  int
+	PageToken string
+	// OrderBy is OrderCreateTimeAsc or OrderCreateTimeDesc; empty uses the API default.
+	OrderBy string
+	// Filter narrows the listing server-side, e.g. CreatedAfter(t).
+	Filter string
+	// Fields is a partial-response mask, e.g. MessageListFields.
+	Fields string
+}
+
+// ListMembersOptions controls ListMembers and ListMembersPage.
+type ListMembersOptions struct {
+	// Limit caps the number of memberships collected across pages; <= 0 means all.
+	Limit int
+	// PageSize is the per-request page size; defaults to min(Limit, 200).
+	PageSize  int
+	PageToken string
+	Filter    string
+	// Fields is a partial-response mask.
+	Fields string
+}
+
+// ListReactionsOptions controls ListReactions and ListReactionsPage.
+type ListReactionsOptions struct {
+	// Limit caps the number of reactions collected across pages; <= 0 means all.
+	Limit int
+	// PageSize is the per-request page size; defaults to min(Limit, 200).
+	PageSize  int
+	PageToken string
+	// Filter narrows the listing server-side, e.g. ReactionsWithEmoji("👀").
+	Filter string
+}
+
+// SendMessageRequest is the payload of SendMessage.
+type SendMessageRequest struct {
+	Text string `json:"text,omitempty"`
+	// Attachment lists uploaded files by the AttachmentDataRef returned by
+	// UploadAttachment.
+	Attachment []Attachment `json:"attachment,omitempty"`
+	// CardsV2 are cards shown below the text; see ParseCardsV2 and
+	// SimpleCard.
+	CardsV2 []CardWithID `json:"cardsV2,omitempty"`
+	// MessageID is an optional client-assigned ID ("client-" prefix). The API
+	// rejects duplicates, which makes retried sends safe.
+	MessageID string `json:"-"`
+	// Thread, when set, makes the message a reply: to the thread with
+	// Thread.Name, or to the one started with Thread.ThreadKey.
+	Thread *ChatThread `json:"thread,omitempty"`
+	// ReplyOption is how the API treats Thread, ReplyOrFail or
+	// ReplyOrNewThread. It defaults to ReplyOrFail when Thread is set.
+	ReplyOption string `json:"-"`
+}
+
+// Reply options for SendMessageRequest.ReplyOption.
+const (
+	// ReplyOrFail fails with NOT_FOUND when the thread does not exist.
+	ReplyOrFail = "REPLY_MESSAGE_OR_FAIL"
+	// ReplyOrNewThread starts a new thread when the thread does not exist,
+	// which is how a thread key opens its thread.
+	ReplyOrNewThread = "REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD"
+)
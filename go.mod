@@ -1,4 +1,4 @@
-module gchatctl
+module github.com/thomas-sievering/gchatctl
 
 go 1.23.0
 
@@ -0,0 +1,245 @@
+// Package cassette records HTTP interactions to a directory and replays them
+// offline. Credentials are scrubbed before anything is written so cassettes
+// can be attached to bug reports and checked in as test fixtures.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/thomas-sievering/gchatctl/internal/redact"
+)
+
+// Redacted replaces scrubbed values.
+const Redacted = redact.Placeholder
+
+// Interaction is one recorded request/response pair.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the scrubbed request half of an Interaction.
+type RecordedRequest struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Body    json.RawMessage     `json:"body,omitempty"`
+}
+
+// RecordedResponse is the scrubbed response half of an Interaction.
+type RecordedResponse struct {
+	Status  int                 `json:"status"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Body    json.RawMessage     `json:"body,omitempty"`
+}
+
+// volatileParams differ on every run (e.g. generated client message IDs) and
+// are ignored when matching requests during replay.
+var volatileParams = map[string]bool{
+	"messageId": true,
+}
+
+// filterTime matches the timestamp of a time comparison in a list filter,
+// such as createTime > "2026-01-02T15:04:05Z". Commands derive it from the
+// current time, so it is ignored when matching too.
+var filterTime = regexp.MustCompile(`((?:createTime|lastUpdateTime)\s*[<>]=?\s*)"[^"]*"`)
+
+// Recorder is an http.RoundTripper that forwards to Base and writes every
+// interaction to Dir as NNNN.json.
+type Recorder struct {
+	Dir  string
+	Base http.RoundTripper
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewRecorder creates dir and returns a Recorder writing into it.
+func NewRecorder(dir string, base http.RoundTripper) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{Dir: dir, Base: base, seq: len(existing)}, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = b
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+
+	resp, err := r.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	it := Interaction{
+		Request: RecordedRequest{
+			Method:  req.Method,
+			URL:     redact.URL(req.URL),
+			Headers: redact.Headers(req.Header),
+			Body:    redact.Body(reqBody),
+		},
+		Response: RecordedResponse{
+			Status:  resp.StatusCode,
+			Headers: redact.Headers(resp.Header),
+			Body:    redact.Body(respBody),
+		},
+	}
+	if err := r.write(it); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *Recorder) write(it Interaction) error {
+	b, err := json.MarshalIndent(it, "", "  ")
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	return os.WriteFile(filepath.Join(r.Dir, fmt.Sprintf("%04d.json", r.seq)), b, 0o600)
+}
+
+// Replayer is an http.RoundTripper that answers requests from a recorded
+// directory without touching the network.
+type Replayer struct {
+	mu     sync.Mutex
+	queues map[string][]Interaction
+	last   map[string]Interaction
+}
+
+// ErrNoInteraction is returned when a request has no recorded response.
+var ErrNoInteraction = errors.New("cassette: no recorded interaction")
+
+// Load reads every interaction in dir.
+func Load(dir string) (*Replayer, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("cassette: no interactions found in %s", dir)
+	}
+	sort.Strings(files)
+	rp := &Replayer{queues: map[string][]Interaction{}, last: map[string]Interaction{}}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var it Interaction
+		if err := json.Unmarshal(b, &it); err != nil {
+			return nil, fmt.Errorf("cassette: %s: %w", f, err)
+		}
+		u, err := url.Parse(it.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("cassette: %s: %w", f, err)
+		}
+		key := matchKey(it.Request.Method, u)
+		rp.queues[key] = append(rp.queues[key], it)
+	}
+	return rp, nil
+}
+
+// RoundTrip implements http.RoundTripper. Interactions with the same method,
+// path and query are served in recorded order; once exhausted the last one
+// is repeated so polling loops keep working.
+func (rp *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	key := matchKey(req.Method, req.URL)
+	rp.mu.Lock()
+	var it Interaction
+	if q := rp.queues[key]; len(q) > 0 {
+		it = q[0]
+		rp.queues[key] = q[1:]
+		rp.last[key] = it
+	} else if prev, ok := rp.last[key]; ok {
+		it = prev
+	} else {
+		rp.mu.Unlock()
+		return nil, fmt.Errorf("%w for %s", ErrNoInteraction, key)
+	}
+	rp.mu.Unlock()
+
+	body := []byte(it.Response.Body)
+	var text string
+	if len(body) > 0 && body[0] == '"' && json.Unmarshal(body, &text) == nil {
+		body = []byte(text)
+	}
+	header := http.Header{}
+	for k, vals := range it.Response.Headers {
+		for _, v := range vals {
+			header.Add(k, v)
+		}
+	}
+	return &http.Response{
+		StatusCode:    it.Response.Status,
+		Status:        fmt.Sprintf("%d %s", it.Response.Status, http.StatusText(it.Response.Status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// matchKey identifies a request by method, path and canonical query, ignoring
+// the host so cassettes replay against any base URL.
+func matchKey(method string, u *url.URL) string {
+	q := u.Query()
+	for k := range q {
+		switch {
+		case volatileParams[k]:
+			q.Del(k)
+		case k == "filter":
+			for i, v := range q[k] {
+				q[k][i] = filterTime.ReplaceAllString(v, `$1"*"`)
+			}
+		case redact.SensitiveParam(k):
+			q.Set(k, Redacted)
+		}
+	}
+	key := method + " " + u.EscapedPath()
+	if enc := q.Encode(); enc != "" {
+		key += "?" + enc
+	}
+	return key
+}
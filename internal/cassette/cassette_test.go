@@ -0,0 +1,82 @@
+package cassette
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thomas-sievering/gchatctl/internal/fakechat"
+)
+
+func TestRecordThenReplayOffline(t *testing.T) {
+	t.Parallel()
+	srv := fakechat.New()
+	srv.AddSpace(fakechat.Space{Name: "spaces/ROOM", DisplayName: "Room", SpaceType: "SPACE"})
+
+	dir := t.TempDir()
+	rec, err := NewRecorder(dir, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.APIBaseURL()+"/spaces?pageSize=10", nil)
+	req.Header.Set("Authorization", "Bearer "+fakechat.DefaultAccessToken)
+	resp, err := (&http.Client{Transport: rec}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	srv.Close()
+
+	raw, err := os.ReadFile(filepath.Join(dir, "0001.json"))
+	if err != nil {
+		t.Fatalf("expected recorded interaction: %v", err)
+	}
+	if strings.Contains(string(raw), fakechat.DefaultAccessToken) {
+		t.Fatalf("recording leaked the bearer token: %s", raw)
+	}
+
+	rp, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayReq, _ := http.NewRequest(http.MethodGet, "https://chat.googleapis.com/v1/spaces?pageSize=10", nil)
+	replayed, err := (&http.Client{Transport: rp}).Do(replayReq)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	got, _ := io.ReadAll(replayed.Body)
+	replayed.Body.Close()
+	if replayed.StatusCode != http.StatusOK || !strings.Contains(string(got), "spaces/ROOM") {
+		t.Fatalf("unexpected replay %d %s (live: %s)", replayed.StatusCode, got, live)
+	}
+
+	missing, _ := http.NewRequest(http.MethodGet, "https://chat.googleapis.com/v1/spaces/OTHER/messages", nil)
+	if _, err := (&http.Client{Transport: rp}).Do(missing); err == nil {
+		t.Fatal("expected error for unrecorded request")
+	}
+}
+
+func TestMatchKeyIgnoresFilterTimes(t *testing.T) {
+	t.Parallel()
+	key := func(raw string) string {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return matchKey(http.MethodGet, u)
+	}
+	base := "https://chat.googleapis.com/v1/spaces/ROOM/messages?pageSize=50&filter="
+	recorded := key(base + url.QueryEscape(`createTime > "2026-01-02T15:04:05Z"`))
+	if got := key(base + url.QueryEscape(`createTime > "2026-03-04T05:06:07.5Z"`)); got != recorded {
+		t.Fatalf("filter time should not affect matching:\n%s\n%s", got, recorded)
+	}
+	threaded := key(base + url.QueryEscape(`createTime > "2026-01-02T15:04:05Z" AND thread.name = spaces/ROOM/threads/A`))
+	if threaded == recorded || threaded == key(base+url.QueryEscape(`createTime > "2026-01-02T15:04:05Z" AND thread.name = spaces/ROOM/threads/B`)) {
+		t.Fatalf("the rest of the filter must still be matched: %s", threaded)
+	}
+}
@@ -0,0 +1,108 @@
+// Package debuglog traces HTTP requests for --debug. Each request is logged
+// as one line (method, URL, status, latency, size, retry attempt) with
+// credentials redacted. Bodies are left out unless explicitly requested,
+// since they contain message text.
+package debuglog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/thomas-sievering/gchatctl/gchat"
+	"github.com/thomas-sievering/gchatctl/internal/redact"
+)
+
+// Transport is an http.RoundTripper that logs every request it forwards to
+// Base.
+type Transport struct {
+	Base http.RoundTripper
+	Out  io.Writer
+	// Bodies also logs request and response bodies. Credentials are still
+	// redacted but message text is not.
+	Bodies bool
+
+	mu sync.Mutex
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	var reqBody []byte
+	if t.Bodies && req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = b
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	latency := time.Since(start)
+	line := fmt.Sprintf("%s %s", req.Method, redact.URL(req.URL))
+	if n := gchat.RetryAttempt(req.Context()); n > 0 {
+		line += fmt.Sprintf(" attempt=%d", n)
+	}
+	if ep := gchat.EndpointName(req.Context()); ep != "" {
+		line += " endpoint=" + ep
+	}
+	if err != nil {
+		t.logf("%s error=%q latency=%s", line, err.Error(), latency.Round(time.Millisecond))
+		return nil, err
+	}
+	line += fmt.Sprintf(" status=%d latency=%s", resp.StatusCode, latency.Round(time.Millisecond))
+
+	if !t.Bodies {
+		resp.Body = &countingBody{ReadCloser: resp.Body, done: func(n int64) {
+			t.logf("%s bytes=%d", line, n)
+		}}
+		return resp, nil
+	}
+	respBody, rerr := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	t.logf("%s bytes=%d", line, len(respBody))
+	if len(reqBody) > 0 {
+		t.logf("  request body: %s", redact.Body(reqBody))
+	}
+	if len(respBody) > 0 {
+		t.logf("  response body: %s", redact.Body(respBody))
+	}
+	return resp, rerr
+}
+
+func (t *Transport) logf(format string, args ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.Out, "%s debug: "+format+"\n", append([]any{time.Now().UTC().Format("15:04:05.000")}, args...)...)
+}
+
+// countingBody reports how many bytes were read once the body is closed, so
+// the size is logged without buffering the response.
+type countingBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(int64)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.n) })
+	return err
+}
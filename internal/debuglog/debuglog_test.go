@@ -0,0 +1,69 @@
+package debuglog
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransportLogsRequestWithoutCredentials(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"access_token":"secret-access","name":"spaces/AAA"}`)
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	client := &http.Client{Transport: &Transport{Out: &buf}}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/spaces?pageSize=10&key=secret-key", nil)
+	req.Header.Set("Authorization", "Bearer secret-bearer")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if !strings.Contains(string(body), "secret-access") {
+		t.Fatalf("response body should reach the caller untouched: %s", body)
+	}
+
+	got := buf.String()
+	for _, want := range []string{"GET ", "/v1/spaces?", "pageSize=10", "status=200", "bytes="} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("log missing %q: %s", want, got)
+		}
+	}
+	for _, secret := range []string{"secret-key", "secret-bearer", "secret-access"} {
+		if strings.Contains(got, secret) {
+			t.Fatalf("log leaked %q: %s", secret, got)
+		}
+	}
+}
+
+func TestTransportBodiesAreRedacted(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"access_token":"secret-access","token_type":"Bearer"}`)
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	client := &http.Client{Transport: &Transport{Out: &buf, Bodies: true}}
+	resp, err := client.Post(srv.URL+"/token", "application/x-www-form-urlencoded", strings.NewReader("grant_type=refresh_token&refresh_token=secret-refresh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	got := buf.String()
+	if !strings.Contains(got, "request body:") || !strings.Contains(got, "grant_type=refresh_token") || !strings.Contains(got, `"token_type":"Bearer"`) {
+		t.Fatalf("bodies not logged: %s", got)
+	}
+	if strings.Contains(got, "secret-refresh") || strings.Contains(got, "secret-access") {
+		t.Fatalf("bodies not redacted: %s", got)
+	}
+}
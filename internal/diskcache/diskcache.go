@@ -0,0 +1,166 @@
+// Package diskcache is the on-disk gchat.Cache used by gchatctl: one JSON
+// file per account holding space listings and memberships, each entry
+// stamped with the time it was stored and served only within a TTL.
+package diskcache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long entries are served when no TTL is configured.
+const DefaultTTL = time.Hour
+
+type entry struct {
+	StoredAt time.Time       `json:"stored_at"`
+	Value    json.RawMessage `json:"value"`
+}
+
+type fileFormat struct {
+	Entries map[string]entry `json:"entries"`
+}
+
+// Cache is a gchat.Cache backed by a single file. Changes are kept in
+// memory until Save.
+type Cache struct {
+	// OnHit, when set, is called for every Get served from the cache.
+	OnHit func()
+
+	path    string
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]entry
+	dirty   bool
+}
+
+// Open loads the cache file at path. A missing or unreadable file yields an
+// empty cache, since its contents can always be fetched again.
+func Open(path string, ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	c := &Cache{path: path, ttl: ttl, entries: map[string]entry{}}
+	if f, err := readFile(path); err == nil && f.Entries != nil {
+		c.entries = f.Entries
+	}
+	return c
+}
+
+// Get implements gchat.Cache.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok || time.Since(e.StoredAt) > c.ttl {
+		return nil, false
+	}
+	if c.OnHit != nil {
+		c.OnHit()
+	}
+	return e.Value, true
+}
+
+// Peek returns the value stored under key however old it is. It does not
+// count as a hit.
+func (c *Cache) Peek(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	return e.Value, ok
+}
+
+// Set implements gchat.Cache.
+func (c *Cache) Set(key string, value []byte) {
+	if !json.Valid(value) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry{StoredAt: time.Now().UTC(), Value: append(json.RawMessage(nil), value...)}
+	c.dirty = true
+}
+
+// Save writes the cache back to disk if it changed, dropping expired
+// entries.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	for k, e := range c.entries {
+		if time.Since(e.StoredAt) > c.ttl {
+			delete(c.entries, k)
+		}
+	}
+	b, err := json.Marshal(fileFormat{Entries: c.entries})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Stats describes one cache file.
+type Stats struct {
+	Path    string    `json:"path"`
+	Entries int       `json:"entries"`
+	Fresh   int       `json:"fresh"`
+	Bytes   int64     `json:"bytes"`
+	Oldest  time.Time `json:"oldest,omitempty"`
+	Newest  time.Time `json:"newest,omitempty"`
+}
+
+// Inspect reports on the cache file at path; entries older than ttl count
+// as stale.
+func Inspect(path string, ttl time.Duration) (Stats, error) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	st := Stats{Path: path}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return st, err
+	}
+	st.Bytes = fi.Size()
+	f, err := readFile(path)
+	if err != nil {
+		return st, err
+	}
+	for _, e := range f.Entries {
+		st.Entries++
+		if time.Since(e.StoredAt) <= ttl {
+			st.Fresh++
+		}
+		if st.Oldest.IsZero() || e.StoredAt.Before(st.Oldest) {
+			st.Oldest = e.StoredAt
+		}
+		if e.StoredAt.After(st.Newest) {
+			st.Newest = e.StoredAt
+		}
+	}
+	return st, nil
+}
+
+func readFile(path string) (fileFormat, error) {
+	var f fileFormat
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	err = json.Unmarshal(b, &f)
+	return f, err
+}
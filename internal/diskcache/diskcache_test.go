@@ -0,0 +1,67 @@
+package diskcache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveAndReopen(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "cache", "acct.json")
+	c := Open(path, time.Hour)
+	if _, ok := c.Get("spaces"); ok {
+		t.Fatal("new cache should be empty")
+	}
+	c.Set("spaces", []byte(`{"spaces":[]}`))
+	c.Set("bad", []byte(`not json`))
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	hits := 0
+	reopened := Open(path, time.Hour)
+	reopened.OnHit = func() { hits++ }
+	got, ok := reopened.Get("spaces")
+	if !ok || string(got) != `{"spaces":[]}` || hits != 1 {
+		t.Fatalf("got %q ok=%v hits=%d", got, ok, hits)
+	}
+	if _, ok := reopened.Get("bad"); ok {
+		t.Fatal("invalid JSON should not be stored")
+	}
+
+	st, err := Inspect(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Entries != 1 || st.Fresh != 1 || st.Bytes == 0 || st.Newest.IsZero() {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestExpiredEntriesAreMissesAndPruned(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "acct.json")
+	c := Open(path, time.Hour)
+	c.Set("old", []byte(`1`))
+	c.entries["old"] = entry{StoredAt: time.Now().Add(-2 * time.Hour), Value: []byte(`1`)}
+	c.Set("new", []byte(`2`))
+	if _, ok := c.Get("old"); ok {
+		t.Fatal("expired entry should be a miss")
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	st, err := Inspect(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Entries != 1 {
+		t.Fatalf("expired entry should be pruned on save: %+v", st)
+	}
+
+	// A shorter TTL on reopen makes the remaining entry stale too.
+	if _, ok := Open(path, time.Nanosecond).Get("new"); ok {
+		t.Fatal("entry older than the TTL should be a miss")
+	}
+}
@@ -0,0 +1,119 @@
+// Package dmindex is the on-disk gchat.DMIndex used by gchatctl: one JSON
+// file per account mapping DM spaces to their peers. Unlike the cache it has
+// no TTL; entries are checked when used and replaced as DMs are seen again.
+package dmindex
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thomas-sievering/gchatctl/gchat"
+)
+
+type fileFormat struct {
+	DMs       []gchat.DMEntry `json:"dms"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Index is a gchat.DMIndex backed by a single file. Changes are kept in
+// memory until Save.
+type Index struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]gchat.DMEntry
+	dirty   bool
+}
+
+// Open loads the index file at path. A missing or unreadable file yields an
+// empty index, since it can always be rebuilt by scanning.
+func Open(path string) *Index {
+	idx := &Index{path: path, entries: map[string]gchat.DMEntry{}}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return idx
+	}
+	var f fileFormat
+	if json.Unmarshal(b, &f) != nil {
+		return idx
+	}
+	for _, e := range f.DMs {
+		idx.entries[e.Space] = e
+	}
+	return idx
+}
+
+// Entries implements gchat.DMIndex. Entries are ordered by space name.
+func (x *Index) Entries() []gchat.DMEntry {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	out := make([]gchat.DMEntry, 0, len(x.entries))
+	for _, e := range x.entries {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Space < out[j].Space })
+	return out
+}
+
+// Put implements gchat.DMIndex.
+func (x *Index) Put(e gchat.DMEntry) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if old, ok := x.entries[e.Space]; ok && old.User == e.User && old.Display == e.Display {
+		// Only the timestamp changed; not worth a write.
+		return
+	}
+	x.entries[e.Space] = e
+	x.dirty = true
+}
+
+// Remove implements gchat.DMIndex.
+func (x *Index) Remove(space string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.entries[space]; ok {
+		delete(x.entries, space)
+		x.dirty = true
+	}
+}
+
+// Reset drops every entry, for a rebuild from scratch.
+func (x *Index) Reset() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.entries = map[string]gchat.DMEntry{}
+	x.dirty = true
+}
+
+// Save writes the index back to disk if it changed.
+func (x *Index) Save() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if !x.dirty {
+		return nil
+	}
+	f := fileFormat{DMs: make([]gchat.DMEntry, 0, len(x.entries)), UpdatedAt: time.Now().UTC()}
+	for _, e := range x.entries {
+		f.DMs = append(f.DMs, e)
+	}
+	sort.Slice(f.DMs, func(i, j int) bool { return f.DMs[i].Space < f.DMs[j].Space })
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(x.path), 0o700); err != nil {
+		return err
+	}
+	tmp := x.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, x.path); err != nil {
+		return err
+	}
+	x.dirty = false
+	return nil
+}
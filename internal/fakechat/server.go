@@ -0,0 +1,1090 @@
+// Package fakechat implements an in-memory stand-in for the Google Chat REST
+// API and the Google OAuth token endpoints so gchatctl can be exercised end to
+// end in tests without network access.
+package fakechat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Space is a Chat space held by the fake server.
+type Space struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+	SpaceType   string `json:"spaceType,omitempty"`
+	// LastActiveTime is reported as lastActiveTime. When zero, the newest
+	// message's createTime is used instead.
+	LastActiveTime time.Time `json:"-"`
+}
+
+// User is a Chat user or app as it appears in memberships and senders.
+type User struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+	Type        string `json:"type,omitempty"`
+}
+
+// Message is a Chat message held by the fake server.
+type Message struct {
+	Name       string    `json:"name"`
+	CreateTime time.Time `json:"-"`
+	// LastUpdateTime is set when the message is edited.
+	LastUpdateTime time.Time `json:"-"`
+	Text           string    `json:"text,omitempty"`
+	Sender         User      `json:"sender"`
+	// Thread is the thread name. When empty the message starts a new
+	// thread; naming an existing thread makes it a reply.
+	Thread    string `json:"-"`
+	ThreadKey string `json:"-"`
+	// Attachments are named and made downloadable when the message is
+	// added.
+	Attachments []Attachment `json:"-"`
+	// CardsV2 is echoed back as the message's cardsV2.
+	CardsV2 json.RawMessage `json:"-"`
+
+	reply     bool
+	reactions []reaction
+}
+
+// Attachment is a file attached to a message. Uploaded content has Data;
+// a Drive file has DriveFileID instead.
+type Attachment struct {
+	ContentName string
+	ContentType string
+	Data        []byte
+	DriveFileID string
+
+	name     string
+	resource string
+}
+
+// upload is content received on the upload endpoint, waiting to be
+// attached by a message send.
+type upload struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+// reaction is one user's Unicode emoji reaction to a message.
+type reaction struct {
+	name  string
+	user  User
+	emoji string
+}
+
+// Fault makes matching requests fail with a Google API error envelope.
+type Fault struct {
+	Method       string // empty matches any method
+	Path         string // exact URL path, e.g. "/v1/spaces/AAA/messages"
+	Status       int
+	GoogleStatus string
+	Message      string
+	Header       http.Header // extra response headers, e.g. Retry-After
+	Times        int         // number of requests to fail; 0 fails every request
+}
+
+// Request is a request observed by the fake server.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+}
+
+// Server is a fake Chat API and OAuth server backed by httptest.
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	spaces       []Space
+	members      map[string][]User
+	messages     map[string][]Message
+	readStates   map[string]time.Time
+	me           string
+	tokens       map[string]struct{}
+	issued       int
+	refresh      string
+	faults       []*Fault
+	delays       map[string]time.Duration
+	requests     []Request
+	nextMessage  int
+	nextThread   int
+	nextReaction int
+	nextUpload   int
+	uploads      map[string]upload
+	media        map[string][]byte
+}
+
+// DefaultAccessToken is accepted by a new server until it is refreshed away.
+const DefaultAccessToken = "fake-access-token"
+
+// DefaultRefreshToken is the refresh token the token endpoint accepts.
+const DefaultRefreshToken = "fake-refresh-token"
+
+// New starts a fake server. Callers must Close it.
+func New() *Server {
+	s := &Server{
+		members:    map[string][]User{},
+		messages:   map[string][]Message{},
+		readStates: map[string]time.Time{},
+		uploads:    map[string]upload{},
+		media:      map[string][]byte{},
+		delays:     map[string]time.Duration{},
+		tokens:     map[string]struct{}{DefaultAccessToken: {}},
+		refresh:    DefaultRefreshToken,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// APIBaseURL is the value to use in place of https://chat.googleapis.com/v1.
+func (s *Server) APIBaseURL() string { return s.URL + "/v1" }
+
+// AuthURL is the fake OAuth authorization endpoint.
+func (s *Server) AuthURL() string { return s.URL + "/o/oauth2/auth" }
+
+// TokenURL is the fake OAuth token endpoint.
+func (s *Server) TokenURL() string { return s.URL + "/token" }
+
+// DeviceURL is the fake OAuth device authorization endpoint.
+func (s *Server) DeviceURL() string { return s.URL + "/device/code" }
+
+// SetCurrentUser makes users/me resolve to name. When unset, users/me
+// returns NOT_FOUND, which mirrors the production API for user auth.
+func (s *Server) SetCurrentUser(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.me = name
+}
+
+// AddSpace registers a space along with its members.
+func (s *Server) AddSpace(space Space, members ...User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spaces = append(s.spaces, space)
+	s.members[space.Name] = append(s.members[space.Name], members...)
+}
+
+// AddMessage appends a message to space. Name is generated when empty.
+func (s *Server) AddMessage(space string, m Message) Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addMessageLocked(space, m)
+}
+
+func (s *Server) addMessageLocked(space string, m Message) Message {
+	if m.Name == "" {
+		s.nextMessage++
+		m.Name = fmt.Sprintf("%s/messages/m%d", space, s.nextMessage)
+	}
+	if m.CreateTime.IsZero() {
+		m.CreateTime = time.Now().UTC()
+	}
+	m.Attachments = append([]Attachment(nil), m.Attachments...)
+	for i := range m.Attachments {
+		a := &m.Attachments[i]
+		a.name = fmt.Sprintf("%s/attachments/a%d", m.Name, i+1)
+		if a.DriveFileID == "" {
+			a.resource = fmt.Sprintf("media-%s-a%d", strings.ReplaceAll(m.Name, "/", "-"), i+1)
+			s.media[a.resource] = a.Data
+		}
+	}
+	if m.Thread == "" {
+		s.nextThread++
+		m.Thread = fmt.Sprintf("%s/threads/t%d", space, s.nextThread)
+	} else {
+		_, m.reply = s.threadLocked(space, m.Thread)
+	}
+	s.messages[space] = append(s.messages[space], m)
+	return m
+}
+
+// AddReaction records user reacting to the message name with the Unicode
+// emoji. It reports false when there is no such message.
+func (s *Server) AddReaction(name string, user User, emoji string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, _, ok := s.messageLocked(name)
+	if ok {
+		s.addReactionLocked(m, user, emoji)
+	}
+	return ok
+}
+
+func (s *Server) addReactionLocked(m *Message, user User, emoji string) reaction {
+	for _, r := range m.reactions {
+		if r.user.Name == user.Name && r.emoji == emoji {
+			return r
+		}
+	}
+	s.nextReaction++
+	r := reaction{name: fmt.Sprintf("%s/reactions/r%d", m.Name, s.nextReaction), user: user, emoji: emoji}
+	m.reactions = append(m.reactions, r)
+	return r
+}
+
+// Reactions returns the emoji of the reactions to the message name, in the
+// order they were added.
+func (s *Server) Reactions(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, _, ok := s.messageLocked(name)
+	if !ok {
+		return nil
+	}
+	out := make([]string, 0, len(m.reactions))
+	for _, r := range m.reactions {
+		out = append(out, r.emoji)
+	}
+	return out
+}
+
+// threadLocked returns the first message of thread in space.
+func (s *Server) threadLocked(space, thread string) (Message, bool) {
+	for _, m := range s.messages[space] {
+		if m.Thread == thread {
+			return m, true
+		}
+	}
+	return Message{}, false
+}
+
+// Messages returns a copy of the messages stored in space.
+func (s *Server) Messages(space string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages[space]...)
+}
+
+// SetReadState sets the caller's last read time for space.
+func (s *Server) SetReadState(space string, lastRead time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readStates[space] = lastRead
+}
+
+// AddFault registers a failure rule. Rules are checked in insertion order.
+func (s *Server) AddFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fc := f
+	s.faults = append(s.faults, &fc)
+}
+
+// SetDelay makes requests to path wait d before being served, or until the
+// client gives up on them.
+func (s *Server) SetDelay(path string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delays[path] = d
+}
+
+// ExpireTokens invalidates every issued access token so the next API call
+// must refresh.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]struct{}{}
+}
+
+// Requests returns the requests observed so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// CountRequests reports how many requests hit path with method.
+func (s *Server) CountRequests(method, path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, r := range s.requests {
+		if r.Method == method && r.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	delay := s.delays[r.URL.Path]
+	s.mu.Unlock()
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query()})
+	if s.applyFaultLocked(w, r) {
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/token":
+		s.serveToken(w, r)
+		return
+	case r.URL.Path == "/device/code":
+		s.serveDeviceCode(w, r)
+		return
+	case !strings.HasPrefix(r.URL.Path, "/v1/") && !strings.HasPrefix(r.URL.Path, "/upload/v1/"):
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "unknown path "+r.URL.Path)
+		return
+	}
+
+	if !s.authorizedLocked(r) {
+		writeError(w, http.StatusUnauthorized, "UNAUTHENTICATED", "Request had invalid authentication credentials.")
+		return
+	}
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/upload/v1/"); ok {
+		space, ok := strings.CutSuffix(rest, "/attachments:upload")
+		switch {
+		case !ok || r.Method != http.MethodPost:
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "unknown path "+r.URL.Path)
+		case !s.hasSpaceLocked(space):
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Space not found.")
+		default:
+			s.uploadAttachment(w, r, space)
+		}
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	parts := strings.Split(path, "/")
+	switch {
+	case r.Method == http.MethodGet && path == "spaces":
+		s.listSpaces(w, r)
+	case r.Method == http.MethodGet && path == "spaces:findDirectMessage":
+		s.findDirectMessage(w, r)
+	case r.Method == http.MethodGet && path == "users/me":
+		if s.me == "" {
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Requested entity was not found.")
+			return
+		}
+		writeJSON(w, map[string]string{"name": s.me})
+	case r.Method == http.MethodGet && len(parts) == 5 && parts[0] == "users" && parts[1] == "me" && parts[4] == "spaceReadState":
+		s.getReadState(w, "spaces/"+parts[3])
+	case len(parts) == 3 && parts[0] == "spaces" && parts[2] == "messages":
+		space := "spaces/" + parts[1]
+		if !s.hasSpaceLocked(space) {
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Space not found.")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.listMessages(w, r, space)
+		case http.MethodPost:
+			s.createMessage(w, r, space)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "INVALID_ARGUMENT", "method not allowed")
+		}
+	case len(parts) == 4 && parts[0] == "spaces" && parts[2] == "messages":
+		switch r.Method {
+		case http.MethodGet:
+			s.getMessage(w, path)
+		case http.MethodPatch:
+			s.updateMessage(w, r, path)
+		case http.MethodDelete:
+			s.deleteMessage(w, path)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "INVALID_ARGUMENT", "method not allowed")
+		}
+	case len(parts) == 5 && parts[0] == "spaces" && parts[2] == "messages" && parts[4] == "reactions":
+		m, _, ok := s.messageLocked(strings.Join(parts[:4], "/"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Message not found.")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.listReactions(w, r, m)
+		case http.MethodPost:
+			s.createReaction(w, r, m)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "INVALID_ARGUMENT", "method not allowed")
+		}
+	case r.Method == http.MethodDelete && len(parts) == 6 && parts[0] == "spaces" && parts[2] == "messages" && parts[4] == "reactions":
+		s.deleteReaction(w, path)
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "media":
+		data, ok := s.media[parts[1]]
+		if !ok || r.URL.Query().Get("alt") != "media" {
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Media not found.")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(data)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "spaces" && parts[2] == "members":
+		space := "spaces/" + parts[1]
+		if !s.hasSpaceLocked(space) {
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Space not found.")
+			return
+		}
+		s.listMembers(w, r, space)
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "unknown path "+r.URL.Path)
+	}
+}
+
+func (s *Server) applyFaultLocked(w http.ResponseWriter, r *http.Request) bool {
+	for i, f := range s.faults {
+		if f.Method != "" && f.Method != r.Method {
+			continue
+		}
+		if f.Path != r.URL.Path {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		for k, vals := range f.Header {
+			for _, v := range vals {
+				w.Header().Add(k, v)
+			}
+		}
+		writeError(w, f.Status, f.GoogleStatus, f.Message)
+		return true
+	}
+	return false
+}
+
+func (s *Server) authorizedLocked(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	_, ok := s.tokens[strings.TrimPrefix(auth, "Bearer ")]
+	return ok
+}
+
+func (s *Server) hasSpaceLocked(name string) bool {
+	for _, sp := range s.spaces {
+		if sp.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) issueTokenLocked() string {
+	s.issued++
+	tok := fmt.Sprintf("fake-access-token-%d", s.issued)
+	s.tokens[tok] = struct{}{}
+	return tok
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, "invalid_request")
+		return
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "refresh_token":
+		if r.PostForm.Get("refresh_token") != s.refresh {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+	case "authorization_code", "urn:ietf:params:oauth:grant-type:device_code":
+	default:
+		writeOAuthError(w, "unsupported_grant_type")
+		return
+	}
+	writeJSON(w, map[string]any{
+		"access_token":  s.issueTokenLocked(),
+		"refresh_token": s.refresh,
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	})
+}
+
+func (s *Server) serveDeviceCode(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{
+		"device_code":      "fake-device-code",
+		"user_code":        "FAKE-CODE",
+		"verification_url": s.URL + "/device",
+		"expires_in":       60,
+		"interval":         1,
+	})
+}
+
+func (s *Server) listSpaces(w http.ResponseWriter, r *http.Request) {
+	page, next := paginate(len(s.spaces), r.URL.Query(), 100)
+	out := make([]map[string]any, 0, len(page))
+	for _, i := range page {
+		out = append(out, s.spaceJSONLocked(s.spaces[i]))
+	}
+	writeJSON(w, map[string]any{"spaces": out, "nextPageToken": next})
+}
+
+func (s *Server) spaceJSONLocked(sp Space) map[string]any {
+	out := map[string]any{"name": sp.Name}
+	if sp.DisplayName != "" {
+		out["displayName"] = sp.DisplayName
+	}
+	if sp.SpaceType != "" {
+		out["spaceType"] = sp.SpaceType
+	}
+	last := sp.LastActiveTime
+	for _, m := range s.messages[sp.Name] {
+		if sp.LastActiveTime.IsZero() && m.CreateTime.After(last) {
+			last = m.CreateTime
+		}
+	}
+	if !last.IsZero() {
+		out["lastActiveTime"] = last.UTC().Format(time.RFC3339Nano)
+	}
+	return out
+}
+
+func (s *Server) findDirectMessage(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("name")
+	for _, sp := range s.spaces {
+		if sp.SpaceType != "DIRECT_MESSAGE" {
+			continue
+		}
+		for _, m := range s.members[sp.Name] {
+			if m.Name == target {
+				writeJSON(w, sp)
+				return
+			}
+		}
+	}
+	writeError(w, http.StatusNotFound, "NOT_FOUND", "Direct message space not found.")
+}
+
+func (s *Server) getReadState(w http.ResponseWriter, space string) {
+	if !s.hasSpaceLocked(space) {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Space not found.")
+		return
+	}
+	out := map[string]string{"name": "users/me/" + space + "/spaceReadState"}
+	if t, ok := s.readStates[space]; ok {
+		out["lastReadTime"] = t.UTC().Format(time.RFC3339Nano)
+	}
+	writeJSON(w, out)
+}
+
+func (s *Server) listMessages(w http.ResponseWriter, r *http.Request, space string) {
+	q := r.URL.Query()
+	after, thread, ok := parseMessageFilter(q.Get("filter"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Invalid filter.")
+		return
+	}
+	msgs := make([]Message, 0, len(s.messages[space]))
+	for _, m := range s.messages[space] {
+		if m.CreateTime.After(after) && (thread == "" || m.Thread == thread) {
+			msgs = append(msgs, m)
+		}
+	}
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].CreateTime.Before(msgs[j].CreateTime) })
+	switch strings.ToLower(strings.Join(strings.Fields(q.Get("orderBy")), " ")) {
+	case "", "createtime asc", "create_time asc":
+	case "createtime desc", "create_time desc":
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Invalid orderBy.")
+		return
+	}
+	page, next := paginate(len(msgs), q, 25)
+	out := make([]map[string]any, 0, len(page))
+	for _, i := range page {
+		out = append(out, messageJSON(msgs[i]))
+	}
+	writeJSON(w, map[string]any{"messages": out, "nextPageToken": next})
+}
+
+func (s *Server) getMessage(w http.ResponseWriter, name string) {
+	m, _, ok := s.messageLocked(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Message not found.")
+		return
+	}
+	writeJSON(w, messageJSON(*m))
+}
+
+// updateMessage supports updateMask=text, the only field gchatctl edits.
+func (s *Server) updateMessage(w http.ResponseWriter, r *http.Request, name string) {
+	if r.URL.Query().Get("updateMask") != "text" {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Unsupported updateMask.")
+		return
+	}
+	var body struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Invalid JSON payload.")
+		return
+	}
+	if strings.TrimSpace(body.Text) == "" {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Message cannot be empty.")
+		return
+	}
+	m, _, ok := s.messageLocked(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Message not found.")
+		return
+	}
+	m.Text = body.Text
+	m.LastUpdateTime = time.Now().UTC()
+	writeJSON(w, messageJSON(*m))
+}
+
+func (s *Server) deleteMessage(w http.ResponseWriter, name string) {
+	_, i, ok := s.messageLocked(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Message not found.")
+		return
+	}
+	space := spaceOf(name)
+	s.messages[space] = append(s.messages[space][:i], s.messages[space][i+1:]...)
+	writeJSON(w, map[string]any{})
+}
+
+// messageLocked finds the message name and its index in its space.
+func (s *Server) messageLocked(name string) (*Message, int, bool) {
+	msgs := s.messages[spaceOf(name)]
+	for i := range msgs {
+		if msgs[i].Name == name {
+			return &msgs[i], i, true
+		}
+	}
+	return nil, -1, false
+}
+
+func (s *Server) createMessage(w http.ResponseWriter, r *http.Request, space string) {
+	var body struct {
+		Text   string `json:"text"`
+		Thread *struct {
+			Name      string `json:"name"`
+			ThreadKey string `json:"threadKey"`
+		} `json:"thread"`
+		Attachment []struct {
+			AttachmentDataRef struct {
+				AttachmentUploadToken string `json:"attachmentUploadToken"`
+			} `json:"attachmentDataRef"`
+		} `json:"attachment"`
+		CardsV2 json.RawMessage `json:"cardsV2"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Invalid JSON payload.")
+		return
+	}
+	if strings.TrimSpace(body.Text) == "" && len(body.Attachment) == 0 && len(body.CardsV2) == 0 {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Message cannot be empty.")
+		return
+	}
+	var attachments []Attachment
+	for _, a := range body.Attachment {
+		up, ok := s.uploads[a.AttachmentDataRef.AttachmentUploadToken]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Invalid attachment upload token.")
+			return
+		}
+		delete(s.uploads, a.AttachmentDataRef.AttachmentUploadToken)
+		attachments = append(attachments, Attachment{ContentName: up.filename, ContentType: up.contentType, Data: up.data})
+	}
+	name := ""
+	if id := r.URL.Query().Get("messageId"); id != "" {
+		name = space + "/messages/" + id
+		for _, existing := range s.messages[space] {
+			if existing.Name == name {
+				writeError(w, http.StatusConflict, "ALREADY_EXISTS", "Message with this ID already exists.")
+				return
+			}
+		}
+	}
+	sender := User{Name: firstNonEmpty(s.me, "users/fake-me"), Type: "HUMAN"}
+	m := Message{Name: name, Text: body.Text, Sender: sender, Attachments: attachments, CardsV2: body.CardsV2}
+	// Like the API, a thread is only honored with a reply option.
+	option := r.URL.Query().Get("messageReplyOption")
+	if body.Thread != nil && option != "" {
+		switch {
+		case body.Thread.Name != "":
+			if _, ok := s.threadLocked(space, body.Thread.Name); ok {
+				m.Thread = body.Thread.Name
+			} else if option == "REPLY_MESSAGE_OR_FAIL" {
+				writeError(w, http.StatusNotFound, "NOT_FOUND", "Thread not found.")
+				return
+			}
+		case body.Thread.ThreadKey != "":
+			m.ThreadKey = body.Thread.ThreadKey
+			for _, existing := range s.messages[space] {
+				if existing.ThreadKey == body.Thread.ThreadKey {
+					m.Thread = existing.Thread
+					break
+				}
+			}
+			if m.Thread == "" && option == "REPLY_MESSAGE_OR_FAIL" {
+				writeError(w, http.StatusNotFound, "NOT_FOUND", "Thread not found.")
+				return
+			}
+		}
+	}
+	m = s.addMessageLocked(space, m)
+	writeJSON(w, messageJSON(m))
+}
+
+// uploadAttachment accepts a multipart/related upload: JSON metadata with
+// the filename, then the content.
+func (s *Server) uploadAttachment(w http.ResponseWriter, r *http.Request, space string) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" || r.URL.Query().Get("uploadType") != "multipart" {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Expected a multipart upload.")
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	metaPart, err := mr.NextPart()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Missing upload metadata.")
+		return
+	}
+	var meta struct {
+		Filename string `json:"filename"`
+	}
+	if err := json.NewDecoder(metaPart).Decode(&meta); err != nil || meta.Filename == "" {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Upload metadata needs a filename.")
+		return
+	}
+	mediaPart, err := mr.NextPart()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Missing upload content.")
+		return
+	}
+	data, err := io.ReadAll(mediaPart)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Unreadable upload content.")
+		return
+	}
+	s.nextUpload++
+	token := fmt.Sprintf("upload-token-%d", s.nextUpload)
+	s.uploads[token] = upload{filename: meta.Filename, contentType: mediaPart.Header.Get("Content-Type"), data: data}
+	writeJSON(w, map[string]any{"attachmentDataRef": map[string]string{
+		"resourceName":          fmt.Sprintf("%s/uploads/u%d", space, s.nextUpload),
+		"attachmentUploadToken": token,
+	}})
+}
+
+func (s *Server) createReaction(w http.ResponseWriter, r *http.Request, m *Message) {
+	var body struct {
+		Emoji struct {
+			Unicode string `json:"unicode"`
+		} `json:"emoji"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Invalid JSON payload.")
+		return
+	}
+	if strings.TrimSpace(body.Emoji.Unicode) == "" {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Emoji is required.")
+		return
+	}
+	user := User{Name: firstNonEmpty(s.me, "users/fake-me"), Type: "HUMAN"}
+	writeJSON(w, reactionJSON(s.addReactionLocked(m, user, body.Emoji.Unicode)))
+}
+
+func (s *Server) listReactions(w http.ResponseWriter, r *http.Request, m *Message) {
+	q := r.URL.Query()
+	emoji, user, ok := parseReactionFilter(q.Get("filter"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Invalid filter.")
+		return
+	}
+	matched := make([]reaction, 0, len(m.reactions))
+	for _, re := range m.reactions {
+		if (emoji == "" || re.emoji == emoji) && (user == "" || re.user.Name == user) {
+			matched = append(matched, re)
+		}
+	}
+	page, next := paginate(len(matched), q, 25)
+	out := make([]map[string]any, 0, len(page))
+	for _, i := range page {
+		out = append(out, reactionJSON(matched[i]))
+	}
+	writeJSON(w, map[string]any{"reactions": out, "nextPageToken": next})
+}
+
+func (s *Server) deleteReaction(w http.ResponseWriter, name string) {
+	m, _, ok := s.messageLocked(name[:strings.LastIndex(name, "/reactions/")])
+	if ok {
+		for i, re := range m.reactions {
+			if re.name != name {
+				continue
+			}
+			if re.user.Name != firstNonEmpty(s.me, "users/fake-me") {
+				writeError(w, http.StatusForbidden, "PERMISSION_DENIED", "Only your own reactions can be deleted.")
+				return
+			}
+			m.reactions = append(m.reactions[:i], m.reactions[i+1:]...)
+			writeJSON(w, map[string]any{})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "NOT_FOUND", "Reaction not found.")
+}
+
+func (s *Server) listMembers(w http.ResponseWriter, r *http.Request, space string) {
+	members := s.members[space]
+	page, next := paginate(len(members), r.URL.Query(), 100)
+	out := make([]map[string]any, 0, len(page))
+	for _, i := range page {
+		m := members[i]
+		out = append(out, map[string]any{
+			"name":   fmt.Sprintf("%s/members/%s", space, strings.TrimPrefix(m.Name, "users/")),
+			"member": m,
+		})
+	}
+	writeJSON(w, map[string]any{"memberships": out, "nextPageToken": next})
+}
+
+// parseMessageFilter understands the filters gchatctl sends:
+// `createTime > "RFC3339"` and `thread.name = spaces/.../threads/...`,
+// alone or joined with AND. An empty filter matches everything.
+func parseMessageFilter(filter string) (time.Time, string, bool) {
+	var after time.Time
+	var thread string
+	for _, clause := range strings.Split(filter, " AND ") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(clause, "thread.name"); ok {
+			rest, ok = strings.CutPrefix(strings.TrimSpace(rest), "=")
+			if !ok {
+				return time.Time{}, "", false
+			}
+			thread = strings.TrimSpace(rest)
+			continue
+		}
+		t, ok := parseCreateTimeFilter(clause)
+		if !ok {
+			return time.Time{}, "", false
+		}
+		after = t
+	}
+	return after, thread, true
+}
+
+// parseReactionFilter understands `emoji.unicode = "X"` and
+// `user.name = "users/X"`, alone or joined with AND.
+func parseReactionFilter(filter string) (string, string, bool) {
+	var emoji, user string
+	for _, clause := range strings.Split(filter, " AND ") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+		field, value, ok := strings.Cut(clause, "=")
+		if !ok {
+			return "", "", false
+		}
+		v, err := strconv.Unquote(strings.TrimSpace(value))
+		if err != nil {
+			return "", "", false
+		}
+		switch strings.TrimSpace(field) {
+		case "emoji.unicode":
+			emoji = v
+		case "user.name":
+			user = v
+		default:
+			return "", "", false
+		}
+	}
+	return emoji, user, true
+}
+
+// parseCreateTimeFilter understands `createTime > "RFC3339"`.
+func parseCreateTimeFilter(filter string) (time.Time, bool) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return time.Time{}, true
+	}
+	rest, ok := strings.CutPrefix(filter, "createTime")
+	if !ok {
+		return time.Time{}, false
+	}
+	rest, ok = strings.CutPrefix(strings.TrimSpace(rest), ">")
+	if !ok {
+		return time.Time{}, false
+	}
+	v, err := strconv.Unquote(strings.TrimSpace(rest))
+	if err != nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	return t, err == nil
+}
+
+func messageJSON(m Message) map[string]any {
+	thread := map[string]any{"name": m.Thread}
+	if m.ThreadKey != "" {
+		thread["threadKey"] = m.ThreadKey
+	}
+	out := map[string]any{
+		"name":       m.Name,
+		"createTime": m.CreateTime.UTC().Format(time.RFC3339Nano),
+		"text":       m.Text,
+		"sender":     m.Sender,
+		"thread":     thread,
+		"space":      map[string]any{"name": spaceOf(m.Name)},
+	}
+	if len(m.Attachments) > 0 {
+		out["attachment"] = attachmentsJSON(m.Attachments)
+	}
+	if len(m.CardsV2) > 0 {
+		out["cardsV2"] = m.CardsV2
+	}
+	if summaries := reactionSummaries(m.reactions); len(summaries) > 0 {
+		out["emojiReactionSummaries"] = summaries
+	}
+	if !m.LastUpdateTime.IsZero() {
+		out["lastUpdateTime"] = m.LastUpdateTime.UTC().Format(time.RFC3339Nano)
+	}
+	if m.reply {
+		out["threadReply"] = true
+	}
+	return out
+}
+
+// reactionSummaries counts reactions by emoji, in order of first use.
+func reactionSummaries(reactions []reaction) []map[string]any {
+	var out []map[string]any
+	index := map[string]int{}
+	for _, r := range reactions {
+		i, ok := index[r.emoji]
+		if !ok {
+			i = len(out)
+			index[r.emoji] = i
+			out = append(out, map[string]any{"emoji": map[string]string{"unicode": r.emoji}, "reactionCount": 0})
+		}
+		out[i]["reactionCount"] = out[i]["reactionCount"].(int) + 1
+	}
+	return out
+}
+
+func attachmentsJSON(attachments []Attachment) []map[string]any {
+	out := make([]map[string]any, 0, len(attachments))
+	for _, a := range attachments {
+		j := map[string]any{
+			"name":        a.name,
+			"contentName": a.ContentName,
+			"contentType": a.ContentType,
+		}
+		if a.DriveFileID != "" {
+			j["source"] = "DRIVE_FILE"
+			j["driveDataRef"] = map[string]string{"driveFileId": a.DriveFileID}
+		} else {
+			j["source"] = "UPLOADED_CONTENT"
+			j["attachmentDataRef"] = map[string]string{"resourceName": a.resource}
+		}
+		out = append(out, j)
+	}
+	return out
+}
+
+func reactionJSON(r reaction) map[string]any {
+	return map[string]any{
+		"name":  r.name,
+		"user":  r.user,
+		"emoji": map[string]string{"unicode": r.emoji},
+	}
+}
+
+// paginate returns the indexes for the requested page and the token for the
+// next page. Page tokens are opaque offsets.
+func paginate(total int, q url.Values, defaultSize int) ([]int, string) {
+	size := defaultSize
+	if v, err := strconv.Atoi(q.Get("pageSize")); err == nil && v > 0 {
+		size = v
+	}
+	if size > 1000 {
+		size = 1000
+	}
+	start := 0
+	if tok := q.Get("pageToken"); tok != "" {
+		if v, err := strconv.Atoi(strings.TrimPrefix(tok, "offset-")); err == nil && v >= 0 {
+			start = v
+		}
+	}
+	if start > total {
+		start = total
+	}
+	end := start + size
+	if end > total {
+		end = total
+	}
+	idx := make([]int, 0, end-start)
+	for i := start; i < end; i++ {
+		idx = append(idx, i)
+	}
+	next := ""
+	if end < total {
+		next = fmt.Sprintf("offset-%d", end)
+	}
+	return idx, next
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, googleStatus, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"code":    status,
+			"message": message,
+			"status":  googleStatus,
+		},
+	})
+}
+
+func writeOAuthError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// spaceOf returns the spaces/... prefix of a message name.
+func spaceOf(name string) string {
+	parts := strings.SplitN(name, "/", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[0] + "/" + parts[1]
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
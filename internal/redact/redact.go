@@ -0,0 +1,120 @@
+// Package redact scrubs credentials from URLs, headers and bodies before they
+// are written anywhere a person might share, such as cassettes and debug logs.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Placeholder replaces scrubbed values.
+const Placeholder = "REDACTED"
+
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Goog-Api-Key":      true,
+}
+
+// sensitiveFields are redacted in JSON bodies, form bodies and query strings.
+var sensitiveFields = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"client_secret": true,
+	"device_code":   true,
+	"code_verifier": true,
+}
+
+// sensitiveParams are additionally redacted in query strings and form bodies.
+var sensitiveParams = map[string]bool{
+	"key":  true,
+	"code": true,
+}
+
+// SensitiveParam reports whether a query or form parameter holds a credential.
+func SensitiveParam(k string) bool {
+	return sensitiveFields[k] || sensitiveParams[k]
+}
+
+// URL returns u as a string with user info dropped and credential query
+// parameters replaced by Placeholder.
+func URL(u *url.URL) string {
+	c := *u
+	c.User = nil
+	q := c.Query()
+	for k := range q {
+		if SensitiveParam(k) {
+			q.Set(k, Placeholder)
+		}
+	}
+	c.RawQuery = q.Encode()
+	return c.String()
+}
+
+// Headers copies h, replacing credential headers with Placeholder.
+func Headers(h http.Header) map[string][]string {
+	if len(h) == 0 {
+		return nil
+	}
+	out := make(map[string][]string, len(h))
+	for k, vals := range h {
+		ck := http.CanonicalHeaderKey(k)
+		if sensitiveHeaders[ck] {
+			out[ck] = []string{Placeholder}
+			continue
+		}
+		out[ck] = append([]string(nil), vals...)
+	}
+	return out
+}
+
+// Body redacts credential fields in JSON and form bodies. Other bodies are
+// returned as JSON strings.
+func Body(b []byte) json.RawMessage {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err == nil {
+		out, _ := json.Marshal(scrubValue(v))
+		return out
+	}
+	s := string(b)
+	if form, err := url.ParseQuery(s); err == nil && strings.Contains(s, "=") {
+		for k := range form {
+			if SensitiveParam(k) {
+				form.Set(k, Placeholder)
+			}
+		}
+		s = form.Encode()
+	}
+	out, _ := json.Marshal(s)
+	return out
+}
+
+func scrubValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			if sensitiveFields[k] {
+				t[k] = Placeholder
+				continue
+			}
+			t[k] = scrubValue(child)
+		}
+		return t
+	case []any:
+		for i := range t {
+			t[i] = scrubValue(t[i])
+		}
+		return t
+	default:
+		return v
+	}
+}
@@ -0,0 +1,21 @@
+package redact
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBodyRedactsCredentials(t *testing.T) {
+	t.Parallel()
+	got := string(Body([]byte(`{"access_token":"a","nested":{"refresh_token":"r"},"error":{"code":403}}`)))
+	if strings.Contains(got, `"a"`) || strings.Contains(got, `"r"`) {
+		t.Fatalf("credentials not scrubbed: %s", got)
+	}
+	if !strings.Contains(got, `"code":403`) {
+		t.Fatalf("error code should be preserved: %s", got)
+	}
+	form := string(Body([]byte("client_id=x&client_secret=s&refresh_token=r")))
+	if strings.Contains(form, "=s") || strings.Contains(form, "=r") {
+		t.Fatalf("form credentials not scrubbed: %s", form)
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -15,14 +18,27 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"golang.org/x/oauth2"
+
+	"github.com/thomas-sievering/gchatctl/gchat"
+	"github.com/thomas-sievering/gchatctl/internal/cassette"
+	"github.com/thomas-sievering/gchatctl/internal/debuglog"
+	"github.com/thomas-sievering/gchatctl/internal/diskcache"
+	"github.com/thomas-sievering/gchatctl/internal/dmindex"
 )
 
 const (
@@ -53,8 +69,26 @@ type OAuthClient struct {
 }
 
 type AppConfig struct {
-	OAuthClient OAuthClient `json:"oauth_client"`
-	Scopes      []string    `json:"scopes"`
+	OAuthClient OAuthClient     `json:"oauth_client"`
+	Scopes      []string        `json:"scopes"`
+	Endpoints   *EndpointConfig `json:"endpoints,omitempty"`
+	// RateLimits overrides per-method client-side rate limits, keyed by
+	// API method name (e.g. "spaces.messages.create").
+	RateLimits map[string]gchat.RateLimit `json:"rate_limits,omitempty"`
+	// CacheTTL is how long cached spaces and memberships are used, as a Go
+	// duration such as "30m". Empty means diskcache.DefaultTTL.
+	CacheTTL string `json:"cache_ttl,omitempty"`
+	// Settings are the defaults managed by `gchatctl config`.
+	Settings *Settings `json:"settings,omitempty"`
+}
+
+// EndpointConfig overrides the Google endpoints used by gchatctl. Empty
+// fields fall back to the production URLs.
+type EndpointConfig struct {
+	APIBaseURL string `json:"api_base_url,omitempty"`
+	AuthURL    string `json:"auth_url,omitempty"`
+	TokenURL   string `json:"token_url,omitempty"`
+	DeviceURL  string `json:"device_url,omitempty"`
 }
 
 type StoredToken struct {
@@ -82,39 +116,6 @@ type DeviceTokenResponse struct {
 	Error        string `json:"error"`
 }
 
-type ChatSpace struct {
-	Name        string `json:"name"`
-	DisplayName string `json:"displayName"`
-	SpaceType   string `json:"spaceType"`
-}
-
-type ListSpacesResponse struct {
-	Spaces        []ChatSpace `json:"spaces"`
-	NextPageToken string      `json:"nextPageToken"`
-}
-
-type SpaceReadState struct {
-	Name         string `json:"name"`
-	LastReadTime string `json:"lastReadTime"`
-}
-
-type ChatSender struct {
-	Name        string `json:"name"`
-	DisplayName string `json:"displayName"`
-}
-
-type ChatMessage struct {
-	Name       string     `json:"name"`
-	CreateTime string     `json:"createTime"`
-	Text       string     `json:"text"`
-	Sender     ChatSender `json:"sender"`
-}
-
-type ListMessagesResponse struct {
-	Messages      []ChatMessage `json:"messages"`
-	NextPageToken string        `json:"nextPageToken"`
-}
-
 type PolledMessage struct {
 	Space      string `json:"space"`
 	Name       string `json:"name"`
@@ -122,26 +123,27 @@ type PolledMessage struct {
 	Sender     string `json:"sender"`
 	SenderUser string `json:"sender_user"`
 	Text       string `json:"text"`
+	// Thread is set for every message; ThreadReply marks replies.
+	Thread      string `json:"thread,omitempty"`
+	ThreadReply bool   `json:"thread_reply,omitempty"`
+	// Reactions counts the emoji reactions to the message.
+	Reactions   []gchat.EmojiReactionSummary `json:"emoji_reaction_summaries,omitempty"`
+	Attachments []gchat.Attachment           `json:"attachments,omitempty"`
 }
 
-type ChatUser struct {
+// DownloadedFile is an attachment saved by chat attachments download.
+type DownloadedFile struct {
 	Name        string `json:"name"`
-	DisplayName string `json:"displayName"`
-	Type        string `json:"type"`
-}
-
-type ChatMembership struct {
-	Name   string   `json:"name"`
-	Member ChatUser `json:"member"`
-}
-
-type ListMembershipsResponse struct {
-	Memberships   []ChatMembership `json:"memberships"`
-	NextPageToken string           `json:"nextPageToken"`
+	ContentType string `json:"content_type,omitempty"`
+	Path        string `json:"path"`
+	Bytes       int64  `json:"bytes"`
 }
 
-type ChatUserResource struct {
-	Name string `json:"name"`
+// SkippedAttachment is an attachment chat attachments download did not
+// save, with the reason.
+type SkippedAttachment struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
 }
 
 type DMSpaceView struct {
@@ -150,6 +152,38 @@ type DMSpaceView struct {
 	PeerDisplayName string `json:"peer_display_name,omitempty"`
 }
 
+type SpaceMemberView struct {
+	User        string `json:"user"`
+	DisplayName string `json:"display_name,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Alias       string `json:"alias,omitempty"`
+}
+
+type InferredAlias struct {
+	User     string `json:"user"`
+	Name     string `json:"name"`
+	Hits     int    `json:"hits"`
+	Existing string `json:"existing,omitempty"`
+	Applied  bool   `json:"applied"`
+}
+
+// SettingView is a setting as shown by `config list` and `config get`.
+// Source is where the value comes from: env, config or default.
+type SettingView struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Source      string `json:"source"`
+	Env         string `json:"env,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// ThreadView is a thread in the grouped output of `chat list`.
+type ThreadView struct {
+	Thread   string              `json:"thread"`
+	Count    int                 `json:"count"`
+	Messages []gchat.ChatMessage `json:"messages"`
+}
+
 type UnreadSpaceView struct {
 	Space     string `json:"space"`
 	SpaceType string `json:"space_type,omitempty"`
@@ -159,1147 +193,4201 @@ type UnreadSpaceView struct {
 	IsUnread  bool   `json:"is_unread"`
 }
 
-type GoogleAPIErrorEnvelope struct {
-	Error struct {
-		Code    int    `json:"code"`
-		Message string `json:"message"`
-		Status  string `json:"status"`
-	} `json:"error"`
-}
-
 type AliasConfig struct {
 	Aliases   map[string]string `json:"aliases"`
 	UpdatedAt time.Time         `json:"updated_at,omitempty"`
 }
 
 func main() {
-	if err := run(); err != nil {
-		if shouldPrintJSONError() {
+	// Commands see a canceled context on Ctrl-C/SIGTERM and wind down: scans
+	// print what they have and refreshed tokens are still saved.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	globals := globalOptions{}
+	args, err := splitGlobalFlags(globals, "", os.Args[1:])
+	if err == nil {
+		err = run(withGlobals(ctx, globals), args)
+	}
+	interrupted := ctx.Err() != nil
+	stop()
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if wantJSONErrors(globals) {
 			_ = printJSONError(err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 		fmt.Fprintln(os.Stderr, "error:", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
-}
-
-func run() error {
-	if len(os.Args) < 2 {
-		printRootHelp()
-		return nil
+	if interrupted {
+		os.Exit(exitCode(context.Canceled))
 	}
+}
 
-	switch os.Args[1] {
-	case "auth":
-		return runAuth(os.Args[2:])
-	case "chat":
-		return runChat(os.Args[2:])
-	case "version", "--version", "-v":
-		fmt.Printf("gchatctl %s\n", version)
-		return nil
-	case "help", "--help", "-h":
-		printRootHelp()
-		return nil
-	default:
-		printRootHelp()
-		return fmt.Errorf("unknown command %q", os.Args[1])
-	}
+// run dispatches the command line after the program name.
+func run(ctx context.Context, args []string) error {
+	return dispatch(ctx, "", args)
 }
 
-func printRootHelp() {
-	fmt.Println("gchatctl: Google Chat CLI for agents")
-	fmt.Println()
-	fmt.Println("Commands:")
-	fmt.Println("  auth setup   Open OAuth setup checklist")
-	fmt.Println("  auth login   Authenticate and save OAuth tokens")
-	fmt.Println("  auth status  Show auth status")
-	fmt.Println("  auth logout  Remove saved token")
-	fmt.Println("  chat inbox   Incoming messages from last N minutes")
-	fmt.Println("  chat recent  Recent messages from a person")
-	fmt.Println("  chat send    Send a message")
-	fmt.Println("  chat spaces  List spaces")
-	fmt.Println("  version      Show version")
-}
-
-func runAuth(args []string) error {
-	if len(args) == 0 {
-		printAuthHelp()
-		return nil
-	}
+func runAuth(ctx context.Context, args []string) error {
+	return dispatch(ctx, "auth", args)
+}
 
-	switch args[0] {
-	case "setup":
-		return runAuthSetup(args[1:])
-	case "login":
-		return runAuthLogin(args[1:])
-	case "status":
-		return runAuthStatus(args[1:])
-	case "logout":
-		return runAuthLogout(args[1:])
-	case "help", "--help", "-h":
-		printAuthHelp()
-		return nil
-	default:
-		printAuthHelp()
-		return fmt.Errorf("unknown auth command %q", args[0])
-	}
+func runChat(ctx context.Context, args []string) error {
+	return dispatch(ctx, "chat", args)
 }
 
-func printAuthHelp() {
-	fmt.Println("gchatctl auth commands:")
-	fmt.Println("  auth setup [--open] [--json]")
-	fmt.Println("  auth login [--all-scopes] [--client-id ...] [--scopes comma,list] [--json]")
-	fmt.Println("  auth status [--json]")
-	fmt.Println("  auth logout")
+func runCache(ctx context.Context, args []string) error {
+	return dispatch(ctx, "cache", args)
 }
 
-func runChat(args []string) error {
-	if len(args) == 0 {
-		printChatHelp()
-		return nil
+func runVersion(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
 	}
+	fmt.Printf("gchatctl %s\n", version)
+	return nil
+}
 
-	switch args[0] {
-	case "inbox":
-		return runChatMessagesIncoming(args[1:])
-	case "incoming":
-		return runChatMessagesIncoming(args[1:])
-	case "recent":
-		return runChatMessagesRecent(args[1:])
-	case "with":
-		return runChatMessagesWith(args[1:])
-	case "send":
-		return runChatMessagesSend(args[1:])
-	case "list":
-		return runChatMessagesList(args[1:])
-	case "poll":
-		return runChatMessagesPoll(args[1:])
-	case "spaces":
-		return runChatSpaces(args[1:])
-	case "users":
-		return runChatUsers(args[1:])
-	case "help", "--help", "-h":
-		printChatHelp()
-		return nil
-	default:
-		printChatHelp()
-		return fmt.Errorf("unknown chat command %q", args[0])
-	}
+// command is a node of the command tree. Groups have no run func; their
+// children are the commands whose path extends theirs by one word.
+type command struct {
+	path    string
+	summary string
+	// args is the positional part of the usage line, e.g. "[METHOD] PATH".
+	args string
+	// aliases are alternative last words of the path.
+	aliases []string
+	// notes are printed at the end of the command's help.
+	notes []string
+	// required, exactlyOne and atLeastOne restate the flag checks the
+	// command makes itself, for `schema`.
+	required   []string
+	exactlyOne [][]string
+	atLeastOne [][]string
+	// output is the JSON Schema of the --json payload; nil when the command
+	// has no JSON output.
+	output map[string]any
+	// hidden commands are left out of help, schema and completion.
+	hidden bool
+	// flags declares the command's flags on a flag set. It must only
+	// declare them: help, schema, completion and config validation call it
+	// without running the command.
+	flags func(*flag.FlagSet)
+	run   func(context.Context, []string) error
 }
 
-func printChatHelp() {
-	fmt.Println("gchatctl chat commands:")
-	fmt.Println("  chat inbox [--since 10m] [--limit 200] [--json]")
-	fmt.Println("  chat recent (--name \"Simon\" | --email user@company.com | --user users/...) [--limit 10] [--json]")
-	fmt.Println("  chat with (--name \"Simon\" | --email user@company.com | --user users/...) [--limit 10] [--json]")
-	fmt.Println("  chat send (--space spaces/AAA... | --email user@company.com | --user users/...) --text \"...\" [--json]")
-	fmt.Println("  chat list --space spaces/AAA... [--limit 50] [--json]")
-	fmt.Println("  chat poll [--space spaces/AAA...] [--since 5m] [--interval 30s] [--iterations 1] [--limit 100] [--json]")
-	fmt.Println("  chat spaces ...   (list, unread, dm, members)")
-	fmt.Println("  chat users aliases ...")
+// flagsOf adapts the constructor of a command's flags, which run uses to
+// get at their values, to command.flags.
+func flagsOf[T any](declare func(*flag.FlagSet) T) func(*flag.FlagSet) {
+	return func(fs *flag.FlagSet) { declare(fs) }
 }
 
-func runChatSpaces(args []string) error {
-	if len(args) == 0 {
-		printChatSpacesHelp()
-		return nil
-	}
-	switch args[0] {
-	case "list":
-		return runChatSpacesList(args[1:])
-	case "unread":
-		return runChatSpacesUnread(args[1:])
-	case "dm":
-		return runChatSpacesDM(args[1:])
-	case "members":
-		return runChatSpacesMembers(args[1:])
-	case "help", "--help", "-h":
-		printChatSpacesHelp()
-		return nil
-	default:
-		printChatSpacesHelp()
-		return fmt.Errorf("unknown chat spaces command %q", args[0])
+// commands is the command tree. Help output, dispatch and leaf --help are
+// all generated from it.
+var commands []command
+
+func init() {
+	apiNote := "Commands that call the API accept --record DIR (save scrubbed HTTP traffic) or --replay DIR (serve it back offline)."
+	person := [][]string{{"email", "user", "name"}}
+	emojiNote := "--emoji takes a Unicode emoji or a common shortcode such as :eyes:, :white_check_mark: or :+1:."
+	sendFileNote := "--file attaches a file, with or without --text; it must be non-empty, at most 200 MB and not of a type Chat blocks, such as .exe or .bat."
+	sendCardNote := "--card-file takes cardsV2 JSON (a card, an array of {cardId, card} or {\"cardsV2\": [...]}) and checks its shape before sending. --card builds one from --title, --subtitle, --kv key=value and --button label=url. --dry-run prints the request instead of sending it."
+	deleteNote := "Asks for confirmation when stdin is a terminal; pass --yes otherwise."
+	settingsNote := "Keys are listed by `gchatctl config list`; per-command defaults are COMMAND.FLAG, e.g. chat.inbox.since. Precedence: flag > env > config > built-in."
+	personMessages := jsonObject(map[string]any{
+		"target":          jsonType("string"),
+		"space":           jsonType("string"),
+		"count":           jsonType("integer"),
+		"messages":        jsonArray(gchat.ChatMessage{}),
+		"resolved_target": jsonType("string"),
+	})
+	commands = []command{
+		{path: "auth", summary: "Set up OAuth and manage the saved token"},
+		{path: "auth setup", summary: "Print the OAuth setup checklist", flags: flagsOf(newAuthSetupFlags), run: runAuthSetup, output: jsonObject(map[string]any{
+			"chat_api_url":       jsonType("string"),
+			"consent_screen_url": jsonType("string"),
+			"credentials_url":    jsonType("string"),
+			"login_example":      jsonType("string"),
+			"scopes_example":     jsonType("string"),
+		})},
+		{path: "auth login", summary: "Authenticate and save OAuth tokens", flags: flagsOf(newAuthLoginFlags), run: runAuthLogin, output: jsonObject(map[string]any{
+			"mode":                  jsonType("string"),
+			"scopes":                jsonArray(""),
+			"authenticated":         jsonType("boolean"),
+			"expiry":                jsonSchemaOf(time.Time{}),
+			"client_secret_present": jsonType("boolean"),
+			"token_path":            jsonType("string"),
+		})},
+		{path: "auth status", summary: "Show auth status", flags: flagsOf(newAuthStatusFlags), run: runAuthStatus, output: jsonObject(map[string]any{
+			"authenticated":         jsonType("boolean"),
+			"valid":                 jsonType("boolean"),
+			"expiry":                jsonSchemaOf(time.Time{}),
+			"saved_at":              jsonSchemaOf(time.Time{}),
+			"mode":                  jsonType("string"),
+			"scopes":                jsonArray(""),
+			"refresh_token_present": jsonType("boolean"),
+			"token_path":            jsonType("string"),
+		}, "valid", "expiry", "saved_at", "mode", "scopes", "refresh_token_present", "token_path")},
+		{path: "auth logout", summary: "Remove the saved token", run: runAuthLogout},
+		{path: "auth token", summary: "Print a fresh access token", flags: flagsOf(newAuthTokenFlags), run: runAuthToken, output: jsonObject(map[string]any{
+			"access_token": jsonType("string"),
+			"token_type":   jsonType("string"),
+			"expiry":       jsonSchemaOf(time.Time{}),
+		})},
+		{path: "chat", summary: "Read and send messages"},
+		{path: "chat inbox", summary: "Incoming messages from the last N minutes", aliases: []string{"incoming"}, notes: []string{apiNote}, flags: flagsOf(newChatMessagesIncomingFlags), run: runChatMessagesIncoming, output: jsonObject(map[string]any{
+			"count":        jsonType("integer"),
+			"since_window": jsonType("string"),
+			"cutoff_utc":   jsonSchemaOf(time.Time{}),
+			"spaces":       jsonType("integer"),
+			"messages":     jsonArray(PolledMessage{}),
+		})},
+		{path: "chat recent", summary: "Recent messages from a person", notes: []string{apiNote}, flags: flagsOf(newChatMessagesRecentFlags), run: runChatMessagesRecent, exactlyOne: person, output: personMessages},
+		{path: "chat with", summary: "DM history with a person (both sides)", notes: []string{apiNote}, flags: flagsOf(newChatMessagesWithFlags), run: runChatMessagesWith, exactlyOne: person, output: personMessages},
+		{path: "chat send", summary: "Send a message", notes: []string{apiNote, sendFileNote, sendCardNote}, flags: flagsOf(newChatMessagesSendFlags), run: runChatMessagesSend, exactlyOne: [][]string{{"space", "email", "user", "thread", "reply-to"}}, atLeastOne: [][]string{{"text", "file", "card-file", "card"}}, output: jsonObject(map[string]any{
+			"space":       jsonType("string"),
+			"message":     jsonSchemaOf(gchat.ChatMessage{}),
+			"dry_run":     jsonType("boolean"),
+			"destination": jsonType("string"),
+			"request":     jsonSchemaOf(gchat.SendMessageRequest{}),
+			"file":        jsonType("string"),
+		}, "space", "message", "dry_run", "destination", "request", "file")},
+		{path: "chat get", summary: "Show one message", notes: []string{apiNote}, flags: flagsOf(newChatGetFlags), run: runChatGet, required: []string{"message"}, output: jsonObject(map[string]any{
+			"space":   jsonType("string"),
+			"message": jsonSchemaOf(gchat.ChatMessage{}),
+		})},
+		{path: "chat edit", summary: "Replace the text of a message", notes: []string{apiNote}, flags: flagsOf(newChatEditFlags), run: runChatEdit, required: []string{"message", "text"}, output: jsonObject(map[string]any{
+			"space":   jsonType("string"),
+			"message": jsonSchemaOf(gchat.ChatMessage{}),
+		})},
+		{path: "chat delete", summary: "Delete a message", notes: []string{apiNote, deleteNote}, flags: flagsOf(newChatDeleteFlags), run: runChatDelete, required: []string{"message"}, output: jsonObject(map[string]any{
+			"space":   jsonType("string"),
+			"message": jsonType("string"),
+			"deleted": jsonType("boolean"),
+		})},
+		{path: "chat react", summary: "React to a message with an emoji", notes: []string{apiNote, emojiNote}, flags: flagsOf(newChatReactFlags), run: runChatReact, required: []string{"message", "emoji"}, output: jsonObject(map[string]any{
+			"space":    jsonType("string"),
+			"reaction": jsonSchemaOf(gchat.Reaction{}),
+		})},
+		{path: "chat unreact", summary: "Remove your emoji reaction from a message", notes: []string{apiNote, emojiNote}, flags: flagsOf(newChatUnreactFlags), run: runChatUnreact, required: []string{"message", "emoji"}, output: jsonObject(map[string]any{
+			"space":   jsonType("string"),
+			"message": jsonType("string"),
+			"emoji":   jsonType("string"),
+			"removed": jsonType("integer"),
+		})},
+		{path: "chat reactions", summary: "Who reacted to a message, and with what", notes: []string{apiNote}, flags: flagsOf(newChatReactionsFlags), run: runChatReactions, required: []string{"message"}, output: jsonObject(map[string]any{
+			"space":     jsonType("string"),
+			"message":   jsonType("string"),
+			"count":     jsonType("integer"),
+			"reactions": jsonArray(gchat.Reaction{}),
+		})},
+		{path: "chat list", summary: "Messages in a space", notes: []string{apiNote}, flags: flagsOf(newChatMessagesListFlags), run: runChatMessagesList, required: []string{"space"}, output: jsonObject(map[string]any{
+			"space":    jsonType("string"),
+			"count":    jsonType("integer"),
+			"messages": jsonArray(gchat.ChatMessage{}),
+			"threads":  jsonArray(ThreadView{}),
+		}, "messages", "threads")},
+		{path: "chat thread", summary: "Messages in a thread, oldest first", notes: []string{apiNote}, flags: flagsOf(newChatThreadFlags), run: runChatThread, exactlyOne: [][]string{{"message", "thread"}}, output: jsonObject(map[string]any{
+			"space":    jsonType("string"),
+			"thread":   jsonType("string"),
+			"count":    jsonType("integer"),
+			"messages": jsonArray(gchat.ChatMessage{}),
+		})},
+		{path: "chat poll", summary: "Poll spaces for new messages", notes: []string{apiNote}, flags: flagsOf(newChatMessagesPollFlags), run: runChatMessagesPoll, output: jsonObject(map[string]any{
+			"iteration":    jsonType("integer"),
+			"iterations":   jsonType("integer"),
+			"since_window": jsonType("string"),
+			"count":        jsonType("integer"),
+			"messages":     jsonArray(PolledMessage{}),
+		})},
+		{path: "chat attachments", summary: "Download message attachments"},
+		{path: "chat attachments download", summary: "Save the files attached to a message", notes: []string{apiNote, "Google Drive files are listed as skipped; open them in Drive."}, flags: flagsOf(newChatAttachmentsDownloadFlags), run: runChatAttachmentsDownload, required: []string{"message"}, output: jsonObject(map[string]any{
+			"message": jsonType("string"),
+			"dir":     jsonType("string"),
+			"count":   jsonType("integer"),
+			"files":   jsonArray(DownloadedFile{}),
+			"skipped": jsonArray(SkippedAttachment{}),
+		}, "skipped")},
+		{path: "chat spaces", summary: "List spaces, unread spaces, DMs and members"},
+		{path: "chat spaces list", summary: "List spaces", flags: flagsOf(newChatSpacesListFlags), run: runChatSpacesList, output: jsonObject(map[string]any{
+			"count":  jsonType("integer"),
+			"spaces": jsonArray(gchat.ChatSpace{}),
+		})},
+		{path: "chat spaces unread", summary: "Spaces with unread messages", flags: flagsOf(newChatSpacesUnreadFlags), run: runChatSpacesUnread, output: jsonObject(map[string]any{
+			"count":  jsonType("integer"),
+			"spaces": jsonArray(UnreadSpaceView{}),
+		})},
+		{path: "chat spaces dm", summary: "Direct-message spaces and their peers", flags: flagsOf(newChatSpacesDMFlags), run: runChatSpacesDM, output: jsonObject(map[string]any{
+			"count":   jsonType("integer"),
+			"dms":     jsonArray(DMSpaceView{}),
+			"indexed": jsonType("integer"),
+		}, "indexed")},
+		{path: "chat spaces members", summary: "Members of a space", flags: flagsOf(newChatSpacesMembersFlags), run: runChatSpacesMembers, required: []string{"space"}, output: jsonObject(map[string]any{
+			"space":   jsonType("string"),
+			"count":   jsonType("integer"),
+			"members": jsonArray(SpaceMemberView{}),
+		})},
+		{path: "chat users", summary: "Manage local user aliases"},
+		{path: "chat users aliases", summary: "Map users/... names to display names"},
+		{path: "chat users aliases list", summary: "List saved aliases", flags: flagsOf(newChatUsersAliasesListFlags), run: runChatUsersAliasesList, output: jsonObject(map[string]any{
+			"count":   jsonType("integer"),
+			"aliases": jsonSchemaOf(map[string]string{}),
+		})},
+		{path: "chat users aliases set", summary: "Save an alias for a user", flags: flagsOf(newChatUsersAliasesSetFlags), run: runChatUsersAliasesSet, required: []string{"user", "name"}},
+		{path: "chat users aliases set-from-space", summary: "Save an alias for the peer of a DM space", flags: flagsOf(newChatUsersAliasesSetFromSpaceFlags), run: runChatUsersAliasesSetFromSpace, required: []string{"space", "name"}},
+		{path: "chat users aliases infer", summary: "Suggest aliases from message signatures", flags: flagsOf(newChatUsersAliasesInferFlags), run: runChatUsersAliasesInfer, output: jsonObject(map[string]any{
+			"count":     jsonType("integer"),
+			"inferred":  jsonArray(InferredAlias{}),
+			"applied":   jsonType("boolean"),
+			"overwrote": jsonType("boolean"),
+		})},
+		{path: "chat users aliases unset", summary: "Remove an alias", flags: flagsOf(newChatUsersAliasesUnsetFlags), run: runChatUsersAliasesUnset, required: []string{"user"}},
+		{path: "api", summary: "Send a raw authenticated Chat API request", args: "[METHOD] PATH", flags: flagsOf(newAPIFlags), run: runAPI, output: map[string]any{"description": "the API response as returned, or with --paginate the merged pages"}, notes: []string{
+			"Sends an authenticated request to the Chat API and prints the JSON response.",
+			"PATH is relative to the API root, e.g. spaces/AAA/messages; METHOD defaults to GET.",
+			"",
+			"Examples:",
+			"  gchatctl api spaces --field pageSize=10",
+			"  gchatctl api GET spaces/AAA/members --paginate",
+			"  gchatctl api POST spaces/AAA/messages --field text=hello",
+			"  gchatctl api PATCH spaces/AAA/messages/BBB --field updateMask=text --input body.json",
+		}},
+		{path: "cache", summary: "Inspect or clear the spaces/memberships cache"},
+		{path: "cache stats", summary: "Show cache files and their freshness", flags: flagsOf(newCacheStatsFlags), run: runCacheStats, output: jsonObject(map[string]any{
+			"dir":   jsonType("string"),
+			"ttl":   jsonType("string"),
+			"count": jsonType("integer"),
+			"files": jsonArray(diskcache.Stats{}),
+		})},
+		{path: "cache clear", summary: "Delete the cache", flags: flagsOf(newCacheClearFlags), run: runCacheClear, output: jsonObject(map[string]any{
+			"cleared": jsonType("integer"),
+			"dir":     jsonType("string"),
+		})},
+		{path: "config", summary: "Show and change settings in config.json"},
+		{path: "config list", summary: "List settings with their values and sources", flags: flagsOf(newConfigListFlags), run: runConfigList, notes: []string{settingsNote}, output: jsonObject(map[string]any{
+			"path":     jsonType("string"),
+			"version":  jsonType("integer"),
+			"count":    jsonType("integer"),
+			"settings": jsonArray(SettingView{}),
+		})},
+		{path: "config get", summary: "Print the effective value of a setting", args: "KEY", flags: flagsOf(newConfigGetFlags), run: runConfigGet, notes: []string{settingsNote}, output: jsonSchemaOf(SettingView{})},
+		{path: "config set", summary: "Save a setting", args: "KEY VALUE", flags: flagsOf(newConfigSetFlags), run: runConfigSet, notes: []string{settingsNote}, output: jsonSchemaOf(SettingView{})},
+		{path: "config unset", summary: "Remove a setting", args: "KEY", flags: flagsOf(newConfigUnsetFlags), run: runConfigUnset, output: jsonObject(map[string]any{
+			"key":     jsonType("string"),
+			"removed": jsonType("boolean"),
+		})},
+		{path: "config edit", summary: "Open config.json in $VISUAL or $EDITOR and check it", run: runConfigEdit},
+		{path: "completion", summary: "Print a shell completion script (bash, zsh, fish or powershell)", args: "SHELL", run: runCompletion, notes: []string{
+			"Spaces, names and users are completed from the cache, aliases and DM index; run a command such as `chat spaces dm` to fill them.",
+			"",
+			"Examples:",
+			"  source <(gchatctl completion bash)",
+			"  gchatctl completion zsh > \"${fpath[1]}/_gchatctl\"",
+			"  gchatctl completion fish > ~/.config/fish/completions/gchatctl.fish",
+			"  gchatctl completion powershell | Out-String | Invoke-Expression",
+		}},
+		{path: "__complete", summary: "Print completions for the words after --", run: runComplete, hidden: true},
+		{path: "schema", summary: "Describe every command, its flags and its JSON output", flags: flagsOf(newSchemaFlags), run: runSchema},
+		{path: "version", summary: "Show version", run: runVersion},
 	}
 }
 
-func printChatSpacesHelp() {
-	fmt.Println("gchatctl chat spaces commands:")
-	fmt.Println("  chat spaces list [--limit 100] [--json]")
-	fmt.Println("  chat spaces unread [--limit 100] [--json]")
-	fmt.Println("  chat spaces dm [--limit 100] [--json]")
-	fmt.Println("  chat spaces members --space spaces/AAA... [--json]")
+// findCommand returns the command at path, matching aliases too.
+func findCommand(path string) (command, bool) {
+	for _, c := range commands {
+		if c.path == path {
+			return c, true
+		}
+		parent, last := splitCommandPath(c.path)
+		for _, a := range c.aliases {
+			if joinCommandPath(parent, a) == path && last != a {
+				return c, true
+			}
+		}
+	}
+	return command{}, false
 }
 
-func runChatSpacesList(args []string) error {
-	fs := flag.NewFlagSet("chat spaces list", flag.ContinueOnError)
-	limit := fs.Int("limit", 100, "max spaces to return")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
-		return err
-	}
-	if *limit <= 0 {
-		return errors.New("--limit must be greater than 0")
+// childCommands returns the commands directly under group, in tree order.
+func childCommands(group string) []command {
+	var out []command
+	for _, c := range commands {
+		if parent, _ := splitCommandPath(c.path); parent == group {
+			out = append(out, c)
+		}
 	}
+	return out
+}
 
-	ctx := context.Background()
-	cfg, st, err := loadAuthContext()
-	if err != nil {
-		return err
+func splitCommandPath(path string) (string, string) {
+	i := strings.LastIndex(path, " ")
+	if i < 0 {
+		return "", path
 	}
+	return path[:i], path[i+1:]
+}
 
-	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
-	tokenSource := oauthCfg.TokenSource(ctx, &st.Token)
-	client := newOAuthClient(ctx, tokenSource)
+func joinCommandPath(group, name string) string {
+	if group == "" {
+		return name
+	}
+	return group + " " + name
+}
 
-	items, err := listSpaces(ctx, client, *limit)
+// dispatch runs the command named by args under group. Global flags are
+// taken out of args first, wherever they appear, and passed down in ctx.
+func dispatch(ctx context.Context, group string, args []string) error {
+	globals := globalsFrom(ctx).clone()
+	args, err := splitGlobalFlags(globals, group, args)
 	if err != nil {
 		return err
 	}
-	if err := saveRefreshedTokenIfChanged(st, tokenSource); err != nil {
-		return err
-	}
-
-	if *jsonOut {
-		out := map[string]any{"count": len(items),
-			"spaces": items,
-		}
-		return printJSON(out)
-	}
+	ctx = withGlobals(ctx, globals)
 
-	if len(items) == 0 {
-		fmt.Printf("No spaces found\n")
+	if len(args) == 0 {
+		printGroupHelp(group)
 		return nil
 	}
-	fmt.Printf("Spaces (%d):\n", len(items))
-	for _, s := range items {
-		display := firstNonEmpty(strings.TrimSpace(s.DisplayName), "(no display name)")
-		fmt.Printf("- %s  [%s]  %s\n", s.Name, firstNonEmpty(s.SpaceType, "SPACE"), display)
+	name := args[0]
+	switch name {
+	case "help", "--help", "-h":
+		printGroupHelp(group)
+		return nil
+	case "--version", "-v":
+		if group == "" {
+			name = "version"
+		}
 	}
-	return nil
-}
-
-func runChatSpacesUnread(args []string) error {
-	fs := flag.NewFlagSet("chat spaces unread", flag.ContinueOnError)
-	limit := fs.Int("limit", 100, "max spaces to check")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
-		return err
+	cmd, ok := findCommand(joinCommandPath(group, name))
+	if !ok {
+		printGroupHelp(group)
+		if group == "" {
+			return usageErrorf("unknown command %q", name)
+		}
+		return usageErrorf("unknown %s command %q", group, name)
 	}
-	if *limit <= 0 {
-		return errors.New("--limit must be greater than 0")
+	if cmd.run == nil {
+		return dispatch(ctx, cmd.path, args[1:])
 	}
+	return cmd.run(ctx, args[1:])
+}
 
-	ctx := context.Background()
-	cfg, st, err := loadAuthContext()
-	if err != nil {
-		return err
+func printGroupHelp(group string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if group == "" {
+		fmt.Fprintln(w, "gchatctl: Google Chat CLI for agents")
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Usage: gchatctl <command> [flags]")
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Commands:")
+	} else {
+		fmt.Fprintf(w, "gchatctl %s commands:\n", group)
 	}
-	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
-	tokenSource := oauthCfg.TokenSource(ctx, &st.Token)
-	client := newOAuthClient(ctx, tokenSource)
-
-	spaces, err := listSpaces(ctx, client, *limit)
-	if err != nil {
-		return err
+	printCommandList(w, group, group)
+	_ = w.Flush()
+	if group == "" {
+		fmt.Println()
+		fmt.Println("Global flags (accepted before or after the command):")
+		printFlags(os.Stdout, globalFlagSet(), nil)
 	}
+	fmt.Println()
+	fmt.Printf("Run \"gchatctl %s --help\" for the flags of a command.\n", joinCommandPath(group, "<command>"))
+}
 
-	unread := make([]UnreadSpaceView, 0, minInt(32, len(spaces)))
-	for _, s := range spaces {
-		latestMsg, lerr := listMessages(ctx, client, s.Name, 1)
-		if lerr != nil || len(latestMsg) == 0 {
+// printCommandList lists every leaf command under group with its summary,
+// named relative to root.
+func printCommandList(w io.Writer, root, group string) {
+	for _, c := range childCommands(group) {
+		if c.hidden {
 			continue
 		}
-		latestTS, lok := parseMessageTime(latestMsg[0].CreateTime)
-		if !lok {
+		if c.run == nil {
+			printCommandList(w, root, c.path)
 			continue
 		}
-		rs, rerr := getSpaceReadState(ctx, client, s.Name)
-		if rerr != nil {
-			return rerr
+		fmt.Fprintf(w, "  %s\t%s\n", strings.TrimSpace(strings.TrimPrefix(c.path, root)), c.summary)
+	}
+}
+
+// printCommandHelp is the --help output of a leaf command, generated from
+// its flag set.
+func printCommandHelp(w io.Writer, fs *flag.FlagSet) {
+	cmd, _ := findCommand(fs.Name())
+	usage := "gchatctl " + fs.Name()
+	if cmd.args != "" {
+		usage += " " + cmd.args
+	}
+	fmt.Fprintf(w, "Usage: %s [flags]\n", usage)
+	if cmd.summary != "" {
+		fmt.Fprintf(w, "\n%s\n", cmd.summary)
+	}
+
+	globals := globalFlagSet()
+	client := flag.NewFlagSet("", flag.ContinueOnError)
+	addClientFlags(client)
+	var own, api int
+	fs.VisitAll(func(f *flag.Flag) {
+		switch {
+		case globals.Lookup(f.Name) != nil:
+		case client.Lookup(f.Name) != nil:
+			api++
+		default:
+			own++
 		}
-		lastReadTS, rok := parseMessageTime(rs.LastReadTime)
-		isUnread := !rok || latestTS.After(lastReadTS)
-		if !isUnread {
-			continue
+	})
+	if own > 0 {
+		fmt.Fprintln(w, "\nFlags:")
+		printFlags(w, fs, func(name string) bool { return globals.Lookup(name) == nil && client.Lookup(name) == nil })
+	}
+	if api > 0 {
+		fmt.Fprintln(w, "\nAPI flags:")
+		printFlags(w, fs, func(name string) bool { return globals.Lookup(name) == nil && client.Lookup(name) != nil })
+	}
+	fmt.Fprintln(w, "\nGlobal flags:")
+	printFlags(w, globals, nil)
+	if len(cmd.notes) > 0 {
+		fmt.Fprintln(w)
+		for _, line := range cmd.notes {
+			fmt.Fprintln(w, line)
 		}
-		unread = append(unread, UnreadSpaceView{
-			Space:     s.Name,
-			SpaceType: s.SpaceType,
-			Display:   strings.TrimSpace(s.DisplayName),
-			LastRead:  rs.LastReadTime,
-			Latest:    latestMsg[0].CreateTime,
-			IsUnread:  true,
-		})
 	}
+}
 
-	sort.Slice(unread, func(i, j int) bool {
-		ti, _ := parseMessageTime(unread[i].Latest)
-		tj, _ := parseMessageTime(unread[j].Latest)
-		return tj.Before(ti)
+// printFlags lists the flags of fs accepted by include (all when nil) in
+// the layout of flag.PrintDefaults, with double dashes.
+func printFlags(w io.Writer, fs *flag.FlagSet, include func(string) bool) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if include != nil && !include(f.Name) {
+			return
+		}
+		kind, usage := flag.UnquoteUsage(f)
+		if isBoolFlag(f) {
+			kind = ""
+		}
+		line := "  --" + f.Name
+		if kind != "" {
+			line += " " + kind
+		}
+		switch f.DefValue {
+		case "", "false", "0", "0s", "[]":
+		default:
+			if kind == "string" {
+				usage += fmt.Sprintf(" (default %q)", f.DefValue)
+			} else {
+				usage += fmt.Sprintf(" (default %s)", f.DefValue)
+			}
+		}
+		fmt.Fprintf(w, "%s\n    \t%s\n", line, usage)
 	})
+}
 
-	if err := saveRefreshedTokenIfChanged(st, tokenSource); err != nil {
-		return err
-	}
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
 
-	if *jsonOut {
-		out := map[string]any{"count": len(unread),
-			"spaces": unread,
-		}
-		return printJSON(out)
-	}
-	if len(unread) == 0 {
-		fmt.Printf("No unread spaces\n")
-		return nil
-	}
-	fmt.Printf("Unread spaces (%d):\n", len(unread))
-	for _, u := range unread {
-		label := firstNonEmpty(strings.TrimSpace(u.Display), "(no display name)")
-		fmt.Printf("- %s  [%s]  %s  latest=%s\n", u.Space, firstNonEmpty(u.SpaceType, "SPACE"), label, u.Latest)
+// commandFlags returns the flag set cmd declares.
+func commandFlags(cmd command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.path, flag.ContinueOnError)
+	if cmd.flags != nil {
+		cmd.flags(fs)
 	}
-	return nil
+	return fs
 }
 
-func runChatSpacesDM(args []string) error {
-	fs := flag.NewFlagSet("chat spaces dm", flag.ContinueOnError)
-	limit := fs.Int("limit", 100, "max DM spaces to return")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
-		return err
-	}
-	if *limit <= 0 {
-		return errors.New("--limit must be greater than 0")
-	}
+// schemaFlags are the flags of `schema`.
+type schemaFlags struct {
+	only *string
+}
 
-	ctx := context.Background()
-	cfg, st, err := loadAuthContext()
-	if err != nil {
-		return err
-	}
-	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
-	tokenSource := oauthCfg.TokenSource(ctx, &st.Token)
-	client := newOAuthClient(ctx, tokenSource)
+// newSchemaFlags declares the flags of `schema` on fs.
+func newSchemaFlags(fs *flag.FlagSet) *schemaFlags {
+	var f schemaFlags
+	f.only = fs.String("command", "", "describe only this command, e.g. \"chat send\"")
+	fs.Bool("json", true, "output is always JSON; accepted for consistency")
+	return &f
+}
 
-	spaces, err := listSpaces(ctx, client, *limit*2)
-	if err != nil {
+func runSchema(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	flags := newSchemaFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
-	aliases, _ := loadAliases()
-	me, _ := currentUserRef(ctx, client)
-	if strings.TrimSpace(me) == "" {
-		me = inferCurrentUserFromDMS(ctx, client, spaces)
+	var selected command
+	if strings.TrimSpace(*flags.only) != "" {
+		c, ok := findCommand(strings.Join(strings.Fields(*flags.only), " "))
+		if !ok || c.run == nil || c.hidden {
+			return usageErrorf("unknown command %q", *flags.only)
+		}
+		selected = c
 	}
 
-	out := make([]DMSpaceView, 0, *limit)
-	for _, s := range spaces {
-		if s.SpaceType != "DIRECT_MESSAGE" {
+	described := make([]map[string]any, 0, len(commands))
+	for _, c := range commands {
+		if c.run == nil || c.hidden || (selected.path != "" && c.path != selected.path) {
 			continue
 		}
-		peerUser, peerName, err := dmPeerForSpace(ctx, client, s.Name, me)
-		if err != nil {
-			continue
+		described = append(described, describeCommand(c))
+	}
+	return printJSON(map[string]any{
+		"program":        "gchatctl",
+		"version":        version,
+		"schema_version": 1,
+		"global_flags":   describeFlags(globalFlagSet(), nil, nil),
+		"commands":       described,
+		// With GCHATCTL_JSON_ENVELOPE=1 payloads are wrapped; errors are
+		// always printed this way when JSON output is on.
+		"envelope": jsonObject(map[string]any{
+			"ok":   map[string]any{"const": true},
+			"data": map[string]any{"description": "the command's output payload"},
+			"meta": jsonSchemaOf(sessionUsage{}),
+		}, "meta"),
+		"error": jsonObject(map[string]any{
+			"ok": map[string]any{"const": false},
+			"error": jsonObject(map[string]any{
+				"code":        jsonType("string"),
+				"message":     jsonType("string"),
+				"exit_code":   jsonType("integer"),
+				"http_status": jsonType("integer"),
+				"status":      jsonType("string"),
+				"candidates":  jsonArray(gchat.DMResolution{}),
+				"errors":      jsonArray(scanFailure{}),
+			}, "http_status", "status", "candidates", "errors"),
+		}),
+	})
+}
+
+// describeCommand is the `schema` entry of a leaf command.
+func describeCommand(c command) map[string]any {
+	fs := commandFlags(c)
+	globals := globalFlagSet()
+	client := flag.NewFlagSet("", flag.ContinueOnError)
+	addClientFlags(client)
+	scope := func(name string) string {
+		switch {
+		case globals.Lookup(name) != nil:
+			return "global"
+		case client.Lookup(name) != nil:
+			return "api"
 		}
-		if peerUser == "" {
-			continue
+		return "command"
+	}
+
+	input := map[string]any{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if scope(f.Name) == "command" {
+			input[f.Name] = flagSchema(f)
 		}
-		if peerName == "" {
-			peerName = strings.TrimSpace(aliases[normalizeUserRef(peerUser)])
+	})
+	inputSchema := map[string]any{"type": "object", "properties": input, "additionalProperties": false}
+	if len(c.required) > 0 {
+		inputSchema["required"] = c.required
+	}
+	var groups []any
+	for _, group := range c.exactlyOne {
+		alternatives := make([]any, 0, len(group))
+		for _, name := range group {
+			alternatives = append(alternatives, map[string]any{"required": []string{name}})
 		}
-		out = append(out, DMSpaceView{
-			Space:           s.Name,
-			PeerUser:        normalizeUserRef(peerUser),
-			PeerDisplayName: peerName,
-		})
-		if len(out) >= *limit {
-			break
+		groups = append(groups, map[string]any{"oneOf": alternatives})
+	}
+	for _, group := range c.atLeastOne {
+		alternatives := make([]any, 0, len(group))
+		for _, name := range group {
+			alternatives = append(alternatives, map[string]any{"required": []string{name}})
 		}
+		groups = append(groups, map[string]any{"anyOf": alternatives})
 	}
-
-	if err := saveRefreshedTokenIfChanged(st, tokenSource); err != nil {
-		return err
+	if len(groups) > 0 {
+		inputSchema["allOf"] = groups
 	}
 
-	if *jsonOut {
-		return printJSON(map[string]any{"count": len(out),
-			"dms": out,
-		})
+	parent, _ := splitCommandPath(c.path)
+	aliases := make([]string, 0, len(c.aliases))
+	for _, a := range c.aliases {
+		aliases = append(aliases, joinCommandPath(parent, a))
 	}
-	if len(out) == 0 {
-		fmt.Printf("No direct-message spaces found\n")
-		return nil
+	usage := "gchatctl " + c.path
+	if c.args != "" {
+		usage += " " + c.args
 	}
-	fmt.Printf("Direct-message spaces (%d):\n", len(out))
-	for _, dm := range out {
-		label := firstNonEmpty(dm.PeerDisplayName, dm.PeerUser)
-		fmt.Printf("- %s  peer=%s (%s)\n", dm.Space, label, dm.PeerUser)
+	return map[string]any{
+		"name":            c.path,
+		"summary":         c.summary,
+		"usage":           usage + " [flags]",
+		"aliases":         aliases,
+		"flags":           describeFlags(fs, scope, c.required),
+		"required":        nonNil(c.required),
+		"exactly_one_of":  nonNil(c.exactlyOne),
+		"at_least_one_of": nonNil(c.atLeastOne),
+		"input":           inputSchema,
+		"output":          outputSchema(c.output, fs),
 	}
-	return nil
 }
 
-func runChatSpacesMembers(args []string) error {
-	fs := flag.NewFlagSet("chat spaces members", flag.ContinueOnError)
-	space := fs.String("space", "", "space resource name or ID")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
-		return err
+// outputSchema completes a command's payload schema with the fields every
+// API command may add (see apiSession.printJSON) and, for commands that
+// scan several spaces, the partial-failure fields.
+func outputSchema(base map[string]any, fs *flag.FlagSet) map[string]any {
+	if base == nil || base["properties"] == nil {
+		return base
+	}
+	props := map[string]any{}
+	for k, v := range base["properties"].(map[string]any) {
+		props[k] = v
+	}
+	out := map[string]any{"type": "object", "properties": props, "required": base["required"]}
+	if fs.Lookup("record") != nil {
+		props["retries"] = jsonType("integer")
+		props["budget_exhausted"] = jsonType("boolean")
+		props["interrupted"] = jsonType("boolean")
+		props["complete"] = jsonType("boolean")
+		props["warning"] = jsonType("string")
+		props["meta"] = jsonSchemaOf(sessionUsage{})
+	}
+	if fs.Lookup("strict") != nil {
+		props["errors"] = jsonArray(scanFailure{})
+		props["failed_spaces"] = jsonType("integer")
 	}
-	if strings.TrimSpace(*space) == "" {
-		return errors.New("--space is required")
-	}
-	spaceName := normalizeSpaceName(*space)
+	return out
+}
 
-	ctx := context.Background()
-	cfg, st, err := loadAuthContext()
-	if err != nil {
-		return err
-	}
-	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
-	tokenSource := oauthCfg.TokenSource(ctx, &st.Token)
-	client := newOAuthClient(ctx, tokenSource)
+// describeFlags lists the flags of fs for `schema`. scope labels each flag;
+// nil labels them all global.
+func describeFlags(fs *flag.FlagSet, scope func(string) string, required []string) []map[string]any {
+	out := []map[string]any{}
+	fs.VisitAll(func(f *flag.Flag) {
+		_, usage := flag.UnquoteUsage(f)
+		entry := map[string]any{
+			"name":  f.Name,
+			"type":  flagType(f),
+			"usage": usage,
+			"scope": "global",
+		}
+		if scope != nil {
+			entry["scope"] = scope(f.Name)
+		}
+		if def, ok := flagSchema(f)["default"]; ok {
+			entry["default"] = def
+		}
+		if _, ok := f.Value.(*stringList); ok {
+			entry["repeatable"] = true
+		}
+		for _, r := range required {
+			if r == f.Name {
+				entry["required"] = true
+			}
+		}
+		out = append(out, entry)
+	})
+	return out
+}
 
-	aliases, _ := loadAliases()
-	members, err := listSpaceMembers(ctx, client, spaceName)
-	if err != nil {
-		return err
-	}
-	if err := saveRefreshedTokenIfChanged(st, tokenSource); err != nil {
-		return err
-	}
+// flagType names the value type of f: bool, string, int, float, duration
+// or string list.
+func flagType(f *flag.Flag) string {
+	switch f.Value.(type) {
+	case *progressMode:
+		return "string"
+	case *stringList:
+		return "string list"
+	}
+	if isBoolFlag(f) {
+		return "bool"
+	}
+	kind, _ := flag.UnquoteUsage(f)
+	switch kind {
+	case "int", "uint":
+		return "int"
+	case "float", "duration":
+		return kind
+	}
+	return "string"
+}
 
-	type memberOut struct {
-		User        string `json:"user"`
-		DisplayName string `json:"display_name,omitempty"`
-		Type        string `json:"type,omitempty"`
-		Alias       string `json:"alias,omitempty"`
+// flagSchema is the JSON Schema of a flag's value, with its default when
+// that is not the zero value.
+func flagSchema(f *flag.Flag) map[string]any {
+	_, usage := flag.UnquoteUsage(f)
+	out := map[string]any{"description": usage}
+	var def any
+	switch flagType(f) {
+	case "bool":
+		out["type"] = "boolean"
+		if f.DefValue == "true" {
+			def = true
+		}
+	case "int":
+		out["type"] = "integer"
+		if n, err := strconv.ParseInt(f.DefValue, 10, 64); err == nil && n != 0 {
+			def = n
+		}
+	case "float":
+		out["type"] = "number"
+		if n, err := strconv.ParseFloat(f.DefValue, 64); err == nil && n != 0 {
+			def = n
+		}
+	case "duration":
+		out["type"] = "string"
+		out["format"] = "duration"
+		if f.DefValue != "0s" {
+			def = f.DefValue
+		}
+	case "string list":
+		out["type"] = "array"
+		out["items"] = jsonType("string")
+	default:
+		out["type"] = "string"
+		if f.DefValue != "" {
+			def = f.DefValue
+		}
 	}
-	out := make([]memberOut, 0, len(members))
-	for _, m := range members {
-		u := normalizeUserRef(m.Member.Name)
-		out = append(out, memberOut{
-			User:        u,
-			DisplayName: strings.TrimSpace(m.Member.DisplayName),
-			Type:        strings.TrimSpace(m.Member.Type),
-			Alias:       strings.TrimSpace(aliases[u]),
-		})
+	if def != nil {
+		out["default"] = def
 	}
+	return out
+}
 
-	if *jsonOut {
-		return printJSON(map[string]any{"space": spaceName,
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// jsonType is the JSON Schema of a plain value of type t.
+func jsonType(t string) map[string]any {
+	return map[string]any{"type": t}
+}
+
+// jsonObject is the JSON Schema of an object with props, all of them
+// required except those named in optional.
+func jsonObject(props map[string]any, optional ...string) map[string]any {
+	required := make([]string, 0, len(props))
+	for name := range props {
+		isOptional := false
+		for _, o := range optional {
+			isOptional = isOptional || o == name
+		}
+		if !isOptional {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+	return map[string]any{"type": "object", "properties": props, "required": required}
+}
+
+// jsonArray is the JSON Schema of an array of values like item.
+func jsonArray(item any) map[string]any {
+	return map[string]any{"type": "array", "items": jsonSchemaOf(item)}
+}
+
+// jsonSchemaOf derives a JSON Schema from the Go type of v the way
+// encoding/json would marshal it: fields by their json names, omitempty
+// fields optional. Output types are described this way so the schema
+// follows them as they change.
+func jsonSchemaOf(v any) map[string]any {
+	return schemaForType(reflect.TypeOf(v))
+}
+
+func schemaForType(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage(nil)):
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaForType(t.Elem())
+	case reflect.String:
+		return jsonType("string")
+	case reflect.Bool:
+		return jsonType("boolean")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonType("integer")
+	case reflect.Float32, reflect.Float64:
+		return jsonType("number")
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaForType(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaForType(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		var optional []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if !f.IsExported() || tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" {
+				embedded := schemaForType(f.Type)
+				for k, v := range embedded["properties"].(map[string]any) {
+					props[k] = v
+				}
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaForType(f.Type)
+			if strings.Contains(","+opts+",", ",omitempty,") {
+				optional = append(optional, name)
+			}
+		}
+		return jsonObject(props, optional...)
+	}
+	return map[string]any{}
+}
+
+// globalOptions holds the global flags given on the command line, by name,
+// in the canonical text of their flag.Value.
+type globalOptions map[string]string
+
+type globalsKey struct{}
+
+func withGlobals(ctx context.Context, g globalOptions) context.Context {
+	return context.WithValue(ctx, globalsKey{}, g)
+}
+
+func globalsFrom(ctx context.Context) globalOptions {
+	g, _ := ctx.Value(globalsKey{}).(globalOptions)
+	return g
+}
+
+func (g globalOptions) clone() globalOptions {
+	out := make(globalOptions, len(g))
+	for k, v := range g {
+		out[k] = v
+	}
+	return out
+}
+
+// json reports whether JSON output was asked for with --json or --format.
+func (g globalOptions) json() bool {
+	return g["json"] == "true" || g["format"] == "json"
+}
+
+// wantJSONErrors reports whether a failed command prints its error as
+// JSON: with JSON output from the command line, the envelope setting or the
+// format setting.
+func wantJSONErrors(g globalOptions) bool {
+	if g.json() || jsonEnvelopeEnabled() {
+		return true
+	}
+	return g["format"] == "" && g["json"] == "" && setting("format") == "json"
+}
+
+// globalFlagSet declares the global flags. Values are only used for
+// validation and help; splitGlobalFlags records what was given.
+func globalFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("gchatctl", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Bool("json", false, "print JSON (same as --format json)")
+	fs.String("format", "", "output format: text or json (default text)")
+	fs.String("profile", "", "use the config, token and cache of a named profile (env GCHATCTL_PROFILE)")
+	fs.Bool("debug", false, "log every HTTP request to stderr (credentials redacted)")
+	fs.Duration("timeout", 0, "deadline for the whole command; partial results are printed when it passes (0 = none)")
+	fs.Bool("no-cache", false, "do not read or write the on-disk cache of spaces and memberships or the DM index")
+	return fs
+}
+
+// splitGlobalFlags moves the global flags in args, the arguments of a
+// command under group, into g and returns the rest. Once args name a leaf
+// command, the value of one of its own flags is never taken for a global
+// (chat send --text --json sends "--json"). Flags after a "--" argument are
+// left alone.
+func splitGlobalFlags(g globalOptions, group string, args []string) ([]string, error) {
+	fs := globalFlagSet()
+	var leaf *flag.FlagSet
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue, ok := splitFlagArg(arg)
+		if !ok {
+			if cmd, found := findCommand(joinCommandPath(group, arg)); found && leaf == nil {
+				group = cmd.path
+				if cmd.run != nil {
+					leaf = commandFlags(cmd)
+				}
+			}
+			rest = append(rest, arg)
+			continue
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			rest = append(rest, arg)
+			if lf := leafFlag(leaf, name); lf != nil && !hasValue && !isBoolFlag(lf) && i+1 < len(args) {
+				i++
+				rest = append(rest, args[i])
+			}
+			continue
+		}
+		if !hasValue {
+			if isBoolFlag(f) {
+				value = "true"
+			} else if i+1 < len(args) {
+				i++
+				value = args[i]
+			} else {
+				return nil, usageErrorf("flag needs an argument: --%s", name)
+			}
+		}
+		if err := fs.Set(name, value); err != nil {
+			return nil, usageErrorf("invalid value %q for flag --%s: %v", value, name, err)
+		}
+		g[name] = f.Value.String()
+	}
+
+	switch g["format"] {
+	case "", "text":
+		if g["format"] == "text" && g["json"] == "true" {
+			return nil, usageError("--format text conflicts with --json")
+		}
+	case "json":
+	default:
+		return nil, usageErrorf("unknown --format %q (want text or json)", g["format"])
+	}
+	if p, ok := g["profile"]; ok {
+		if err := setProfile(p); err != nil {
+			return nil, err
+		}
+	}
+	return rest, nil
+}
+
+// splitFlagArg reads arg the way package flag does: -name or --name,
+// optionally followed by =value. ok is false for anything else, which is
+// left for the command's flag set to accept or reject.
+func splitFlagArg(arg string) (name, value string, hasValue, ok bool) {
+	if len(arg) < 2 || arg[0] != '-' {
+		return "", "", false, false
+	}
+	name = arg[1:]
+	if name[0] == '-' {
+		name = name[1:]
+	}
+	if name == "" || name[0] == '-' || name[0] == '=' {
+		return "", "", false, false
+	}
+	name, value, hasValue = strings.Cut(name, "=")
+	return name, value, hasValue, true
+}
+
+func leafFlag(fs *flag.FlagSet, name string) *flag.Flag {
+	if fs == nil {
+		return nil
+	}
+	return fs.Lookup(name)
+}
+
+// applyGlobals sets the global flags given on the command line in fs, for
+// the ones fs declares. A command flag with a global's name must mean the
+// same thing (see TestCommandFlagsDoNotShadowGlobals).
+func applyGlobals(ctx context.Context, fs *flag.FlagSet) error {
+	for name, value := range globalsFrom(ctx) {
+		if name == "format" {
+			name = "json"
+			value = strconv.FormatBool(value == "json")
+		}
+		if fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return usageErrorf("invalid value %q for flag --%s: %v", value, name, err)
+		}
+	}
+	return nil
+}
+
+// apiFlags are the flags of `api`.
+type apiFlags struct {
+	fields     stringList
+	input      *string
+	paginate   *bool
+	clientOpts *clientOptions
+}
+
+// newAPIFlags declares the flags of `api` on fs.
+func newAPIFlags(fs *flag.FlagSet) *apiFlags {
+	var f apiFlags
+	fs.Var(&f.fields, "field", "parameter as key=value: query parameter for GET/DELETE or with --input, otherwise a JSON body field (dots nest; repeatable)")
+	f.input = fs.String("input", "", "read the JSON request body from this file (- for stdin)")
+	f.paginate = fs.Bool("paginate", false, "follow nextPageToken and merge the arrays of every page")
+	fs.Bool("json", true, "output is always JSON; accepted for consistency")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runAPI(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	flags := newAPIFlags(fs)
+	positional, err := parseInterspersed(ctx, fs, args)
+	if err != nil {
+		return err
+	}
+	method := http.MethodGet
+	switch len(positional) {
+	case 1:
+	case 2:
+		method = strings.ToUpper(positional[0])
+	default:
+		return usageError("usage: gchatctl api [METHOD] PATH (see gchatctl api --help)")
+	}
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return usageErrorf("unsupported method %q", method)
+	}
+	if *flags.paginate && method != http.MethodGet {
+		return usageError("--paginate only applies to GET")
+	}
+
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
+	if err != nil {
+		return err
+	}
+	defer sess.release()
+	client := sess.client
+
+	path, query, err := apiPath(positional[len(positional)-1], client.BaseURL())
+	if err != nil {
+		return err
+	}
+	var body any
+	bodyFields := method != http.MethodGet && method != http.MethodDelete && *flags.input == ""
+	if *flags.input != "" {
+		raw, rerr := readAPIInput(*flags.input)
+		if rerr != nil {
+			return rerr
+		}
+		body = raw
+	}
+	obj := map[string]any{}
+	for _, f := range flags.fields {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return usageErrorf("--field %q must be key=value", f)
+		}
+		if !bodyFields {
+			query.Add(key, value)
+			continue
+		}
+		if err := setField(obj, key, parseFieldValue(value)); err != nil {
+			return err
+		}
+	}
+	if bodyFields && len(obj) > 0 {
+		body = obj
+	}
+
+	var out any
+	if *flags.paginate {
+		out, err = fetchAllPages(ctx, client, path, query)
+	} else {
+		out, err = client.Raw(ctx, method, path, query, body)
+	}
+	if err != nil {
+		return err
+	}
+	if err := sess.close(); err != nil {
+		return err
+	}
+	return printJSON(out)
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments, and returns the positional ones.
+func parseInterspersed(ctx context.Context, fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := parseFlags(ctx, fs, args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// apiPath splits the PATH argument of `api` into a path under the API root
+// and its query. Absolute URLs are accepted only under baseURL, so the
+// access token is never sent to another host.
+func apiPath(raw, baseURL string) (string, url.Values, error) {
+	p := strings.TrimSpace(raw)
+	if strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://") {
+		rest, ok := strings.CutPrefix(p, baseURL+"/")
+		if !ok {
+			return "", nil, usageErrorf("URL %s is not under the Chat API root %s", p, baseURL)
+		}
+		p = rest
+	}
+	p = strings.TrimPrefix(p, "/")
+	p = strings.TrimPrefix(p, "v1/")
+	p, rawQuery, _ := strings.Cut(p, "?")
+	if p == "" {
+		return "", nil, usageError("PATH must not be empty")
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", nil, usageErrorf("invalid query in PATH: %v", err)
+	}
+	return p, query, nil
+}
+
+func readAPIInput(name string) (json.RawMessage, error) {
+	var b []byte
+	var err error
+	if name == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(b) {
+		return nil, usageErrorf("--input %s is not valid JSON", name)
+	}
+	return json.RawMessage(b), nil
+}
+
+// parseFieldValue types a --field value: true, false, null and numbers
+// become JSON literals, anything else stays a string.
+func parseFieldValue(v string) any {
+	switch v {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	}
+	return v
+}
+
+// setField sets a dotted key such as "thread.name" in obj, creating nested
+// objects on the way.
+func setField(obj map[string]any, key string, value any) error {
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := obj[part].(map[string]any)
+		if !ok {
+			if _, exists := obj[part]; exists {
+				return usageErrorf("--field %s conflicts with an earlier field", key)
+			}
+			next = map[string]any{}
+			obj[part] = next
+		}
+		obj = next
+	}
+	obj[parts[len(parts)-1]] = value
+	return nil
+}
+
+// fetchAllPages GETs path page by page, appending each page's arrays to
+// the first page's, and drops nextPageToken from the merged result.
+func fetchAllPages(ctx context.Context, client *gchat.Client, path string, query url.Values) (map[string]any, error) {
+	merged := map[string]any{}
+	for {
+		raw, err := client.Raw(ctx, http.MethodGet, path, query, nil)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var page map[string]any
+		if err := dec.Decode(&page); err != nil {
+			return nil, fmt.Errorf("--paginate needs a JSON object response: %w", err)
+		}
+		next, _ := page["nextPageToken"].(string)
+		delete(page, "nextPageToken")
+		for k, v := range page {
+			items, isList := v.([]any)
+			prev, hadList := merged[k].([]any)
+			if isList && hadList {
+				merged[k] = append(prev, items...)
+			} else {
+				merged[k] = v
+			}
+		}
+		if next == "" {
+			return merged, nil
+		}
+		query.Set("pageToken", next)
+	}
+}
+
+// cacheStatsFlags are the flags of `cache stats`.
+type cacheStatsFlags struct {
+	jsonOut *bool
+}
+
+// newCacheStatsFlags declares the flags of `cache stats` on fs.
+func newCacheStatsFlags(fs *flag.FlagSet) *cacheStatsFlags {
+	var f cacheStatsFlags
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	return &f
+}
+
+func runCacheStats(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("cache stats", flag.ContinueOnError)
+	flags := newCacheStatsFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	cfg, _ := loadConfig()
+	ttl, err := cacheTTL(cfg, &clientOptions{})
+	if err != nil {
+		return err
+	}
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	stats := make([]diskcache.Stats, 0, len(files))
+	for _, f := range files {
+		st, err := diskcache.Inspect(f, ttl)
+		if err != nil {
+			continue
+		}
+		stats = append(stats, st)
+	}
+
+	if *flags.jsonOut {
+		return printJSON(map[string]any{
+			"dir":   dir,
+			"ttl":   ttl.String(),
+			"count": len(stats),
+			"files": stats,
+		})
+	}
+	if len(stats) == 0 {
+		fmt.Printf("Cache is empty (%s)\n", dir)
+		return nil
+	}
+	fmt.Printf("Cache %s (ttl %s):\n", dir, ttl)
+	for _, st := range stats {
+		fmt.Printf("- %s  entries=%d fresh=%d bytes=%d newest=%s\n", filepath.Base(st.Path), st.Entries, st.Fresh, st.Bytes, st.Newest.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// cacheClearFlags are the flags of `cache clear`.
+type cacheClearFlags struct {
+	jsonOut *bool
+}
+
+// newCacheClearFlags declares the flags of `cache clear` on fs.
+func newCacheClearFlags(fs *flag.FlagSet) *cacheClearFlags {
+	var f cacheClearFlags
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	return &f
+}
+
+func runCacheClear(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("cache clear", flag.ContinueOnError)
+	flags := newCacheClearFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if *flags.jsonOut {
+		return printJSON(map[string]any{"cleared": len(files), "dir": dir})
+	}
+	fmt.Printf("Removed %d cache files\n", len(files))
+	return nil
+}
+
+// settingRef is a setting named on the command line: a key of settingKeys,
+// or a per-command flag default.
+type settingRef struct {
+	key     string
+	global  settingKey
+	command string
+	flag    string
+}
+
+// resolveSettingName checks a setting name given to `config`. Per-command
+// defaults are COMMAND.FLAG with the words of the command joined by dots;
+// command aliases are accepted and saved under the canonical name.
+func resolveSettingName(raw string) (settingRef, error) {
+	raw = strings.TrimSpace(raw)
+	if k, ok := lookupSettingKey(raw); ok {
+		return settingRef{key: k.name, global: k, flag: k.flag}, nil
+	}
+	if i := strings.LastIndex(raw, "."); i > 0 {
+		cmd, ok := findCommand(strings.ReplaceAll(raw[:i], ".", " "))
+		name := raw[i+1:]
+		if ok && cmd.run != nil && !cmd.hidden && commandFlags(cmd).Lookup(name) != nil {
+			return settingRef{key: commandSettingKey(cmd.path, name), command: cmd.path, flag: name}, nil
+		}
+	}
+	return settingRef{}, usageErrorf("unknown setting %q (see gchatctl config list; per-command defaults are COMMAND.FLAG, e.g. chat.inbox.since)", raw)
+}
+
+// check validates v as a value of the setting. Flag defaults are checked
+// by setting them on the flag sets that declare the flag.
+func (r settingRef) check(v string) error {
+	if r.global.check != nil {
+		return r.global.check(v)
+	}
+	for _, c := range commands {
+		if c.run == nil || c.hidden || (r.command != "" && c.path != r.command) {
+			continue
+		}
+		fs := commandFlags(c)
+		if fs.Lookup(r.flag) == nil {
+			continue
+		}
+		if err := fs.Set(r.flag, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// view is the effective value of the setting and where it comes from.
+func (r settingRef) view(s Settings) SettingView {
+	if r.command == "" {
+		v, source := settingValue(s, r.global)
+		return SettingView{Key: r.key, Value: v, Source: source, Env: r.global.env, Description: r.global.usage}
+	}
+	out := SettingView{Key: r.key, Source: "default", Description: fmt.Sprintf("default --%s of gchatctl %s", r.flag, r.command)}
+	v, origin, ok := flagSetting(s, r.command, r.flag)
+	switch {
+	case !ok:
+		if cmd, found := findCommand(r.command); found {
+			out.Value = commandFlags(cmd).Lookup(r.flag).DefValue
+		}
+	case strings.HasPrefix(origin, "GCHATCTL_"):
+		out.Value, out.Source, out.Env = v, "env", origin
+	default:
+		out.Value, out.Source = v, "config"
+	}
+	return out
+}
+
+// configListFlags are the flags of `config list`.
+type configListFlags struct {
+	jsonOut *bool
+}
+
+// newConfigListFlags declares the flags of `config list` on fs.
+func newConfigListFlags(fs *flag.FlagSet) *configListFlags {
+	var f configListFlags
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	return &f
+}
+
+func runConfigList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("config list", flag.ContinueOnError)
+	flags := newConfigListFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	s, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	views := make([]SettingView, 0, len(settingKeys)+len(s.Values))
+	for _, k := range settingKeys {
+		views = append(views, settingRef{key: k.name, global: k, flag: k.flag}.view(s))
+	}
+	keys := make([]string, 0, len(s.Values))
+	for k := range s.Values {
+		if _, ok := lookupSettingKey(k); !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ref, err := resolveSettingName(k)
+		if err != nil {
+			views = append(views, SettingView{Key: k, Value: s.Values[k], Source: "config", Description: "unknown setting; remove it with gchatctl config unset"})
+			continue
+		}
+		views = append(views, ref.view(s))
+	}
+
+	if *flags.jsonOut {
+		return printJSON(map[string]any{
+			"path":     path,
+			"version":  settingsVersion,
+			"count":    len(views),
+			"settings": views,
+		})
+	}
+	fmt.Printf("Settings in %s (flag > env > config > default):\n", path)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, v := range views {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", v.Key, firstNonEmpty(v.Value, "-"), v.Source, v.Description)
+	}
+	_ = w.Flush()
+	fmt.Println()
+	fmt.Println("Per-command defaults are COMMAND.FLAG, e.g.: gchatctl config set chat.inbox.since 30m")
+	return nil
+}
+
+// configGetFlags are the flags of `config get`.
+type configGetFlags struct {
+	jsonOut *bool
+}
+
+// newConfigGetFlags declares the flags of `config get` on fs.
+func newConfigGetFlags(fs *flag.FlagSet) *configGetFlags {
+	var f configGetFlags
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	return &f
+}
+
+func runConfigGet(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("config get", flag.ContinueOnError)
+	flags := newConfigGetFlags(fs)
+	positional, err := parseInterspersed(ctx, fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("usage: gchatctl config get KEY")
+	}
+	ref, err := resolveSettingName(positional[0])
+	if err != nil {
+		return err
+	}
+	s, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	view := ref.view(s)
+	if *flags.jsonOut {
+		return printJSON(view)
+	}
+	fmt.Println(view.Value)
+	return nil
+}
+
+// configSetFlags are the flags of `config set`.
+type configSetFlags struct {
+	jsonOut *bool
+}
+
+// newConfigSetFlags declares the flags of `config set` on fs.
+func newConfigSetFlags(fs *flag.FlagSet) *configSetFlags {
+	var f configSetFlags
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	return &f
+}
+
+func runConfigSet(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("config set", flag.ContinueOnError)
+	flags := newConfigSetFlags(fs)
+	positional, err := parseInterspersed(ctx, fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return usageError("usage: gchatctl config set KEY VALUE")
+	}
+	ref, err := resolveSettingName(positional[0])
+	if err != nil {
+		return err
+	}
+	value := strings.TrimSpace(positional[1])
+	if err := ref.check(value); err != nil {
+		return usageErrorf("invalid value %q for %s: %v", value, ref.key, err)
+	}
+
+	cfg, s, err := loadConfigSettings()
+	if err != nil {
+		return err
+	}
+	s.Values[ref.key] = value
+	cfg.Settings = &s
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+
+	view := ref.view(s)
+	if *flags.jsonOut {
+		return printJSON(view)
+	}
+	fmt.Printf("Set %s = %s\n", ref.key, value)
+	if view.Source == "env" {
+		fmt.Printf("Note: %s is set in the environment and takes precedence.\n", view.Env)
+	}
+	return nil
+}
+
+// configUnsetFlags are the flags of `config unset`.
+type configUnsetFlags struct {
+	jsonOut *bool
+}
+
+// newConfigUnsetFlags declares the flags of `config unset` on fs.
+func newConfigUnsetFlags(fs *flag.FlagSet) *configUnsetFlags {
+	var f configUnsetFlags
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	return &f
+}
+
+func runConfigUnset(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("config unset", flag.ContinueOnError)
+	flags := newConfigUnsetFlags(fs)
+	positional, err := parseInterspersed(ctx, fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("usage: gchatctl config unset KEY")
+	}
+	cfg, s, err := loadConfigSettings()
+	if err != nil {
+		return err
+	}
+	// Keys that no longer resolve can still be removed as written.
+	key := strings.TrimSpace(positional[0])
+	if _, ok := s.Values[key]; !ok {
+		ref, err := resolveSettingName(key)
+		if err != nil {
+			return err
+		}
+		key = ref.key
+	}
+	_, removed := s.Values[key]
+	if removed {
+		delete(s.Values, key)
+		cfg.Settings = &s
+		if err := saveConfig(cfg); err != nil {
+			return err
+		}
+	}
+
+	if *flags.jsonOut {
+		return printJSON(map[string]any{"key": key, "removed": removed})
+	}
+	if removed {
+		fmt.Printf("Removed %s\n", key)
+	} else {
+		fmt.Printf("%s was not set\n", key)
+	}
+	return nil
+}
+
+func runConfigEdit(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("config edit", flag.ContinueOnError)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if err := saveConfig(AppConfig{Settings: &Settings{Version: settingsVersion}}); err != nil {
+			return err
+		}
+	}
+
+	editor := strings.Fields(firstNonEmpty(os.Getenv("VISUAL"), os.Getenv("EDITOR")))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+		if runtime.GOOS == "windows" {
+			editor = []string{"notepad"}
+		}
+	}
+	cmd := exec.CommandContext(ctx, editor[0], append(editor[1:], path)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("run editor %s: %w", editor[0], err)
+	}
+
+	s, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	for key, value := range s.Values {
+		ref, err := resolveSettingName(key)
+		if err != nil {
+			return err
+		}
+		if err := ref.check(value); err != nil {
+			return usageErrorf("invalid value %q for %s in %s: %v (run gchatctl config edit again)", value, key, path, err)
+		}
+	}
+	fmt.Printf("Saved %s\n", path)
+	return nil
+}
+
+// loadConfigSettings reads config.json for a change to its settings.
+func loadConfigSettings() (AppConfig, Settings, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return cfg, Settings{}, fmt.Errorf("read config.json: %w (fix it with: gchatctl config edit)", err)
+	}
+	s, err := cfg.settings()
+	return cfg, s, err
+}
+
+func runCompletion(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("completion", flag.ContinueOnError)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("usage: gchatctl completion bash|zsh|fish|powershell")
+	}
+	script, ok := completionScripts[fs.Arg(0)]
+	if !ok {
+		return usageErrorf("unknown shell %q (want bash, zsh, fish or powershell)", fs.Arg(0))
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// runComplete is called by the completion scripts as
+// `gchatctl __complete -- WORDS...`, WORDS being the words after the program
+// name up to the cursor; the last is the one being completed and may be
+// empty. It prints one candidate per line, a tab and a description, and
+// never calls the API.
+func runComplete(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("__complete", flag.ContinueOnError)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	words := fs.Args()
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	// Older PowerShell drops empty arguments, so its script passes "" quoted.
+	if words[len(words)-1] == `""` {
+		words[len(words)-1] = ""
+	}
+	for _, c := range completeWords(words) {
+		fmt.Printf("%s\t%s\n", c.value, c.description)
+	}
+	return nil
+}
+
+type completion struct {
+	value       string
+	description string
+}
+
+// completeWords returns the candidates for the last of words: subcommands,
+// flags or flag values depending on what precedes it.
+func completeWords(words []string) []completion {
+	words = joinFlagValues(words)
+	cur, done := words[len(words)-1], words[:len(words)-1]
+	globals := globalFlagSet()
+	group := ""
+	var leaf *flag.FlagSet
+	var pending *flag.Flag
+	positional := 0
+	for _, w := range done {
+		if pending != nil {
+			if pending.Name == "profile" {
+				_ = setProfile(w)
+			}
+			pending = nil
+			continue
+		}
+		if strings.HasPrefix(w, "-") {
+			name, value, hasValue := strings.Cut(strings.TrimLeft(w, "-"), "=")
+			f := lookupCompletionFlag(leaf, globals, name)
+			switch {
+			case f == nil:
+			case hasValue && name == "profile":
+				_ = setProfile(value)
+			case !hasValue && !isBoolFlag(f):
+				pending = f
+			}
+			continue
+		}
+		if leaf != nil {
+			// A positional argument, e.g. the PATH of `api`.
+			positional++
+			continue
+		}
+		c, ok := findCommand(joinCommandPath(group, w))
+		if !ok {
+			return nil
+		}
+		if c.run == nil {
+			group = c.path
+		} else {
+			leaf = commandFlags(c)
+		}
+	}
+
+	switch {
+	case pending != nil:
+		return completeFlagValue(pending.Name, cur, "")
+	case strings.HasPrefix(cur, "-") && strings.Contains(cur, "="):
+		name, value, _ := strings.Cut(cur, "=")
+		return completeFlagValue(strings.TrimLeft(name, "-"), value, name+"=")
+	case strings.HasPrefix(cur, "-"):
+		return completeFlagNames(leaf, globals, cur)
+	case leaf != nil:
+		switch leaf.Name() {
+		case "config get", "config set", "config unset":
+			if positional == 0 {
+				return settingCompletions(cur)
+			}
+		}
+		return nil
+	}
+	var out []completion
+	for _, c := range childCommands(group) {
+		_, name := splitCommandPath(c.path)
+		if !c.hidden && strings.HasPrefix(name, cur) {
+			out = append(out, completion{name, c.summary})
+		}
+	}
+	return out
+}
+
+// joinFlagValues undoes bash splitting "--flag=value" into three words.
+func joinFlagValues(words []string) []string {
+	out := make([]string, 0, len(words))
+	for i := 0; i < len(words); i++ {
+		if n := len(out); words[i] == "=" && n > 0 && strings.HasPrefix(out[n-1], "-") && !strings.Contains(out[n-1], "=") {
+			out[n-1] += "="
+			if i+1 < len(words) {
+				i++
+				out[n-1] += words[i]
+			}
+			continue
+		}
+		out = append(out, words[i])
+	}
+	return out
+}
+
+func lookupCompletionFlag(leaf, globals *flag.FlagSet, name string) *flag.Flag {
+	if leaf != nil {
+		if f := leaf.Lookup(name); f != nil {
+			return f
+		}
+	}
+	return globals.Lookup(name)
+}
+
+// completeFlagNames lists the flags of leaf, or only the global flags before
+// a command is named.
+func completeFlagNames(leaf, globals *flag.FlagSet, cur string) []completion {
+	var out []completion
+	seen := map[string]bool{}
+	add := func(f *flag.Flag) {
+		name := "--" + f.Name
+		if seen[f.Name] || !strings.HasPrefix(name, cur) {
+			return
+		}
+		seen[f.Name] = true
+		out = append(out, completion{name, f.Usage})
+	}
+	if leaf != nil {
+		leaf.VisitAll(add)
+	}
+	globals.VisitAll(add)
+	return out
+}
+
+// completeFlagValue suggests values for --name starting with prefix. Each
+// value is prepended with lead, for the --name=value form.
+func completeFlagValue(name, prefix, lead string) []completion {
+	var all []completion
+	switch name {
+	case "space":
+		all = spaceCompletions()
+	case "name":
+		all = nameCompletions()
+	case "user":
+		all = userCompletions()
+	case "format":
+		all = []completion{{"text", ""}, {"json", ""}}
+	case "progress":
+		all = []completion{{"text", ""}, {"json", ""}, {"none", ""}}
+	case "mode":
+		all = []completion{{"auto", ""}, {"browser", ""}, {"device", ""}}
+	case "input", "record", "replay", "debug-file", "file", "dir", "card-file":
+		all = pathCompletions(prefix)
+	}
+	out := make([]completion, 0, len(all))
+	for _, c := range all {
+		if strings.HasPrefix(strings.ToLower(c.value), strings.ToLower(prefix)) {
+			out = append(out, completion{lead + c.value, c.description})
+		}
+	}
+	return out
+}
+
+// settingCompletions lists the setting keys, and the per-command keys
+// already in config.json.
+func settingCompletions(prefix string) []completion {
+	var out []completion
+	for _, k := range settingKeys {
+		if strings.HasPrefix(k.name, prefix) {
+			out = append(out, completion{k.name, k.usage})
+		}
+	}
+	s, _ := loadSettings()
+	keys := make([]string, 0, len(s.Values))
+	for k := range s.Values {
+		if _, ok := lookupSettingKey(k); !ok && strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		out = append(out, completion{k, s.Values[k]})
+	}
+	return out
+}
+
+// staleCache serves every cache entry whatever its age: for completion an
+// old listing beats none.
+type staleCache struct{ c *diskcache.Cache }
+
+func (s staleCache) Get(key string) ([]byte, bool) { return s.c.Peek(key) }
+func (s staleCache) Set(string, []byte)            {}
+
+// completionSources opens the cache and DM index of the saved account for
+// reading. Both are empty when there is no account.
+func completionSources() (gchat.Cache, []gchat.DMEntry, map[string]string) {
+	aliases, _ := loadAliases()
+	st, err := loadToken()
+	if err != nil {
+		return nil, nil, aliases
+	}
+	var cache gchat.Cache
+	if p, err := cachePath(st); err == nil {
+		cache = staleCache{diskcache.Open(p, 0)}
+	}
+	var dms []gchat.DMEntry
+	if p, err := dmIndexPath(st); err == nil {
+		dms = dmindex.Open(p).Entries()
+	}
+	return cache, dms, aliases
+}
+
+// spaceCompletions lists cached spaces by display name; DMs are described
+// by their peer.
+func spaceCompletions() []completion {
+	cache, dms, aliases := completionSources()
+	peers := map[string]string{}
+	for _, e := range dms {
+		peers[e.Space] = firstNonEmpty(e.Display, aliases[e.User], e.User)
+	}
+	descs := map[string]string{}
+	if cache != nil {
+		spaces, _ := gchat.CachedSpaces(cache)
+		for _, s := range spaces {
+			desc := s.DisplayName
+			if desc == "" && peers[s.Name] != "" {
+				desc = "DM with " + peers[s.Name]
+			}
+			descs[s.Name] = firstNonEmpty(desc, s.SpaceType)
+		}
+	}
+	for space, peer := range peers {
+		if descs[space] == "" || descs[space] == "DIRECT_MESSAGE" {
+			descs[space] = "DM with " + peer
+		}
+	}
+	return sortedCompletions(descs)
+}
+
+// nameCompletions lists the display names --name can resolve without a
+// scan: aliases and indexed DM peers.
+func nameCompletions() []completion {
+	_, dms, aliases := completionSources()
+	names := map[string]string{}
+	for user, name := range aliases {
+		names[name] = user
+	}
+	for _, e := range dms {
+		if name := firstNonEmpty(aliases[e.User], e.Display); name != "" && names[name] == "" {
+			names[name] = e.User
+		}
+	}
+	return sortedCompletions(names)
+}
+
+// userCompletions lists the human members of cached spaces, plus aliased
+// and indexed users.
+func userCompletions() []completion {
+	cache, dms, aliases := completionSources()
+	users := map[string]string{}
+	add := func(user, display string) {
+		user = gchat.NormalizeUserRef(user)
+		if user != "" && users[user] == "" {
+			users[user] = firstNonEmpty(aliases[user], display)
+		}
+	}
+	if cache != nil {
+		spaces, _ := gchat.CachedSpaces(cache)
+		for _, s := range spaces {
+			members, _ := gchat.CachedMembers(cache, s.Name)
+			for _, m := range members {
+				if strings.EqualFold(m.Member.Type, "HUMAN") {
+					add(m.Member.Name, m.Member.DisplayName)
+				}
+			}
+		}
+	}
+	for _, e := range dms {
+		add(e.User, e.Display)
+	}
+	for user := range aliases {
+		add(user, "")
+	}
+	return sortedCompletions(users)
+}
+
+func pathCompletions(prefix string) []completion {
+	matches, _ := filepath.Glob(prefix + "*")
+	out := make([]completion, 0, len(matches))
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err == nil && fi.IsDir() {
+			m += string(filepath.Separator)
+		}
+		out = append(out, completion{m, ""})
+	}
+	return out
+}
+
+func sortedCompletions(m map[string]string) []completion {
+	out := make([]completion, 0, len(m))
+	for value, desc := range m {
+		out = append(out, completion{value, desc})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].value < out[j].value })
+	return out
+}
+
+// completionScripts are printed by `gchatctl completion SHELL`. Each defers
+// to `gchatctl __complete`, so they never go stale as commands change.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for gchatctl
+# Load with: source <(gchatctl completion bash)
+_gchatctl() {
+    local IFS=$'\n' line value
+    COMPREPLY=()
+    for line in $(gchatctl __complete -- "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null); do
+        value=${line%%$'\t'*}
+        # bash splits --flag=value at the "=" and completes only the value.
+        [[ $value == --*=* ]] && value=${value#*=}
+        COMPREPLY+=("$(printf '%q' "$value")")
+    done
+}
+complete -o default -F _gchatctl gchatctl
+`,
+	"zsh": `#compdef gchatctl
+# zsh completion for gchatctl
+# Load with: source <(gchatctl completion zsh)
+_gchatctl() {
+    local -a lines candidates
+    local line
+    lines=("${(@f)$(gchatctl __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    for line in $lines; do
+        [[ -z $line ]] && continue
+        candidates+=("${${line%%$'\t'*}//:/\\:}:${line#*$'\t'}")
+    done
+    if (( ${#candidates} )); then
+        _describe -t values gchatctl candidates
+    else
+        _files
+    fi
+}
+if (( $+functions[compdef] )); then
+    compdef _gchatctl gchatctl
+fi
+`,
+	"fish": `# fish completion for gchatctl
+# Load with: gchatctl completion fish | source
+function __gchatctl_complete
+    set -l words (commandline -opc) (commandline -ct)
+    gchatctl __complete -- $words[2..-1] 2>/dev/null
+end
+complete -c gchatctl -f -a '(__gchatctl_complete)'
+`,
+	"powershell": `# PowerShell completion for gchatctl
+# Load with: gchatctl completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName gchatctl, gchatctl.exe -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @($commandAst.CommandElements |
+        Where-Object { $_.Extent.StartOffset -lt $cursorPosition } |
+        Select-Object -Skip 1 |
+        ForEach-Object { $_.ToString() })
+    if ($wordToComplete -eq '') { $words += '""' }
+    gchatctl __complete -- @words 2>$null | ForEach-Object {
+        $value, $desc = $_ -split "` + "`" + `t", 2
+        if (-not $desc) { $desc = $value }
+        [System.Management.Automation.CompletionResult]::new($value, $value, 'ParameterValue', $desc)
+    }
+}
+`,
+}
+
+// chatSpacesListFlags are the flags of `chat spaces list`.
+type chatSpacesListFlags struct {
+	limit      *int
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatSpacesListFlags declares the flags of `chat spaces list` on fs.
+func newChatSpacesListFlags(fs *flag.FlagSet) *chatSpacesListFlags {
+	var f chatSpacesListFlags
+	f.limit = fs.Int("limit", 100, "max spaces to return")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatSpacesList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat spaces list", flag.ContinueOnError)
+	flags := newChatSpacesListFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	if *flags.limit <= 0 {
+		return usageError("--limit must be greater than 0")
+	}
+
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
+	if err != nil {
+		return err
+	}
+	defer sess.release()
+	client := sess.client
+
+	items, err := client.ListSpaces(ctx, gchat.ListSpacesOptions{Limit: *flags.limit})
+	if err != nil {
+		return err
+	}
+	if err := sess.close(); err != nil {
+		return err
+	}
+
+	if *flags.jsonOut {
+		out := map[string]any{"count": len(items),
+			"spaces": items,
+		}
+		return sess.printJSON(out)
+	}
+
+	if len(items) == 0 {
+		fmt.Printf("No spaces found\n")
+		return nil
+	}
+	fmt.Printf("Spaces (%d):\n", len(items))
+	for _, s := range items {
+		display := firstNonEmpty(strings.TrimSpace(s.DisplayName), "(no display name)")
+		fmt.Printf("- %s  [%s]  %s\n", s.Name, firstNonEmpty(s.SpaceType, "SPACE"), display)
+	}
+	return nil
+}
+
+// chatSpacesUnreadFlags are the flags of `chat spaces unread`.
+type chatSpacesUnreadFlags struct {
+	limit      *int
+	exclude    *string
+	strict     *bool
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatSpacesUnreadFlags declares the flags of `chat spaces unread` on fs.
+func newChatSpacesUnreadFlags(fs *flag.FlagSet) *chatSpacesUnreadFlags {
+	var f chatSpacesUnreadFlags
+	f.limit = fs.Int("limit", 100, "max spaces to check")
+	f.exclude = fs.String("exclude-spaces", "", "comma-separated spaces to skip when scanning")
+	f.strict = fs.Bool("strict", false, strictUsage)
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatSpacesUnread(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat spaces unread", flag.ContinueOnError)
+	flags := newChatSpacesUnreadFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	if *flags.limit <= 0 {
+		return usageError("--limit must be greater than 0")
+	}
+
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
+	if err != nil {
+		return err
+	}
+	defer sess.release()
+	client := sess.client
+
+	spaces, err := client.ListSpaces(ctx, gchat.ListSpacesOptions{Limit: *flags.limit})
+	if err != nil {
+		return err
+	}
+	skip := excludedSpaces(*flags.exclude)
+	kept := spaces[:0]
+	for _, s := range spaces {
+		if !skip[s.Name] {
+			kept = append(kept, s)
+		}
+	}
+	spaces = kept
+
+	checks, errs := gchat.FanOut(gchat.WithStage(ctx, "check unread"), client.Concurrency(), spaces, func(ctx context.Context, s gchat.ChatSpace) (*UnreadSpaceView, error) {
+		latestMsg, err := client.ListMessages(ctx, s.Name, gchat.ListMessagesOptions{Limit: 1, OrderBy: gchat.OrderCreateTimeDesc})
+		if err != nil || len(latestMsg) == 0 {
+			return nil, opError(gchat.EndpointMessagesList, err)
+		}
+		latestTS, ok := gchat.ParseTime(latestMsg[0].CreateTime)
+		if !ok {
+			return nil, nil
+		}
+		rs, err := client.GetSpaceReadState(ctx, s.Name)
+		if err != nil {
+			return nil, opError(gchat.EndpointGetSpaceReadState, err)
+		}
+		lastReadTS, rok := gchat.ParseTime(rs.LastReadTime)
+		if rok && !latestTS.After(lastReadTS) {
+			return nil, nil
+		}
+		gchat.CountFound(ctx, 1)
+		return &UnreadSpaceView{
+			Space:     s.Name,
+			SpaceType: s.SpaceType,
+			Display:   strings.TrimSpace(s.DisplayName),
+			LastRead:  rs.LastReadTime,
+			Latest:    latestMsg[0].CreateTime,
+			IsUnread:  true,
+		}, nil
+	})
+	failures := scanFailures(spaceNames(spaces), errs)
+	if err := checkScan(*flags.strict, failures); err != nil {
+		return err
+	}
+	unread := make([]UnreadSpaceView, 0, minInt(32, len(spaces)))
+	for _, v := range checks {
+		if v != nil {
+			unread = append(unread, *v)
+		}
+	}
+
+	sort.Slice(unread, func(i, j int) bool {
+		ti, _ := gchat.ParseTime(unread[i].Latest)
+		tj, _ := gchat.ParseTime(unread[j].Latest)
+		return tj.Before(ti)
+	})
+
+	if err := sess.close(); err != nil {
+		return err
+	}
+
+	if *flags.jsonOut {
+		out := map[string]any{"count": len(unread),
+			"spaces": unread,
+		}
+		addScanFailures(out, failures)
+		return sess.printJSON(out)
+	}
+	if len(unread) == 0 {
+		fmt.Printf("No unread spaces\n")
+		return nil
+	}
+	fmt.Printf("Unread spaces (%d):\n", len(unread))
+	for _, u := range unread {
+		label := firstNonEmpty(strings.TrimSpace(u.Display), "(no display name)")
+		fmt.Printf("- %s  [%s]  %s  latest=%s\n", u.Space, firstNonEmpty(u.SpaceType, "SPACE"), label, u.Latest)
+	}
+	return nil
+}
+
+// chatSpacesDMFlags are the flags of `chat spaces dm`.
+type chatSpacesDMFlags struct {
+	limit      *int
+	reindex    *bool
+	strict     *bool
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatSpacesDMFlags declares the flags of `chat spaces dm` on fs.
+func newChatSpacesDMFlags(fs *flag.FlagSet) *chatSpacesDMFlags {
+	var f chatSpacesDMFlags
+	f.limit = fs.Int("limit", 100, "max DM spaces to return")
+	f.reindex = fs.Bool("reindex", false, "rebuild the name-to-DM index from every DM space")
+	f.strict = fs.Bool("strict", false, strictUsage)
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatSpacesDM(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat spaces dm", flag.ContinueOnError)
+	flags := newChatSpacesDMFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	if *flags.limit <= 0 {
+		return usageError("--limit must be greater than 0")
+	}
+	if *flags.reindex && (flags.clientOpts.noCache || flags.clientOpts.record != "" || flags.clientOpts.replay != "") {
+		return usageError("--reindex cannot be combined with --no-cache, --record or --replay")
+	}
+
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
+	if err != nil {
+		return err
+	}
+	defer sess.release()
+	client := sess.client
+
+	// A rebuild starts from an empty index and live memberships, and has to
+	// see every DM rather than the first --limit.
+	spaceLimit := *flags.limit * 2
+	if *flags.reindex && sess.index != nil {
+		sess.index.Reset()
+		ctx = gchat.WithCacheRefresh(ctx)
+		spaceLimit = 0
+	}
+	spaces, err := client.ListSpaces(ctx, gchat.ListSpacesOptions{Limit: spaceLimit})
+	if err != nil {
+		return err
+	}
+	aliases, _ := loadAliases()
+	me, _ := client.CurrentUser(ctx)
+	if strings.TrimSpace(me) == "" {
+		me = client.InferCurrentUser(ctx, spaces)
+	}
+
+	dmSpaces := make([]gchat.ChatSpace, 0, len(spaces))
+	for _, s := range spaces {
+		if s.SpaceType == "DIRECT_MESSAGE" {
+			dmSpaces = append(dmSpaces, s)
+		}
+	}
+	views, errs := gchat.FanOut(gchat.WithStage(ctx, "list DM peers"), client.Concurrency(), dmSpaces, func(ctx context.Context, s gchat.ChatSpace) (DMSpaceView, error) {
+		peerUser, peerName, err := client.DMPeer(ctx, s.Name, me)
+		if err != nil || peerUser == "" {
+			return DMSpaceView{}, opError(gchat.EndpointMembersList, err)
+		}
+		if peerName == "" {
+			peerName = strings.TrimSpace(aliases[gchat.NormalizeUserRef(peerUser)])
+		}
+		gchat.CountFound(ctx, 1)
+		return DMSpaceView{
+			Space:           s.Name,
+			PeerUser:        gchat.NormalizeUserRef(peerUser),
+			PeerDisplayName: peerName,
+		}, nil
+	})
+	failures := scanFailures(spaceNames(dmSpaces), errs)
+	if err := checkScan(*flags.strict, failures); err != nil {
+		return err
+	}
+	out := make([]DMSpaceView, 0, *flags.limit)
+	for _, v := range views {
+		if v.Space == "" {
+			continue
+		}
+		out = append(out, v)
+		if len(out) >= *flags.limit {
+			break
+		}
+	}
+
+	if err := sess.close(); err != nil {
+		return err
+	}
+
+	if *flags.jsonOut {
+		payload := map[string]any{"count": len(out),
+			"dms": out,
+		}
+		if *flags.reindex && sess.index != nil {
+			payload["indexed"] = len(sess.index.Entries())
+		}
+		addScanFailures(payload, failures)
+		return sess.printJSON(payload)
+	}
+	if len(out) == 0 {
+		fmt.Printf("No direct-message spaces found\n")
+		return nil
+	}
+	fmt.Printf("Direct-message spaces (%d):\n", len(out))
+	for _, dm := range out {
+		label := firstNonEmpty(dm.PeerDisplayName, dm.PeerUser)
+		fmt.Printf("- %s  peer=%s (%s)\n", dm.Space, label, dm.PeerUser)
+	}
+	if *flags.reindex && sess.index != nil {
+		fmt.Printf("Indexed %d DM spaces\n", len(sess.index.Entries()))
+	}
+	return nil
+}
+
+// chatSpacesMembersFlags are the flags of `chat spaces members`.
+type chatSpacesMembersFlags struct {
+	space      *string
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatSpacesMembersFlags declares the flags of `chat spaces members` on fs.
+func newChatSpacesMembersFlags(fs *flag.FlagSet) *chatSpacesMembersFlags {
+	var f chatSpacesMembersFlags
+	f.space = fs.String("space", "", "space resource name or ID")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatSpacesMembers(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat spaces members", flag.ContinueOnError)
+	flags := newChatSpacesMembersFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*flags.space) == "" {
+		return usageError("--space is required")
+	}
+	spaceName := gchat.NormalizeSpaceName(*flags.space)
+
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
+	if err != nil {
+		return err
+	}
+	defer sess.release()
+	client := sess.client
+
+	aliases, _ := loadAliases()
+	members, err := client.ListMembers(ctx, spaceName, gchat.ListMembersOptions{})
+	if err != nil {
+		return err
+	}
+	if err := sess.close(); err != nil {
+		return err
+	}
+
+	out := make([]SpaceMemberView, 0, len(members))
+	for _, m := range members {
+		u := gchat.NormalizeUserRef(m.Member.Name)
+		out = append(out, SpaceMemberView{
+			User:        u,
+			DisplayName: strings.TrimSpace(m.Member.DisplayName),
+			Type:        strings.TrimSpace(m.Member.Type),
+			Alias:       strings.TrimSpace(aliases[u]),
+		})
+	}
+
+	if *flags.jsonOut {
+		return sess.printJSON(map[string]any{"space": spaceName,
 			"count":   len(out),
 			"members": out,
 		})
 	}
-	if len(out) == 0 {
-		fmt.Printf("No members found in %s\n", spaceName)
+	if len(out) == 0 {
+		fmt.Printf("No members found in %s\n", spaceName)
+		return nil
+	}
+	fmt.Printf("Members in %s:\n", spaceName)
+	for _, m := range out {
+		label := firstNonEmpty(m.Alias, m.DisplayName, m.User)
+		fmt.Printf("- %s  (%s)\n", label, m.User)
+	}
+	return nil
+}
+
+// chatUsersAliasesListFlags are the flags of `chat users aliases list`.
+type chatUsersAliasesListFlags struct {
+	jsonOut *bool
+}
+
+// newChatUsersAliasesListFlags declares the flags of `chat users aliases list` on fs.
+func newChatUsersAliasesListFlags(fs *flag.FlagSet) *chatUsersAliasesListFlags {
+	var f chatUsersAliasesListFlags
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	return &f
+}
+
+func runChatUsersAliasesList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat users aliases list", flag.ContinueOnError)
+	flags := newChatUsersAliasesListFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	aliases, err := loadAliases()
+	if err != nil {
+		return err
+	}
+	if *flags.jsonOut {
+		out := map[string]any{
+			"count":   len(aliases),
+			"aliases": aliases,
+		}
+		return printJSON(out)
+	}
+	if len(aliases) == 0 {
+		fmt.Println("No user aliases configured")
+		return nil
+	}
+	fmt.Printf("User aliases (%d):\n", len(aliases))
+	for user, name := range aliases {
+		fmt.Printf("- %s => %s\n", user, name)
+	}
+	return nil
+}
+
+// chatUsersAliasesSetFlags are the flags of `chat users aliases set`.
+type chatUsersAliasesSetFlags struct {
+	user *string
+	name *string
+}
+
+// newChatUsersAliasesSetFlags declares the flags of `chat users aliases set` on fs.
+func newChatUsersAliasesSetFlags(fs *flag.FlagSet) *chatUsersAliasesSetFlags {
+	var f chatUsersAliasesSetFlags
+	f.user = fs.String("user", "", "user resource name (users/...)")
+	f.name = fs.String("name", "", "display name")
+	return &f
+}
+
+func runChatUsersAliasesSet(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat users aliases set", flag.ContinueOnError)
+	flags := newChatUsersAliasesSetFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*flags.user) == "" {
+		return usageError("--user is required (example: users/123...)")
+	}
+	if strings.TrimSpace(*flags.name) == "" {
+		return usageError("--name is required")
+	}
+	aliases, err := loadAliases()
+	if err != nil {
+		return err
+	}
+	key := gchat.NormalizeUserRef(*flags.user)
+	aliases[key] = strings.TrimSpace(*flags.name)
+	if err := saveAliases(aliases); err != nil {
+		return err
+	}
+	fmt.Printf("Saved alias: %s => %s\n", key, aliases[key])
+	return nil
+}
+
+// chatUsersAliasesUnsetFlags are the flags of `chat users aliases unset`.
+type chatUsersAliasesUnsetFlags struct {
+	user *string
+}
+
+// newChatUsersAliasesUnsetFlags declares the flags of `chat users aliases unset` on fs.
+func newChatUsersAliasesUnsetFlags(fs *flag.FlagSet) *chatUsersAliasesUnsetFlags {
+	var f chatUsersAliasesUnsetFlags
+	f.user = fs.String("user", "", "user resource name (users/...)")
+	return &f
+}
+
+func runChatUsersAliasesUnset(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat users aliases unset", flag.ContinueOnError)
+	flags := newChatUsersAliasesUnsetFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*flags.user) == "" {
+		return usageError("--user is required (example: users/123...)")
+	}
+	aliases, err := loadAliases()
+	if err != nil {
+		return err
+	}
+	key := gchat.NormalizeUserRef(*flags.user)
+	delete(aliases, key)
+	if err := saveAliases(aliases); err != nil {
+		return err
+	}
+	fmt.Printf("Removed alias for %s\n", key)
+	return nil
+}
+
+// chatUsersAliasesSetFromSpaceFlags are the flags of `chat users aliases set-from-space`.
+type chatUsersAliasesSetFromSpaceFlags struct {
+	space      *string
+	name       *string
+	clientOpts *clientOptions
+}
+
+// newChatUsersAliasesSetFromSpaceFlags declares the flags of `chat users aliases set-from-space` on fs.
+func newChatUsersAliasesSetFromSpaceFlags(fs *flag.FlagSet) *chatUsersAliasesSetFromSpaceFlags {
+	var f chatUsersAliasesSetFromSpaceFlags
+	f.space = fs.String("space", "", "space resource name or ID (DIRECT_MESSAGE)")
+	f.name = fs.String("name", "", "display name alias")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatUsersAliasesSetFromSpace(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat users aliases set-from-space", flag.ContinueOnError)
+	flags := newChatUsersAliasesSetFromSpaceFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*flags.space) == "" {
+		return usageError("--space is required")
+	}
+	if strings.TrimSpace(*flags.name) == "" {
+		return usageError("--name is required")
+	}
+	spaceName := gchat.NormalizeSpaceName(*flags.space)
+
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
+	if err != nil {
+		return err
+	}
+	defer sess.release()
+	client := sess.client
+
+	me, _ := client.CurrentUser(ctx)
+	if strings.TrimSpace(me) == "" {
+		spaceList, _ := client.ListSpaces(ctx, gchat.ListSpacesOptions{Limit: 200})
+		me = client.InferCurrentUser(ctx, spaceList)
+	}
+	peerUser, _, err := client.DMPeer(ctx, spaceName, me)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(peerUser) == "" {
+		return &cliError{code: gchat.CodeNotFound, err: errors.New("could not infer DM peer user from space memberships")}
+	}
+
+	aliases, err := loadAliases()
+	if err != nil {
+		return err
+	}
+	key := gchat.NormalizeUserRef(peerUser)
+	aliases[key] = strings.TrimSpace(*flags.name)
+	if err := saveAliases(aliases); err != nil {
+		return err
+	}
+	if err := sess.close(); err != nil {
+		return err
+	}
+	fmt.Printf("Saved alias from %s: %s => %s\n", spaceName, key, aliases[key])
+	return nil
+}
+
+// chatUsersAliasesInferFlags are the flags of `chat users aliases infer`.
+type chatUsersAliasesInferFlags struct {
+	spaceLimit   *int
+	messageLimit *int
+	apply        *bool
+	force        *bool
+	strict       *bool
+	jsonOut      *bool
+	clientOpts   *clientOptions
+}
+
+// newChatUsersAliasesInferFlags declares the flags of `chat users aliases infer` on fs.
+func newChatUsersAliasesInferFlags(fs *flag.FlagSet) *chatUsersAliasesInferFlags {
+	var f chatUsersAliasesInferFlags
+	f.spaceLimit = fs.Int("space-limit", 100, "max spaces to scan")
+	f.messageLimit = fs.Int("message-limit", 100, "max messages per space")
+	f.apply = fs.Bool("apply", false, "save inferred aliases")
+	f.force = fs.Bool("force", false, "overwrite existing aliases")
+	f.strict = fs.Bool("strict", false, strictUsage)
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatUsersAliasesInfer(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat users aliases infer", flag.ContinueOnError)
+	flags := newChatUsersAliasesInferFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	if *flags.spaceLimit <= 0 || *flags.messageLimit <= 0 {
+		return usageError("--space-limit and --message-limit must be greater than 0")
+	}
+
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
+	if err != nil {
+		return err
+	}
+	defer sess.release()
+	client := sess.client
+
+	spaces, err := client.ListSpaces(ctx, gchat.ListSpacesOptions{Limit: *flags.spaceLimit})
+	if err != nil {
+		return err
+	}
+	existing, _ := loadAliases()
+	aliasHits := map[string]map[string]int{}
+	re := regexp.MustCompile(`(?i)^\s*([\p{L}][\p{L}\s.'-]{1,80}?)\s*\([^\s()]+@[^\s()]+\)`)
+
+	type aliasHit struct{ user, name string }
+	perSpace, errs := gchat.FanOut(gchat.WithStage(ctx, "infer aliases"), client.Concurrency(), spaces, func(ctx context.Context, s gchat.ChatSpace) ([]aliasHit, error) {
+		members, err := client.ListMembers(ctx, s.Name, gchat.ListMembersOptions{})
+		if err != nil {
+			return nil, opError(gchat.EndpointMembersList, err)
+		}
+		humanSenders := map[string]struct{}{}
+		for _, mem := range members {
+			if strings.ToUpper(strings.TrimSpace(mem.Member.Type)) != "HUMAN" {
+				continue
+			}
+			id := gchat.NormalizeUserRef(mem.Member.Name)
+			if id == "" || id == "users/" {
+				continue
+			}
+			humanSenders[id] = struct{}{}
+		}
+		if len(humanSenders) == 0 {
+			return nil, nil
+		}
+
+		msgs, err := client.ListMessages(ctx, s.Name, gchat.ListMessagesOptions{Limit: *flags.messageLimit, OrderBy: gchat.OrderCreateTimeDesc})
+		if err != nil {
+			return nil, opError(gchat.EndpointMessagesList, err)
+		}
+		var hits []aliasHit
+		for _, m := range msgs {
+			user := gchat.NormalizeUserRef(m.Sender.Name)
+			if user == "" || user == "users/" {
+				continue
+			}
+			if _, ok := humanSenders[user]; !ok {
+				continue
+			}
+			text := strings.TrimSpace(m.Text)
+			if text == "" {
+				continue
+			}
+			match := re.FindStringSubmatch(text)
+			if len(match) < 2 {
+				continue
+			}
+			name := strings.TrimSpace(match[1])
+			if name == "" {
+				continue
+			}
+			hits = append(hits, aliasHit{user: user, name: name})
+		}
+		gchat.CountFound(ctx, len(hits))
+		return hits, nil
+	})
+	failures := scanFailures(spaceNames(spaces), errs)
+	if err := checkScan(*flags.strict, failures); err != nil {
+		return err
+	}
+	for _, hits := range perSpace {
+		for _, h := range hits {
+			if _, ok := aliasHits[h.user]; !ok {
+				aliasHits[h.user] = map[string]int{}
+			}
+			aliasHits[h.user][h.name]++
+		}
+	}
+
+	inferredList := make([]InferredAlias, 0, len(aliasHits))
+	for user, names := range aliasHits {
+		bestName := ""
+		bestHits := 0
+		for n, c := range names {
+			if c > bestHits {
+				bestHits = c
+				bestName = n
+			}
+		}
+		if bestName == "" {
+			continue
+		}
+		cur := strings.TrimSpace(existing[user])
+		applied := false
+		if *flags.apply {
+			if cur == "" || *flags.force {
+				existing[user] = bestName
+				applied = true
+			}
+		}
+		inferredList = append(inferredList, InferredAlias{
+			User:     user,
+			Name:     bestName,
+			Hits:     bestHits,
+			Existing: cur,
+			Applied:  applied,
+		})
+	}
+	sort.Slice(inferredList, func(i, j int) bool {
+		if inferredList[i].Hits == inferredList[j].Hits {
+			return inferredList[i].User < inferredList[j].User
+		}
+		return inferredList[i].Hits > inferredList[j].Hits
+	})
+
+	if *flags.apply {
+		if err := saveAliases(existing); err != nil {
+			return err
+		}
+	}
+	if err := sess.close(); err != nil {
+		return err
+	}
+
+	if *flags.jsonOut {
+		payload := map[string]any{"count": len(inferredList),
+			"inferred":  inferredList,
+			"applied":   *flags.apply,
+			"overwrote": *flags.force,
+		}
+		addScanFailures(payload, failures)
+		return sess.printJSON(payload)
+	}
+	if len(inferredList) == 0 {
+		fmt.Println("No aliases inferred from recent messages")
 		return nil
 	}
-	fmt.Printf("Members in %s:\n", spaceName)
-	for _, m := range out {
-		label := firstNonEmpty(m.Alias, m.DisplayName, m.User)
-		fmt.Printf("- %s  (%s)\n", label, m.User)
+	fmt.Printf("Inferred aliases (%d):\n", len(inferredList))
+	for _, it := range inferredList {
+		status := "suggested"
+		if it.Applied {
+			status = "applied"
+		}
+		if it.Existing != "" && !it.Applied && it.Existing != it.Name {
+			status = "kept-existing"
+		}
+		fmt.Printf("- %s => %s  (hits=%d, %s)\n", it.User, it.Name, it.Hits, status)
+	}
+	return nil
+}
+
+// chatMessagesListFlags are the flags of `chat list`.
+type chatMessagesListFlags struct {
+	space        *string
+	limit        *int
+	jsonOut      *bool
+	person       *string
+	groupThreads *bool
+	clientOpts   *clientOptions
+}
+
+// newChatMessagesListFlags declares the flags of `chat list` on fs.
+func newChatMessagesListFlags(fs *flag.FlagSet) *chatMessagesListFlags {
+	var f chatMessagesListFlags
+	f.space = fs.String("space", "", "space resource name or ID")
+	f.limit = fs.Int("limit", 50, "max messages to return")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.person = fs.String("person", "", "filter by sender (display name, user ID, or users/...)")
+	f.groupThreads = fs.Bool("group-threads", false, "group messages by thread, threads with the newest activity first")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatMessagesList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat list", flag.ContinueOnError)
+	flags := newChatMessagesListFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	if *flags.limit <= 0 {
+		return usageError("--limit must be greater than 0")
+	}
+	if strings.TrimSpace(*flags.space) == "" {
+		return usageError("--space is required (example: --space spaces/AAA...); for person chat use: gchatctl chat with --name \"Simon\"")
+	}
+	spaceName := gchat.NormalizeSpaceName(*flags.space)
+
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
+	if err != nil {
+		return err
+	}
+	defer sess.release()
+	client := sess.client
+
+	items, err := client.ListMessages(ctx, spaceName, gchat.ListMessagesOptions{Limit: *flags.limit, OrderBy: gchat.OrderCreateTimeDesc})
+	if err != nil {
+		return err
+	}
+	fillSenderNames(ctx, client, spaceName, items)
+	if strings.TrimSpace(*flags.person) != "" {
+		items = filterMessagesByPerson(items, *flags.person)
+	}
+	if err := sess.close(); err != nil {
+		return err
+	}
+
+	if *flags.groupThreads {
+		threads := groupByThread(items)
+		if *flags.jsonOut {
+			return sess.printJSON(map[string]any{"space": spaceName,
+				"count":   len(items),
+				"threads": threads,
+			})
+		}
+		if len(threads) == 0 {
+			fmt.Printf("No messages found in %q\n", spaceName)
+			return nil
+		}
+		fmt.Printf("Threads (%d) with %d messages in %q:\n", len(threads), len(items), spaceName)
+		loc := displayLocation()
+		for _, t := range threads {
+			fmt.Printf("\nThread %s (%d):\n", firstNonEmpty(t.Thread, "unknown-thread"), t.Count)
+			for _, m := range t.Messages {
+				printMessageLine(m, loc)
+			}
+		}
+		return nil
+	}
+
+	if *flags.jsonOut {
+		out := map[string]any{"space": spaceName,
+			"count":    len(items),
+			"messages": items,
+		}
+		return sess.printJSON(out)
+	}
+
+	if len(items) == 0 {
+		fmt.Printf("No messages found in %q\n", spaceName)
+		return nil
+	}
+	fmt.Printf("Messages (%d) in %q:\n", len(items), spaceName)
+	loc := displayLocation()
+	for _, m := range items {
+		printMessageLine(m, loc)
+	}
+	return nil
+}
+
+// fillSenderNames sets missing sender display names from the space's
+// members, then from aliases. Lookup failures leave names empty.
+func fillSenderNames(ctx context.Context, client *gchat.Client, spaceName string, items []gchat.ChatMessage) {
+	aliases, _ := loadAliases()
+	senderNames, err := client.MemberDisplayNames(ctx, spaceName)
+	if err != nil {
+		// Keep message listing functional even if sender-name enrichment fails.
+		senderNames = map[string]string{}
+	}
+	for i := range items {
+		if strings.TrimSpace(items[i].Sender.DisplayName) == "" {
+			if v := strings.TrimSpace(senderNames[items[i].Sender.Name]); v != "" {
+				items[i].Sender.DisplayName = v
+				continue
+			}
+			if v := strings.TrimSpace(aliases[gchat.NormalizeUserRef(items[i].Sender.Name)]); v != "" {
+				items[i].Sender.DisplayName = v
+			}
+		}
 	}
-	return nil
 }
 
-func runChatUsers(args []string) error {
-	if len(args) == 0 {
-		printChatUsersHelp()
-		return nil
+// groupByThread groups newest-first items by thread. Threads keep the
+// order of their newest message; messages within one run oldest first.
+func groupByThread(items []gchat.ChatMessage) []ThreadView {
+	out := make([]ThreadView, 0, len(items))
+	index := map[string]int{}
+	for _, m := range items {
+		key := m.ThreadName()
+		if key == "" {
+			// Without thread info each message stands alone.
+			key = m.Name
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(out)
+			index[key] = i
+			out = append(out, ThreadView{Thread: m.ThreadName()})
+		}
+		out[i].Messages = append(out[i].Messages, m)
+		out[i].Count++
 	}
-	switch args[0] {
-	case "aliases":
-		return runChatUsersAliases(args[1:])
-	case "help", "--help", "-h":
-		printChatUsersHelp()
-		return nil
-	default:
-		printChatUsersHelp()
-		return fmt.Errorf("unknown chat users command %q", args[0])
+	for _, t := range out {
+		for a, b := 0, len(t.Messages)-1; a < b; a, b = a+1, b-1 {
+			t.Messages[a], t.Messages[b] = t.Messages[b], t.Messages[a]
+		}
 	}
+	return out
 }
 
-func printChatUsersHelp() {
-	fmt.Println("gchatctl chat users commands:")
-	fmt.Println("  chat users aliases list [--json]")
-	fmt.Println("  chat users aliases set --user users/... --name \"Display Name\"")
-	fmt.Println("  chat users aliases set-from-space --space spaces/... --name \"Simon\"")
-	fmt.Println("  chat users aliases infer [--apply]")
-	fmt.Println("  chat users aliases unset --user users/...")
+// chatThreadFlags are the flags of `chat thread`.
+type chatThreadFlags struct {
+	message    *string
+	thread     *string
+	limit      *int
+	jsonOut    *bool
+	clientOpts *clientOptions
 }
 
-func runChatUsersAliases(args []string) error {
-	if len(args) == 0 {
-		printChatUsersHelp()
-		return nil
-	}
-	switch args[0] {
-	case "list":
-		return runChatUsersAliasesList(args[1:])
-	case "set":
-		return runChatUsersAliasesSet(args[1:])
-	case "set-from-space":
-		return runChatUsersAliasesSetFromSpace(args[1:])
-	case "infer":
-		return runChatUsersAliasesInfer(args[1:])
-	case "unset":
-		return runChatUsersAliasesUnset(args[1:])
-	case "help", "--help", "-h":
-		printChatUsersHelp()
-		return nil
-	default:
-		printChatUsersHelp()
-		return fmt.Errorf("unknown chat users aliases command %q", args[0])
-	}
+// newChatThreadFlags declares the flags of `chat thread` on fs.
+func newChatThreadFlags(fs *flag.FlagSet) *chatThreadFlags {
+	var f chatThreadFlags
+	f.message = fs.String("message", "", "any message in the thread (spaces/.../messages/...)")
+	f.thread = fs.String("thread", "", "thread name (spaces/.../threads/...)")
+	f.limit = fs.Int("limit", 100, "max messages to return")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
 }
 
-func runChatUsersAliasesList(args []string) error {
-	fs := flag.NewFlagSet("chat users aliases list", flag.ContinueOnError)
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
+func runChatThread(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat thread", flag.ContinueOnError)
+	flags := newChatThreadFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
-	aliases, err := loadAliases()
+	if *flags.limit <= 0 {
+		return usageError("--limit must be greater than 0")
+	}
+	messageName, threadName := strings.TrimSpace(*flags.message), strings.TrimSpace(*flags.thread)
+	switch {
+	case messageName == "" && threadName == "":
+		return usageError("provide --message or --thread")
+	case messageName != "" && threadName != "":
+		return usageError("use either --message or --thread, not both")
+	case messageName != "" && !strings.Contains(messageName, "/messages/"):
+		return usageErrorf("--message must be a message name (spaces/.../messages/...), got %q", messageName)
+	case threadName != "" && !strings.Contains(threadName, "/threads/"):
+		return usageErrorf("--thread must be a thread name (spaces/.../threads/...), got %q", threadName)
+	}
+
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
 	if err != nil {
 		return err
 	}
-	if *jsonOut {
-		out := map[string]any{
-			"count":   len(aliases),
-			"aliases": aliases,
+	defer sess.release()
+	client := sess.client
+
+	if messageName != "" {
+		m, err := client.GetMessage(ctx, messageName)
+		if err != nil {
+			return err
+		}
+		if threadName = m.ThreadName(); threadName == "" {
+			return fmt.Errorf("message %s has no thread", messageName)
 		}
-		return printJSON(out)
 	}
-	if len(aliases) == 0 {
-		fmt.Println("No user aliases configured")
+	spaceName := gchat.SpaceOf(threadName)
+	items, err := client.ListMessages(ctx, spaceName, gchat.ListMessagesOptions{
+		Limit:   *flags.limit,
+		OrderBy: gchat.OrderCreateTimeAsc,
+		Filter:  gchat.InThread(threadName),
+	})
+	if err != nil {
+		return err
+	}
+	fillSenderNames(ctx, client, spaceName, items)
+	if err := sess.close(); err != nil {
+		return err
+	}
+
+	if *flags.jsonOut {
+		return sess.printJSON(map[string]any{"space": spaceName,
+			"thread":   threadName,
+			"count":    len(items),
+			"messages": items,
+		})
+	}
+	if len(items) == 0 {
+		fmt.Printf("No messages found in thread %s\n", threadName)
 		return nil
 	}
-	fmt.Printf("User aliases (%d):\n", len(aliases))
-	for user, name := range aliases {
-		fmt.Printf("- %s => %s\n", user, name)
+	fmt.Printf("Thread %s (%d messages):\n", threadName, len(items))
+	loc := displayLocation()
+	for _, m := range items {
+		printMessageLine(m, loc)
 	}
 	return nil
 }
 
-func runChatUsersAliasesSet(args []string) error {
-	fs := flag.NewFlagSet("chat users aliases set", flag.ContinueOnError)
-	user := fs.String("user", "", "user resource name (users/...)")
-	name := fs.String("name", "", "display name")
-	if err := fs.Parse(args); err != nil {
+// chatMessagesSendFlags are the flags of `chat send`.
+type chatMessagesSendFlags struct {
+	space        *string
+	email        *string
+	user         *string
+	text         *string
+	thread       *string
+	replyTo      *string
+	threadKey    *string
+	file         *string
+	cardFile     *string
+	card         *bool
+	cardTitle    *string
+	cardSubtitle *string
+	cardFields   stringList
+	cardButtons  stringList
+	dryRun       *bool
+	jsonOut      *bool
+	clientOpts   *clientOptions
+}
+
+// newChatMessagesSendFlags declares the flags of `chat send` on fs.
+func newChatMessagesSendFlags(fs *flag.FlagSet) *chatMessagesSendFlags {
+	var f chatMessagesSendFlags
+	f.space = fs.String("space", "", "space resource name or ID")
+	f.email = fs.String("email", "", "recipient email (maps to users/<email>)")
+	f.user = fs.String("user", "", "recipient user resource (users/...)")
+	f.text = fs.String("text", "", "message text to send")
+	f.thread = fs.String("thread", "", "reply in this thread (spaces/.../threads/...); names the space too")
+	f.replyTo = fs.String("reply-to", "", "reply in the thread of this message (spaces/.../messages/...); names the space too")
+	f.threadKey = fs.String("thread-key", "", "reply in the thread started with this key, starting it if there is none")
+	f.file = fs.String("file", "", "upload this file and attach it to the message")
+	f.cardFile = fs.String("card-file", "", "attach the cardsV2 in this JSON file (- for stdin)")
+	f.card = fs.Bool("card", false, "attach a card built from --title, --subtitle, --kv and --button")
+	f.cardTitle = fs.String("title", "", "card title (with --card)")
+	f.cardSubtitle = fs.String("subtitle", "", "card subtitle (with --card)")
+	fs.Var(&f.cardFields, "kv", "card row as key=value (with --card; repeatable)")
+	fs.Var(&f.cardButtons, "button", "card link button as label=url (with --card; repeatable)")
+	f.dryRun = fs.Bool("dry-run", false, "print the message that would be sent without sending it")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatMessagesSend(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat send", flag.ContinueOnError)
+	flags := newChatMessagesSendFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
-	if strings.TrimSpace(*user) == "" {
-		return errors.New("--user is required (example: users/123...)")
+
+	threadName, replyName, key := strings.TrimSpace(*flags.thread), strings.TrimSpace(*flags.replyTo), strings.TrimSpace(*flags.threadKey)
+	spaceProvided := strings.TrimSpace(*flags.space) != ""
+	recipientProvided := strings.TrimSpace(*flags.email) != "" || strings.TrimSpace(*flags.user) != ""
+	threadProvided := threadName != "" || replyName != ""
+	switch {
+	case threadName != "" && replyName != "":
+		return usageError("use either --thread or --reply-to, not both")
+	case threadProvided && key != "":
+		return usageError("--thread-key cannot be combined with --thread or --reply-to")
+	case threadProvided && (spaceProvided || recipientProvided):
+		return usageError("--thread and --reply-to already name the space; drop --space/--email/--user")
+	case threadName != "" && (gchat.SpaceOf(threadName) == "" || !strings.Contains(threadName, "/threads/")):
+		return usageErrorf("--thread must be a thread name (spaces/.../threads/...), got %q", threadName)
+	case replyName != "" && (gchat.SpaceOf(replyName) == "" || !strings.Contains(replyName, "/messages/")):
+		return usageErrorf("--reply-to must be a message name (spaces/.../messages/...), got %q", replyName)
+	}
+	if !spaceProvided && !recipientProvided && !threadProvided {
+		return usageError("destination required: provide --space, --email/--user, --thread or --reply-to")
 	}
-	if strings.TrimSpace(*name) == "" {
-		return errors.New("--name is required")
+	if spaceProvided && recipientProvided {
+		return usageError("use either --space or --email/--user, not both")
 	}
-	aliases, err := loadAliases()
+	if strings.TrimSpace(*flags.email) != "" && strings.TrimSpace(*flags.user) != "" {
+		return usageError("use either --email or --user, not both")
+	}
+	msgText := strings.TrimSpace(*flags.text)
+	filePath := strings.TrimSpace(*flags.file)
+	if msgText == "" && filePath == "" && *flags.cardFile == "" && !*flags.card {
+		return usageError("nothing to send: provide --text, --file, --card-file or --card")
+	}
+	var (
+		file     *os.File
+		fileSize int64
+	)
+	if filePath != "" {
+		var err error
+		if file, fileSize, err = openAttachmentFile(filePath); err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+	cards, err := messageCards(*flags.cardFile, *flags.card, *flags.cardTitle, *flags.cardSubtitle, flags.cardFields, flags.cardButtons)
 	if err != nil {
 		return err
 	}
-	key := normalizeUserRef(*user)
-	aliases[key] = strings.TrimSpace(*name)
-	if err := saveAliases(aliases); err != nil {
+
+	req := gchat.SendMessageRequest{Text: msgText, CardsV2: cards}
+	if *flags.dryRun {
+		preview := req
+		destination := ""
+		switch {
+		case replyName != "":
+			destination = "thread of " + replyName
+		case threadName != "":
+			destination = gchat.SpaceOf(threadName)
+			preview.Thread = &gchat.ChatThread{Name: threadName}
+		case spaceProvided:
+			destination = gchat.NormalizeSpaceName(*flags.space)
+		default:
+			destination = "direct message with " + gchat.NormalizeUserRef(firstNonEmpty(*flags.user, *flags.email))
+		}
+		if key != "" {
+			preview.Thread = &gchat.ChatThread{ThreadKey: key}
+		}
+		return printSendDryRun(destination, preview, filePath, fileSize, *flags.jsonOut)
+	}
+
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
+	if err != nil {
 		return err
 	}
-	fmt.Printf("Saved alias: %s => %s\n", key, aliases[key])
+	defer sess.release()
+	client := sess.client
+
+	spaceName := ""
+	switch {
+	case replyName != "":
+		parent, err := client.GetMessage(ctx, replyName)
+		if err != nil {
+			return err
+		}
+		if parent.ThreadName() == "" {
+			return fmt.Errorf("message %s has no thread", replyName)
+		}
+		spaceName = gchat.SpaceOf(replyName)
+		req.Thread = &gchat.ChatThread{Name: parent.ThreadName()}
+	case threadName != "":
+		spaceName = gchat.SpaceOf(threadName)
+		req.Thread = &gchat.ChatThread{Name: threadName}
+	case spaceProvided:
+		spaceName = gchat.NormalizeSpaceName(*flags.space)
+	default:
+		targetUser := gchat.NormalizeUserRef(firstNonEmpty(*flags.user, *flags.email))
+		dm, derr := client.FindDirectMessage(ctx, targetUser)
+		if derr != nil {
+			return derr
+		}
+		spaceName = dm.Name
+	}
+	if key != "" {
+		req.Thread = &gchat.ChatThread{ThreadKey: key}
+		req.ReplyOption = gchat.ReplyOrNewThread
+	}
+
+	if filePath != "" {
+		ref, err := client.UploadAttachment(ctx, spaceName, filepath.Base(filePath), "", file, fileSize)
+		if err != nil {
+			return err
+		}
+		req.Attachment = []gchat.Attachment{{AttachmentDataRef: &ref}}
+	}
+
+	messageID, err := newClientMessageID()
+	if err != nil {
+		return err
+	}
+	req.MessageID = messageID
+	sent, err := client.SendMessage(ctx, spaceName, req)
+	if err != nil {
+		return err
+	}
+	if err := sess.close(); err != nil {
+		return err
+	}
+
+	if *flags.jsonOut {
+		out := map[string]any{"space": spaceName,
+			"message": sent,
+		}
+		return sess.printJSON(out)
+	}
+	if req.Thread != nil && sent.ThreadName() != "" {
+		fmt.Printf("Sent reply to %s in thread %s\n", spaceName, sent.ThreadName())
+	} else {
+		fmt.Printf("Sent message to %s\n", spaceName)
+	}
+	if strings.TrimSpace(sent.Name) != "" {
+		fmt.Printf("Message ID: %s\n", sent.Name)
+	}
 	return nil
 }
 
-func runChatUsersAliasesUnset(args []string) error {
-	fs := flag.NewFlagSet("chat users aliases unset", flag.ContinueOnError)
-	user := fs.String("user", "", "user resource name (users/...)")
-	if err := fs.Parse(args); err != nil {
+// chatGetFlags are the flags of `chat get`.
+type chatGetFlags struct {
+	message    *string
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatGetFlags declares the flags of `chat get` on fs.
+func newChatGetFlags(fs *flag.FlagSet) *chatGetFlags {
+	var f chatGetFlags
+	f.message = fs.String("message", "", "message name (spaces/.../messages/...)")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatGet(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat get", flag.ContinueOnError)
+	flags := newChatGetFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
-	if strings.TrimSpace(*user) == "" {
-		return errors.New("--user is required (example: users/123...)")
+	name, err := messageNameFlag("message", *flags.message)
+	if err != nil {
+		return err
 	}
-	aliases, err := loadAliases()
+
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
 	if err != nil {
 		return err
 	}
-	key := normalizeUserRef(*user)
-	delete(aliases, key)
-	if err := saveAliases(aliases); err != nil {
+	defer sess.release()
+	client := sess.client
+
+	m, err := client.GetMessage(ctx, name)
+	if err != nil {
 		return err
 	}
-	fmt.Printf("Removed alias for %s\n", key)
+	spaceName := gchat.SpaceOf(name)
+	items := []gchat.ChatMessage{m}
+	fillSenderNames(ctx, client, spaceName, items)
+	m = items[0]
+	if err := sess.close(); err != nil {
+		return err
+	}
+
+	if *flags.jsonOut {
+		return sess.printJSON(map[string]any{"space": spaceName,
+			"message": m,
+		})
+	}
+	loc := displayLocation()
+	fmt.Printf("Message: %s\n", m.Name)
+	fmt.Printf("From:    %s\n", firstNonEmpty(strings.TrimSpace(m.Sender.DisplayName), strings.TrimSpace(m.Sender.Name), "unknown-sender"))
+	fmt.Printf("Sent:    %s\n", displayTime(m.CreateTime, loc))
+	if m.LastUpdateTime != "" {
+		fmt.Printf("Edited:  %s\n", displayTime(m.LastUpdateTime, loc))
+	}
+	if thread := m.ThreadName(); thread != "" {
+		fmt.Printf("Thread:  %s\n", thread)
+	}
+	for _, a := range m.Attachment {
+		fmt.Printf("File:    %s (%s, %s)\n", firstNonEmpty(a.ContentName, a.Name), firstNonEmpty(a.ContentType, "unknown type"), attachmentSource(a))
+	}
+	fmt.Println()
+	switch {
+	case strings.TrimSpace(m.Text) != "":
+		fmt.Println(m.Text)
+	case len(m.Attachment) == 0:
+		fmt.Println("(non-text message)")
+	}
 	return nil
 }
 
-func runChatUsersAliasesSetFromSpace(args []string) error {
-	fs := flag.NewFlagSet("chat users aliases set-from-space", flag.ContinueOnError)
-	space := fs.String("space", "", "space resource name or ID (DIRECT_MESSAGE)")
-	name := fs.String("name", "", "display name alias")
-	if err := fs.Parse(args); err != nil {
+// chatEditFlags are the flags of `chat edit`.
+type chatEditFlags struct {
+	message    *string
+	text       *string
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatEditFlags declares the flags of `chat edit` on fs.
+func newChatEditFlags(fs *flag.FlagSet) *chatEditFlags {
+	var f chatEditFlags
+	f.message = fs.String("message", "", "message name (spaces/.../messages/...)")
+	f.text = fs.String("text", "", "replacement message text")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatEdit(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat edit", flag.ContinueOnError)
+	flags := newChatEditFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
-	if strings.TrimSpace(*space) == "" {
-		return errors.New("--space is required")
+	name, err := messageNameFlag("message", *flags.message)
+	if err != nil {
+		return err
 	}
-	if strings.TrimSpace(*name) == "" {
-		return errors.New("--name is required")
+	msgText := strings.TrimSpace(*flags.text)
+	if msgText == "" {
+		return usageError("--text is required")
 	}
-	spaceName := normalizeSpaceName(*space)
 
-	ctx := context.Background()
-	cfg, st, err := loadAuthContext()
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
 	if err != nil {
 		return err
 	}
-	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
-	tokenSource := oauthCfg.TokenSource(ctx, &st.Token)
-	client := newOAuthClient(ctx, tokenSource)
+	defer sess.release()
 
-	me, _ := currentUserRef(ctx, client)
-	if strings.TrimSpace(me) == "" {
-		spaceList, _ := listSpaces(ctx, client, 200)
-		me = inferCurrentUserFromDMS(ctx, client, spaceList)
+	updated, err := sess.client.UpdateMessage(ctx, name, msgText)
+	if err != nil {
+		return err
+	}
+	if err := sess.close(); err != nil {
+		return err
+	}
+
+	if *flags.jsonOut {
+		return sess.printJSON(map[string]any{"space": gchat.SpaceOf(name),
+			"message": updated,
+		})
+	}
+	fmt.Printf("Updated message %s\n", firstNonEmpty(updated.Name, name))
+	return nil
+}
+
+// chatDeleteFlags are the flags of `chat delete`.
+type chatDeleteFlags struct {
+	message    *string
+	yes        *bool
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatDeleteFlags declares the flags of `chat delete` on fs.
+func newChatDeleteFlags(fs *flag.FlagSet) *chatDeleteFlags {
+	var f chatDeleteFlags
+	f.message = fs.String("message", "", "message name (spaces/.../messages/...)")
+	f.yes = fs.Bool("yes", false, "delete without asking for confirmation")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatDelete(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat delete", flag.ContinueOnError)
+	flags := newChatDeleteFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
 	}
-	peerUser, _, err := dmPeerForSpace(ctx, client, spaceName, me)
+	name, err := messageNameFlag("message", *flags.message)
 	if err != nil {
 		return err
 	}
-	if strings.TrimSpace(peerUser) == "" {
-		return errors.New("could not infer DM peer user from space memberships")
+	if !*flags.yes {
+		if !isInteractive() {
+			return usageError("refusing to delete without --yes when stdin is not a terminal")
+		}
+		ok, err := confirm("Delete message " + name + "?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("aborted; message not deleted")
+		}
 	}
 
-	aliases, err := loadAliases()
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
 	if err != nil {
 		return err
 	}
-	key := normalizeUserRef(peerUser)
-	aliases[key] = strings.TrimSpace(*name)
-	if err := saveAliases(aliases); err != nil {
+	defer sess.release()
+
+	if err := sess.client.DeleteMessage(ctx, name); err != nil {
 		return err
 	}
-	if err := saveRefreshedTokenIfChanged(st, tokenSource); err != nil {
+	if err := sess.close(); err != nil {
 		return err
 	}
-	fmt.Printf("Saved alias from %s: %s => %s\n", spaceName, key, aliases[key])
+
+	if *flags.jsonOut {
+		return sess.printJSON(map[string]any{"space": gchat.SpaceOf(name),
+			"message": name,
+			"deleted": true,
+		})
+	}
+	fmt.Printf("Deleted message %s\n", name)
 	return nil
 }
 
-func runChatUsersAliasesInfer(args []string) error {
-	fs := flag.NewFlagSet("chat users aliases infer", flag.ContinueOnError)
-	spaceLimit := fs.Int("space-limit", 100, "max spaces to scan")
-	messageLimit := fs.Int("message-limit", 100, "max messages per space")
-	apply := fs.Bool("apply", false, "save inferred aliases")
-	force := fs.Bool("force", false, "overwrite existing aliases")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
+// chatReactFlags are the flags of `chat react`.
+type chatReactFlags struct {
+	message    *string
+	emojiFlag  *string
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatReactFlags declares the flags of `chat react` on fs.
+func newChatReactFlags(fs *flag.FlagSet) *chatReactFlags {
+	var f chatReactFlags
+	f.message = fs.String("message", "", "message name (spaces/.../messages/...)")
+	f.emojiFlag = fs.String("emoji", "", "Unicode emoji or shortcode such as :eyes:")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatReact(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat react", flag.ContinueOnError)
+	flags := newChatReactFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	name, err := messageNameFlag("message", *flags.message)
+	if err != nil {
 		return err
 	}
-	if *spaceLimit <= 0 || *messageLimit <= 0 {
-		return errors.New("--space-limit and --message-limit must be greater than 0")
+	emoji, err := parseEmoji(*flags.emojiFlag)
+	if err != nil {
+		return err
 	}
 
-	ctx := context.Background()
-	cfg, st, err := loadAuthContext()
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
 	if err != nil {
 		return err
 	}
-	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
-	tokenSource := oauthCfg.TokenSource(ctx, &st.Token)
-	client := newOAuthClient(ctx, tokenSource)
+	defer sess.release()
 
-	spaces, err := listSpaces(ctx, client, *spaceLimit)
+	reaction, err := sess.client.CreateReaction(ctx, name, gchat.Emoji{Unicode: emoji})
 	if err != nil {
 		return err
 	}
-	existing, _ := loadAliases()
-	aliasHits := map[string]map[string]int{}
-	re := regexp.MustCompile(`(?i)^\s*([\p{L}][\p{L}\s.'-]{1,80}?)\s*\([^\s()]+@[^\s()]+\)`)
+	if err := sess.close(); err != nil {
+		return err
+	}
 
-	for _, s := range spaces {
-		members, err := listSpaceMembers(ctx, client, s.Name)
-		if err != nil {
-			continue
-		}
-		humanSenders := map[string]struct{}{}
-		for _, mem := range members {
-			if strings.ToUpper(strings.TrimSpace(mem.Member.Type)) != "HUMAN" {
-				continue
-			}
-			id := normalizeUserRef(mem.Member.Name)
-			if id == "" || id == "users/" {
-				continue
-			}
-			humanSenders[id] = struct{}{}
-		}
-		if len(humanSenders) == 0 {
-			continue
-		}
+	if *flags.jsonOut {
+		return sess.printJSON(map[string]any{"space": gchat.SpaceOf(name),
+			"reaction": reaction,
+		})
+	}
+	fmt.Printf("Reacted %s to %s\n", emoji, name)
+	return nil
+}
 
-		msgs, err := listMessages(ctx, client, s.Name, *messageLimit)
-		if err != nil {
-			continue
-		}
-		for _, m := range msgs {
-			user := normalizeUserRef(m.Sender.Name)
-			if user == "" || user == "users/" {
-				continue
-			}
-			if _, ok := humanSenders[user]; !ok {
-				continue
-			}
-			text := strings.TrimSpace(m.Text)
-			if text == "" {
-				continue
-			}
-			match := re.FindStringSubmatch(text)
-			if len(match) < 2 {
-				continue
-			}
-			name := strings.TrimSpace(match[1])
-			if name == "" {
-				continue
-			}
-			if _, ok := aliasHits[user]; !ok {
-				aliasHits[user] = map[string]int{}
-			}
-			aliasHits[user][name]++
-		}
+// chatUnreactFlags are the flags of `chat unreact`.
+type chatUnreactFlags struct {
+	message    *string
+	emojiFlag  *string
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatUnreactFlags declares the flags of `chat unreact` on fs.
+func newChatUnreactFlags(fs *flag.FlagSet) *chatUnreactFlags {
+	var f chatUnreactFlags
+	f.message = fs.String("message", "", "message name (spaces/.../messages/...)")
+	f.emojiFlag = fs.String("emoji", "", "Unicode emoji or shortcode such as :eyes:")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatUnreact(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat unreact", flag.ContinueOnError)
+	flags := newChatUnreactFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	name, err := messageNameFlag("message", *flags.message)
+	if err != nil {
+		return err
+	}
+	emoji, err := parseEmoji(*flags.emojiFlag)
+	if err != nil {
+		return err
 	}
 
-	type inferred struct {
-		User     string `json:"user"`
-		Name     string `json:"name"`
-		Hits     int    `json:"hits"`
-		Existing string `json:"existing,omitempty"`
-		Applied  bool   `json:"applied"`
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
+	if err != nil {
+		return err
 	}
-	inferredList := make([]inferred, 0, len(aliasHits))
-	for user, names := range aliasHits {
-		bestName := ""
-		bestHits := 0
-		for n, c := range names {
-			if c > bestHits {
-				bestHits = c
-				bestName = n
-			}
-		}
-		if bestName == "" {
-			continue
-		}
-		cur := strings.TrimSpace(existing[user])
-		applied := false
-		if *apply {
-			if cur == "" || *force {
-				existing[user] = bestName
-				applied = true
-			}
+	defer sess.release()
+	client := sess.client
+
+	// Only your own reaction can be removed, so find it by user as well as
+	// by emoji.
+	me, _ := client.CurrentUser(ctx)
+	if strings.TrimSpace(me) == "" {
+		spaces, err := client.ListSpaces(ctx, gchat.ListSpacesOptions{})
+		if err != nil {
+			return err
 		}
-		inferredList = append(inferredList, inferred{
-			User:     user,
-			Name:     bestName,
-			Hits:     bestHits,
-			Existing: cur,
-			Applied:  applied,
-		})
+		me = client.InferCurrentUser(ctx, spaces)
 	}
-	sort.Slice(inferredList, func(i, j int) bool {
-		if inferredList[i].Hits == inferredList[j].Hits {
-			return inferredList[i].User < inferredList[j].User
-		}
-		return inferredList[i].Hits > inferredList[j].Hits
+	if strings.TrimSpace(me) == "" {
+		return errors.New("could not determine the signed-in user to find their reaction")
+	}
+	reactions, err := client.ListReactions(ctx, name, gchat.ListReactionsOptions{
+		Filter: gchat.ReactionsWithEmoji(emoji) + " AND " + gchat.ReactionsByUser(me),
 	})
-
-	if *apply {
-		if err := saveAliases(existing); err != nil {
+	if err != nil {
+		return err
+	}
+	for _, r := range reactions {
+		if err := client.DeleteReaction(ctx, r.Name); err != nil {
 			return err
 		}
 	}
-	if err := saveRefreshedTokenIfChanged(st, tokenSource); err != nil {
+	if err := sess.close(); err != nil {
 		return err
 	}
 
-	if *jsonOut {
-		return printJSON(map[string]any{"count": len(inferredList),
-			"inferred":  inferredList,
-			"applied":   *apply,
-			"overwrote": *force,
+	if *flags.jsonOut {
+		return sess.printJSON(map[string]any{"space": gchat.SpaceOf(name),
+			"message": name,
+			"emoji":   emoji,
+			"removed": len(reactions),
 		})
 	}
-	if len(inferredList) == 0 {
-		fmt.Println("No aliases inferred from recent messages")
+	if len(reactions) == 0 {
+		fmt.Printf("No %s reaction of yours on %s\n", emoji, name)
 		return nil
 	}
-	fmt.Printf("Inferred aliases (%d):\n", len(inferredList))
-	for _, it := range inferredList {
-		status := "suggested"
-		if it.Applied {
-			status = "applied"
-		}
-		if it.Existing != "" && !it.Applied && it.Existing != it.Name {
-			status = "kept-existing"
-		}
-		fmt.Printf("- %s => %s  (hits=%d, %s)\n", it.User, it.Name, it.Hits, status)
-	}
+	fmt.Printf("Removed %s from %s\n", emoji, name)
 	return nil
 }
 
-func runChatMessagesList(args []string) error {
-	fs := flag.NewFlagSet("chat list", flag.ContinueOnError)
-	space := fs.String("space", "", "space resource name or ID")
-	limit := fs.Int("limit", 50, "max messages to return")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	person := fs.String("person", "", "filter by sender (display name, user ID, or users/...)")
-	if err := fs.Parse(args); err != nil {
+// chatReactionsFlags are the flags of `chat reactions`.
+type chatReactionsFlags struct {
+	message    *string
+	limit      *int
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatReactionsFlags declares the flags of `chat reactions` on fs.
+func newChatReactionsFlags(fs *flag.FlagSet) *chatReactionsFlags {
+	var f chatReactionsFlags
+	f.message = fs.String("message", "", "message name (spaces/.../messages/...)")
+	f.limit = fs.Int("limit", 200, "max reactions to return")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatReactions(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat reactions", flag.ContinueOnError)
+	flags := newChatReactionsFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
-	if *limit <= 0 {
-		return errors.New("--limit must be greater than 0")
+	if *flags.limit <= 0 {
+		return usageError("--limit must be greater than 0")
 	}
-	if strings.TrimSpace(*space) == "" {
-		return errors.New("--space is required (example: --space spaces/AAA...); for person chat use: gchatctl chat with --name \"Simon\"")
+	name, err := messageNameFlag("message", *flags.message)
+	if err != nil {
+		return err
 	}
-	spaceName := normalizeSpaceName(*space)
 
-	ctx := context.Background()
-	cfg, st, err := loadAuthContext()
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
 	if err != nil {
 		return err
 	}
+	defer sess.release()
+	client := sess.client
 
-	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
-	tokenSource := oauthCfg.TokenSource(ctx, &st.Token)
-	client := newOAuthClient(ctx, tokenSource)
-
-	items, err := listMessages(ctx, client, spaceName, *limit)
+	reactions, err := client.ListReactions(ctx, name, gchat.ListReactionsOptions{Limit: *flags.limit})
 	if err != nil {
 		return err
 	}
+	spaceName := gchat.SpaceOf(name)
+	fillReactionUserNames(ctx, client, spaceName, reactions)
+	if err := sess.close(); err != nil {
+		return err
+	}
+
+	if *flags.jsonOut {
+		return sess.printJSON(map[string]any{"space": spaceName,
+			"message":   name,
+			"count":     len(reactions),
+			"reactions": reactions,
+		})
+	}
+	if len(reactions) == 0 {
+		fmt.Printf("No reactions on %s\n", name)
+		return nil
+	}
+	fmt.Printf("Reactions on %s (%d):\n", name, len(reactions))
+	order := []string{}
+	byEmoji := map[string][]string{}
+	for _, r := range reactions {
+		e := r.Emoji.String()
+		if _, ok := byEmoji[e]; !ok {
+			order = append(order, e)
+		}
+		byEmoji[e] = append(byEmoji[e], firstNonEmpty(strings.TrimSpace(r.User.DisplayName), strings.TrimSpace(r.User.Name), "unknown-user"))
+	}
+	for _, e := range order {
+		fmt.Printf("- %s %d  %s\n", e, len(byEmoji[e]), strings.Join(byEmoji[e], ", "))
+	}
+	return nil
+}
+
+// fillReactionUserNames sets missing user display names from the space
+// members and aliases, like fillSenderNames.
+func fillReactionUserNames(ctx context.Context, client *gchat.Client, spaceName string, reactions []gchat.Reaction) {
 	aliases, _ := loadAliases()
-	senderNames, nameErr := listSpaceSenderNames(ctx, client, spaceName)
-	if nameErr != nil {
-		// Keep message listing functional even if sender-name enrichment fails.
-		senderNames = map[string]string{}
+	names, err := client.MemberDisplayNames(ctx, spaceName)
+	if err != nil {
+		names = map[string]string{}
 	}
-	for i := range items {
-		if strings.TrimSpace(items[i].Sender.DisplayName) == "" {
-			if v := strings.TrimSpace(senderNames[items[i].Sender.Name]); v != "" {
-				items[i].Sender.DisplayName = v
-				continue
-			}
-			if v := strings.TrimSpace(aliases[normalizeUserRef(items[i].Sender.Name)]); v != "" {
-				items[i].Sender.DisplayName = v
+	for i := range reactions {
+		u := &reactions[i].User
+		if strings.TrimSpace(u.DisplayName) == "" {
+			u.DisplayName = firstNonEmpty(names[u.Name], aliases[gchat.NormalizeUserRef(u.Name)])
+		}
+	}
+}
+
+// emojiShortcodes are the shortcodes --emoji accepts besides Unicode emoji.
+var emojiShortcodes = map[string]string{
+	"eyes":             "👀",
+	"white_check_mark": "✅",
+	"heavy_check_mark": "✔️",
+	"x":                "❌",
+	"+1":               "👍",
+	"thumbsup":         "👍",
+	"-1":               "👎",
+	"thumbsdown":       "👎",
+	"heart":            "❤️",
+	"tada":             "🎉",
+	"rocket":           "🚀",
+	"pray":             "🙏",
+	"warning":          "⚠️",
+	"hourglass":        "⌛",
+}
+
+// parseEmoji returns the Unicode emoji for an --emoji value: the emoji
+// itself or one of emojiShortcodes, with or without colons.
+func parseEmoji(raw string) (string, error) {
+	v := strings.TrimSpace(raw)
+	if v == "" {
+		return "", usageError("--emoji is required")
+	}
+	if e, ok := emojiShortcodes[strings.ToLower(strings.Trim(v, ":"))]; ok {
+		return e, nil
+	}
+	for _, r := range v {
+		if r < utf8.RuneSelf || unicode.IsSpace(r) {
+			codes := make([]string, 0, len(emojiShortcodes))
+			for code := range emojiShortcodes {
+				codes = append(codes, ":"+code+":")
 			}
+			sort.Strings(codes)
+			return "", usageErrorf("--emoji must be a Unicode emoji or one of %s, got %q", strings.Join(codes, " "), v)
 		}
 	}
-	if strings.TrimSpace(*person) != "" {
-		items = filterMessagesByPerson(items, *person)
+	return v, nil
+}
+
+// chatAttachmentsDownloadFlags are the flags of `chat attachments download`.
+type chatAttachmentsDownloadFlags struct {
+	message    *string
+	dir        *string
+	force      *bool
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatAttachmentsDownloadFlags declares the flags of `chat attachments download` on fs.
+func newChatAttachmentsDownloadFlags(fs *flag.FlagSet) *chatAttachmentsDownloadFlags {
+	var f chatAttachmentsDownloadFlags
+	f.message = fs.String("message", "", "message name (spaces/.../messages/...)")
+	f.dir = fs.String("dir", ".", "directory to save the files in")
+	f.force = fs.Bool("force", false, "overwrite existing files")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatAttachmentsDownload(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat attachments download", flag.ContinueOnError)
+	flags := newChatAttachmentsDownloadFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
 	}
-	if err := saveRefreshedTokenIfChanged(st, tokenSource); err != nil {
+	name, err := messageNameFlag("message", *flags.message)
+	if err != nil {
 		return err
 	}
+	if strings.TrimSpace(*flags.dir) == "" {
+		return usageError("--dir must not be empty")
+	}
 
-	if *jsonOut {
-		out := map[string]any{"space": spaceName,
-			"count":    len(items),
-			"messages": items,
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
+	if err != nil {
+		return err
+	}
+	defer sess.release()
+	client := sess.client
+
+	m, err := client.GetMessage(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*flags.dir, 0o755); err != nil {
+		return err
+	}
+	files := []DownloadedFile{}
+	var skipped []SkippedAttachment
+	used := map[string]bool{}
+	for i, a := range m.Attachment {
+		label := firstNonEmpty(a.ContentName, a.Name)
+		if a.AttachmentDataRef == nil || a.AttachmentDataRef.ResourceName == "" {
+			reason := "no downloadable content"
+			if a.DriveDataRef != nil {
+				reason = "Google Drive file " + a.DriveDataRef.DriveFileID
+			}
+			skipped = append(skipped, SkippedAttachment{Name: label, Reason: reason})
+			continue
 		}
-		return printJSON(out)
+		base := attachmentFileName(a.ContentName, i)
+		for n := 2; used[base]; n++ {
+			ext := filepath.Ext(base)
+			base = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(attachmentFileName(a.ContentName, i), ext), n, ext)
+		}
+		used[base] = true
+		path := filepath.Join(*flags.dir, base)
+		n, err := downloadAttachment(ctx, client, a.AttachmentDataRef.ResourceName, path, *flags.force)
+		if err != nil {
+			return err
+		}
+		files = append(files, DownloadedFile{Name: label, ContentType: a.ContentType, Path: path, Bytes: n})
+	}
+	if err := sess.close(); err != nil {
+		return err
 	}
 
-	if len(items) == 0 {
-		fmt.Printf("No messages found in %q\n", spaceName)
+	if *flags.jsonOut {
+		out := map[string]any{"message": name,
+			"dir":   *flags.dir,
+			"count": len(files),
+			"files": files,
+		}
+		if len(skipped) > 0 {
+			out["skipped"] = skipped
+		}
+		return sess.printJSON(out)
+	}
+	if len(files) == 0 && len(skipped) == 0 {
+		fmt.Printf("No attachments on %s\n", name)
 		return nil
 	}
-	fmt.Printf("Messages (%d) in %q:\n", len(items), spaceName)
-	for _, m := range items {
-		when := firstNonEmpty(strings.TrimSpace(m.CreateTime), "unknown-time")
-		sender := firstNonEmpty(strings.TrimSpace(m.Sender.DisplayName), strings.TrimSpace(m.Sender.Name), "unknown-sender")
-		text := compactMessageText(m.Text)
-		fmt.Printf("- %s  %s: %s\n", when, sender, text)
+	for _, f := range files {
+		fmt.Printf("Saved %s (%d bytes)\n", f.Path, f.Bytes)
+	}
+	for _, sk := range skipped {
+		fmt.Printf("Skipped %s: %s\n", sk.Name, sk.Reason)
 	}
 	return nil
 }
 
-func runChatMessagesSend(args []string) error {
-	fs := flag.NewFlagSet("chat send", flag.ContinueOnError)
-	space := fs.String("space", "", "space resource name or ID")
-	email := fs.String("email", "", "recipient email (maps to users/<email>)")
-	user := fs.String("user", "", "recipient user resource (users/...)")
-	text := fs.String("text", "", "message text to send")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
-		return err
+// downloadAttachment saves an uploaded attachment to path, through a
+// temporary file so a failed download leaves nothing behind.
+func downloadAttachment(ctx context.Context, client *gchat.Client, resource, path string, force bool) (int64, error) {
+	if !force {
+		if _, err := os.Stat(path); err == nil {
+			return 0, usageErrorf("%s already exists; pass --force to overwrite", path)
+		}
 	}
-
-	spaceProvided := strings.TrimSpace(*space) != ""
-	recipientProvided := strings.TrimSpace(*email) != "" || strings.TrimSpace(*user) != ""
-	if !spaceProvided && !recipientProvided {
-		return errors.New("destination required: provide --space or --email/--user")
+	f, err := os.CreateTemp(filepath.Dir(path), ".download-*")
+	if err != nil {
+		return 0, err
 	}
-	if spaceProvided && recipientProvided {
-		return errors.New("use either --space or --email/--user, not both")
+	defer os.Remove(f.Name())
+	n, err := client.DownloadAttachment(ctx, resource, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
 	}
-	if strings.TrimSpace(*email) != "" && strings.TrimSpace(*user) != "" {
-		return errors.New("use either --email or --user, not both")
+	if err != nil {
+		return 0, err
 	}
-	msgText := strings.TrimSpace(*text)
-	if msgText == "" {
-		return errors.New("--text is required")
+	return n, os.Rename(f.Name(), path)
+}
+
+// attachmentFileName is a safe local name for the i-th attachment: its
+// content name without any directory part.
+func attachmentFileName(contentName string, i int) string {
+	base := filepath.Base(strings.ReplaceAll(strings.TrimSpace(contentName), "\\", "/"))
+	switch base {
+	case "", ".", "..", "/":
+		return fmt.Sprintf("attachment-%d", i+1)
 	}
+	return base
+}
 
-	ctx := context.Background()
-	cfg, st, err := loadAuthContext()
-	if err != nil {
-		return err
+// attachmentSource describes where an attachment lives, for text output.
+func attachmentSource(a gchat.Attachment) string {
+	if a.Source == gchat.AttachmentDrive || a.DriveDataRef != nil {
+		return "Google Drive"
 	}
-	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
-	tokenSource := oauthCfg.TokenSource(ctx, &st.Token)
-	client := newOAuthClient(ctx, tokenSource)
+	return "uploaded"
+}
 
-	spaceName := ""
-	if spaceProvided {
-		spaceName = normalizeSpaceName(*space)
-	} else {
-		targetUser := normalizeUserRef(firstNonEmpty(*user, *email))
-		dm, derr := findDirectMessageSpace(ctx, client, targetUser)
-		if derr != nil {
-			return derr
+// openAttachmentFile opens a --file to attach and returns it with its size,
+// checking it against the Chat attachment limits before anything is read.
+func openAttachmentFile(path string) (*os.File, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, usageErrorf("--file: %v", err)
+	}
+	fi, err := f.Stat()
+	switch {
+	case err != nil:
+		err = usageErrorf("--file: %v", err)
+	case !fi.Mode().IsRegular():
+		err = usageErrorf("--file %s is not a regular file", path)
+	default:
+		if cerr := gchat.CheckAttachment(filepath.Base(path), fi.Size()); cerr != nil {
+			err = usageErrorf("--file %v", cerr)
 		}
-		spaceName = dm.Name
 	}
-
-	sent, err := sendChatMessage(ctx, client, spaceName, msgText)
 	if err != nil {
-		return err
+		f.Close()
+		return nil, 0, err
 	}
-	if err := saveRefreshedTokenIfChanged(st, tokenSource); err != nil {
-		return err
+	return f, fi.Size(), nil
+}
+
+// messageCards returns the cardsV2 of chat send: read from --card-file, or
+// built from the --card flags. It returns nil when neither is given.
+func messageCards(cardFile string, card bool, title, subtitle string, fields, buttons []string) ([]gchat.CardWithID, error) {
+	builder := title != "" || subtitle != "" || len(fields) > 0 || len(buttons) > 0
+	switch {
+	case cardFile != "" && (card || builder):
+		return nil, usageError("use either --card-file or --card, not both")
+	case builder && !card:
+		return nil, usageError("--title, --subtitle, --kv and --button build a card; add --card")
+	case cardFile != "":
+		var b []byte
+		var err error
+		if cardFile == "-" {
+			b, err = io.ReadAll(os.Stdin)
+		} else {
+			b, err = os.ReadFile(cardFile)
+		}
+		if err != nil {
+			return nil, usageErrorf("--card-file: %v", err)
+		}
+		cards, err := gchat.ParseCardsV2(b)
+		if err != nil {
+			return nil, usageErrorf("--card-file %s: %v", cardFile, err)
+		}
+		return cards, nil
+	case !card:
+		return nil, nil
+	}
+	if strings.TrimSpace(title) == "" {
+		return nil, usageError("--card needs --title")
+	}
+	simple := gchat.SimpleCard{Title: strings.TrimSpace(title), Subtitle: strings.TrimSpace(subtitle)}
+	for _, kv := range fields {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, usageErrorf("--kv must be key=value, got %q", kv)
+		}
+		simple.Fields = append(simple.Fields, gchat.CardField{Key: strings.TrimSpace(k), Value: strings.TrimSpace(v)})
+	}
+	for _, b := range buttons {
+		label, link, ok := strings.Cut(b, "=")
+		if !ok || strings.TrimSpace(label) == "" {
+			return nil, usageErrorf("--button must be label=url, got %q", b)
+		}
+		simple.Buttons = append(simple.Buttons, gchat.CardButton{Label: strings.TrimSpace(label), URL: strings.TrimSpace(link)})
+	}
+	cards, err := simple.CardsV2("card-1")
+	if err != nil {
+		return nil, usageErrorf("--card: %v", err)
 	}
+	return cards, nil
+}
 
-	if *jsonOut {
-		out := map[string]any{"space": spaceName,
-			"message": sent,
+// printSendDryRun shows what chat send --dry-run would have sent.
+func printSendDryRun(destination string, req gchat.SendMessageRequest, file string, size int64, jsonOut bool) error {
+	if jsonOut {
+		out := map[string]any{"dry_run": true,
+			"destination": destination,
+			"request":     req,
+		}
+		if file != "" {
+			out["file"] = file
 		}
 		return printJSON(out)
 	}
-	fmt.Printf("Sent message to %s\n", spaceName)
-	if strings.TrimSpace(sent.Name) != "" {
-		fmt.Printf("Message ID: %s\n", sent.Name)
+	fmt.Printf("Dry run: nothing sent to %s\n", destination)
+	if file != "" {
+		fmt.Printf("Would upload %s (%d bytes)\n", file, size)
 	}
-	return nil
+	// Card text often holds HTML formatting; keep it readable.
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(req)
+}
+
+// messageNameFlag checks that the value of --flag is a message name.
+func messageNameFlag(flag, value string) (string, error) {
+	name := strings.TrimSpace(value)
+	switch {
+	case name == "":
+		return "", usageErrorf("--%s is required", flag)
+	case gchat.SpaceOf(name) == "" || !strings.Contains(name, "/messages/"):
+		return "", usageErrorf("--%s must be a message name (spaces/.../messages/...), got %q", flag, name)
+	}
+	return name, nil
+}
+
+// chatMessagesWithFlags are the flags of `chat with`.
+type chatMessagesWithFlags struct {
+	email      *string
+	user       *string
+	name       *string
+	limit      *int
+	scanLimit  *int
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatMessagesWithFlags declares the flags of `chat with` on fs.
+func newChatMessagesWithFlags(fs *flag.FlagSet) *chatMessagesWithFlags {
+	var f chatMessagesWithFlags
+	f.email = fs.String("email", "", "user email (maps to users/<email>)")
+	f.user = fs.String("user", "", "user resource name (users/...)")
+	f.name = fs.String("name", "", "peer display name in DM spaces (example: Simon)")
+	f.limit = fs.Int("limit", 10, "max messages to return")
+	f.scanLimit = fs.Int("scan-limit", 200, "max DM spaces scanned when --name is used")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
 }
 
-func runChatMessagesWith(args []string) error {
+func runChatMessagesWith(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("chat with", flag.ContinueOnError)
-	email := fs.String("email", "", "user email (maps to users/<email>)")
-	user := fs.String("user", "", "user resource name (users/...)")
-	name := fs.String("name", "", "peer display name in DM spaces (example: Simon)")
-	limit := fs.Int("limit", 10, "max messages to return")
-	scanLimit := fs.Int("scan-limit", 200, "max DM spaces scanned when --name is used")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
+	flags := newChatMessagesWithFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
-	if *limit <= 0 {
-		return errors.New("--limit must be greater than 0")
+	if *flags.limit <= 0 {
+		return usageError("--limit must be greater than 0")
 	}
-	if *scanLimit <= 0 {
-		return errors.New("--scan-limit must be greater than 0")
+	if *flags.scanLimit <= 0 {
+		return usageError("--scan-limit must be greater than 0")
 	}
 	identityCount := 0
-	if strings.TrimSpace(*email) != "" {
+	if strings.TrimSpace(*flags.email) != "" {
 		identityCount++
 	}
-	if strings.TrimSpace(*user) != "" {
+	if strings.TrimSpace(*flags.user) != "" {
 		identityCount++
 	}
-	if strings.TrimSpace(*name) != "" {
+	if strings.TrimSpace(*flags.name) != "" {
 		identityCount++
 	}
 	if identityCount == 0 {
-		return errors.New("one of --email or --user or --name is required")
+		return usageError("one of --email or --user or --name is required")
 	}
 	if identityCount > 1 {
-		return errors.New("use exactly one of --email, --user, or --name")
+		return usageError("use exactly one of --email, --user, or --name")
 	}
 
-	ctx := context.Background()
-	cfg, st, err := loadAuthContext()
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
 	if err != nil {
 		return err
 	}
-	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
-	tokenSource := oauthCfg.TokenSource(ctx, &st.Token)
-	client := newOAuthClient(ctx, tokenSource)
+	defer sess.release()
+	client := sess.client
 
 	targetUser := ""
 	targetSpace := ""
 	resolvedDisplay := ""
-	if strings.TrimSpace(*name) != "" {
-		targetUser, targetSpace, resolvedDisplay, err = resolveDMByName(ctx, client, *name, *scanLimit)
+	if strings.TrimSpace(*flags.name) != "" {
+		targetUser, targetSpace, resolvedDisplay, err = resolveDMByName(ctx, client, *flags.name, *flags.scanLimit)
 		if err != nil {
 			return err
 		}
 	} else {
-		targetUser = normalizeUserRef(firstNonEmpty(*user, *email))
-		space, ferr := findDirectMessageSpace(ctx, client, targetUser)
+		targetUser = gchat.NormalizeUserRef(firstNonEmpty(*flags.user, *flags.email))
+		space, ferr := client.FindDirectMessage(ctx, targetUser)
 		if ferr != nil {
 			return ferr
 		}
 		targetSpace = space.Name
 	}
-	items, err := listMessages(ctx, client, targetSpace, *limit)
+	items, err := client.ListMessages(ctx, targetSpace, gchat.ListMessagesOptions{Limit: *flags.limit, OrderBy: gchat.OrderCreateTimeDesc})
 	if err != nil {
 		return err
 	}
-	aliases, _ := loadAliases()
-	senderNames, _ := listSpaceSenderNames(ctx, client, targetSpace)
-	for i := range items {
-		if strings.TrimSpace(items[i].Sender.DisplayName) == "" {
-			if v := strings.TrimSpace(senderNames[items[i].Sender.Name]); v != "" {
-				items[i].Sender.DisplayName = v
-				continue
-			}
-			if v := strings.TrimSpace(aliases[normalizeUserRef(items[i].Sender.Name)]); v != "" {
-				items[i].Sender.DisplayName = v
-			}
-		}
-	}
-	if err := saveRefreshedTokenIfChanged(st, tokenSource); err != nil {
+	fillSenderNames(ctx, client, targetSpace, items)
+	if err := sess.close(); err != nil {
 		return err
 	}
 
-	if *jsonOut {
+	if *flags.jsonOut {
 		out := map[string]any{"target": targetUser,
 			"space":           targetSpace,
 			"count":           len(items),
 			"messages":        items,
 			"resolved_target": resolvedDisplay,
 		}
-		return printJSON(out)
+		return sess.printJSON(out)
 	}
 	if len(items) == 0 {
 		fmt.Printf("No messages found with %s (%s)\n", firstNonEmpty(resolvedDisplay, targetUser), targetSpace)
 		return nil
 	}
 	fmt.Printf("Messages (%d) with %s in %s:\n", len(items), firstNonEmpty(resolvedDisplay, targetUser), targetSpace)
+	loc := displayLocation()
 	for _, m := range items {
-		when := firstNonEmpty(strings.TrimSpace(m.CreateTime), "unknown-time")
-		sender := firstNonEmpty(strings.TrimSpace(m.Sender.DisplayName), strings.TrimSpace(m.Sender.Name), "unknown-sender")
-		text := compactMessageText(m.Text)
-		fmt.Printf("- %s  %s: %s\n", when, sender, text)
+		printMessageLine(m, loc)
 	}
 	return nil
 }
 
-func runChatMessagesRecent(args []string) error {
+// chatMessagesRecentFlags are the flags of `chat recent`.
+type chatMessagesRecentFlags struct {
+	email      *string
+	user       *string
+	name       *string
+	limit      *int
+	scanLimit  *int
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatMessagesRecentFlags declares the flags of `chat recent` on fs.
+func newChatMessagesRecentFlags(fs *flag.FlagSet) *chatMessagesRecentFlags {
+	var f chatMessagesRecentFlags
+	f.email = fs.String("email", "", "user email (maps to users/<email>)")
+	f.user = fs.String("user", "", "user resource name (users/...)")
+	f.name = fs.String("name", "", "peer display name in DM spaces (example: Simon)")
+	f.limit = fs.Int("limit", 10, "max messages to return")
+	f.scanLimit = fs.Int("scan-limit", 200, "max DM spaces scanned when --name is used")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatMessagesRecent(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("chat recent", flag.ContinueOnError)
-	email := fs.String("email", "", "user email (maps to users/<email>)")
-	user := fs.String("user", "", "user resource name (users/...)")
-	name := fs.String("name", "", "peer display name in DM spaces (example: Simon)")
-	limit := fs.Int("limit", 10, "max messages to return")
-	scanLimit := fs.Int("scan-limit", 200, "max DM spaces scanned when --name is used")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
+	flags := newChatMessagesRecentFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
-	if *limit <= 0 {
-		return errors.New("--limit must be greater than 0")
+	if *flags.limit <= 0 {
+		return usageError("--limit must be greater than 0")
 	}
-	if *scanLimit <= 0 {
-		return errors.New("--scan-limit must be greater than 0")
+	if *flags.scanLimit <= 0 {
+		return usageError("--scan-limit must be greater than 0")
 	}
 
 	identityCount := 0
-	if strings.TrimSpace(*email) != "" {
+	if strings.TrimSpace(*flags.email) != "" {
 		identityCount++
 	}
-	if strings.TrimSpace(*user) != "" {
+	if strings.TrimSpace(*flags.user) != "" {
 		identityCount++
 	}
-	if strings.TrimSpace(*name) != "" {
+	if strings.TrimSpace(*flags.name) != "" {
 		identityCount++
 	}
 	if identityCount == 0 {
-		return errors.New("one of --email or --user or --name is required")
+		return usageError("one of --email or --user or --name is required")
 	}
 	if identityCount > 1 {
-		return errors.New("use exactly one of --email, --user, or --name")
+		return usageError("use exactly one of --email, --user, or --name")
 	}
 
-	ctx := context.Background()
-	cfg, st, err := loadAuthContext()
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
 	if err != nil {
 		return err
 	}
-	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
-	tokenSource := oauthCfg.TokenSource(ctx, &st.Token)
-	client := newOAuthClient(ctx, tokenSource)
+	defer sess.release()
+	client := sess.client
 
 	targetUser := ""
 	targetSpace := ""
 	resolvedDisplay := ""
-	if strings.TrimSpace(*name) != "" {
-		targetUser, targetSpace, resolvedDisplay, err = resolveDMByName(ctx, client, *name, *scanLimit)
+	if strings.TrimSpace(*flags.name) != "" {
+		targetUser, targetSpace, resolvedDisplay, err = resolveDMByName(ctx, client, *flags.name, *flags.scanLimit)
 		if err != nil {
 			return err
 		}
 	} else {
-		targetUser = normalizeUserRef(firstNonEmpty(*user, *email))
-		space, ferr := findDirectMessageSpace(ctx, client, targetUser)
+		targetUser = gchat.NormalizeUserRef(firstNonEmpty(*flags.user, *flags.email))
+		space, ferr := client.FindDirectMessage(ctx, targetUser)
 		if ferr != nil {
 			return ferr
 		}
 		targetSpace = space.Name
 	}
 
-	fetchLimit := *limit * 12
+	fetchLimit := *flags.limit * 12
 	if fetchLimit < 50 {
 		fetchLimit = 50
 	}
 	if fetchLimit > 500 {
 		fetchLimit = 500
 	}
-	items, err := listMessages(ctx, client, targetSpace, fetchLimit)
+	items, err := client.ListMessages(ctx, targetSpace, gchat.ListMessagesOptions{Limit: fetchLimit, OrderBy: gchat.OrderCreateTimeDesc})
 	if err != nil {
 		return err
 	}
-	aliases, _ := loadAliases()
-	senderNames, _ := listSpaceSenderNames(ctx, client, targetSpace)
-	for i := range items {
-		if strings.TrimSpace(items[i].Sender.DisplayName) == "" {
-			if v := strings.TrimSpace(senderNames[items[i].Sender.Name]); v != "" {
-				items[i].Sender.DisplayName = v
-				continue
-			}
-			if v := strings.TrimSpace(aliases[normalizeUserRef(items[i].Sender.Name)]); v != "" {
-				items[i].Sender.DisplayName = v
-			}
-		}
-	}
+	fillSenderNames(ctx, client, targetSpace, items)
 
-	fromTarget := make([]ChatMessage, 0, *limit)
-	targetNorm := strings.ToLower(strings.TrimSpace(normalizeUserRef(targetUser)))
+	fromTarget := make([]gchat.ChatMessage, 0, *flags.limit)
+	targetNorm := strings.ToLower(strings.TrimSpace(gchat.NormalizeUserRef(targetUser)))
 	for _, m := range items {
-		senderNorm := strings.ToLower(strings.TrimSpace(normalizeUserRef(m.Sender.Name)))
+		senderNorm := strings.ToLower(strings.TrimSpace(gchat.NormalizeUserRef(m.Sender.Name)))
 		if senderNorm != targetNorm {
 			continue
 		}
 		fromTarget = append(fromTarget, m)
-		if len(fromTarget) >= *limit {
+		if len(fromTarget) >= *flags.limit {
 			break
 		}
 	}
 
-	if err := saveRefreshedTokenIfChanged(st, tokenSource); err != nil {
+	if err := sess.close(); err != nil {
 		return err
 	}
 
-	if *jsonOut {
+	if *flags.jsonOut {
 		out := map[string]any{"target": targetUser,
 			"space":           targetSpace,
 			"count":           len(fromTarget),
 			"messages":        fromTarget,
 			"resolved_target": resolvedDisplay,
 		}
-		return printJSON(out)
+		return sess.printJSON(out)
 	}
 	label := firstNonEmpty(resolvedDisplay, targetUser)
 	if len(fromTarget) == 0 {
@@ -1307,66 +4395,88 @@ func runChatMessagesRecent(args []string) error {
 		return nil
 	}
 	fmt.Printf("Recent messages (%d) from %s in %s:\n", len(fromTarget), label, targetSpace)
+	loc := displayLocation()
 	for _, m := range fromTarget {
-		when := firstNonEmpty(strings.TrimSpace(m.CreateTime), "unknown-time")
-		sender := firstNonEmpty(strings.TrimSpace(m.Sender.DisplayName), strings.TrimSpace(m.Sender.Name), "unknown-sender")
-		text := compactMessageText(m.Text)
-		fmt.Printf("- %s  %s: %s\n", when, sender, text)
+		printMessageLine(m, loc)
 	}
 	return nil
 }
 
-func runChatMessagesIncoming(args []string) error {
-	fs := flag.NewFlagSet("chat incoming", flag.ContinueOnError)
-	space := fs.String("space", "", "optional single space resource name or ID")
-	since := fs.Duration("since", 10*time.Minute, "look back window")
-	limit := fs.Int("limit", 200, "max messages returned")
-	fetchLimit := fs.Int("fetch-limit", 40, "max messages fetched per space before filtering")
-	spaceLimit := fs.Int("space-limit", 50, "max spaces scanned when --space is not provided")
-	includeSelf := fs.Bool("include-self", false, "include messages sent by current user")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
+// chatMessagesIncomingFlags are the flags of `chat inbox`.
+type chatMessagesIncomingFlags struct {
+	space       *string
+	since       *time.Duration
+	limit       *int
+	fetchLimit  *int
+	spaceLimit  *int
+	exclude     *string
+	includeSelf *bool
+	strict      *bool
+	jsonOut     *bool
+	clientOpts  *clientOptions
+}
+
+// newChatMessagesIncomingFlags declares the flags of `chat inbox` on fs.
+func newChatMessagesIncomingFlags(fs *flag.FlagSet) *chatMessagesIncomingFlags {
+	var f chatMessagesIncomingFlags
+	f.space = fs.String("space", "", "optional single space resource name or ID")
+	f.since = fs.Duration("since", 10*time.Minute, "look back window")
+	f.limit = fs.Int("limit", 200, "max messages returned")
+	f.fetchLimit = fs.Int("fetch-limit", 40, "max messages fetched per space before filtering")
+	f.spaceLimit = fs.Int("space-limit", 50, "max spaces scanned when --space is not provided")
+	f.exclude = fs.String("exclude-spaces", "", "comma-separated spaces to skip when scanning")
+	f.includeSelf = fs.Bool("include-self", false, "include messages sent by current user")
+	f.strict = fs.Bool("strict", false, strictUsage)
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatMessagesIncoming(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat inbox", flag.ContinueOnError)
+	flags := newChatMessagesIncomingFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
-	if *since <= 0 {
-		return errors.New("--since must be greater than 0")
+	if *flags.since <= 0 {
+		return usageError("--since must be greater than 0")
 	}
-	if *limit <= 0 {
-		return errors.New("--limit must be greater than 0")
+	if *flags.limit <= 0 {
+		return usageError("--limit must be greater than 0")
 	}
-	if *fetchLimit <= 0 {
-		return errors.New("--fetch-limit must be greater than 0")
+	if *flags.fetchLimit <= 0 {
+		return usageError("--fetch-limit must be greater than 0")
 	}
-	if *spaceLimit <= 0 {
-		return errors.New("--space-limit must be greater than 0")
+	if *flags.spaceLimit <= 0 {
+		return usageError("--space-limit must be greater than 0")
 	}
-	broadScan := strings.TrimSpace(*space) == "" && *since > 24*time.Hour && *spaceLimit > 30
+	broadScan := strings.TrimSpace(*flags.space) == "" && *flags.since > 24*time.Hour && *flags.spaceLimit > 30
 	warningText := ""
 	if broadScan {
 		warningText = fmt.Sprintf(
 			"broad scan requested (--since=%s across up to %d spaces); this may be slow. Consider --space, lower --since, or smaller --space-limit/--fetch-limit",
-			since.String(), *spaceLimit,
+			flags.since.String(), *flags.spaceLimit,
 		)
 	}
 
-	ctx := context.Background()
-	cfg, st, err := loadAuthContext()
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
 	if err != nil {
 		return err
 	}
-	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
-	tokenSource := oauthCfg.TokenSource(ctx, &st.Token)
-	client := newOAuthClient(ctx, tokenSource)
+	defer sess.release()
+	client := sess.client
 	aliases, _ := loadAliases()
 
-	targetSpaces := make([]string, 0, *spaceLimit)
-	spaceCatalog := make([]ChatSpace, 0, *spaceLimit)
-	if strings.TrimSpace(*space) != "" {
-		sn := normalizeSpaceName(*space)
+	cutoff := time.Now().UTC().Add(-*flags.since)
+	exclude := excludedSpaces(*flags.exclude)
+	targetSpaces := make([]string, 0, *flags.spaceLimit)
+	spaceCatalog := make([]gchat.ChatSpace, 0, *flags.spaceLimit)
+	if strings.TrimSpace(*flags.space) != "" {
+		sn := gchat.NormalizeSpaceName(*flags.space)
 		targetSpaces = append(targetSpaces, sn)
-		spaceCatalog = append(spaceCatalog, ChatSpace{Name: sn})
+		spaceCatalog = append(spaceCatalog, gchat.ChatSpace{Name: sn})
 	} else {
-		spaces, lerr := listSpaces(ctx, client, *spaceLimit)
+		spaces, lerr := listActiveSpaces(ctx, client, cutoff, *flags.spaceLimit, exclude)
 		if lerr != nil {
 			return lerr
 		}
@@ -1376,126 +4486,154 @@ func runChatMessagesIncoming(args []string) error {
 		}
 	}
 
-	me, _ := currentUserRef(ctx, client)
-	if strings.TrimSpace(me) == "" && !*includeSelf {
-		me = inferCurrentUserFromDMS(ctx, client, spaceCatalog)
+	me, _ := client.CurrentUser(ctx)
+	if strings.TrimSpace(me) == "" && !*flags.includeSelf {
+		me = client.InferCurrentUser(ctx, spaceCatalog)
 	}
-	meNorm := strings.TrimSpace(normalizeUserRef(me))
-	cutoff := time.Now().UTC().Add(-*since)
+	meNorm := strings.TrimSpace(gchat.NormalizeUserRef(me))
 
-	found := make([]PolledMessage, 0, minInt(*limit, 256))
-	for _, sp := range targetSpaces {
-		msgs, lerr := listMessages(ctx, client, sp, *fetchLimit)
-		if lerr != nil {
-			continue
-		}
-		spaceNames, _ := listSpaceSenderNames(ctx, client, sp)
-		for _, m := range msgs {
-			msgTime, ok := parseMessageTime(m.CreateTime)
+	fetched, errs := fetchRecentMessages(ctx, client, targetSpaces, cutoff, *flags.fetchLimit)
+	failures := scanFailures(targetSpaces, errs)
+	if err := checkScan(*flags.strict, failures); err != nil {
+		return err
+	}
+	found := make([]PolledMessage, 0, minInt(*flags.limit, 256))
+	for i, sp := range targetSpaces {
+		spaceNames := fetched[i].names
+		for _, m := range fetched[i].messages {
+			msgTime, ok := gchat.ParseTime(m.CreateTime)
 			if !ok || msgTime.Before(cutoff) {
 				continue
 			}
-			if !*includeSelf && meNorm != "" && normalizeUserRef(m.Sender.Name) == meNorm {
+			if !*flags.includeSelf && meNorm != "" && gchat.NormalizeUserRef(m.Sender.Name) == meNorm {
 				continue
 			}
 			sender := firstNonEmpty(
 				strings.TrimSpace(m.Sender.DisplayName),
 				strings.TrimSpace(spaceNames[m.Sender.Name]),
-				strings.TrimSpace(aliases[normalizeUserRef(m.Sender.Name)]),
+				strings.TrimSpace(aliases[gchat.NormalizeUserRef(m.Sender.Name)]),
 				strings.TrimSpace(m.Sender.Name),
 			)
 			found = append(found, PolledMessage{
-				Space:      sp,
-				Name:       m.Name,
-				CreateTime: m.CreateTime,
-				Sender:     sender,
-				SenderUser: m.Sender.Name,
-				Text:       compactMessageText(m.Text),
+				Space:       sp,
+				Name:        m.Name,
+				CreateTime:  m.CreateTime,
+				Sender:      sender,
+				SenderUser:  m.Sender.Name,
+				Text:        messageText(m),
+				Thread:      m.ThreadName(),
+				ThreadReply: m.ThreadReply,
+				Reactions:   m.EmojiReactionSummaries,
+				Attachments: m.Attachment,
 			})
 		}
 	}
 
 	sort.Slice(found, func(a, b int) bool {
-		ta, oka := parseMessageTime(found[a].CreateTime)
-		tb, okb := parseMessageTime(found[b].CreateTime)
+		ta, oka := gchat.ParseTime(found[a].CreateTime)
+		tb, okb := gchat.ParseTime(found[b].CreateTime)
 		if !oka || !okb {
 			return found[a].CreateTime > found[b].CreateTime
 		}
 		return ta.After(tb)
 	})
-	if len(found) > *limit {
-		found = found[:*limit]
+	if len(found) > *flags.limit {
+		found = found[:*flags.limit]
 	}
 
-	if err := saveRefreshedTokenIfChanged(st, tokenSource); err != nil {
+	if err := sess.close(); err != nil {
 		return err
 	}
 
-	if *jsonOut {
+	if *flags.jsonOut {
 		out := map[string]any{"count": len(found),
-			"since_window": since.String(),
+			"since_window": flags.since.String(),
 			"cutoff_utc":   cutoff.Format(time.RFC3339Nano),
 			"spaces":       len(targetSpaces),
 			"messages":     found,
 		}
+		addScanFailures(out, failures)
 		if warningText != "" {
 			out["warning"] = warningText
 		}
-		return printJSON(out)
+		return sess.printJSON(out)
 	}
 	if warningText != "" {
 		fmt.Printf("warning: %s\n", warningText)
 	}
 	if len(found) == 0 {
-		fmt.Printf("No incoming messages in the last %s\n", since.String())
+		fmt.Printf("No incoming messages in the last %s\n", flags.since.String())
 		return nil
 	}
-	fmt.Printf("Incoming messages (%d) in the last %s:\n", len(found), since.String())
+	fmt.Printf("Incoming messages (%d) in the last %s:\n", len(found), flags.since.String())
+	loc := displayLocation()
 	for _, m := range found {
-		fmt.Printf("- %s  %s  %s: %s\n", m.CreateTime, m.Space, m.Sender, m.Text)
+		fmt.Printf("- %s  %s  %s%s: %s%s\n", displayTime(m.CreateTime, loc), m.Space, m.Sender, threadLabel(m.ThreadReply, m.Thread), m.Text, reactionsLabel(m.Reactions))
 	}
 	return nil
 }
 
-func runChatMessagesPoll(args []string) error {
+// chatMessagesPollFlags are the flags of `chat poll`.
+type chatMessagesPollFlags struct {
+	space      *string
+	since      *time.Duration
+	interval   *time.Duration
+	iterations *int
+	limit      *int
+	exclude    *string
+	strict     *bool
+	jsonOut    *bool
+	clientOpts *clientOptions
+}
+
+// newChatMessagesPollFlags declares the flags of `chat poll` on fs.
+func newChatMessagesPollFlags(fs *flag.FlagSet) *chatMessagesPollFlags {
+	var f chatMessagesPollFlags
+	f.space = fs.String("space", "", "optional single space resource name or ID")
+	f.since = fs.Duration("since", 5*time.Minute, "look back window for first poll")
+	f.interval = fs.Duration("interval", 30*time.Second, "poll interval between iterations")
+	f.iterations = fs.Int("iterations", 1, "number of poll iterations")
+	f.limit = fs.Int("limit", 100, "max messages fetched per space per iteration")
+	f.exclude = fs.String("exclude-spaces", "", "comma-separated spaces to skip when scanning")
+	f.strict = fs.Bool("strict", false, strictUsage)
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.clientOpts = addClientFlags(fs)
+	return &f
+}
+
+func runChatMessagesPoll(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("chat poll", flag.ContinueOnError)
-	space := fs.String("space", "", "optional single space resource name or ID")
-	since := fs.Duration("since", 5*time.Minute, "look back window for first poll")
-	interval := fs.Duration("interval", 30*time.Second, "poll interval between iterations")
-	iterations := fs.Int("iterations", 1, "number of poll iterations")
-	limit := fs.Int("limit", 100, "max messages fetched per space per iteration")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
+	flags := newChatMessagesPollFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
-	if *since <= 0 {
-		return errors.New("--since must be greater than 0")
+	if *flags.since <= 0 {
+		return usageError("--since must be greater than 0")
 	}
-	if *iterations <= 0 {
-		return errors.New("--iterations must be greater than 0")
+	if *flags.iterations <= 0 {
+		return usageError("--iterations must be greater than 0")
 	}
-	if *interval <= 0 {
-		return errors.New("--interval must be greater than 0")
+	if *flags.interval <= 0 {
+		return usageError("--interval must be greater than 0")
 	}
-	if *limit <= 0 {
-		return errors.New("--limit must be greater than 0")
+	if *flags.limit <= 0 {
+		return usageError("--limit must be greater than 0")
 	}
 
-	ctx := context.Background()
-	cfg, st, err := loadAuthContext()
+	ctx, sess, err := openSession(ctx, flags.clientOpts)
 	if err != nil {
 		return err
 	}
-	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
-	tokenSource := oauthCfg.TokenSource(ctx, &st.Token)
-	client := newOAuthClient(ctx, tokenSource)
+	defer sess.release()
+	client := sess.client
 	aliases, _ := loadAliases()
 
 	targetSpaces := []string{}
-	if strings.TrimSpace(*space) != "" {
-		targetSpaces = append(targetSpaces, normalizeSpaceName(*space))
+	exclude := excludedSpaces(*flags.exclude)
+	if strings.TrimSpace(*flags.space) != "" {
+		targetSpaces = append(targetSpaces, gchat.NormalizeSpaceName(*flags.space))
 	} else {
-		spaces, lerr := listSpaces(ctx, client, 200)
+		spaces, lerr := listActiveSpaces(ctx, client, time.Time{}, 200, exclude)
 		if lerr != nil {
 			return lerr
 		}
@@ -1504,20 +4642,21 @@ func runChatMessagesPoll(args []string) error {
 		}
 	}
 
-	cutoff := time.Now().UTC().Add(-*since)
+	cutoff := time.Now().UTC().Add(-*flags.since)
 	seen := map[string]struct{}{}
-	for i := 0; i < *iterations; i++ {
+	for i := 0; i < *flags.iterations; i++ {
 		iterStart := time.Now().UTC()
 		found := make([]PolledMessage, 0, 16)
 
-		for _, sp := range targetSpaces {
-			msgs, lerr := listMessages(ctx, client, sp, *limit)
-			if lerr != nil {
-				continue
-			}
-			spaceNames, _ := listSpaceSenderNames(ctx, client, sp)
-			for _, m := range msgs {
-				msgTime, ok := parseMessageTime(m.CreateTime)
+		fetched, errs := fetchRecentMessages(ctx, client, targetSpaces, cutoff, *flags.limit)
+		failures := scanFailures(targetSpaces, errs)
+		if err := checkScan(*flags.strict, failures); err != nil {
+			return err
+		}
+		for si, sp := range targetSpaces {
+			spaceNames := fetched[si].names
+			for _, m := range fetched[si].messages {
+				msgTime, ok := gchat.ParseTime(m.CreateTime)
 				if !ok || msgTime.Before(cutoff) {
 					continue
 				}
@@ -1528,71 +4667,93 @@ func runChatMessagesPoll(args []string) error {
 				sender := firstNonEmpty(
 					strings.TrimSpace(m.Sender.DisplayName),
 					strings.TrimSpace(spaceNames[m.Sender.Name]),
-					strings.TrimSpace(aliases[normalizeUserRef(m.Sender.Name)]),
+					strings.TrimSpace(aliases[gchat.NormalizeUserRef(m.Sender.Name)]),
 					strings.TrimSpace(m.Sender.Name),
 				)
 				found = append(found, PolledMessage{
-					Space:      sp,
-					Name:       m.Name,
-					CreateTime: m.CreateTime,
-					Sender:     sender,
-					SenderUser: m.Sender.Name,
-					Text:       compactMessageText(m.Text),
+					Space:       sp,
+					Name:        m.Name,
+					CreateTime:  m.CreateTime,
+					Sender:      sender,
+					SenderUser:  m.Sender.Name,
+					Text:        messageText(m),
+					Thread:      m.ThreadName(),
+					ThreadReply: m.ThreadReply,
+					Reactions:   m.EmojiReactionSummaries,
+					Attachments: m.Attachment,
 				})
 			}
 		}
 
 		sort.Slice(found, func(a, b int) bool {
-			ta, oka := parseMessageTime(found[a].CreateTime)
-			tb, okb := parseMessageTime(found[b].CreateTime)
+			ta, oka := gchat.ParseTime(found[a].CreateTime)
+			tb, okb := gchat.ParseTime(found[b].CreateTime)
 			if !oka || !okb {
 				return found[a].CreateTime < found[b].CreateTime
 			}
 			return ta.Before(tb)
 		})
 
-		if *jsonOut {
+		if *flags.jsonOut {
 			out := map[string]any{"iteration": i + 1,
-				"iterations":   *iterations,
-				"since_window": since.String(),
+				"iterations":   *flags.iterations,
+				"since_window": flags.since.String(),
 				"count":        len(found),
 				"messages":     found,
 			}
-			if err := printJSON(out); err != nil {
+			addScanFailures(out, failures)
+			if err := sess.printJSON(out); err != nil {
 				return err
 			}
 		} else {
 			if len(found) == 0 {
-				fmt.Printf("[poll %d/%d] no new messages\n", i+1, *iterations)
+				fmt.Printf("[poll %d/%d] no new messages\n", i+1, *flags.iterations)
 			} else {
-				fmt.Printf("[poll %d/%d] new messages: %d\n", i+1, *iterations, len(found))
+				fmt.Printf("[poll %d/%d] new messages: %d\n", i+1, *flags.iterations, len(found))
+				loc := displayLocation()
 				for _, m := range found {
-					fmt.Printf("- %s  %s  %s: %s\n", m.CreateTime, m.Space, m.Sender, m.Text)
+					fmt.Printf("- %s  %s  %s%s: %s%s\n", displayTime(m.CreateTime, loc), m.Space, m.Sender, threadLabel(m.ThreadReply, m.Thread), m.Text, reactionsLabel(m.Reactions))
 				}
 			}
 		}
 
 		cutoff = iterStart
-		if i < *iterations-1 {
-			time.Sleep(*interval)
+		if sess.budgetExhausted() || i == *flags.iterations-1 {
+			break
+		}
+		if !sleepContext(ctx, *flags.interval) {
+			break
 		}
 	}
 
-	if err := saveRefreshedTokenIfChanged(st, tokenSource); err != nil {
+	if err := sess.close(); err != nil {
 		return err
 	}
 	return nil
 }
 
-func runAuthSetup(args []string) error {
+// authSetupFlags are the flags of `auth setup`.
+type authSetupFlags struct {
+	openLinks *bool
+	jsonOut   *bool
+}
+
+// newAuthSetupFlags declares the flags of `auth setup` on fs.
+func newAuthSetupFlags(fs *flag.FlagSet) *authSetupFlags {
+	var f authSetupFlags
+	f.openLinks = fs.Bool("open", false, "open setup links in browser")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	return &f
+}
+
+func runAuthSetup(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("auth setup", flag.ContinueOnError)
-	openLinks := fs.Bool("open", false, "open setup links in browser")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
+	flags := newAuthSetupFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
 
-	if *jsonOut {
+	if *flags.jsonOut {
 		out := map[string]any{
 			"chat_api_url":       gcpChatAPIURL,
 			"consent_screen_url": gcpConsentURL,
@@ -1619,7 +4780,7 @@ func runAuthSetup(args []string) error {
 		fmt.Println("   gchatctl auth login --client-id <YOUR_CLIENT_ID> --scopes https://www.googleapis.com/auth/chat.messages,https://www.googleapis.com/auth/chat.spaces.readonly")
 	}
 
-	if !*openLinks {
+	if !*flags.openLinks {
 		return nil
 	}
 	links := []string{gcpChatAPIURL, gcpConsentURL, gcpCredsURL}
@@ -1639,28 +4800,43 @@ func loadAuthContext() (AppConfig, StoredToken, error) {
 	st, err := loadToken()
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return AppConfig{}, StoredToken{}, errors.New("not authenticated; run: gchatctl auth login")
+			return AppConfig{}, StoredToken{}, authRequiredError("not authenticated; run: gchatctl auth login")
 		}
 		return AppConfig{}, StoredToken{}, err
 	}
 	if strings.TrimSpace(cfg.OAuthClient.ClientID) == "" {
-		return AppConfig{}, StoredToken{}, errors.New("missing OAuth client ID in config; run `gchatctl auth login` again")
+		return AppConfig{}, StoredToken{}, authRequiredError("missing OAuth client ID in config; run `gchatctl auth login` again")
 	}
 	return cfg, st, nil
 }
 
 func oauthConfigFrom(cfg AppConfig, scopes []string) *oauth2.Config {
+	ep := resolveEndpoints(cfg)
 	return &oauth2.Config{
 		ClientID:     cfg.OAuthClient.ClientID,
 		ClientSecret: cfg.OAuthClient.ClientSecret,
 		Scopes:       scopes,
 		Endpoint: oauth2.Endpoint{
-			AuthURL:  googleAuthURL,
-			TokenURL: googleTokenURL,
+			AuthURL:  ep.AuthURL,
+			TokenURL: ep.TokenURL,
 		},
 	}
 }
 
+// resolveEndpoints applies endpoint overrides with precedence env > config > default.
+func resolveEndpoints(cfg AppConfig) EndpointConfig {
+	var fromCfg EndpointConfig
+	if cfg.Endpoints != nil {
+		fromCfg = *cfg.Endpoints
+	}
+	return EndpointConfig{
+		APIBaseURL: strings.TrimRight(firstNonEmpty(os.Getenv("GCHATCTL_API_BASE_URL"), fromCfg.APIBaseURL, gchat.DefaultBaseURL), "/"),
+		AuthURL:    firstNonEmpty(os.Getenv("GCHATCTL_AUTH_URL"), fromCfg.AuthURL, googleAuthURL),
+		TokenURL:   firstNonEmpty(os.Getenv("GCHATCTL_TOKEN_URL"), fromCfg.TokenURL, googleTokenURL),
+		DeviceURL:  firstNonEmpty(os.Getenv("GCHATCTL_DEVICE_URL"), fromCfg.DeviceURL, googleDeviceURL),
+	}
+}
+
 func saveRefreshedTokenIfChanged(previous StoredToken, source oauth2.TokenSource) error {
 	current, err := source.Token()
 	if err != nil {
@@ -1677,20 +4853,20 @@ func saveRefreshedTokenIfChanged(previous StoredToken, source oauth2.TokenSource
 	return saveToken(previous)
 }
 
-func normalizeSpaceName(raw string) string {
-	s := strings.TrimSpace(raw)
-	if strings.HasPrefix(s, "spaces/") {
-		return s
+// messageText is the one-line text of a message, naming its attachments.
+func messageText(m gchat.ChatMessage) string {
+	names := make([]string, 0, len(m.Attachment))
+	for _, a := range m.Attachment {
+		names = append(names, firstNonEmpty(a.ContentName, a.Name, "file"))
 	}
-	return "spaces/" + s
-}
-
-func normalizeUserRef(raw string) string {
-	s := strings.TrimSpace(raw)
-	if strings.HasPrefix(s, "users/") {
-		return s
+	if len(names) == 0 {
+		return compactMessageText(m.Text)
 	}
-	return "users/" + s
+	label := "(attachment: " + strings.Join(names, ", ") + ")"
+	if strings.TrimSpace(m.Text) == "" {
+		return label
+	}
+	return compactMessageText(m.Text) + " " + label
 }
 
 func compactMessageText(text string) string {
@@ -1707,469 +4883,12 @@ func compactMessageText(text string) string {
 	return t
 }
 
-func listSpaces(ctx context.Context, client *http.Client, limit int) ([]ChatSpace, error) {
-	items := make([]ChatSpace, 0, minInt(limit, 100))
-	pageToken := ""
-
-	for len(items) < limit {
-		pageSize := minInt(limit-len(items), 100)
-		u, err := url.Parse("https://chat.googleapis.com/v1/spaces")
-		if err != nil {
-			return nil, err
-		}
-		q := u.Query()
-		q.Set("pageSize", fmt.Sprintf("%d", pageSize))
-		if pageToken != "" {
-			q.Set("pageToken", pageToken)
-		}
-		u.RawQuery = q.Encode()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-		if err != nil {
-			return nil, err
-		}
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		var parsed ListSpacesResponse
-		if err := decodeAPIResponse(resp, &parsed); err != nil {
-			return nil, err
-		}
-		items = append(items, parsed.Spaces...)
-		if parsed.NextPageToken == "" || len(parsed.Spaces) == 0 {
-			break
-		}
-		pageToken = parsed.NextPageToken
-	}
-	if len(items) > limit {
-		items = items[:limit]
-	}
-	return items, nil
-}
-
-func listMessages(ctx context.Context, client *http.Client, spaceName string, limit int) ([]ChatMessage, error) {
-	items := make([]ChatMessage, 0, minInt(limit, 100))
-	pageToken := ""
-
-	for len(items) < limit {
-		pageSize := minInt(limit-len(items), 100)
-		base := fmt.Sprintf("https://chat.googleapis.com/v1/%s/messages", spaceName)
-		u, err := url.Parse(base)
-		if err != nil {
-			return nil, err
-		}
-		q := u.Query()
-		q.Set("pageSize", fmt.Sprintf("%d", pageSize))
-		q.Set("orderBy", "createTime desc")
-		if pageToken != "" {
-			q.Set("pageToken", pageToken)
-		}
-		u.RawQuery = q.Encode()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-		if err != nil {
-			return nil, err
-		}
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		var parsed ListMessagesResponse
-		if err := decodeAPIResponse(resp, &parsed); err != nil {
-			return nil, err
-		}
-		items = append(items, parsed.Messages...)
-		if parsed.NextPageToken == "" || len(parsed.Messages) == 0 {
-			break
-		}
-		pageToken = parsed.NextPageToken
-	}
-	if len(items) > limit {
-		items = items[:limit]
-	}
-	return items, nil
-}
-
-func sendChatMessage(ctx context.Context, client *http.Client, spaceName, text string) (ChatMessage, error) {
-	var out ChatMessage
-	body := map[string]string{"text": text}
-	b, err := json.Marshal(body)
-	if err != nil {
-		return out, err
-	}
-	u := fmt.Sprintf("https://chat.googleapis.com/v1/%s/messages", normalizeSpaceName(spaceName))
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(string(b)))
-	if err != nil {
-		return out, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := client.Do(req)
-	if err != nil {
-		return out, err
-	}
-	if err := decodeAPIResponse(resp, &out); err != nil {
-		return out, err
-	}
-	return out, nil
-}
-
-func findDirectMessageSpace(ctx context.Context, client *http.Client, userName string) (ChatSpace, error) {
-	var out ChatSpace
-	u, err := url.Parse("https://chat.googleapis.com/v1/spaces:findDirectMessage")
-	if err != nil {
-		return out, err
-	}
-	q := u.Query()
-	q.Set("name", userName)
-	u.RawQuery = q.Encode()
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-	if err != nil {
-		return out, err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return out, err
-	}
-	if err := decodeAPIResponse(resp, &out); err != nil {
-		return out, err
-	}
-	if strings.TrimSpace(out.Name) == "" {
-		return out, fmt.Errorf("no direct message found for %s", userName)
-	}
-	return out, nil
-}
-
-func getSpaceReadState(ctx context.Context, client *http.Client, spaceName string) (SpaceReadState, error) {
-	var out SpaceReadState
-	spaceID := strings.TrimPrefix(normalizeSpaceName(spaceName), "spaces/")
-	u := fmt.Sprintf("https://chat.googleapis.com/v1/users/me/spaces/%s/spaceReadState", url.PathEscape(spaceID))
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
-	if err != nil {
-		return out, err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return out, err
-	}
-	if err := decodeAPIResponse(resp, &out); err != nil {
-		return out, err
-	}
-	return out, nil
-}
-
-func parseMessageTime(raw string) (time.Time, bool) {
-	raw = strings.TrimSpace(raw)
-	if raw == "" {
-		return time.Time{}, false
-	}
-	t, err := time.Parse(time.RFC3339Nano, raw)
-	if err == nil {
-		return t.UTC(), true
-	}
-	t, err = time.Parse(time.RFC3339, raw)
-	if err == nil {
-		return t.UTC(), true
-	}
-	return time.Time{}, false
-}
-
-func listSpaceSenderNames(ctx context.Context, client *http.Client, spaceName string) (map[string]string, error) {
-	out := map[string]string{}
-	members, err := listSpaceMembers(ctx, client, spaceName)
-	if err != nil {
-		return nil, err
-	}
-	for _, m := range members {
-		id := strings.TrimSpace(m.Member.Name)
-		name := strings.TrimSpace(m.Member.DisplayName)
-		if id == "" || name == "" {
-			continue
-		}
-		out[id] = name
-	}
-	return out, nil
-}
-
-func listSpaceMembers(ctx context.Context, client *http.Client, spaceName string) ([]ChatMembership, error) {
-	out := make([]ChatMembership, 0, 16)
-	pageToken := ""
-
-	for {
-		u, err := url.Parse(fmt.Sprintf("https://chat.googleapis.com/v1/%s/members", spaceName))
-		if err != nil {
-			return nil, err
-		}
-		q := u.Query()
-		q.Set("pageSize", "200")
-		if pageToken != "" {
-			q.Set("pageToken", pageToken)
-		}
-		u.RawQuery = q.Encode()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-		if err != nil {
-			return nil, err
-		}
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		var parsed ListMembershipsResponse
-		if err := decodeAPIResponse(resp, &parsed); err != nil {
-			return nil, err
-		}
-		out = append(out, parsed.Memberships...)
-		if parsed.NextPageToken == "" {
-			break
-		}
-		pageToken = parsed.NextPageToken
-	}
-	return out, nil
-}
-
-func currentUserRef(ctx context.Context, client *http.Client) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://chat.googleapis.com/v1/users/me", nil)
-	if err != nil {
-		return "", err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	var u ChatUserResource
-	if err := decodeAPIResponse(resp, &u); err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(u.Name), nil
-}
-
-func dmPeerForSpace(ctx context.Context, client *http.Client, spaceName, currentUser string) (string, string, error) {
-	members, err := listSpaceMembers(ctx, client, spaceName)
-	if err != nil {
-		return "", "", err
-	}
-	cur := strings.TrimSpace(currentUser)
-	var fallbackUser string
-	var fallbackName string
-	for _, m := range members {
-		if strings.ToUpper(strings.TrimSpace(m.Member.Type)) != "HUMAN" {
-			continue
-		}
-		id := strings.TrimSpace(m.Member.Name)
-		if id == "" {
-			continue
-		}
-		name := strings.TrimSpace(m.Member.DisplayName)
-		if fallbackUser == "" {
-			fallbackUser = id
-			fallbackName = name
-		}
-		if cur != "" && id == cur {
-			continue
-		}
-		return id, name, nil
-	}
-	return fallbackUser, fallbackName, nil
-}
-
-func inferCurrentUserFromDMS(ctx context.Context, client *http.Client, spaces []ChatSpace) string {
-	counts := map[string]int{}
-	for _, s := range spaces {
-		if s.SpaceType != "DIRECT_MESSAGE" {
-			continue
-		}
-		members, err := listSpaceMembers(ctx, client, s.Name)
-		if err != nil {
-			continue
-		}
-		for _, m := range members {
-			if strings.ToUpper(strings.TrimSpace(m.Member.Type)) != "HUMAN" {
-				continue
-			}
-			id := normalizeUserRef(m.Member.Name)
-			if id == "" || id == "users/" {
-				continue
-			}
-			counts[id]++
-		}
-	}
-	bestID := ""
-	bestCount := 0
-	for id, n := range counts {
-		if n > bestCount {
-			bestID = id
-			bestCount = n
-		}
-	}
-	return bestID
-}
-
-type dmNameResolution struct {
-	Space   string
-	User    string
-	Display string
-	Score   int
-}
-
-func resolveDMByName(ctx context.Context, client *http.Client, rawName string, scanLimit int) (string, string, string, error) {
-	query := normalizeLookup(rawName)
-	if query == "" {
-		return "", "", "", errors.New("--name cannot be empty")
-	}
-
-	aliases, _ := loadAliases()
-	aliasMatches := make([]dmNameResolution, 0, 4)
-	for userRef, display := range aliases {
-		user := normalizeUserRef(userRef)
-		score := personMatchScore(query, display, user)
-		if score <= 0 {
-			continue
-		}
-		aliasMatches = append(aliasMatches, dmNameResolution{
-			User:    user,
-			Display: strings.TrimSpace(display),
-			Score:   score,
-		})
-	}
-	if len(aliasMatches) > 0 {
-		sort.Slice(aliasMatches, func(i, j int) bool {
-			if aliasMatches[i].Score != aliasMatches[j].Score {
-				return aliasMatches[i].Score > aliasMatches[j].Score
-			}
-			li := strings.ToLower(firstNonEmpty(aliasMatches[i].Display, aliasMatches[i].User))
-			lj := strings.ToLower(firstNonEmpty(aliasMatches[j].Display, aliasMatches[j].User))
-			return li < lj
-		})
-		if len(aliasMatches) > 1 && aliasMatches[0].Score == aliasMatches[1].Score {
-			choices := make([]string, 0, minInt(3, len(aliasMatches)))
-			for i := 0; i < len(aliasMatches) && i < 3; i++ {
-				label := firstNonEmpty(aliasMatches[i].Display, aliasMatches[i].User)
-				choices = append(choices, fmt.Sprintf("%s (%s)", label, aliasMatches[i].User))
-			}
-			return "", "", "", fmt.Errorf("name %q is ambiguous in aliases; matches: %s; use --email or --user", strings.TrimSpace(rawName), strings.Join(choices, ", "))
-		}
-		space, err := findDirectMessageSpace(ctx, client, aliasMatches[0].User)
-		if err == nil && strings.TrimSpace(space.Name) != "" {
-			return aliasMatches[0].User, space.Name, aliasMatches[0].Display, nil
-		}
-	}
-
-	spaceFetchLimit := scanLimit * 3
-	if spaceFetchLimit < 100 {
-		spaceFetchLimit = 100
-	}
-	spaces, err := listSpaces(ctx, client, spaceFetchLimit)
-	if err != nil {
-		return "", "", "", err
-	}
-
-	me, _ := currentUserRef(ctx, client)
-	if strings.TrimSpace(me) == "" {
-		me = inferCurrentUserFromDMS(ctx, client, spaces)
-	}
-
-	matches := make([]dmNameResolution, 0, 8)
-	dmScanned := 0
-	for _, s := range spaces {
-		if s.SpaceType != "DIRECT_MESSAGE" {
-			continue
-		}
-		dmScanned++
-		if dmScanned > scanLimit {
-			break
-		}
-		peerUser, peerName, derr := dmPeerForSpace(ctx, client, s.Name, me)
-		if derr != nil || strings.TrimSpace(peerUser) == "" {
-			continue
-		}
-		peerUser = normalizeUserRef(peerUser)
-		display := strings.TrimSpace(peerName)
-		if display == "" {
-			display = strings.TrimSpace(aliases[peerUser])
-		}
-		score := personMatchScore(query, display, peerUser)
-		if score <= 0 {
-			continue
-		}
-		matches = append(matches, dmNameResolution{
-			Space:   s.Name,
-			User:    peerUser,
-			Display: display,
-			Score:   score,
-		})
-	}
-
-	if len(matches) == 0 {
-		return "", "", "", fmt.Errorf("no direct-message peer matched name %q in the last %d DM spaces; use --email or --user", strings.TrimSpace(rawName), scanLimit)
-	}
-
-	sort.Slice(matches, func(i, j int) bool {
-		if matches[i].Score != matches[j].Score {
-			return matches[i].Score > matches[j].Score
-		}
-		li := strings.ToLower(firstNonEmpty(matches[i].Display, matches[i].User))
-		lj := strings.ToLower(firstNonEmpty(matches[j].Display, matches[j].User))
-		return li < lj
-	})
-
-	if len(matches) > 1 && matches[0].Score == matches[1].Score {
-		choices := make([]string, 0, minInt(3, len(matches)))
-		for i := 0; i < len(matches) && i < 3; i++ {
-			label := firstNonEmpty(matches[i].Display, matches[i].User)
-			choices = append(choices, fmt.Sprintf("%s (%s)", label, matches[i].User))
-		}
-		return "", "", "", fmt.Errorf("name %q is ambiguous; matches: %s; use --email or --user", strings.TrimSpace(rawName), strings.Join(choices, ", "))
-	}
-
-	best := matches[0]
-	return best.User, best.Space, best.Display, nil
-}
-
-func normalizeLookup(raw string) string {
-	return strings.ToLower(strings.TrimSpace(raw))
-}
-
-func personMatchScore(query, displayName, userRef string) int {
-	q := normalizeLookup(query)
-	if q == "" {
-		return 0
-	}
-	display := normalizeLookup(displayName)
-	user := normalizeLookup(strings.TrimPrefix(normalizeUserRef(userRef), "users/"))
-
-	if display != "" {
-		if display == q {
-			return 300
-		}
-		if strings.HasPrefix(display, q) {
-			return 250
-		}
-		if strings.Contains(display, q) {
-			return 200
-		}
-	}
-	if user != "" {
-		if user == q {
-			return 150
-		}
-		if strings.HasPrefix(user, q) {
-			return 120
-		}
-		if strings.Contains(user, q) {
-			return 100
-		}
-	}
-	return 0
-}
-
-func filterMessagesByPerson(messages []ChatMessage, person string) []ChatMessage {
+func filterMessagesByPerson(messages []gchat.ChatMessage, person string) []gchat.ChatMessage {
 	q := strings.ToLower(strings.TrimSpace(person))
 	if q == "" {
 		return messages
 	}
-	out := make([]ChatMessage, 0, len(messages))
+	out := make([]gchat.ChatMessage, 0, len(messages))
 	for _, m := range messages {
 		name := strings.ToLower(strings.TrimSpace(m.Sender.DisplayName))
 		id := strings.ToLower(strings.TrimSpace(m.Sender.Name))
@@ -2181,33 +4900,6 @@ func filterMessagesByPerson(messages []ChatMessage, person string) []ChatMessage
 	return out
 }
 
-func decodeAPIResponse(resp *http.Response, out any) error {
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		trimmed := strings.TrimSpace(string(body))
-		if trimmed == "" {
-			trimmed = resp.Status
-		}
-		var apiErr GoogleAPIErrorEnvelope
-		if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Error.Message != "" {
-			msg := strings.TrimSpace(apiErr.Error.Message)
-			if strings.Contains(strings.ToLower(msg), "google chat app not found") {
-				return errors.New("google chat app not found in this project; enable Chat API and configure a Chat app in Google Cloud Console (gchatctl auth setup shows links)")
-			}
-			if apiErr.Error.Code == 403 && strings.Contains(strings.ToLower(msg), "insufficient authentication scopes") {
-				return errors.New("insufficient auth scopes; run `gchatctl auth login --all-scopes`")
-			}
-			return fmt.Errorf("google chat api request failed (%s): %s", resp.Status, msg)
-		}
-		return fmt.Errorf("google chat api request failed (%s): %s", resp.Status, trimmed)
-	}
-	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
-		return err
-	}
-	return nil
-}
-
 func minInt(a, b int) int {
 	if a < b {
 		return a
@@ -2216,7 +4908,7 @@ func minInt(a, b int) int {
 }
 
 func writeJSON(v any) error {
-	pretty := strings.TrimSpace(os.Getenv("GCHATCTL_JSON_PRETTY")) == "1"
+	pretty, _ := strconv.ParseBool(setting("json-pretty"))
 	var (
 		b   []byte
 		err error
@@ -2234,7 +4926,8 @@ func writeJSON(v any) error {
 }
 
 func jsonEnvelopeEnabled() bool {
-	return strings.TrimSpace(os.Getenv("GCHATCTL_JSON_ENVELOPE")) == "1"
+	on, _ := strconv.ParseBool(setting("json-envelope"))
+	return on
 }
 
 func printJSON(v any) error {
@@ -2252,25 +4945,106 @@ func printJSONError(err error) error {
 	if err != nil {
 		msg = err.Error()
 	}
+	body := map[string]any{
+		"code":      errorCode(err),
+		"message":   msg,
+		"exit_code": exitCode(err),
+	}
+	var apiErr *gchat.APIError
+	if errors.As(err, &apiErr) {
+		body["http_status"] = apiErr.HTTPStatus
+		if apiErr.Status != "" {
+			body["status"] = apiErr.Status
+		}
+	}
+	var ambiguous *gchat.AmbiguousNameError
+	if errors.As(err, &ambiguous) {
+		body["candidates"] = ambiguous.Matches
+	}
+	var partial *partialError
+	if errors.As(err, &partial) {
+		body["errors"] = partial.failures
+	}
 	return writeJSON(map[string]any{
-		"ok": false,
-		"error": map[string]any{
-			"code":    "FATAL",
-			"message": msg,
-		},
+		"ok":    false,
+		"error": body,
 	})
 }
 
-func shouldPrintJSONError() bool {
-	if jsonEnvelopeEnabled() {
-		return true
+// codeFatal is reported for errors that fit no other category.
+const codeFatal gchat.ErrorCode = "FATAL"
+
+// errorCode returns the stable code reported in JSON errors.
+func errorCode(err error) gchat.ErrorCode {
+	if code := gchat.ErrorCodeOf(err); code != "" {
+		return code
+	}
+	return codeFatal
+}
+
+// exitCode maps an error to the documented process exit status.
+func exitCode(err error) int {
+	switch errorCode(err) {
+	case gchat.CodeValidation:
+		return 2
+	case gchat.CodeAuthRequired:
+		return 3
+	case gchat.CodeInsufficientScopes, gchat.CodePermissionDenied, gchat.CodeChatAppNotFound:
+		return 4
+	case gchat.CodeNotFound:
+		return 5
+	case gchat.CodeAmbiguousName:
+		return 6
+	case gchat.CodeRateLimited, gchat.CodeBudgetExhausted:
+		return 7
+	case gchat.CodeNetwork, gchat.CodeTimeout, gchat.CodeUnavailable:
+		return 8
+	case gchat.CodeCanceled:
+		return 130
+	default:
+		return 1
 	}
-	for _, arg := range os.Args[1:] {
-		if arg == "--json" {
-			return true
+}
+
+// cliError attaches a stable error code to an error raised by gchatctl itself.
+type cliError struct {
+	code gchat.ErrorCode
+	err  error
+}
+
+func (e *cliError) Error() string              { return e.err.Error() }
+func (e *cliError) Unwrap() error              { return e.err }
+func (e *cliError) ErrorCode() gchat.ErrorCode { return e.code }
+
+func usageError(msg string) error {
+	return &cliError{code: gchat.CodeValidation, err: errors.New(msg)}
+}
+
+func usageErrorf(format string, args ...any) error {
+	return &cliError{code: gchat.CodeValidation, err: fmt.Errorf(format, args...)}
+}
+
+func authRequiredError(msg string) error {
+	return &cliError{code: gchat.CodeAuthRequired, err: errors.New(msg)}
+}
+
+// parseFlags parses args into fs and applies the global flags from ctx.
+// Bad flags are VALIDATION errors; --help prints the command's help and
+// returns flag.ErrHelp.
+func parseFlags(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printCommandHelp(os.Stdout, fs)
+			return err
 		}
+		return &cliError{code: gchat.CodeValidation, err: fmt.Errorf("%w (see gchatctl %s --help)", err, fs.Name())}
+	}
+	if err := applySettings(fs); err != nil {
+		return err
 	}
-	return false
+	return applyGlobals(ctx, fs)
 }
 
 func aliasesPath() (string, error) {
@@ -2319,27 +5093,60 @@ func saveAliases(aliases map[string]string) error {
 	return os.WriteFile(p, b, 0o600)
 }
 
-func runAuthLogin(args []string) error {
+// authLoginFlags are the flags of `auth login`.
+type authLoginFlags struct {
+	clientID        *string
+	clientSecret    *string
+	scopesRaw       *string
+	allScopes       *bool
+	mode            *string
+	noOpen          *bool
+	callbackTimeout *time.Duration
+	jsonOut         *bool
+	debugOpts       *debugOptions
+}
+
+// newAuthLoginFlags declares the flags of `auth login` on fs.
+func newAuthLoginFlags(fs *flag.FlagSet) *authLoginFlags {
+	var f authLoginFlags
+	f.clientID = fs.String("client-id", "", "OAuth client ID")
+	f.clientSecret = fs.String("client-secret", "", "OAuth client secret")
+	f.scopesRaw = fs.String("scopes", "", "comma-separated OAuth scopes")
+	f.allScopes = fs.Bool("all-scopes", false, "use recommended full chat read scopes")
+	f.mode = fs.String("mode", "auto", "auth mode: auto, browser, device")
+	f.noOpen = fs.Bool("no-open", false, "do not open browser automatically")
+	f.callbackTimeout = fs.Duration("callback-timeout", 3*time.Minute, "how long to wait for the browser callback")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.debugOpts = addDebugFlags(fs)
+	return &f
+}
+
+func runAuthLogin(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("auth login", flag.ContinueOnError)
-	clientID := fs.String("client-id", "", "OAuth client ID")
-	clientSecret := fs.String("client-secret", "", "OAuth client secret")
-	scopesRaw := fs.String("scopes", "", "comma-separated OAuth scopes")
-	allScopes := fs.Bool("all-scopes", false, "use recommended full chat read scopes")
-	mode := fs.String("mode", "auto", "auth mode: auto, browser, device")
-	noOpen := fs.Bool("no-open", false, "do not open browser automatically")
-	timeout := fs.Duration("timeout", 3*time.Minute, "browser callback timeout")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
+	flags := newAuthLoginFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	if _, ok := globalsFrom(ctx)["timeout"]; ok {
+		// --timeout used to be the callback wait; it is now the global
+		// command deadline, which login does not use. Fail rather than
+		// silently ignore it in existing scripts.
+		return usageError("auth login does not take --timeout; use --callback-timeout to set how long to wait for the browser")
+	}
+	debug, err := openDebugLog(flags.debugOpts)
+	if err != nil {
 		return err
 	}
+	defer debug.close()
+	ctx = debug.withHTTPClient(ctx)
 
 	cfg, err := loadConfig()
 	if err != nil {
 		return err
 	}
 
-	effectiveScopesRaw := *scopesRaw
-	if *allScopes {
+	effectiveScopesRaw := *flags.scopesRaw
+	if *flags.allScopes {
 		effectiveScopesRaw = defaultChatScopesCSV
 	}
 	scopes := chooseScopes(effectiveScopesRaw, cfg.Scopes)
@@ -2347,11 +5154,11 @@ func runAuthLogin(args []string) error {
 		scopes = append([]string(nil), defaultChatScopes...)
 	}
 
-	cid := firstNonEmpty(*clientID, os.Getenv("GCHATCTL_CLIENT_ID"), cfg.OAuthClient.ClientID)
-	secret := firstNonEmpty(*clientSecret, os.Getenv("GCHATCTL_CLIENT_SECRET"), cfg.OAuthClient.ClientSecret)
+	cid := firstNonEmpty(*flags.clientID, os.Getenv("GCHATCTL_CLIENT_ID"), cfg.OAuthClient.ClientID)
+	secret := firstNonEmpty(*flags.clientSecret, os.Getenv("GCHATCTL_CLIENT_SECRET"), cfg.OAuthClient.ClientSecret)
 	if cid == "" {
 		if !isInteractive() {
-			return errors.New("missing client ID; pass --client-id or set GCHATCTL_CLIENT_ID (create one in Google Cloud Console: APIs & Services > Credentials)")
+			return usageError("missing client ID; pass --client-id or set GCHATCTL_CLIENT_ID (create one in Google Cloud Console: APIs & Services > Credentials)")
 		}
 		printOAuthClientIDHelp()
 		v, perr := prompt("Google OAuth client ID: ")
@@ -2361,22 +5168,22 @@ func runAuthLogin(args []string) error {
 		cid = strings.TrimSpace(v)
 	}
 
-	if *timeout <= 0 {
-		return errors.New("--timeout must be greater than 0")
+	if *flags.callbackTimeout <= 0 {
+		return usageError("--callback-timeout must be greater than 0")
 	}
 
-	resolvedMode := resolveMode(*mode, *noOpen, isInteractive())
+	resolvedMode := resolveMode(*flags.mode, *flags.noOpen, isInteractive())
 	if resolvedMode == "" {
-		return errors.New("invalid --mode, expected auto|browser|device")
+		return usageError("invalid --mode, expected auto|browser|device")
 	}
 
-	ctx := context.Background()
+	endpoints := resolveEndpoints(cfg)
 	var tok *oauth2.Token
 	switch resolvedMode {
 	case "browser":
-		tok, err = loginBrowserFlow(ctx, cid, secret, scopes, *noOpen, *timeout)
+		tok, err = loginBrowserFlow(ctx, endpoints, cid, secret, scopes, *flags.noOpen, *flags.callbackTimeout)
 	case "device":
-		tok, err = loginDeviceFlow(ctx, cid, secret, scopes)
+		tok, err = loginDeviceFlow(ctx, endpoints, cid, secret, scopes)
 	default:
 		return fmt.Errorf("unsupported mode %q", resolvedMode)
 	}
@@ -2394,7 +5201,7 @@ func runAuthLogin(args []string) error {
 		return err
 	}
 
-	if *jsonOut {
+	if *flags.jsonOut {
 		tokenFile, _ := tokenPath()
 		out := map[string]any{
 			"mode":                  resolvedMode,
@@ -2419,17 +5226,29 @@ func runAuthLogin(args []string) error {
 	return nil
 }
 
-func runAuthStatus(args []string) error {
+// authStatusFlags are the flags of `auth status`.
+type authStatusFlags struct {
+	jsonOut *bool
+}
+
+// newAuthStatusFlags declares the flags of `auth status` on fs.
+func newAuthStatusFlags(fs *flag.FlagSet) *authStatusFlags {
+	var f authStatusFlags
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	return &f
+}
+
+func runAuthStatus(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("auth status", flag.ContinueOnError)
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args); err != nil {
+	flags := newAuthStatusFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
 
 	st, err := loadToken()
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			if *jsonOut {
+			if *flags.jsonOut {
 				fmt.Printf("{\"authenticated\":false}\n")
 				return nil
 			}
@@ -2454,7 +5273,7 @@ func runAuthStatus(args []string) error {
 		"token_path":            tokenFile,
 	}
 
-	if *jsonOut {
+	if *flags.jsonOut {
 		return printJSON(status)
 	}
 
@@ -2477,9 +5296,9 @@ func runAuthStatus(args []string) error {
 	return nil
 }
 
-func runAuthLogout(args []string) error {
+func runAuthLogout(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("auth logout", flag.ContinueOnError)
-	if err := fs.Parse(args); err != nil {
+	if err := parseFlags(ctx, fs, args); err != nil {
 		return err
 	}
 	err := deleteToken()
@@ -2490,7 +5309,64 @@ func runAuthLogout(args []string) error {
 	return nil
 }
 
-func loginBrowserFlow(ctx context.Context, clientID, clientSecret string, scopes []string, noOpen bool, timeout time.Duration) (*oauth2.Token, error) {
+// authTokenFlags are the flags of `auth token`.
+type authTokenFlags struct {
+	minValid  *time.Duration
+	jsonOut   *bool
+	debugOpts *debugOptions
+}
+
+// newAuthTokenFlags declares the flags of `auth token` on fs.
+func newAuthTokenFlags(fs *flag.FlagSet) *authTokenFlags {
+	var f authTokenFlags
+	f.minValid = fs.Duration("min-valid", 5*time.Minute, "refresh first if the saved token expires sooner than this")
+	f.jsonOut = fs.Bool("json", false, "print JSON")
+	f.debugOpts = addDebugFlags(fs)
+	return &f
+}
+
+func runAuthToken(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("auth token", flag.ContinueOnError)
+	flags := newAuthTokenFlags(fs)
+	if err := parseFlags(ctx, fs, args); err != nil {
+		return err
+	}
+	debug, err := openDebugLog(flags.debugOpts)
+	if err != nil {
+		return err
+	}
+	defer debug.close()
+
+	cfg, st, err := loadAuthContext()
+	if err != nil {
+		return err
+	}
+	current := st.Token
+	if time.Until(current.Expiry) < *flags.minValid {
+		// An empty access token makes the token source refresh.
+		current.AccessToken = ""
+	}
+	source := oauthConfigFrom(cfg, st.Scopes).TokenSource(debug.withHTTPClient(context.WithoutCancel(ctx)), &current)
+	tok, err := source.Token()
+	if err != nil {
+		return err
+	}
+	if err := saveRefreshedTokenIfChanged(st, source); err != nil {
+		return err
+	}
+
+	if *flags.jsonOut {
+		return printJSON(map[string]any{
+			"access_token": tok.AccessToken,
+			"token_type":   tok.Type(),
+			"expiry":       tok.Expiry.UTC().Format(time.RFC3339),
+		})
+	}
+	fmt.Println(tok.AccessToken)
+	return nil
+}
+
+func loginBrowserFlow(ctx context.Context, endpoints EndpointConfig, clientID, clientSecret string, scopes []string, noOpen bool, timeout time.Duration) (*oauth2.Token, error) {
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		return nil, err
@@ -2504,8 +5380,8 @@ func loginBrowserFlow(ctx context.Context, clientID, clientSecret string, scopes
 		RedirectURL:  redirectURI,
 		Scopes:       scopes,
 		Endpoint: oauth2.Endpoint{
-			AuthURL:  googleAuthURL,
-			TokenURL: googleTokenURL,
+			AuthURL:  endpoints.AuthURL,
+			TokenURL: endpoints.TokenURL,
 		},
 	}
 
@@ -2578,21 +5454,23 @@ func loginBrowserFlow(ctx context.Context, clientID, clientSecret string, scopes
 		return nil, e
 	case <-time.After(timeout):
 		return nil, fmt.Errorf("timed out waiting for browser callback after %s", timeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
-func loginDeviceFlow(ctx context.Context, clientID, clientSecret string, scopes []string) (*oauth2.Token, error) {
+func loginDeviceFlow(ctx context.Context, endpoints EndpointConfig, clientID, clientSecret string, scopes []string) (*oauth2.Token, error) {
 	v := url.Values{}
 	v.Set("client_id", clientID)
 	v.Set("scope", strings.Join(scopes, " "))
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleDeviceURL, strings.NewReader(v.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoints.DeviceURL, strings.NewReader(v.Encode()))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := (&http.Client{Timeout: defaultHTTPTimeout}).Do(req)
+	resp, err := authHTTPClient(ctx).Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -2623,7 +5501,7 @@ func loginDeviceFlow(ctx context.Context, clientID, clientSecret string, scopes
 	interval := time.Duration(dc.Interval) * time.Second
 
 	for time.Now().Before(deadline) {
-		tok, pending, slowDown, err := pollDeviceToken(ctx, clientID, clientSecret, dc.DeviceCode)
+		tok, pending, slowDown, err := pollDeviceToken(ctx, endpoints.TokenURL, clientID, clientSecret, dc.DeviceCode)
 		if err != nil {
 			return nil, err
 		}
@@ -2634,7 +5512,9 @@ func loginDeviceFlow(ctx context.Context, clientID, clientSecret string, scopes
 			if slowDown {
 				interval += 5 * time.Second
 			}
-			time.Sleep(interval)
+			if !sleepContext(ctx, interval) {
+				return nil, ctx.Err()
+			}
 			continue
 		}
 	}
@@ -2642,7 +5522,7 @@ func loginDeviceFlow(ctx context.Context, clientID, clientSecret string, scopes
 	return nil, errors.New("device login timed out")
 }
 
-func pollDeviceToken(ctx context.Context, clientID, clientSecret, deviceCode string) (*oauth2.Token, bool, bool, error) {
+func pollDeviceToken(ctx context.Context, tokenURL, clientID, clientSecret, deviceCode string) (*oauth2.Token, bool, bool, error) {
 	v := url.Values{}
 	v.Set("client_id", clientID)
 	if strings.TrimSpace(clientSecret) != "" {
@@ -2651,13 +5531,13 @@ func pollDeviceToken(ctx context.Context, clientID, clientSecret, deviceCode str
 	v.Set("device_code", deviceCode)
 	v.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleTokenURL, strings.NewReader(v.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(v.Encode()))
 	if err != nil {
 		return nil, false, false, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := (&http.Client{Timeout: defaultHTTPTimeout}).Do(req)
+	resp, err := authHTTPClient(ctx).Do(req)
 	if err != nil {
 		return nil, false, false, err
 	}
@@ -2682,88 +5562,823 @@ func pollDeviceToken(ctx context.Context, clientID, clientSecret, deviceCode str
 			return nil, false, false, fmt.Errorf("device token error: %s", tr.Error)
 		}
 	}
-
-	if tr.AccessToken == "" {
-		return nil, true, false, nil
+
+	if tr.AccessToken == "" {
+		return nil, true, false, nil
+	}
+
+	tok := &oauth2.Token{
+		AccessToken:  tr.AccessToken,
+		TokenType:    tr.TokenType,
+		RefreshToken: tr.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second),
+	}
+	return tok, false, false, nil
+}
+
+func openBrowser(target string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32.exe", "url.dll,FileProtocolHandler", target)
+	case "darwin":
+		cmd = exec.Command("open", target)
+	default:
+		cmd = exec.Command("xdg-open", target)
+	}
+	return cmd.Start()
+}
+
+func chooseScopes(flagRaw string, defaultScopes []string) []string {
+	raw := strings.TrimSpace(firstNonEmpty(flagRaw, setting("scopes")))
+	if raw == "" {
+		return uniqueScopes(defaultScopes)
+	}
+	parts := strings.Split(raw, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		s := strings.TrimSpace(p)
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return uniqueScopes(out)
+}
+
+func uniqueScopes(scopes []string) []string {
+	seen := make(map[string]struct{}, len(scopes))
+	out := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		s := strings.TrimSpace(scope)
+		if s == "" {
+			continue
+		}
+		if _, ok := seen[s]; ok {
+			continue
+		}
+		seen[s] = struct{}{}
+		out = append(out, s)
+	}
+	return out
+}
+
+func resolveMode(mode string, noOpen, interactive bool) string {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "auto":
+		if noOpen || !interactive {
+			return "device"
+		}
+		return "browser"
+	case "browser", "device":
+		return strings.ToLower(mode)
+	default:
+		return ""
+	}
+}
+
+// newOAuthClient authorizes requests on top of base. Per-request timeouts are
+// enforced by the retry transport so a backoff is not cut short.
+func newOAuthClient(ctx context.Context, tokenSource oauth2.TokenSource, base http.RoundTripper) *http.Client {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &http.Client{
+		Transport: &oauth2.Transport{
+			Source: tokenSource,
+			Base:   base,
+		},
+	}
+}
+
+// clientOptions holds the flags shared by every command that calls the Chat API.
+type clientOptions struct {
+	record        string
+	replay        string
+	maxAttempts   int
+	retryDeadline time.Duration
+	concurrency   int
+	rate          float64
+	burst         int
+	maxRequests   int64
+	timeout       time.Duration
+	stats         bool
+	noCache       bool
+	refreshCache  bool
+	cacheTTL      time.Duration
+	progress      progressMode
+	command       string
+	debug         *debugOptions
+}
+
+func addClientFlags(fs *flag.FlagSet) *clientOptions {
+	opts := &clientOptions{command: fs.Name()}
+	fs.StringVar(&opts.record, "record", "", "record API traffic to this directory (credentials scrubbed)")
+	fs.StringVar(&opts.replay, "replay", "", "answer API calls from a recording directory instead of the network")
+	fs.IntVar(&opts.maxAttempts, "max-attempts", gchat.DefaultMaxAttempts, "max tries per API request on 429/5xx (1 disables retries)")
+	fs.DurationVar(&opts.retryDeadline, "retry-deadline", gchat.DefaultRetryDeadline, "max time spent retrying a single API request")
+	fs.IntVar(&opts.concurrency, "concurrency", gchat.DefaultConcurrency, "max spaces scanned in parallel")
+	fs.Float64Var(&opts.rate, "rate", gchat.DefaultRateLimit.Rate, "max API requests per second (0 disables rate limiting)")
+	fs.IntVar(&opts.burst, "burst", gchat.DefaultRateLimit.Burst, "max API requests sent in a burst before --rate applies")
+	fs.Int64Var(&opts.maxRequests, "max-requests", 0, "stop after this many API requests and return partial results (0 = unlimited)")
+	fs.DurationVar(&opts.timeout, "timeout", 0, "deadline for the whole command; partial results are printed when it passes (0 = none)")
+	fs.BoolVar(&opts.stats, "stats", false, "report API calls, pages, bytes, retries and wall time for this command")
+	fs.BoolVar(&opts.noCache, "no-cache", false, "do not read or write the on-disk cache of spaces and memberships or the DM index")
+	fs.BoolVar(&opts.refreshCache, "refresh-cache", false, "ignore cached spaces and memberships but store fresh ones")
+	fs.DurationVar(&opts.cacheTTL, "cache-ttl", 0, "max age of cached spaces and memberships (default 1h, or cache_ttl in config.json)")
+	fs.Var(&opts.progress, "progress", "report scan progress on stderr: --progress, --progress=json or --progress=none (default: on when stderr is a terminal)")
+	opts.debug = addDebugFlags(fs)
+	return opts
+}
+
+// progressMode is the --progress flag. A bare --progress selects text; the
+// zero value means text when stderr is a terminal and none otherwise.
+type progressMode string
+
+func (m *progressMode) String() string { return string(*m) }
+
+func (m *progressMode) Set(v string) error {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "true", "text":
+		*m = "text"
+	case "false", "none":
+		*m = "none"
+	case "json":
+		*m = "json"
+	default:
+		return fmt.Errorf("must be text, json or none")
+	}
+	return nil
+}
+
+func (m *progressMode) IsBoolFlag() bool { return true }
+
+// progressReporter prints gchat.Progress events for one command on stderr,
+// as a self-updating line or as newline-delimited JSON.
+type progressReporter struct {
+	command string
+	json    bool
+	tty     bool
+	out     io.Writer
+	start   time.Time
+	last    time.Time
+}
+
+func newProgressReporter(opts *clientOptions) *progressReporter {
+	tty := isTerminal(os.Stderr)
+	mode := opts.progress
+	if mode == "" && tty {
+		mode = "text"
+	}
+	if mode != "text" && mode != "json" {
+		return nil
+	}
+	return &progressReporter{command: opts.command, json: mode == "json", tty: tty, out: os.Stderr, start: time.Now()}
+}
+
+// report is the gchat.WithProgress callback; calls are serialized.
+func (r *progressReporter) report(p gchat.Progress) {
+	now := time.Now()
+	final := p.Done >= p.Total
+	elapsed := now.Sub(r.start)
+	var eta time.Duration
+	if p.Done > 0 && !final {
+		eta = time.Duration(int64(elapsed) / int64(p.Done) * int64(p.Total-p.Done))
+	}
+	if r.json {
+		b, _ := json.Marshal(map[string]any{
+			"type":       "progress",
+			"command":    r.command,
+			"stage":      p.Stage,
+			"done":       p.Done,
+			"total":      p.Total,
+			"failed":     p.Failed,
+			"found":      p.Found,
+			"elapsed_ms": elapsed.Milliseconds(),
+			"eta_ms":     eta.Milliseconds(),
+		})
+		fmt.Fprintln(r.out, string(b))
+		return
+	}
+	// Plain-text updates are throttled; a terminal gets one line rewritten
+	// in place.
+	interval := 250 * time.Millisecond
+	if !r.tty {
+		interval = 2 * time.Second
+	}
+	if !final && now.Sub(r.last) < interval {
+		return
+	}
+	r.last = now
+	line := fmt.Sprintf("%s: %s %d/%d spaces", r.command, firstNonEmpty(p.Stage, "scan"), p.Done, p.Total)
+	if p.Failed > 0 {
+		line += fmt.Sprintf(" (%d failed)", p.Failed)
+	}
+	line += fmt.Sprintf(", %d found", p.Found)
+	if !final {
+		line += ", eta " + eta.Round(time.Second).String()
+	}
+	switch {
+	case !r.tty:
+		fmt.Fprintln(r.out, line)
+	case final:
+		fmt.Fprintf(r.out, "\r\x1b[K%s\n", line)
+	default:
+		fmt.Fprintf(r.out, "\r\x1b[K%s", line)
+	}
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// debugOptions selects HTTP tracing for --debug.
+type debugOptions struct {
+	enabled bool
+	bodies  bool
+	file    string
+}
+
+func addDebugFlags(fs *flag.FlagSet) *debugOptions {
+	opts := &debugOptions{}
+	fs.BoolVar(&opts.enabled, "debug", false, "log every HTTP request to stderr (credentials redacted)")
+	fs.BoolVar(&opts.bodies, "debug-bodies", false, "with --debug, also log request/response bodies, including message text")
+	fs.StringVar(&opts.file, "debug-file", "", "append --debug output to this file instead of stderr")
+	return opts
+}
+
+// debugLog is an open --debug destination.
+type debugLog struct {
+	out    io.Writer
+	file   *os.File
+	bodies bool
+}
+
+// openDebugLog applies GCHATCTL_DEBUG ("1", or "body" to include bodies) and
+// GCHATCTL_DEBUG_FILE under the flags. It returns nil when tracing is off.
+func openDebugLog(opts *debugOptions) (*debugLog, error) {
+	env := strings.ToLower(strings.TrimSpace(os.Getenv("GCHATCTL_DEBUG")))
+	enabled := opts.enabled || opts.bodies || (env != "" && env != "0" && env != "false")
+	if !enabled {
+		return nil, nil
+	}
+	d := &debugLog{out: os.Stderr, bodies: opts.bodies || env == "body"}
+	if path := firstNonEmpty(opts.file, os.Getenv("GCHATCTL_DEBUG_FILE")); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		d.out, d.file = f, f
+	}
+	return d, nil
+}
+
+// wrap traces requests sent through base; a nil debugLog returns base as-is.
+func (d *debugLog) wrap(base http.RoundTripper) http.RoundTripper {
+	if d == nil {
+		return base
+	}
+	return &debuglog.Transport{Base: base, Out: d.out, Bodies: d.bodies}
+}
+
+// withHTTPClient stores the client used for OAuth token requests in ctx,
+// where the oauth2 package and authHTTPClient look for it. It is set even
+// without --debug, since the oauth2 package would otherwise refresh tokens
+// with http.DefaultClient, which has no timeout.
+func (d *debugLog) withHTTPClient(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Timeout: defaultHTTPTimeout, Transport: d.wrap(http.DefaultTransport)})
+}
+
+func (d *debugLog) close() error {
+	if d == nil || d.file == nil {
+		return nil
+	}
+	return d.file.Close()
+}
+
+// authHTTPClient returns the client for OAuth requests made outside the
+// oauth2 package, honoring a client stored in ctx the same way it does.
+func authHTTPClient(ctx context.Context) *http.Client {
+	if hc, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && hc != nil {
+		return hc
+	}
+	return &http.Client{Timeout: defaultHTTPTimeout}
+}
+
+// apiSession is the authenticated Chat client for one command invocation.
+type apiSession struct {
+	client      *gchat.Client
+	stored      StoredToken
+	tokenSource oauth2.TokenSource
+	retry       *gchat.RetryTransport
+	limiter     *gchat.RateLimitTransport
+	stats       *gchat.StatsTransport
+	cache       *diskcache.Cache
+	index       *dmindex.Index
+	debug       *debugLog
+	replaying   bool
+	// started and showStats drive the usage report; reported is set once it
+	// has been included in JSON output.
+	started   time.Time
+	showStats bool
+	reported  bool
+	// ctx carries the --timeout deadline; cancel releases it.
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed bool
+}
+
+// openSession loads credentials and builds the Chat client, wiring in
+// --record/--replay. Replay needs no saved login. The returned context
+// carries the --timeout deadline and must be used for all API calls; callers
+// defer sess.release().
+func openSession(ctx context.Context, opts *clientOptions) (context.Context, *apiSession, error) {
+	if opts.timeout < 0 {
+		return ctx, nil, usageError("--timeout must not be negative")
+	}
+	debug, err := openDebugLog(opts.debug)
+	if err != nil {
+		return ctx, nil, err
+	}
+	sess, err := newSession(ctx, opts, debug)
+	if err != nil {
+		_ = debug.close()
+		return ctx, nil, err
+	}
+	sess.debug = debug
+	sess.started = time.Now()
+	sess.showStats = opts.stats
+	if r := newProgressReporter(opts); r != nil {
+		ctx = gchat.WithProgress(ctx, r.report)
+	}
+	if opts.refreshCache {
+		ctx = gchat.WithCacheRefresh(ctx)
+	}
+	sess.ctx, sess.cancel = ctx, func() {}
+	if opts.timeout > 0 {
+		sess.ctx, sess.cancel = context.WithTimeout(ctx, opts.timeout)
+	}
+	return sess.ctx, sess, nil
+}
+
+func newSession(ctx context.Context, opts *clientOptions, debug *debugLog) (*apiSession, error) {
+	if opts.record != "" && opts.replay != "" {
+		return nil, usageError("use either --record or --replay, not both")
+	}
+	if opts.maxAttempts <= 0 {
+		return nil, usageError("--max-attempts must be greater than 0")
+	}
+	if opts.retryDeadline <= 0 {
+		return nil, usageError("--retry-deadline must be greater than 0")
+	}
+	if opts.concurrency <= 0 {
+		return nil, usageError("--concurrency must be greater than 0")
+	}
+	if opts.rate < 0 {
+		return nil, usageError("--rate must not be negative")
+	}
+	if opts.burst <= 0 {
+		return nil, usageError("--burst must be greater than 0")
+	}
+	if opts.maxRequests < 0 {
+		return nil, usageError("--max-requests must not be negative")
+	}
+	// The limiter sits under the retry transport so every attempt is paced
+	// and counted against --max-requests.
+	limiter := &gchat.RateLimitTransport{
+		Limit:       gchat.RateLimit{Rate: opts.rate, Burst: opts.burst},
+		MaxRequests: opts.maxRequests,
+	}
+	retry := &gchat.RetryTransport{
+		Base:           limiter,
+		MaxAttempts:    opts.maxAttempts,
+		Deadline:       opts.retryDeadline,
+		AttemptTimeout: defaultHTTPTimeout,
+	}
+	stats := &gchat.StatsTransport{Base: retry}
+	if opts.replay != "" {
+		rp, err := cassette.Load(opts.replay)
+		if err != nil {
+			return nil, err
+		}
+		limiter.Base = debug.wrap(rp)
+		cfg, _ := loadConfig()
+		limiter.Endpoints = endpointRateLimits(cfg)
+		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "replay", TokenType: "Bearer"})
+		return &apiSession{client: newChatClient(cfg, newOAuthClient(ctx, ts, stats), opts.concurrency, nil, nil), retry: retry, limiter: limiter, stats: stats, replaying: true}, nil
+	}
+
+	cfg, st, err := loadAuthContext()
+	if err != nil {
+		return nil, err
+	}
+	limiter.Endpoints = endpointRateLimits(cfg)
+	limiter.Base = debug.wrap(http.DefaultTransport)
+	if opts.record != "" {
+		rec, rerr := cassette.NewRecorder(opts.record, http.DefaultTransport)
+		if rerr != nil {
+			return nil, rerr
+		}
+		limiter.Base = debug.wrap(rec)
+	}
+	oauthCfg := oauthConfigFrom(cfg, st.Scopes)
+	// Refreshes must not fail just because the command was interrupted, or
+	// the refreshed token could not be persisted on the way out.
+	tokenSource := oauthCfg.TokenSource(debug.withHTTPClient(context.WithoutCancel(ctx)), &st.Token)
+	sess := &apiSession{
+		stored:      st,
+		tokenSource: tokenSource,
+		retry:       retry,
+		limiter:     limiter,
+		stats:       stats,
+	}
+	// Recordings must capture real traffic, so they bypass the cache and
+	// the DM index.
+	var cache gchat.Cache
+	var index gchat.DMIndex
+	if !opts.noCache && opts.record == "" {
+		ttl, err := cacheTTL(cfg, opts)
+		if err != nil {
+			return nil, err
+		}
+		if path, err := cachePath(st); err == nil {
+			sess.cache = diskcache.Open(path, ttl)
+			sess.cache.OnHit = stats.CountCacheHit
+			cache = sess.cache
+		}
+		if path, err := dmIndexPath(st); err == nil {
+			sess.index = dmindex.Open(path)
+			index = sess.index
+		}
+	}
+	sess.client = newChatClient(cfg, newOAuthClient(ctx, tokenSource, stats), opts.concurrency, cache, index)
+	return sess, nil
+}
+
+// cacheTTL picks the cache TTL: --cache-ttl, then cache_ttl in config.json,
+// then the default.
+func cacheTTL(cfg AppConfig, opts *clientOptions) (time.Duration, error) {
+	if opts.cacheTTL < 0 {
+		return 0, usageError("--cache-ttl must not be negative")
+	}
+	if opts.cacheTTL > 0 {
+		return opts.cacheTTL, nil
+	}
+	if strings.TrimSpace(cfg.CacheTTL) == "" {
+		return diskcache.DefaultTTL, nil
+	}
+	ttl, err := time.ParseDuration(strings.TrimSpace(cfg.CacheTTL))
+	if err != nil || ttl <= 0 {
+		return 0, usageErrorf("invalid cache_ttl %q in config.json", cfg.CacheTTL)
+	}
+	return ttl, nil
+}
+
+// endpointRateLimits merges the per-method limits from config.json over the
+// built-in write limits. A zero rate in config lifts the limit for that method.
+func endpointRateLimits(cfg AppConfig) map[string]gchat.RateLimit {
+	limits := gchat.DefaultEndpointRateLimits()
+	for name, l := range cfg.RateLimits {
+		limits[name] = l
+	}
+	return limits
+}
+
+// budgetExhausted reports whether --max-requests cut the command short.
+func (s *apiSession) budgetExhausted() bool {
+	return s.limiter != nil && s.limiter.Exhausted()
+}
+
+func (s *apiSession) budgetWarning() string {
+	return fmt.Sprintf("request budget of %d exhausted (--max-requests); results are partial", s.limiter.MaxRequests)
+}
+
+// interruptWarning describes why the command stopped early, or returns ""
+// when it ran to completion.
+func (s *apiSession) interruptWarning() string {
+	switch err := s.ctx.Err(); {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline reached (--timeout); results are partial"
+	default:
+		return "interrupted; results are partial"
+	}
+}
+
+// close persists the OAuth token if it was refreshed during the session and
+// warns on stderr when the results are partial.
+func (s *apiSession) close() error {
+	s.closed = true
+	if s.cache != nil {
+		if err := s.cache.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not save cache:", err)
+		}
+	}
+	if s.index != nil {
+		if err := s.index.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not save DM index:", err)
+		}
+	}
+	if s.budgetExhausted() {
+		fmt.Fprintln(os.Stderr, "warning:", s.budgetWarning())
+	}
+	if w := s.interruptWarning(); w != "" {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+	if s.replaying {
+		return nil
+	}
+	return saveRefreshedTokenIfChanged(s.stored, s.tokenSource)
+}
+
+// release cancels the --timeout deadline. On error paths that never reached
+// close it still persists a refreshed token.
+func (s *apiSession) release() {
+	if !s.closed {
+		_ = s.close()
+	}
+	s.cancel()
+	_ = s.debug.close()
+	if s.showStats && !s.reported {
+		fmt.Fprintln(os.Stderr, s.usage())
+	}
+}
+
+// sessionUsage is the --stats report, also sent as "meta" in the JSON
+// envelope.
+type sessionUsage struct {
+	gchat.Usage
+	Retries int64 `json:"retries"`
+	WallMS  int64 `json:"wall_ms"`
+}
+
+func (s *apiSession) usage() sessionUsage {
+	u := sessionUsage{WallMS: time.Since(s.started).Milliseconds()}
+	if s.stats != nil {
+		u.Usage = s.stats.Usage()
+	}
+	if s.retry != nil {
+		u.Retries = s.retry.Retries()
+	}
+	return u
+}
+
+// String formats the report for stderr.
+func (u sessionUsage) String() string {
+	names := make([]string, 0, len(u.Endpoints))
+	for name := range u.Endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "stats: %d API calls", u.Calls)
+	for i, name := range names {
+		sep := ", "
+		if i == 0 {
+			sep = " ("
+		}
+		fmt.Fprintf(&b, "%s%s=%d", sep, name, u.Endpoints[name])
+	}
+	if len(names) > 0 {
+		b.WriteString(")")
+	}
+	fmt.Fprintf(&b, ", %d pages, %d bytes, %d retries, %d cache hits, %s", u.Pages, u.Bytes, u.Retries, u.CacheHits, time.Duration(u.WallMS)*time.Millisecond)
+	return b.String()
+}
+
+// printJSON prints v like the package-level printJSON, adding the number of
+// retried API requests to object payloads when any were needed, and flagging
+// results cut short by --max-requests. The usage report goes into the
+// envelope as "meta", or into the payload with --stats.
+func (s *apiSession) printJSON(v any) error {
+	if m, ok := v.(map[string]any); ok {
+		if s.retry != nil {
+			if n := s.retry.Retries(); n > 0 {
+				m["retries"] = n
+			}
+		}
+		if s.budgetExhausted() {
+			m["budget_exhausted"] = true
+			m["complete"] = false
+			addWarning(m, s.budgetWarning())
+		}
+		if w := s.interruptWarning(); w != "" {
+			m["interrupted"] = true
+			m["complete"] = false
+			addWarning(m, w)
+		}
+	}
+	if jsonEnvelopeEnabled() {
+		s.reported = true
+		return writeJSON(map[string]any{
+			"ok":   true,
+			"data": v,
+			"meta": s.usage(),
+		})
 	}
-
-	tok := &oauth2.Token{
-		AccessToken:  tr.AccessToken,
-		TokenType:    tr.TokenType,
-		RefreshToken: tr.RefreshToken,
-		Expiry:       time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second),
+	if m, ok := v.(map[string]any); ok && s.showStats {
+		s.reported = true
+		m["meta"] = s.usage()
 	}
-	return tok, false, false, nil
+	return printJSON(v)
 }
 
-func openBrowser(target string) error {
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "windows":
-		cmd = exec.Command("rundll32.exe", "url.dll,FileProtocolHandler", target)
-	case "darwin":
-		cmd = exec.Command("open", target)
-	default:
-		cmd = exec.Command("xdg-open", target)
+// addWarning appends text to the "warning" field of a JSON payload.
+func addWarning(m map[string]any, text string) {
+	if prev, ok := m["warning"].(string); ok && prev != "" {
+		text = prev + "; " + text
 	}
-	return cmd.Start()
+	m["warning"] = text
 }
 
-func chooseScopes(flagRaw string, defaultScopes []string) []string {
-	fromEnv := os.Getenv("GCHATCTL_SCOPES")
-	raw := strings.TrimSpace(firstNonEmpty(flagRaw, fromEnv))
-	if raw == "" {
-		return uniqueScopes(defaultScopes)
+// spaceMessages is one space's newest messages plus its member display
+// names, used to label senders.
+type spaceMessages struct {
+	messages []gchat.ChatMessage
+	names    map[string]string
+}
+
+// fetchRecentMessages lists the newest messages created after cutoff in
+// every space on the client's worker pool. Results line up with spaces; a
+// space whose messages could not be listed has an empty entry and a non-nil
+// error.
+func fetchRecentMessages(ctx context.Context, client *gchat.Client, spaces []string, cutoff time.Time, limit int) ([]spaceMessages, []error) {
+	opts := gchat.ListMessagesOptions{
+		Limit:   limit,
+		OrderBy: gchat.OrderCreateTimeDesc,
+		Filter:  gchat.CreatedAfter(cutoff),
+		Fields:  gchat.MessageListFields,
+	}
+	return gchat.FanOut(gchat.WithStage(ctx, "read messages"), client.Concurrency(), spaces, func(ctx context.Context, sp string) (spaceMessages, error) {
+		msgs, err := client.ListMessages(ctx, sp, opts)
+		if err != nil {
+			return spaceMessages{}, opError(gchat.EndpointMessagesList, err)
+		}
+		gchat.CountFound(ctx, len(msgs))
+		names, _ := client.MemberDisplayNames(ctx, sp)
+		return spaceMessages{messages: msgs, names: names}, nil
+	})
+}
+
+// listActiveSpaces lists the caller's spaces most recently active first and
+// keeps the first limit. With a non-zero cutoff, spaces known to have been
+// quiet since then are left out before the limit is applied. The listing
+// always comes from the API, since activity read from the cache would hide
+// spaces that got messages, or were created, after it was stored; the fresh
+// listing is written back to the cache.
+func listActiveSpaces(ctx context.Context, client *gchat.Client, cutoff time.Time, limit int, exclude map[string]bool) ([]gchat.ChatSpace, error) {
+	spaces, err := client.ListSpaces(gchat.WithCacheRefresh(ctx), gchat.ListSpacesOptions{PageSize: 1000, Fields: gchat.SpaceListFields})
+	if err != nil {
+		return nil, err
 	}
-	parts := strings.Split(raw, ",")
-	out := make([]string, 0, len(parts))
-	for _, p := range parts {
-		s := strings.TrimSpace(p)
-		if s != "" {
-			out = append(out, s)
+	active := spaces[:0]
+	for _, sp := range spaces {
+		if exclude[sp.Name] {
+			continue
+		}
+		if cutoff.IsZero() || !gchat.InactiveSince(sp, cutoff) {
+			active = append(active, sp)
 		}
 	}
-	return uniqueScopes(out)
+	gchat.SortSpacesByActivity(active)
+	if len(active) > limit {
+		active = active[:limit]
+	}
+	return active, nil
 }
 
-func uniqueScopes(scopes []string) []string {
-	seen := make(map[string]struct{}, len(scopes))
-	out := make([]string, 0, len(scopes))
-	for _, scope := range scopes {
-		s := strings.TrimSpace(scope)
-		if s == "" {
+// strictUsage is the help text of --strict on multi-space commands.
+const strictUsage = "fail instead of returning partial results when a space cannot be read"
+
+// spaceOpError records which API method failed while reading a space.
+type spaceOpError struct {
+	op  string
+	err error
+}
+
+func (e *spaceOpError) Error() string { return e.err.Error() }
+func (e *spaceOpError) Unwrap() error { return e.err }
+
+// opError tags err with the API method (a gchat.Endpoint constant) that
+// returned it; nil stays nil.
+func opError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &spaceOpError{op: op, err: err}
+}
+
+// scanFailure is a space a multi-space command skipped, as listed in the
+// JSON "errors" array.
+type scanFailure struct {
+	Space     string          `json:"space"`
+	Operation string          `json:"operation,omitempty"`
+	Code      gchat.ErrorCode `json:"code"`
+	Message   string          `json:"message"`
+	err       error
+}
+
+// scanFailures pairs the non-nil entries of errs with their spaces.
+func scanFailures(spaces []string, errs []error) []scanFailure {
+	var out []scanFailure
+	for i, err := range errs {
+		if err == nil {
 			continue
 		}
-		if _, ok := seen[s]; ok {
-			continue
+		f := scanFailure{Space: spaces[i], Code: errorCode(err), Message: err.Error(), err: err}
+		var opErr *spaceOpError
+		if errors.As(err, &opErr) {
+			f.Operation = opErr.op
 		}
-		seen[s] = struct{}{}
-		out = append(out, s)
+		out = append(out, f)
 	}
 	return out
 }
 
-func resolveMode(mode string, noOpen, interactive bool) string {
-	switch strings.ToLower(strings.TrimSpace(mode)) {
-	case "auto":
-		if noOpen || !interactive {
-			return "device"
-		}
-		return "browser"
-	case "browser", "device":
-		return strings.ToLower(mode)
-	default:
-		return ""
+func spaceNames(spaces []gchat.ChatSpace) []string {
+	names := make([]string, len(spaces))
+	for i, s := range spaces {
+		names[i] = s.Name
 	}
+	return names
 }
 
-func newOAuthClient(ctx context.Context, tokenSource oauth2.TokenSource) *http.Client {
-	return &http.Client{
-		Timeout: defaultHTTPTimeout,
-		Transport: &oauth2.Transport{
-			Source: tokenSource,
-			Base:   http.DefaultTransport,
-		},
+// checkScan reports skipped spaces: as an error with --strict, otherwise as
+// a warning on stderr.
+func checkScan(strict bool, failures []scanFailure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	if strict {
+		return &partialError{failures: failures}
+	}
+	fmt.Fprintf(os.Stderr, "warning: %d of the scanned spaces could not be read; results are partial\n", len(failures))
+	return nil
+}
+
+// addScanFailures marks a JSON payload complete or not and lists the
+// skipped spaces under "errors".
+func addScanFailures(out map[string]any, failures []scanFailure) {
+	out["complete"] = len(failures) == 0
+	if len(failures) > 0 {
+		out["errors"] = failures
+		out["failed_spaces"] = len(failures)
+	}
+}
+
+// partialError fails a --strict scan. Its code is that of the first
+// failure.
+type partialError struct {
+	failures []scanFailure
+}
+
+func (e *partialError) Error() string {
+	f := e.failures[0]
+	return fmt.Sprintf("%d spaces could not be read (--strict); %s: %s", len(e.failures), f.Space, f.Message)
+}
+
+func (e *partialError) Unwrap() error { return e.failures[0].err }
+
+func newChatClient(cfg AppConfig, httpClient *http.Client, concurrency int, cache gchat.Cache, index gchat.DMIndex) *gchat.Client {
+	return gchat.NewClient(gchat.Config{
+		HTTPClient:  httpClient,
+		BaseURL:     resolveEndpoints(cfg).APIBaseURL,
+		Concurrency: concurrency,
+		Cache:       cache,
+		DMIndex:     index,
+	})
+}
+
+// resolveDMByName resolves a peer by name using the saved aliases. DM
+// spaces the scan skipped are reported like those of any other scan.
+func resolveDMByName(ctx context.Context, client *gchat.Client, rawName string, scanLimit int) (string, string, string, error) {
+	aliases, _ := loadAliases()
+	res, err := client.ResolveDMByName(ctx, rawName, gchat.ResolveOptions{ScanLimit: scanLimit, Aliases: aliases})
+	if err != nil {
+		return "", "", "", err
+	}
+	spaces := make([]string, len(res.Skipped))
+	errs := make([]error, len(res.Skipped))
+	for i, sk := range res.Skipped {
+		spaces[i], errs[i] = sk.Space, sk.Err
+	}
+	if err := checkScan(false, scanFailures(spaces, errs)); err != nil {
+		return "", "", "", err
+	}
+	return res.User, res.Space, res.Display, nil
+}
+
+// sleepContext waits for d and reports false if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
 	}
 }
 
@@ -2776,12 +6391,40 @@ func firstNonEmpty(values ...string) string {
 	return ""
 }
 
+// activeProfile is the --profile of this process. It is package state
+// rather than passed along because every path under configDir depends on
+// it.
+var activeProfile string
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// setProfile selects the profile whose files configDir returns.
+func setProfile(name string) error {
+	name = strings.TrimSpace(name)
+	if name != "" && !profileNamePattern.MatchString(name) {
+		return usageErrorf("invalid profile name %q (letters, digits, '.', '_' and '-')", name)
+	}
+	activeProfile = name
+	return nil
+}
+
+// configDir holds config, token, aliases and cache. A profile (--profile or
+// GCHATCTL_PROFILE) gets its own directory under profiles/.
 func configDir() (string, error) {
-	root, err := os.UserConfigDir()
-	if err != nil {
-		return "", err
+	d := strings.TrimSpace(os.Getenv("GCHATCTL_CONFIG_DIR"))
+	if d == "" {
+		root, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		d = filepath.Join(root, "gchatctl")
+	}
+	if profile := firstNonEmpty(activeProfile, os.Getenv("GCHATCTL_PROFILE")); profile != "" {
+		if !profileNamePattern.MatchString(profile) {
+			return "", usageErrorf("invalid profile name %q (letters, digits, '.', '_' and '-')", profile)
+		}
+		d = filepath.Join(d, "profiles", profile)
 	}
-	d := filepath.Join(root, "gchatctl")
 	if err := os.MkdirAll(d, 0o700); err != nil {
 		return "", err
 	}
@@ -2838,6 +6481,262 @@ func saveConfig(cfg AppConfig) error {
 	return nil
 }
 
+// settingsVersion is the version of the settings section this build reads
+// and writes. Newer sections are refused rather than misread.
+const settingsVersion = 1
+
+// Settings is the "settings" section of config.json: defaults for command
+// behavior, managed with `gchatctl config`. Values holds the keys of
+// settingKeys and per-command flag defaults such as "chat.inbox.since".
+type Settings struct {
+	Version int               `json:"version"`
+	Values  map[string]string `json:"values,omitempty"`
+}
+
+// settingKey is a setting that applies to every command.
+type settingKey struct {
+	name  string
+	env   string
+	usage string
+	// def is the built-in value, when there is a single one.
+	def string
+	// flag is the command flag the setting is the default for; settings
+	// without one are read where they apply.
+	flag  string
+	check func(string) error
+}
+
+var settingKeys = []settingKey{
+	{name: "format", env: "GCHATCTL_FORMAT", usage: "default output format: text or json", def: "text", flag: "json", check: checkFormatSetting},
+	{name: "timezone", env: "GCHATCTL_TIMEZONE", usage: "time zone of message times in text output, e.g. Europe/Berlin or Local (default: UTC as sent by the API)", check: checkTimezoneSetting},
+	{name: "limit", env: "GCHATCTL_LIMIT", usage: "default --limit of every command that has one", flag: "limit"},
+	{name: "space-limit", env: "GCHATCTL_SPACE_LIMIT", usage: "default --space-limit", flag: "space-limit"},
+	{name: "since", env: "GCHATCTL_SINCE", usage: "default --since look back window", flag: "since"},
+	{name: "concurrency", env: "GCHATCTL_CONCURRENCY", usage: "default --concurrency", flag: "concurrency"},
+	{name: "exclude-spaces", env: "GCHATCTL_EXCLUDE_SPACES", usage: "comma-separated spaces skipped by inbox, poll and unread scans", flag: "exclude-spaces"},
+	{name: "json-pretty", env: "GCHATCTL_JSON_PRETTY", usage: "indent JSON output", def: "false", check: checkBoolSetting},
+	{name: "json-envelope", env: "GCHATCTL_JSON_ENVELOPE", usage: "wrap JSON output in {\"ok\":true,\"data\":...}", def: "false", check: checkBoolSetting},
+	{name: "scopes", env: "GCHATCTL_SCOPES", usage: "comma-separated OAuth scopes requested by auth login"},
+}
+
+func checkFormatSetting(v string) error {
+	if v != "text" && v != "json" {
+		return fmt.Errorf("want text or json")
+	}
+	return nil
+}
+
+func checkTimezoneSetting(v string) error {
+	_, err := time.LoadLocation(v)
+	return err
+}
+
+func checkBoolSetting(v string) error {
+	_, err := strconv.ParseBool(v)
+	return err
+}
+
+func lookupSettingKey(name string) (settingKey, bool) {
+	for _, k := range settingKeys {
+		if k.name == name {
+			return k, true
+		}
+	}
+	return settingKey{}, false
+}
+
+// loadSettings reads the settings section of config.json.
+func loadSettings() (Settings, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return Settings{}, fmt.Errorf("read config.json: %w (fix it with: gchatctl config edit)", err)
+	}
+	return cfg.settings()
+}
+
+// settings returns the settings section, empty when there is none.
+func (c AppConfig) settings() (Settings, error) {
+	s := Settings{Version: settingsVersion, Values: map[string]string{}}
+	if c.Settings == nil {
+		return s, nil
+	}
+	if c.Settings.Version > settingsVersion {
+		return s, fmt.Errorf("config.json settings are version %d; this gchatctl reads up to version %d", c.Settings.Version, settingsVersion)
+	}
+	for k, v := range c.Settings.Values {
+		s.Values[k] = v
+	}
+	return s, nil
+}
+
+// settingValue resolves a setting that applies to every command: the
+// environment, then config.json, then the built-in value. source is "env",
+// "config" or "default".
+func settingValue(s Settings, k settingKey) (value, source string) {
+	if v := strings.TrimSpace(os.Getenv(k.env)); v != "" {
+		return v, "env"
+	}
+	if v, ok := s.Values[k.name]; ok {
+		return v, "config"
+	}
+	return k.def, "default"
+}
+
+// setting is settingValue for code without the settings at hand. A broken
+// config.json counts as empty here; commands report it when they parse
+// their flags.
+func setting(name string) string {
+	k, _ := lookupSettingKey(name)
+	s, _ := loadSettings()
+	v, _ := settingValue(s, k)
+	return v
+}
+
+// commandSettingKey is the key of a per-command flag default, e.g.
+// "chat.inbox.since".
+func commandSettingKey(command, flagName string) string {
+	return strings.ReplaceAll(command, " ", ".") + "." + flagName
+}
+
+// flagSetting resolves the configured default of --flagName on command: the
+// environment, then the command's own key, then the key for every command.
+// origin names the env var or key it came from; ok is false when nothing is
+// configured.
+func flagSetting(s Settings, command, flagName string) (value, origin string, ok bool) {
+	var global settingKey
+	for _, k := range settingKeys {
+		if k.flag == flagName {
+			global = k
+		}
+	}
+	if global.name != "" {
+		if v := strings.TrimSpace(os.Getenv(global.env)); v != "" {
+			return globalFlagValue(global, v), global.env, true
+		}
+	}
+	key := commandSettingKey(command, flagName)
+	if v, ok := s.Values[key]; ok {
+		return v, key, true
+	}
+	if global.name != "" {
+		if v, ok := s.Values[global.name]; ok {
+			return globalFlagValue(global, v), global.name, true
+		}
+	}
+	return "", "", false
+}
+
+// globalFlagValue maps a setting to the value of its flag; format is the
+// only one that differs.
+func globalFlagValue(k settingKey, v string) string {
+	if k.name == "format" {
+		return strconv.FormatBool(v == "json")
+	}
+	return v
+}
+
+// applySettings fills in the flags of fs not given on the command line
+// from the environment and config.json, so the precedence is flag > env >
+// config > built-in.
+func applySettings(fs *flag.FlagSet) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	open := 0
+	fs.VisitAll(func(f *flag.Flag) {
+		if !explicit[f.Name] {
+			open++
+		}
+	})
+	if open == 0 {
+		return nil
+	}
+	s, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	var setErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] || setErr != nil {
+			return
+		}
+		value, origin, ok := flagSetting(s, fs.Name(), f.Name)
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			setErr = usageErrorf("invalid value %q for --%s from %s: %v", value, f.Name, origin, err)
+		}
+	})
+	return setErr
+}
+
+// displayLocation is the timezone setting, nil when times are shown as the
+// API sends them.
+func displayLocation() *time.Location {
+	name := setting("timezone")
+	if name == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// displayTime formats an API timestamp for text output in loc.
+func displayTime(raw string, loc *time.Location) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "unknown-time"
+	}
+	t, ok := gchat.ParseTime(raw)
+	if loc == nil || !ok {
+		return raw
+	}
+	return t.In(loc).Format(time.RFC3339)
+}
+
+// printMessageLine prints a message in the text output of the message
+// commands.
+func printMessageLine(m gchat.ChatMessage, loc *time.Location) {
+	when := displayTime(m.CreateTime, loc)
+	sender := firstNonEmpty(strings.TrimSpace(m.Sender.DisplayName), strings.TrimSpace(m.Sender.Name), "unknown-sender")
+	text := messageText(m)
+	fmt.Printf("- %s  %s%s: %s%s\n", when, sender, threadLabel(m.ThreadReply, m.ThreadName()), text, reactionsLabel(m.EmojiReactionSummaries))
+}
+
+// reactionsLabel appends reaction counts to a message line, e.g. " [👀 2, ✅ 1]".
+func reactionsLabel(summaries []gchat.EmojiReactionSummary) string {
+	if len(summaries) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(summaries))
+	for _, r := range summaries {
+		parts = append(parts, fmt.Sprintf("%s %d", r.Emoji, r.ReactionCount))
+	}
+	return " [" + strings.Join(parts, ", ") + "]"
+}
+
+// threadLabel marks thread replies in text output with their thread ID.
+func threadLabel(reply bool, thread string) string {
+	if !reply || thread == "" {
+		return ""
+	}
+	return " (reply in thread " + thread[strings.LastIndex(thread, "/")+1:] + ")"
+}
+
+// excludedSpaces parses a comma-separated --exclude-spaces value.
+func excludedSpaces(raw string) map[string]bool {
+	out := map[string]bool{}
+	for _, s := range strings.Split(raw, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out[gchat.NormalizeSpaceName(s)] = true
+		}
+	}
+	return out
+}
+
 func loadToken() (StoredToken, error) {
 	var st StoredToken
 	p, err := tokenPath()
@@ -2869,6 +6768,52 @@ func saveToken(st StoredToken) error {
 	return nil
 }
 
+func cacheDir() (string, error) {
+	d, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d, "cache"), nil
+}
+
+// cachePath is the cache file of the account st belongs to.
+func cachePath(st StoredToken) (string, error) {
+	d, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	key, err := accountKey(st)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d, key+".json"), nil
+}
+
+// dmIndexPath is the DM index file of the account st belongs to. It lives
+// outside the cache dir so `cache clear` does not throw it away.
+func dmIndexPath(st StoredToken) (string, error) {
+	d, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	key, err := accountKey(st)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d, "dm-index", key+".json"), nil
+}
+
+// accountKey names per-account files. Accounts are told apart by a hash of
+// their refresh token, so a new login starts afresh.
+func accountKey(st StoredToken) (string, error) {
+	secret := firstNonEmpty(st.Token.RefreshToken, st.Token.AccessToken)
+	if secret == "" {
+		return "", errors.New("no token to derive an account key from")
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8]), nil
+}
+
 func deleteToken() error {
 	p, err := tokenPath()
 	if err != nil {
@@ -2885,6 +6830,16 @@ func randomString(size int) (string, error) {
 	return base64.RawURLEncoding.EncodeToString(buf), nil
 }
 
+// newClientMessageID returns a client-assigned message ID so a retried send
+// cannot post the message twice.
+func newClientMessageID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "client-" + hex.EncodeToString(buf), nil
+}
+
 func pkceChallenge(verifier string) string {
 	sum := sha256.Sum256([]byte(verifier))
 	return base64.RawURLEncoding.EncodeToString(sum[:])
@@ -2912,6 +6867,20 @@ func prompt(label string) (string, error) {
 	return value, nil
 }
 
+// confirm asks question on stderr and reports whether the answer was yes.
+func confirm(question string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", question)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
 func isInteractive() bool {
 	st, err := os.Stdin.Stat()
 	if err != nil {
@@ -1,82 +1,230 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"flag"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
 
-func TestNormalizeRefs(t *testing.T) {
+func TestRunChatMessagesRecentValidation(t *testing.T) {
 	t.Parallel()
 
-	if got := normalizeSpaceName("AAA123"); got != "spaces/AAA123" {
-		t.Fatalf("normalizeSpaceName added prefix incorrectly: got %q", got)
+	if err := runChatMessagesRecent(context.Background(), []string{}); err == nil || err.Error() != "one of --email or --user or --name is required" {
+		t.Fatalf("unexpected error for missing identity: %v", err)
 	}
-	if got := normalizeSpaceName("spaces/AAA123"); got != "spaces/AAA123" {
-		t.Fatalf("normalizeSpaceName changed existing prefix: got %q", got)
+
+	if err := runChatMessagesRecent(context.Background(), []string{"--name", "Simon", "--email", "simon@example.com"}); err == nil || err.Error() != "use exactly one of --email, --user, or --name" {
+		t.Fatalf("unexpected error for multiple identities: %v", err)
 	}
-	if got := normalizeUserRef("alice@example.com"); got != "users/alice@example.com" {
-		t.Fatalf("normalizeUserRef added prefix incorrectly: got %q", got)
+
+	if err := runChatMessagesRecent(context.Background(), []string{"--name", "Simon", "--limit", "0"}); err == nil || err.Error() != "--limit must be greater than 0" {
+		t.Fatalf("unexpected error for invalid limit: %v", err)
 	}
-	if got := normalizeUserRef("users/123"); got != "users/123" {
-		t.Fatalf("normalizeUserRef changed existing prefix: got %q", got)
+
+	if err := runChatMessagesRecent(context.Background(), []string{"--bogus"}); exitCode(err) != 2 || errorCode(err) != "VALIDATION" {
+		t.Fatalf("unknown flag should be a VALIDATION error with exit code 2, got %v", err)
 	}
 }
 
-func TestParseMessageTime(t *testing.T) {
+func TestSplitGlobalFlagsAnywhere(t *testing.T) {
+	t.Parallel()
+
+	g := globalOptions{}
+	rest, err := splitGlobalFlags(g, "", []string{"--json", "chat", "send", "--timeout", "5s", "--text", "hi", "--no-cache", "--", "--debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"chat", "send", "--text", "hi", "--", "--debug"}
+	if strings.Join(rest, " ") != strings.Join(want, " ") {
+		t.Fatalf("rest = %q, want %q", rest, want)
+	}
+	if !g.json() || g["timeout"] != "5s" || g["no-cache"] != "true" || g["debug"] != "" {
+		t.Fatalf("unexpected globals: %v", g)
+	}
+
+	if _, err := splitGlobalFlags(globalOptions{}, "", []string{"--format", "yaml"}); errorCode(err) != "VALIDATION" {
+		t.Fatalf("unknown format should be a VALIDATION error, got %v", err)
+	}
+	if _, err := splitGlobalFlags(globalOptions{}, "", []string{"chat", "--timeout"}); errorCode(err) != "VALIDATION" {
+		t.Fatalf("missing value should be a VALIDATION error, got %v", err)
+	}
+}
+
+func TestSplitGlobalFlagsKeepsCommandFlagValues(t *testing.T) {
 	t.Parallel()
 
 	cases := []struct {
-		name   string
-		input  string
-		expect bool
+		name    string
+		group   string
+		args    []string
+		rest    []string
+		globals globalOptions
 	}{
-		{name: "RFC3339Nano", input: "2026-02-17T12:34:56.123456Z", expect: true},
-		{name: "RFC3339", input: "2026-02-17T12:34:56Z", expect: true},
-		{name: "empty", input: "", expect: false},
-		{name: "invalid", input: "not-a-time", expect: false},
+		{name: "value of a leaf flag", args: []string{"chat", "send", "--text", "--json"}, rest: []string{"chat", "send", "--text", "--json"}, globals: globalOptions{}},
+		{name: "single dash value", args: []string{"chat", "send", "-text", "-debug", "--json"}, rest: []string{"chat", "send", "-text", "-debug"}, globals: globalOptions{"json": "true"}},
+		{name: "inline value", args: []string{"chat", "send", "--text=hi", "--json"}, rest: []string{"chat", "send", "--text=hi"}, globals: globalOptions{"json": "true"}},
+		{name: "bool leaf flag", args: []string{"chat", "send", "--card", "--json"}, rest: []string{"chat", "send", "--card"}, globals: globalOptions{"json": "true"}},
+		{name: "under a group", group: "chat", args: []string{"send", "--space", "--no-cache", "--debug"}, rest: []string{"send", "--space", "--no-cache"}, globals: globalOptions{"debug": "true"}},
+		{name: "after --", args: []string{"chat", "send", "--", "--json"}, rest: []string{"chat", "send", "--", "--json"}, globals: globalOptions{}},
+		{name: "bad dashes", args: []string{"chat", "send", "---json", "-=json"}, rest: []string{"chat", "send", "---json", "-=json"}, globals: globalOptions{}},
 	}
-
 	for _, tc := range cases {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			_, ok := parseMessageTime(tc.input)
-			if ok != tc.expect {
-				t.Fatalf("parseMessageTime(%q) ok=%v, expected %v", tc.input, ok, tc.expect)
+			g := globalOptions{}
+			rest, err := splitGlobalFlags(g, tc.group, tc.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(rest, " ") != strings.Join(tc.rest, " ") {
+				t.Fatalf("rest = %q, want %q", rest, tc.rest)
+			}
+			if !maps.Equal(g, tc.globals) {
+				t.Fatalf("globals = %v, want %v", g, tc.globals)
 			}
 		})
 	}
 }
 
-func TestPersonMatchScoreOrdering(t *testing.T) {
+func TestAuthLoginRejectsOldTimeoutFlag(t *testing.T) {
+	t.Setenv("GCHATCTL_CONFIG_DIR", t.TempDir())
+	err := dispatch(context.Background(), "", []string{"auth", "login", "--timeout", "5m"})
+	if errorCode(err) != "VALIDATION" || !strings.Contains(err.Error(), "--callback-timeout") {
+		t.Fatalf("auth login --timeout should point at --callback-timeout, got %v", err)
+	}
+}
+
+func TestGlobalFlagsReachLeafFlagSets(t *testing.T) {
 	t.Parallel()
 
-	exactDisplay := personMatchScore("simon", "Simon", "users/simon@example.com")
-	prefixDisplay := personMatchScore("sim", "Simon", "users/simon@example.com")
-	containsDisplay := personMatchScore("imo", "Simon", "users/simon@example.com")
-	userOnly := personMatchScore("simon@example", "", "users/simon@example.com")
-	none := personMatchScore("zzz", "Simon", "users/simon@example.com")
+	fs := flag.NewFlagSet("chat list", flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print JSON")
+	opts := addClientFlags(fs)
+	ctx := withGlobals(context.Background(), globalOptions{"format": "json", "timeout": "2m0s", "no-cache": "true", "profile": "work"})
+	if err := parseFlags(ctx, fs, []string{"--limit-less"}); errorCode(err) != "VALIDATION" {
+		t.Fatalf("unknown leaf flag should still fail, got %v", err)
+	}
+	if err := parseFlags(ctx, fs, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !*jsonOut || opts.timeout != 2*time.Minute || !opts.noCache {
+		t.Fatalf("globals not applied: json=%v timeout=%v no-cache=%v", *jsonOut, opts.timeout, opts.noCache)
+	}
+}
+
+func TestLeafHelpListsFlagsBySection(t *testing.T) {
+	t.Parallel()
 
-	if !(exactDisplay > prefixDisplay && prefixDisplay > containsDisplay) {
-		t.Fatalf("expected display score order exact > prefix > contains, got %d, %d, %d", exactDisplay, prefixDisplay, containsDisplay)
+	fs := flag.NewFlagSet("chat spaces dm", flag.ContinueOnError)
+	fs.Int("limit", 100, "max DM spaces to return")
+	fs.Bool("json", false, "print JSON")
+	addClientFlags(fs)
+	var buf bytes.Buffer
+	printCommandHelp(&buf, fs)
+	out := buf.String()
+	for _, want := range []string{"Usage: gchatctl chat spaces dm [flags]", "Direct-message spaces", "Flags:\n  --limit int", "API flags:", "--max-attempts int", "Global flags:", "--profile string"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("help missing %q:\n%s", want, out)
+		}
 	}
-	if userOnly <= 0 {
-		t.Fatalf("expected positive score for user-only match, got %d", userOnly)
+	if strings.Count(out, "  --json\n") != 1 {
+		t.Fatalf("--json should only be listed as a global flag:\n%s", out)
 	}
-	if none != 0 {
-		t.Fatalf("expected zero score for no match, got %d", none)
+}
+
+func TestRunDeclaresTheCommandFlags(t *testing.T) {
+	for _, c := range commands {
+		if c.run == nil {
+			continue
+		}
+		got, _ := captureStdout(t, func() error {
+			return run(context.Background(), append(strings.Fields(c.path), "--help"))
+		})
+		var want bytes.Buffer
+		printCommandHelp(&want, commandFlags(c))
+		if got != want.String() {
+			t.Fatalf("%s: --help differs from the help of its declared flags; run and flags must use the same constructor\ngot:\n%s\nwant:\n%s", c.path, got, want.String())
+		}
 	}
 }
 
-func TestRunChatMessagesRecentValidation(t *testing.T) {
+func TestCommandFlagsDoNotShadowGlobals(t *testing.T) {
 	t.Parallel()
 
-	if err := runChatMessagesRecent([]string{}); err == nil || err.Error() != "one of --email or --user or --name is required" {
-		t.Fatalf("unexpected error for missing identity: %v", err)
+	globals := globalFlagSet()
+	for _, c := range commands {
+		if c.run == nil {
+			continue
+		}
+		commandFlags(c).VisitAll(func(f *flag.Flag) {
+			g := globals.Lookup(f.Name)
+			if g == nil || f.Name == "json" {
+				return
+			}
+			if f.Usage != g.Usage || f.DefValue != g.DefValue {
+				t.Errorf("%s: --%s means something else than the global --%s, which would be written into it", c.path, f.Name, f.Name)
+			}
+		})
 	}
+}
 
-	if err := runChatMessagesRecent([]string{"--name", "Simon", "--email", "simon@example.com"}); err == nil || err.Error() != "use exactly one of --email, --user, or --name" {
-		t.Fatalf("unexpected error for multiple identities: %v", err)
+func TestSchemaDescribesCommands(t *testing.T) {
+	t.Setenv("GCHATCTL_JSON_ENVELOPE", "")
+	var out struct {
+		Commands []struct {
+			Name         string     `json:"name"`
+			ExactlyOneOf [][]string `json:"exactly_one_of"`
+			AtLeastOneOf [][]string `json:"at_least_one_of"`
+			Flags        []struct {
+				Name     string `json:"name"`
+				Scope    string `json:"scope"`
+				Required bool   `json:"required"`
+			} `json:"flags"`
+			Input struct {
+				Properties map[string]any `json:"properties"`
+				Required   []string       `json:"required"`
+			} `json:"input"`
+			Output struct {
+				Properties map[string]struct {
+					Items struct {
+						Properties map[string]any `json:"properties"`
+					} `json:"items"`
+				} `json:"properties"`
+			} `json:"output"`
+		} `json:"commands"`
 	}
+	runJSON(t, &out, func() error { return runSchema(context.Background(), nil) })
 
-	if err := runChatMessagesRecent([]string{"--name", "Simon", "--limit", "0"}); err == nil || err.Error() != "--limit must be greater than 0" {
-		t.Fatalf("unexpected error for invalid limit: %v", err)
+	leaves := 0
+	for _, c := range commands {
+		if c.run != nil && !c.hidden {
+			leaves++
+		}
+	}
+	if len(out.Commands) != leaves {
+		t.Fatalf("schema lists %d commands, tree has %d", len(out.Commands), leaves)
+	}
+	byName := map[string]int{}
+	for i, c := range out.Commands {
+		byName[c.Name] = i
+	}
+	send := out.Commands[byName["chat send"]]
+	if len(send.ExactlyOneOf) != 1 || len(send.AtLeastOneOf) != 1 || len(send.Input.Required) != 0 || send.Input.Properties["space"] == nil {
+		t.Fatalf("chat send constraints missing: %+v", send)
+	}
+	if send.Input.Properties["max-attempts"] != nil {
+		t.Fatal("API flags should not be part of the input schema")
+	}
+	list := out.Commands[byName["chat list"]]
+	if list.Output.Properties["messages"].Items.Properties["sender"] == nil {
+		t.Fatalf("chat list output should describe messages: %+v", list.Output)
+	}
+	if _, ok := list.Output.Properties["retries"]; !ok {
+		t.Fatal("API commands should document retries")
 	}
 }